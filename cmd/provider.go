@@ -10,6 +10,7 @@ import (
 	"os"
 	"os/signal"
 	"path"
+	"sync"
 	"syscall"
 
 	"github.com/containerd/containerd"
@@ -115,8 +116,26 @@ func makeProviderCmd() *cobra.Command {
 		fs.Client = client
 		fs.CNI = &cni
 
+		fs.CreateWasmInterfaces(cfg.MaxWasmContainers)
+
+		/* The DB is removed on shutdown unless UseDatabase is set, taking the
+		 * stored policies and stats snapshots with it */
+		if cfg.UseDatabase != 1 {
+			msg := "UseDatabase is not set; stored policies and stats snapshots will not survive a restart"
+			log.Printf("Warning: %s\n", msg)
+			timec.LogEvent("provider", msg, 1)
+		}
+
+		/* Re-adopt replicas that survived a restart and remove orphans before
+		 * the daemons start spawning/expiring replicas */
+		log.Printf("Reconciled stored replicas: %s\n", fs.Reconcile())
+
 		go func() {
 			gocron.Every(uint64(cfg.ContainerCleanupInterval)).Second().Do(fs.CleanupDaemon, client, cni)
+			gocron.Every(uint64(cfg.ContainerCleanupInterval)).Second().Do(fs.WarmPoolDaemon)
+			if cfg.StatsSnapshotInterval > 0 {
+				gocron.Every(uint64(cfg.StatsSnapshotInterval)).Second().Do(fs.SnapshotDaemon)
+			}
 			<-gocron.Start()
 		}()
 
@@ -125,6 +144,29 @@ func makeProviderCmd() *cobra.Command {
 			fs.ProcessFunctionStats()
 		}()
 
+		/* Drain in-flight invocations, release replicas, flush logs and close
+		 * the DB. Used on shutdown signals and by the admin drain endpoint. */
+		var shutdownOnce sync.Once
+		shutdown := func() {
+			shutdownOnce.Do(func() {
+				report := fs.Drain(fs.DrainTimeout())
+				log.Printf("Drained: %+v\n", report)
+				// err := supervisor.Remove(services)
+				// if err != nil {
+				// 	fmt.Println(err)
+				// }
+				timec.WriteEventLog()
+				timec.WriteDurationLog()
+				if err := storageManager.Close(); err != nil {
+					log.Printf("Unable to close database: %s\n", err)
+				}
+				if cfg.UseDatabase != 1 {
+					os.Remove("/mnt/faasedge/sqlite.db")
+				}
+				os.Exit(0)
+			})
+		}
+
 		/* Watch for shutdown signal so we can cleanup gracefully */
 		go func() {
 			sig := make(chan os.Signal, 1)
@@ -133,21 +175,10 @@ func makeProviderCmd() *cobra.Command {
 			log.Printf("fecore: waiting for SIGTERM or SIGINT\n")
 			<-sig
 
-			log.Printf("Signal received. Shutting down server")
-			// err := supervisor.Remove(services)
-			// if err != nil {
-			// 	fmt.Println(err)
-			// }
-			timec.WriteEventLog()
-			timec.WriteDurationLog()
-			if cfg.UseDatabase != 1 {
-				os.Remove("/mnt/faasedge/sqlite.db")
-			}
-			os.Exit(0)
+			log.Printf("Signal received. Draining and shutting down server")
+			shutdown()
 		}()
 
-		fs.CreateWasmInterfaces(cfg.MaxWasmContainers)
-
 		invokeResolver := handlers.NewInvokeResolver(client, cni, fs)
 
 		baseUserSecretsPath := path.Join(wd, "secrets")
@@ -175,6 +206,7 @@ func makeProviderCmd() *cobra.Command {
 		bootstrap.Router().HandleFunc("/metrics", handlers.MakeMetricsHandler(fs))
 		bootstrap.Router().HandleFunc("/policy", handlers.MakePolicyHandler(fs))
 		bootstrap.Router().HandleFunc("/ipam", handlers.MakeIPAMHandler(fs))
+		bootstrap.Router().HandleFunc("/admin/drain", handlers.MakeDrainHandler(fs, shutdown))
 
 		log.Printf("Listening on TCP port: %d\n", *config.TCPPort)
 		bootstrap.Serve(&bootstrapHandlers, config)
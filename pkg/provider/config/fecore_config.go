@@ -15,6 +15,29 @@ type Config struct {
 	DefaultLogLevel           int `json:"DefaultLogLevel"`
 	CurrLogLevel              int `json:"CurrLogLevel"`
 	UseDatabase               int `json:"UseDatabase"`
+	/* How idle replicas of other Functions are reclaimed when the node is at
+	 * its container limit: "lru", "weighted" or "off" */
+	EvictionPolicy string `json:"EvictionPolicy"`
+	/* Seconds a drain waits for in-flight invocations to finish */
+	DrainTimeout int `json:"DrainTimeout"`
+	/* What a drain does with replicas: "delete" tears them down, "keep"
+	 * leaves them running so they are re-adopted on the next start */
+	DrainReplicas string `json:"DrainReplicas"`
+	/* Token required in the X-Fecore-Admin-Token header of admin API
+	 * requests. The admin API is disabled when empty. */
+	AdminToken string `json:"AdminToken"`
+	/* Largest request/response body in bytes the proxy passes between a
+	 * client and a Replica; 0 means no limit */
+	MaxRequestBodyBytes  int64 `json:"MaxRequestBodyBytes"`
+	MaxResponseBodyBytes int64 `json:"MaxResponseBodyBytes"`
+	/* Directory of policy documents (<fname>.json, .yaml or .yml) applied
+	 * to the restored Functions on startup; none when empty */
+	PolicyDir string `json:"PolicyDir"`
+	/* Seconds between snapshots of Function stats and evaluated policies in
+	 * the DB (0 disables them), and the age in seconds after which a
+	 * snapshot is discarded instead of restored on startup (0 = no limit) */
+	StatsSnapshotInterval int `json:"StatsSnapshotInterval"`
+	StatsSnapshotMaxAge   int `json:"StatsSnapshotMaxAge"`
 }
 
 func CreateDefaultConfig() Config {
@@ -28,6 +51,15 @@ func CreateDefaultConfig() Config {
 	cfg.DefaultLogLevel = 2
 	cfg.CurrLogLevel = 2
 	cfg.UseDatabase = 0
+	cfg.EvictionPolicy = "lru"
+	cfg.DrainTimeout = 30
+	cfg.DrainReplicas = "delete"
+	cfg.AdminToken = ""
+	cfg.MaxRequestBodyBytes = 0
+	cfg.MaxResponseBodyBytes = 0
+	cfg.PolicyDir = ""
+	cfg.StatsSnapshotInterval = 60
+	cfg.StatsSnapshotMaxAge = 86400
 
 	return cfg
 }
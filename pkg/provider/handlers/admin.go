@@ -0,0 +1,71 @@
+package handlers
+
+import (
+	"crypto/subtle"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.gatech.edu/faasedge/fecore/pkg/timec"
+)
+
+/* Header carrying the admin token (see the AdminToken config option) */
+const AdminTokenHeader = "X-Fecore-Admin-Token"
+
+/* Returns true if the request carries the configured admin token. The admin
+ * API is disabled when no token is configured. */
+func (fs *FunctionStore) IsAdmin(r *http.Request) bool {
+	token := fs.cfg.AdminToken
+	if token == "" {
+		return false
+	}
+	return subtle.ConstantTimeCompare([]byte(r.Header.Get(AdminTokenHeader)), []byte(token)) == 1
+}
+
+// MakeDrainHandler drains the provider ahead of planned maintenance. The
+// optional timeout param (seconds) overrides the DrainTimeout config option.
+// onDrained runs once the drain report has been sent, e.g. to shut down.
+func MakeDrainHandler(fs *FunctionStore, onDrained func()) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.Body != nil {
+			defer r.Body.Close()
+		}
+		if r.Method != http.MethodPost {
+			w.WriteHeader(http.StatusMethodNotAllowed)
+			return
+		}
+		if !fs.IsAdmin(r) {
+			http.Error(w, "admin token required", http.StatusForbidden)
+			return
+		}
+
+		timeout := fs.DrainTimeout()
+		if v := r.URL.Query().Get("timeout"); v != "" {
+			secs, err := strconv.Atoi(v)
+			if err != nil || secs < 0 {
+				http.Error(w, fmt.Sprintf("timeout must be a non-negative number of seconds, got '%s'", v), http.StatusBadRequest)
+				return
+			}
+			timeout = time.Duration(secs) * time.Second
+		}
+
+		timec.LogEvent("admin/MakeDrainHandler", fmt.Sprintf("Drain requested by %s", r.RemoteAddr), 2)
+		report := fs.Drain(timeout)
+		out, err := json.Marshal(report)
+		if err != nil {
+			w.WriteHeader(http.StatusInternalServerError)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusOK)
+		w.Write(out)
+		if flusher, ok := w.(http.Flusher); ok {
+			flusher.Flush()
+		}
+		if onDrained != nil {
+			go onDrained()
+		}
+	}
+}
@@ -0,0 +1,242 @@
+package handlers
+
+import (
+	"context"
+	"fmt"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.gatech.edu/faasedge/fecore/pkg/timec"
+)
+
+/* Admission control. A Function with a maxInflight limit admits at most that
+ * many concurrent invocations; further requests wait in a bounded FIFO queue
+ * (maxQueue) for up to queueTimeout ms. Requests that don't fit in the queue
+ * or time out are rejected so the proxy can answer 429 instead of piling
+ * containers up until the node limit is hit. A maxInflight of 0 means no
+ * limit. */
+
+const defaultQueueTimeoutMs = 10000
+
+type admissionQueue struct {
+	mu       sync.Mutex
+	limit    int // maxInflight the queue was last admitted under
+	inflight int
+	waiters  []chan struct{} // FIFO; closed when the waiter is admitted
+	/* Stats */
+	admitted    int64
+	rejected    int64 // queue full
+	timedOut    int64
+	totalWaitMs int64
+	maxWaitMs   int64
+	maxDepth    int
+}
+
+type admissionReport struct {
+	Fname        string  `json:"fname"`
+	MaxInflight  int     `json:"maxInflight"`
+	MaxQueue     int     `json:"maxQueue"`
+	QueueTimeout int     `json:"queueTimeoutMs"`
+	Inflight     int     `json:"inflight"`
+	QueueDepth   int     `json:"queueDepth"`
+	MaxDepth     int     `json:"maxQueueDepth"`
+	Admitted     int64   `json:"admitted"`
+	Rejected     int64   `json:"rejected"`
+	TimedOut     int64   `json:"timedOut"`
+	AvgWaitMs    float64 `json:"avgWaitMs"`
+	MaxWaitMs    int64   `json:"maxWaitMs"`
+}
+
+/* Returned by Admit when a request can't be admitted */
+type AdmissionError struct {
+	Reason     string
+	RetryAfter time.Duration // hint for the client's Retry-After header
+}
+
+func (e *AdmissionError) Error() string {
+	return e.Reason
+}
+
+/* Reads the maxInflight, maxQueue and queueTimeout labels into a Function's
+ * policy */
+func parseAdmissionLabels(labels map[string]string, policy *Policy) error {
+	policy.maxInflight = 0
+	policy.maxQueue = 0
+	policy.queueTimeout = defaultQueueTimeoutMs
+	for label, field := range map[string]*int{"maxInflight": &policy.maxInflight, "maxQueue": &policy.maxQueue, "queueTimeout": &policy.queueTimeout} {
+		if v, ok := labels[label]; ok {
+			val, err := strconv.Atoi(v)
+			if err != nil || val < 0 {
+				return fmt.Errorf("[admission/parseAdmissionLabels] %s must be a non-negative integer, got '%s'", label, v)
+			}
+			*field = val
+		}
+	}
+	return nil
+}
+
+/* Admits waiters while there is room. Caller must hold q.mu */
+func (q *admissionQueue) dispatch() {
+	for len(q.waiters) > 0 && (q.limit == 0 || q.inflight < q.limit) {
+		waiter := q.waiters[0]
+		q.waiters = q.waiters[1:]
+		q.inflight += 1
+		close(waiter)
+	}
+}
+
+func (q *admissionQueue) release() {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	q.inflight -= 1
+	q.dispatch()
+}
+
+/* Removes a waiter from the queue. Returns false if it was already admitted.
+ * Caller must hold q.mu */
+func (q *admissionQueue) dequeue(waiter chan struct{}) bool {
+	for i, w := range q.waiters {
+		if w == waiter {
+			q.waiters = append(q.waiters[:i], q.waiters[i+1:]...)
+			return true
+		}
+	}
+	return false
+}
+
+func (q *admissionQueue) recordWait(wait time.Duration) {
+	waitMs := wait.Milliseconds()
+	q.admitted += 1
+	q.totalWaitMs += waitMs
+	if waitMs > q.maxWaitMs {
+		q.maxWaitMs = waitMs
+	}
+}
+
+/* Waits until the Function can take another invocation. On success the
+ * caller must call the returned release func once the invocation is done.
+ * Returns an *AdmissionError if the queue is full or the wait timed out. */
+func (fs *FunctionStore) Admit(ctx context.Context, fname string, requestID string) (func(), error) {
+	fs.dfMu.RLock()
+	fn, ok := fs.deployedFunctions[fname]
+	fs.dfMu.RUnlock()
+	if !ok {
+		/* Let the resolver report unknown Functions */
+		return func() {}, nil
+	}
+
+	policy := fs.GetInvocationPolicy(fname)
+	q := &fn.admission
+	start := time.Now()
+
+	q.mu.Lock()
+	q.limit = policy.maxInflight
+	if len(q.waiters) == 0 && (q.limit == 0 || q.inflight < q.limit) {
+		q.inflight += 1
+		q.recordWait(0)
+		q.mu.Unlock()
+		return q.release, nil
+	}
+	if len(q.waiters) >= policy.maxQueue {
+		q.rejected += 1
+		depth := len(q.waiters)
+		q.mu.Unlock()
+		timec.LogEvent("admission/Admit", fmt.Sprintf("Queue for '%s' is full (depth=%d); rejecting <requestID=%s>", fname, depth, requestID), 2)
+		return nil, &AdmissionError{Reason: fmt.Sprintf("Too many requests for '%s'", fname), RetryAfter: fs.retryAfter(fname, depth+1, policy.maxInflight)}
+	}
+	/* Don't queue a request that is expected to miss its deadline anyway */
+	if deadline, ok := ctx.Deadline(); ok {
+		depth := len(q.waiters)
+		estimated := fs.queueWait(fname, depth+1, policy.maxInflight)
+		if remaining := time.Until(deadline); estimated > remaining {
+			q.rejected += 1
+			q.mu.Unlock()
+			timec.LogEvent("admission/Admit", fmt.Sprintf("Request for '%s' would miss its deadline in queue (depth=%d); rejecting <requestID=%s>", fname, depth, requestID), 2)
+			return nil, &DeadlineError{Reason: fmt.Sprintf("'%s' can't be served within the deadline: about %d ms in queue, %d ms left", fname, estimated.Milliseconds(), remaining.Milliseconds()), Estimated: estimated, Remaining: remaining}
+		}
+	}
+	waiter := make(chan struct{})
+	q.waiters = append(q.waiters, waiter)
+	if len(q.waiters) > q.maxDepth {
+		q.maxDepth = len(q.waiters)
+	}
+	depth := len(q.waiters)
+	q.mu.Unlock()
+	timec.LogEvent("admission/Admit", fmt.Sprintf("Queued request for '%s' (depth=%d) <requestID=%s>", fname, depth, requestID), 3)
+
+	timer := time.NewTimer(time.Duration(policy.queueTimeout) * time.Millisecond)
+	defer timer.Stop()
+	select {
+	case <-waiter:
+	case <-timer.C:
+	case <-ctx.Done():
+	}
+
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	if q.dequeue(waiter) {
+		q.timedOut += 1
+		timec.LogEvent("admission/Admit", fmt.Sprintf("Request for '%s' gave up after %d ms in queue <requestID=%s>", fname, time.Since(start).Milliseconds(), requestID), 2)
+		if ctx.Err() == context.DeadlineExceeded {
+			return nil, &DeadlineError{Reason: fmt.Sprintf("Deadline passed waiting in queue for '%s'", fname), Estimated: time.Since(start)}
+		}
+		return nil, &AdmissionError{Reason: fmt.Sprintf("Timed out waiting in queue for '%s'", fname), RetryAfter: fs.retryAfter(fname, len(q.waiters)+1, policy.maxInflight)}
+	}
+	/* Admitted (possibly right as the wait ended) */
+	q.recordWait(time.Since(start))
+	return q.release, nil
+}
+
+/* Estimates how long a client should wait before retrying: the time needed
+ * to drain the requests ahead of it at the Function's average service time */
+func (fs *FunctionStore) retryAfter(fname string, ahead int, maxInflight int) time.Duration {
+	retryAfter := fs.queueWait(fname, ahead, maxInflight)
+	if retryAfter < time.Second {
+		retryAfter = time.Second
+	}
+	return retryAfter
+}
+
+/* Time needed to serve ahead requests at the Function's average service time
+ * with maxInflight of them running at once; 0 if there are no stats yet */
+func (fs *FunctionStore) queueWait(fname string, ahead int, maxInflight int) time.Duration {
+	avgSvcTime := 0
+	if stats, ok := fs.lookupStats(fname); ok {
+		stats.statMu.RLock()
+		avgSvcTime = stats.avgSvcTime
+		stats.statMu.RUnlock()
+	}
+	if maxInflight < 1 {
+		maxInflight = 1
+	}
+	return time.Duration(avgSvcTime*ahead/maxInflight) * time.Millisecond
+}
+
+/* Returns the queue depth and wait time stats of a Function */
+func GetAdmissionReport(fs *FunctionStore, fname string) (admissionReport, error) {
+	fs.dfMu.RLock()
+	fn, ok := fs.deployedFunctions[fname]
+	fs.dfMu.RUnlock()
+	if !ok {
+		return admissionReport{}, fmt.Errorf("[admission/GetAdmissionReport] Function '%s' not found", fname)
+	}
+
+	policy := fs.GetInvocationPolicy(fname)
+	report := admissionReport{Fname: fname, MaxInflight: policy.maxInflight, MaxQueue: policy.maxQueue, QueueTimeout: policy.queueTimeout}
+
+	q := &fn.admission
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	report.Inflight = q.inflight
+	report.QueueDepth = len(q.waiters)
+	report.MaxDepth = q.maxDepth
+	report.Admitted = q.admitted
+	report.Rejected = q.rejected
+	report.TimedOut = q.timedOut
+	report.MaxWaitMs = q.maxWaitMs
+	if q.admitted > 0 {
+		report.AvgWaitMs = float64(q.totalWaitMs) / float64(q.admitted)
+	}
+	return report, nil
+}
@@ -0,0 +1,127 @@
+package handlers
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.gatech.edu/faasedge/fecore/pkg/provider/config"
+)
+
+func newAdmissionTestStore(t *testing.T, maxInflight int, maxQueue int, queueTimeout int) *FunctionStore {
+	fn := &Function{name: "fn"}
+	fn.policy.maxInflight = maxInflight
+	fn.policy.maxQueue = maxQueue
+	fn.policy.queueTimeout = queueTimeout
+	return newTestStore(t, config.Config{}, fn)
+}
+
+func Test_parseAdmissionLabels(t *testing.T) {
+	tests := []struct {
+		name    string
+		labels  map[string]string
+		want    Policy
+		wantErr bool
+	}{
+		{name: "defaults", labels: map[string]string{}, want: Policy{queueTimeout: defaultQueueTimeoutMs}},
+		{name: "all labels", labels: map[string]string{"maxInflight": "4", "maxQueue": "16", "queueTimeout": "500"}, want: Policy{maxInflight: 4, maxQueue: 16, queueTimeout: 500}},
+		{name: "negative value", labels: map[string]string{"maxQueue": "-1"}, wantErr: true},
+		{name: "not a number", labels: map[string]string{"maxInflight": "many"}, wantErr: true},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			policy := Policy{}
+			err := parseAdmissionLabels(tc.labels, &policy)
+			if tc.wantErr {
+				if err == nil {
+					t.Fatalf("want error, got policy %+v", policy)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("want no error, got: %s", err)
+			}
+			if policy != tc.want {
+				t.Fatalf("want %+v, got %+v", tc.want, policy)
+			}
+		})
+	}
+}
+
+func Test_AdmitQueuesAndRejects(t *testing.T) {
+	fs := newAdmissionTestStore(t, 1, 1, 5000)
+	ctx := context.Background()
+
+	release, err := fs.Admit(ctx, "fn", "first")
+	if err != nil {
+		t.Fatalf("want first request admitted, got: %s", err)
+	}
+
+	/* Second request waits in the queue */
+	admitted := make(chan func())
+	go func() {
+		secondRelease, err := fs.Admit(ctx, "fn", "second")
+		if err != nil {
+			t.Errorf("want second request admitted, got: %s", err)
+		}
+		admitted <- secondRelease
+	}()
+	for {
+		report, _ := GetAdmissionReport(fs, "fn")
+		if report.QueueDepth == 1 {
+			break
+		}
+		time.Sleep(time.Millisecond)
+	}
+
+	/* Third request doesn't fit in the queue */
+	_, err = fs.Admit(ctx, "fn", "third")
+	var rejected *AdmissionError
+	if !errors.As(err, &rejected) {
+		t.Fatalf("want AdmissionError for full queue, got: %v", err)
+	}
+	if rejected.RetryAfter < time.Second {
+		t.Fatalf("want Retry-After of at least 1s, got %s", rejected.RetryAfter)
+	}
+
+	release()
+	secondRelease := <-admitted
+	secondRelease()
+
+	report, _ := GetAdmissionReport(fs, "fn")
+	if report.Inflight != 0 || report.QueueDepth != 0 || report.Admitted != 2 || report.Rejected != 1 {
+		t.Fatalf("unexpected report: %+v", report)
+	}
+}
+
+func Test_AdmitTimesOut(t *testing.T) {
+	fs := newAdmissionTestStore(t, 1, 1, 10)
+	ctx := context.Background()
+
+	release, err := fs.Admit(ctx, "fn", "first")
+	if err != nil {
+		t.Fatalf("want first request admitted, got: %s", err)
+	}
+	defer release()
+
+	_, err = fs.Admit(ctx, "fn", "second")
+	var rejected *AdmissionError
+	if !errors.As(err, &rejected) {
+		t.Fatalf("want AdmissionError for queue timeout, got: %v", err)
+	}
+	report, _ := GetAdmissionReport(fs, "fn")
+	if report.TimedOut != 1 || report.QueueDepth != 0 {
+		t.Fatalf("unexpected report: %+v", report)
+	}
+}
+
+func Test_AdmitUnlimited(t *testing.T) {
+	fs := newAdmissionTestStore(t, 0, 0, 0)
+	for i := 0; i < 10; i++ {
+		if _, err := fs.Admit(context.Background(), "fn", "req"); err != nil {
+			t.Fatalf("want request admitted without limit, got: %s", err)
+		}
+	}
+}
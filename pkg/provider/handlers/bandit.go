@@ -0,0 +1,290 @@
+package handlers
+
+import (
+	"fmt"
+	"math"
+	"math/rand"
+	"strconv"
+	"sync"
+)
+
+/* Exploration-aware sandbox choice for Hybrid Functions
+ * (policyEvaluator=bandit). The other evaluators only learn about the sandbox
+ * they currently pick, so a variant that looked slow early on never gets
+ * another sample. The bandit keeps, per startup type, an exponentially
+ * decayed mean and variance of the service time of each sandbox (its arms)
+ * and re-picks the sandbox on every invocation:
+ *   thompson - samples each arm's mean from its posterior and takes the
+ *              fastest; arms with few or stale samples are tried more often
+ *   epsilon  - takes the fastest arm, and the other one explorationRate
+ *              percent of the time
+ * Observations lose half their weight after banditHalfLife newer ones, so
+ * the bandit follows changes in either sandbox. An arm whose weight has
+ * decayed below one sample is always tried next. The policy's
+ * coldStartCtrType/warmStartCtrType show the fastest arm. */
+
+const (
+	evaluatorBandit        = "bandit"
+	banditThompson         = "thompson"
+	banditEpsilon          = "epsilon"
+	defaultExplorationRate = 10 // percent
+	defaultBanditHalfLife  = 50 // observations
+)
+
+/* Sources of randomness; replaced in tests */
+var (
+	banditFloat = rand.Float64
+	banditNorm  = rand.NormFloat64
+)
+
+type banditArm struct {
+	weight float64 // decayed number of observations
+	mean   float64 // ms
+	sq     float64 // decayed sum of squared deviations
+}
+
+type banditState struct {
+	mu   sync.Mutex
+	arms map[string]*banditArm // keyed by "<startupType>/<ctrType>"
+}
+
+type banditArmJSON struct {
+	Mean    float64 `json:"mean"`    // ms
+	Samples float64 `json:"samples"` // effective (decayed) number of observations
+	CI95    float64 `json:"ci95"`    // ms; half-width of the 95% confidence interval of the mean
+	PBest   float64 `json:"pBest"`   // probability this arm is the fastest for its startup type
+}
+
+func init() {
+	RegisterPolicyEvaluator(banditEvaluator{})
+}
+
+/* Reads the banditStrategy, explorationRate and banditHalfLife labels into a
+ * Function's policy */
+func parseBanditLabels(labels map[string]string, policy *Policy) error {
+	policy.banditStrategy = banditThompson
+	policy.explorationRate = defaultExplorationRate
+	policy.banditHalfLife = defaultBanditHalfLife
+	if v, ok := labels["banditStrategy"]; ok {
+		if v != banditThompson && v != banditEpsilon {
+			return fmt.Errorf("[bandit/parseBanditLabels] banditStrategy must be '%s' or '%s', got '%s'", banditThompson, banditEpsilon, v)
+		}
+		policy.banditStrategy = v
+	}
+	if v, ok := labels["explorationRate"]; ok {
+		val, err := strconv.Atoi(v)
+		if err != nil || val < 0 || val > 100 {
+			return fmt.Errorf("[bandit/parseBanditLabels] explorationRate must be a percentage between 0 and 100, got '%s'", v)
+		}
+		policy.explorationRate = val
+	}
+	if v, ok := labels["banditHalfLife"]; ok {
+		val, err := strconv.Atoi(v)
+		if err != nil || val < 1 {
+			return fmt.Errorf("[bandit/parseBanditLabels] banditHalfLife must be a positive number of observations, got '%s'", v)
+		}
+		policy.banditHalfLife = val
+	}
+	return nil
+}
+
+/* Weight an observation keeps per newer observation */
+func (p Policy) banditDecay() float64 {
+	halfLife := p.banditHalfLife
+	if halfLife < 1 {
+		halfLife = defaultBanditHalfLife
+	}
+	return math.Pow(0.5, 1/float64(halfLife))
+}
+
+func banditKey(startupType string, ctrType string) string {
+	return startupType + "/" + ctrType
+}
+
+/* Thawed starts are warm starts from a frozen Replica */
+func banditStartupType(startupType string) string {
+	if startupType == "thawed" {
+		return "warm"
+	}
+	return startupType
+}
+
+/* Records the service time of an invocation; the arms of the other sandbox
+ * for the same startup type age as well */
+func (b *banditState) observe(startupType string, ctrType string, svcTime float64, decay float64) {
+	startupType = banditStartupType(startupType)
+	if startupType != "cold" && startupType != "warm" {
+		return
+	}
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if b.arms == nil {
+		b.arms = make(map[string]*banditArm)
+	}
+	for _, other := range []string{"native", "wasm"} {
+		if arm, ok := b.arms[banditKey(startupType, other)]; ok {
+			arm.weight *= decay
+			arm.sq *= decay
+		}
+	}
+	arm, ok := b.arms[banditKey(startupType, ctrType)]
+	if !ok {
+		arm = &banditArm{}
+		b.arms[banditKey(startupType, ctrType)] = arm
+	}
+	arm.weight += 1
+	delta := svcTime - arm.mean
+	arm.mean += delta / arm.weight
+	arm.sq += delta * (svcTime - arm.mean)
+}
+
+/* Standard error of an arm's mean. Until the arm has a few samples its
+ * variance is assumed to be at least 10% of the mean, so a lucky first
+ * sample doesn't look certain. */
+func (arm banditArm) stdErr() float64 {
+	if arm.weight <= 0 {
+		return math.Inf(1)
+	}
+	variance := arm.sq / arm.weight
+	floor := math.Max(0.1*arm.mean, 1)
+	if variance < floor*floor {
+		variance = floor * floor
+	}
+	return math.Sqrt(variance / arm.weight)
+}
+
+func (b *banditState) arm(startupType string, ctrType string) (banditArm, bool) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	arm, ok := b.arms[banditKey(startupType, ctrType)]
+	if !ok {
+		return banditArm{}, false
+	}
+	return *arm, true
+}
+
+/* Picks the sandbox for an invocation; fallback is used while neither
+ * sandbox has samples */
+func (b *banditState) choose(startupType string, strategy string, explorationRate int, fallback string) string {
+	native, nativeOk := b.arm(startupType, "native")
+	wasm, wasmOk := b.arm(startupType, "wasm")
+	nativeStale := !nativeOk || native.weight < 1
+	wasmStale := !wasmOk || wasm.weight < 1
+	switch {
+	case nativeStale && wasmStale:
+		return fallback
+	case nativeStale:
+		return "native"
+	case wasmStale:
+		return "wasm"
+	}
+	if strategy == banditEpsilon {
+		best, other := "native", "wasm"
+		if wasm.mean < native.mean {
+			best, other = "wasm", "native"
+		}
+		if banditFloat()*100 < float64(explorationRate) {
+			return other
+		}
+		return best
+	}
+	if wasm.mean+banditNorm()*wasm.stdErr() < native.mean+banditNorm()*native.stdErr() {
+		return "wasm"
+	}
+	return "native"
+}
+
+/* Decayed mean service time of an arm; 0 if it has no samples */
+func (b *banditState) mean(startupType string, ctrType string) float64 {
+	arm, ok := b.arm(startupType, ctrType)
+	if !ok {
+		return 0
+	}
+	return arm.mean
+}
+
+/* Per-arm means and confidence for the policy view */
+func (b *banditState) view() map[string]banditArmJSON {
+	view := make(map[string]banditArmJSON)
+	for _, startupType := range []string{"cold", "warm"} {
+		native, nativeOk := b.arm(startupType, "native")
+		wasm, wasmOk := b.arm(startupType, "wasm")
+		/* P(native is faster) for normally distributed means */
+		pNative := 0.5
+		if nativeOk && wasmOk {
+			pNative = 0.5 * (1 + math.Erf((wasm.mean-native.mean)/math.Sqrt(2*(native.stdErr()*native.stdErr()+wasm.stdErr()*wasm.stdErr()))))
+		} else if nativeOk {
+			pNative = 1
+		} else if wasmOk {
+			pNative = 0
+		}
+		if nativeOk {
+			view[banditKey(startupType, "native")] = banditArmJSON{Mean: native.mean, Samples: native.weight, CI95: 1.96 * native.stdErr(), PBest: pNative}
+		}
+		if wasmOk {
+			view[banditKey(startupType, "wasm")] = banditArmJSON{Mean: wasm.mean, Samples: wasm.weight, CI95: 1.96 * wasm.stdErr(), PBest: 1 - pNative}
+		}
+	}
+	return view
+}
+
+/* Feeds an invocation of a Hybrid Function to its bandit */
+func (fs *FunctionStore) observeBandit(stat FunctionStat) {
+	f, ok := fs.lookupFunction(stat.Fn)
+	if !ok || f.labels["ctrType"] != "hybrid" {
+		return
+	}
+	f.policyMu.RLock()
+	decay := f.policy.banditDecay()
+	f.policyMu.RUnlock()
+	f.bandit.observe(stat.StartupType, stat.CtrType, float64(stat.StartupTime+stat.ExecTime), decay)
+}
+
+/* Sandbox for one invocation of a Hybrid Function using the bandit
+ * evaluator; returns fallback for other evaluators */
+func (fs *FunctionStore) banditChoice(fname string, policy Policy, startupType string, fallback string) string {
+	if policy.evaluator != evaluatorBandit {
+		return fallback
+	}
+	f, ok := fs.lookupFunction(fname)
+	if !ok {
+		return fallback
+	}
+	return f.bandit.choose(startupType, policy.banditStrategy, policy.explorationRate, fallback)
+}
+
+/* Sets the policy to the sandbox with the lowest decayed mean; exploration
+ * happens per invocation in ResolveHybrid */
+type banditEvaluator struct{}
+
+func (banditEvaluator) Name() string { return evaluatorBandit }
+
+func banditBest(native float64, wasm float64) (string, bool) {
+	if native == 0 || wasm == 0 {
+		return "", false
+	}
+	if native < wasm {
+		return "native", true
+	}
+	return "wasm", true
+}
+
+func (banditEvaluator) ColdStart(s HybridSnapshot) (string, int) {
+	ctrType, ok := banditBest(s.Native.DecayedSvcCold, s.Wasm.DecayedSvcCold)
+	if !ok {
+		return greedyEvaluator{}.ColdStart(s)
+	}
+	return ctrType, addlCtrsFor(ctrType, s)
+}
+
+func (banditEvaluator) WarmStart(s HybridSnapshot) string {
+	ctrType, ok := banditBest(s.Native.DecayedSvcWarm, s.Wasm.DecayedSvcWarm)
+	if !ok {
+		return greedyEvaluator{}.WarmStart(s)
+	}
+	return ctrType
+}
+
+func (banditEvaluator) SpawnAddlCtrs(s HybridSnapshot) int {
+	return greedyEvaluator{}.SpawnAddlCtrs(s)
+}
@@ -0,0 +1,148 @@
+package handlers
+
+import (
+	"math"
+	"testing"
+
+	"github.gatech.edu/faasedge/fecore/pkg/provider/config"
+)
+
+func Test_parseBanditLabels(t *testing.T) {
+	tests := []struct {
+		name         string
+		labels       map[string]string
+		wantStrategy string
+		wantRate     int
+		wantHalfLife int
+		wantErr      bool
+	}{
+		{name: "defaults", labels: map[string]string{}, wantStrategy: banditThompson, wantRate: defaultExplorationRate, wantHalfLife: defaultBanditHalfLife},
+		{name: "epsilon", labels: map[string]string{"banditStrategy": "epsilon", "explorationRate": "25", "banditHalfLife": "10"}, wantStrategy: banditEpsilon, wantRate: 25, wantHalfLife: 10},
+		{name: "unknown strategy", labels: map[string]string{"banditStrategy": "ucb"}, wantErr: true},
+		{name: "rate over 100", labels: map[string]string{"explorationRate": "101"}, wantErr: true},
+		{name: "zero half-life", labels: map[string]string{"banditHalfLife": "0"}, wantErr: true},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			policy := Policy{}
+			err := parseBanditLabels(tc.labels, &policy)
+			if tc.wantErr {
+				if err == nil {
+					t.Fatalf("want error, got strategy '%s'", policy.banditStrategy)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("want no error, got: %s", err)
+			}
+			if policy.banditStrategy != tc.wantStrategy || policy.explorationRate != tc.wantRate || policy.banditHalfLife != tc.wantHalfLife {
+				t.Fatalf("want %s/%d/%d, got %s/%d/%d", tc.wantStrategy, tc.wantRate, tc.wantHalfLife, policy.banditStrategy, policy.explorationRate, policy.banditHalfLife)
+			}
+		})
+	}
+}
+
+func Test_banditObserve(t *testing.T) {
+	decay := Policy{banditHalfLife: 2}.banditDecay()
+	b := &banditState{}
+	b.observe("cold", "wasm", 100, decay)
+	for i := 0; i < 4; i++ {
+		b.observe("cold", "native", 300, decay)
+	}
+	/* Thawed starts count as warm starts; other startup types are ignored */
+	b.observe("thawed", "native", 10, decay)
+	b.observe("", "native", 10, decay)
+
+	wasm, _ := b.arm("cold", "wasm")
+	if math.Abs(wasm.weight-0.25) > 1e-9 || wasm.mean != 100 {
+		t.Fatalf("want the wasm arm to keep its mean with a quarter of its weight after 4 newer observations, got mean=%.2f weight=%.2f", wasm.mean, wasm.weight)
+	}
+	native, _ := b.arm("cold", "native")
+	if native.mean != 300 {
+		t.Fatalf("want native mean 300, got %.2f", native.mean)
+	}
+	if warm := b.mean("warm", "native"); warm != 10 {
+		t.Fatalf("want a warm native mean of 10 from the thawed start, got %.2f", warm)
+	}
+	if got := b.choose("cold", banditThompson, 0, "native"); got != "wasm" {
+		t.Fatalf("want the stale wasm arm to be tried, got %s", got)
+	}
+
+	/* Recent observations outweigh old ones */
+	b.observe("cold", "wasm", 500, decay)
+	b.observe("cold", "wasm", 500, decay)
+	if mean := b.mean("cold", "wasm"); mean < 450 {
+		t.Fatalf("want the wasm mean to follow recent observations, got %.2f", mean)
+	}
+}
+
+func Test_banditChoose(t *testing.T) {
+	withArms := func(native float64, wasm float64) *banditState {
+		b := &banditState{arms: make(map[string]*banditArm)}
+		if native > 0 {
+			b.arms[banditKey("cold", "native")] = &banditArm{weight: 10, mean: native, sq: 10 * 100}
+		}
+		if wasm > 0 {
+			b.arms[banditKey("cold", "wasm")] = &banditArm{weight: 10, mean: wasm, sq: 10 * 100}
+		}
+		return b
+	}
+
+	tests := []struct {
+		name     string
+		native   float64
+		wasm     float64
+		strategy string
+		float    float64 // banditFloat()
+		norm     []float64
+		want     string
+	}{
+		{name: "no samples uses the policy", strategy: banditThompson, want: "native"},
+		{name: "unsampled arm is tried", native: 100, strategy: banditThompson, want: "wasm"},
+		{name: "epsilon exploits", native: 100, wasm: 200, strategy: banditEpsilon, float: 0.5, want: "native"},
+		{name: "epsilon explores", native: 100, wasm: 200, strategy: banditEpsilon, float: 0.05, want: "wasm"},
+		{name: "thompson takes the faster mean", native: 200, wasm: 100, strategy: banditThompson, norm: []float64{0, 0}, want: "wasm"},
+		{name: "thompson explores within the posterior", native: 100, wasm: 105, strategy: banditThompson, norm: []float64{-2, 2}, want: "wasm"},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			defer func(float func() float64, norm func() float64) {
+				banditFloat, banditNorm = float, norm
+			}(banditFloat, banditNorm)
+			banditFloat = func() float64 { return tc.float }
+			draws := tc.norm
+			banditNorm = func() float64 {
+				if len(draws) == 0 {
+					return 0
+				}
+				draw := draws[0]
+				draws = draws[1:]
+				return draw
+			}
+
+			if got := withArms(tc.native, tc.wasm).choose("cold", tc.strategy, 10, "native"); got != tc.want {
+				t.Fatalf("want %s, got %s", tc.want, got)
+			}
+		})
+	}
+}
+
+func Test_observeBandit(t *testing.T) {
+	hybrid := &Function{name: "fn", labels: map[string]string{"ctrType": "hybrid"}}
+	hybrid.policy.evaluator = evaluatorBandit
+	native := &Function{name: "fn-n", labels: map[string]string{"ctrType": "native"}}
+	fs := newTestStore(t, config.Config{}, hybrid, native)
+
+	fs.observeBandit(FunctionStat{Fn: "fn", CtrType: "wasm", StartupTime: 20, ExecTime: 30, StartupType: "cold"})
+	fs.observeBandit(FunctionStat{Fn: "fn-n", CtrType: "native", StartupTime: 20, ExecTime: 30, StartupType: "cold"})
+	if native.bandit.mean("cold", "native") != 0 {
+		t.Fatalf("want sandbox Functions not to be observed")
+	}
+	view := GetPolicyView(fs, "fn").Bandit
+	arm, ok := view["cold/wasm"]
+	if !ok || arm.Mean != 50 || arm.Samples != 1 || arm.PBest != 1 {
+		t.Fatalf("want cold/wasm with mean 50 from 1 sample in the policy view, got %+v", view)
+	}
+}
@@ -0,0 +1,45 @@
+package handlers
+
+import (
+	"fmt"
+	"strconv"
+)
+
+/* Body size limits. The proxy streams request and response bodies between
+ * clients and Replicas without buffering them, so large uploads and
+ * chunked/SSE responses pass through as they arrive. MaxRequestBodyBytes and
+ * MaxResponseBodyBytes in feconfig.json cap them for every Function (0 means
+ * no limit); the maxRequestBody and maxResponseBody labels (bytes) override
+ * the node-wide limits for a Function. */
+
+/* Reads the maxRequestBody and maxResponseBody labels into a Function's
+ * policy; 0 (or unset) means the node-wide limit applies */
+func parseBodyLimitLabels(labels map[string]string, policy *Policy) error {
+	policy.maxRequestBody = 0
+	policy.maxResponseBody = 0
+	for label, field := range map[string]*int{"maxRequestBody": &policy.maxRequestBody, "maxResponseBody": &policy.maxResponseBody} {
+		if v, ok := labels[label]; ok {
+			val, err := strconv.Atoi(v)
+			if err != nil || val < 0 {
+				return fmt.Errorf("[body_limits/parseBodyLimitLabels] %s must be a non-negative number of bytes, got '%s'", label, v)
+			}
+			*field = val
+		}
+	}
+	return nil
+}
+
+/* Returns the request and response body limits of a Function in bytes; 0
+ * means no limit */
+func (fs *FunctionStore) BodyLimits(fname string) (int64, int64) {
+	policy := fs.GetInvocationPolicy(fname)
+	maxRequest := fs.cfg.MaxRequestBodyBytes
+	if policy.maxRequestBody > 0 {
+		maxRequest = int64(policy.maxRequestBody)
+	}
+	maxResponse := fs.cfg.MaxResponseBodyBytes
+	if policy.maxResponseBody > 0 {
+		maxResponse = int64(policy.maxResponseBody)
+	}
+	return maxRequest, maxResponse
+}
@@ -0,0 +1,70 @@
+package handlers
+
+import (
+	"testing"
+
+	"github.gatech.edu/faasedge/fecore/pkg/provider/config"
+)
+
+func Test_parseBodyLimitLabels(t *testing.T) {
+	tests := []struct {
+		name         string
+		labels       map[string]string
+		wantRequest  int
+		wantResponse int
+		wantErr      bool
+	}{
+		{name: "node-wide limits by default", labels: map[string]string{}},
+		{name: "both limits", labels: map[string]string{"maxRequestBody": "1048576", "maxResponseBody": "4096"}, wantRequest: 1048576, wantResponse: 4096},
+		{name: "negative limit", labels: map[string]string{"maxRequestBody": "-1"}, wantErr: true},
+		{name: "not a number", labels: map[string]string{"maxResponseBody": "1MB"}, wantErr: true},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			policy := Policy{}
+			err := parseBodyLimitLabels(tc.labels, &policy)
+			if tc.wantErr {
+				if err == nil {
+					t.Fatalf("want error, got limits %d/%d", policy.maxRequestBody, policy.maxResponseBody)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("want no error, got: %s", err)
+			}
+			if policy.maxRequestBody != tc.wantRequest || policy.maxResponseBody != tc.wantResponse {
+				t.Fatalf("want limits %d/%d, got %d/%d", tc.wantRequest, tc.wantResponse, policy.maxRequestBody, policy.maxResponseBody)
+			}
+		})
+	}
+}
+
+func Test_BodyLimits(t *testing.T) {
+	tests := []struct {
+		name         string
+		cfg          config.Config
+		labels       map[string]string
+		wantRequest  int64
+		wantResponse int64
+	}{
+		{name: "unlimited", labels: map[string]string{}},
+		{name: "node-wide limits", cfg: config.Config{MaxRequestBodyBytes: 100, MaxResponseBodyBytes: 200}, labels: map[string]string{}, wantRequest: 100, wantResponse: 200},
+		{name: "labels override node-wide limits", cfg: config.Config{MaxRequestBodyBytes: 100, MaxResponseBodyBytes: 200}, labels: map[string]string{"maxRequestBody": "1000"}, wantRequest: 1000, wantResponse: 200},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			fn := &Function{name: "fn"}
+			if err := parseBodyLimitLabels(tc.labels, &fn.policy); err != nil {
+				t.Fatalf("want no error, got: %s", err)
+			}
+			fs := newTestStore(t, tc.cfg, fn)
+
+			maxRequest, maxResponse := fs.BodyLimits("fn")
+			if maxRequest != tc.wantRequest || maxResponse != tc.wantResponse {
+				t.Fatalf("want limits %d/%d, got %d/%d", tc.wantRequest, tc.wantResponse, maxRequest, maxResponse)
+			}
+		})
+	}
+}
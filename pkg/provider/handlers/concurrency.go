@@ -0,0 +1,73 @@
+package handlers
+
+import (
+	"fmt"
+	"strconv"
+)
+
+/* Replica concurrency. By default a Replica serves one invocation at a time:
+ * it moves from idle to activeReplicas and back. Functions with a
+ * replicaConcurrency above 1 (e.g. I/O-bound Functions) let an active Replica
+ * take further invocations while it has spare capacity; it only returns to
+ * the idle pool once its last invocation finishes. */
+
+/* Reads the replicaConcurrency label into a Function's policy */
+func (fs *FunctionStore) parseConcurrencyLabels(labels map[string]string, policy *Policy) error {
+	policy.replicaConcurrency = 1
+	if v, ok := labels["replicaConcurrency"]; ok {
+		val, err := strconv.Atoi(v)
+		if err != nil || val < 1 || val > fs.MAX_REPLICA_CONCURRENCY {
+			return fmt.Errorf("[concurrency/parseConcurrencyLabels] replicaConcurrency must be between 1 and %d, got '%s'", fs.MAX_REPLICA_CONCURRENCY, v)
+		}
+		policy.replicaConcurrency = val
+	}
+	return nil
+}
+
+/* Invocations a single Replica of the Function may serve at once */
+func (p Policy) concurrency() int {
+	/* A Replica that is reset after every invocation can't share them */
+	if p.replicaConcurrency < 1 || p.isolation == isolationReset {
+		return 1
+	}
+	return p.replicaConcurrency
+}
+
+/* Returns the least loaded active Replica that still has spare capacity and
+ * hasn't used up its maxInvocations (0 = no limit), or nil. Caller must hold
+ * poolMu */
+func (fn *Function) sharedReplica(concurrency int, maxInvocations int) *Replica {
+	var shared *Replica
+	for _, replica := range fn.activeReplicas {
+		/* A Replica is frozen until the invocation that checked it out
+		 * has thawed it */
+		if replica.inflight >= concurrency || replica.frozen.Load() || replica.broken {
+			continue
+		}
+		if maxInvocations > 0 && replica.accessCount >= maxInvocations {
+			continue
+		}
+		if shared == nil || replica.inflight < shared.inflight {
+			shared = replica
+		}
+	}
+	return shared
+}
+
+/* Returns the number of active Replicas and their load, i.e. the in-flight
+ * invocations as a fraction of each Replica's capacity summed over all
+ * active Replicas */
+func (fs *FunctionStore) activeLoad(fname string) (int, float32) {
+	concurrency := fs.GetInvocationPolicy(fname).concurrency()
+	fn, ok := fs.lookupFunction(fname)
+	if !ok {
+		return 0, 0
+	}
+	fn.poolMu.RLock()
+	defer fn.poolMu.RUnlock()
+	var load float32
+	for _, replica := range fn.activeReplicas {
+		load += float32(replica.inflight) / float32(concurrency)
+	}
+	return len(fn.activeReplicas), load
+}
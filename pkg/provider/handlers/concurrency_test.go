@@ -0,0 +1,124 @@
+package handlers
+
+import (
+	"testing"
+
+	"github.gatech.edu/faasedge/fecore/pkg/provider/config"
+)
+
+func Test_parseConcurrencyLabels(t *testing.T) {
+	fs := newTestStore(t, config.Config{})
+	tests := []struct {
+		name    string
+		labels  map[string]string
+		want    int
+		wantErr bool
+	}{
+		{name: "default is one invocation per replica", labels: map[string]string{}, want: 1},
+		{name: "concurrent replicas", labels: map[string]string{"replicaConcurrency": "8"}, want: 8},
+		{name: "zero", labels: map[string]string{"replicaConcurrency": "0"}, wantErr: true},
+		{name: "above limit", labels: map[string]string{"replicaConcurrency": "65"}, wantErr: true},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			policy := Policy{}
+			err := fs.parseConcurrencyLabels(tc.labels, &policy)
+			if tc.wantErr {
+				if err == nil {
+					t.Fatalf("want error, got replicaConcurrency=%d", policy.replicaConcurrency)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("want no error, got: %s", err)
+			}
+			if policy.replicaConcurrency != tc.want {
+				t.Fatalf("want replicaConcurrency=%d, got %d", tc.want, policy.replicaConcurrency)
+			}
+		})
+	}
+}
+
+func Test_SharedReplica(t *testing.T) {
+	fn := &Function{name: "fn", activeReplicas: make(map[string]*Replica)}
+	fn.policy.replicaConcurrency = 2
+	fs := newTestStore(t, config.Config{}, fn)
+	fs.AddIdleReplica(&Replica{fname: "fn", uuid: "a"})
+
+	/* Both invocations share the one replica */
+	for i := 0; i < 2; i++ {
+		name, _, _, err := fs.GetIdleReplica("fn", "test")
+		if err != nil || name != "a" {
+			t.Fatalf("want replica 'a' for invocation %d, got '%s' (err=%v)", i, name, err)
+		}
+	}
+	if fn.activeReplicas["a"].inflight != 2 {
+		t.Fatalf("want 2 in-flight invocations, got %d", fn.activeReplicas["a"].inflight)
+	}
+
+	/* The replica is at capacity and there are no idle replicas left */
+	if name, _, _, err := fs.GetIdleReplica("fn", "test"); err == nil {
+		t.Fatalf("want no replica available, got '%s'", name)
+	}
+
+	/* The replica stays active until its last invocation finishes */
+	if err := fs.UpdateReplicaStatusInactive("fn", "a", "test"); err != nil {
+		t.Fatalf("want no error, got: %s", err)
+	}
+	if _, ok := fn.activeReplicas["a"]; !ok || fn.idleReplicas.size() != 0 {
+		t.Fatalf("want replica 'a' still active with one invocation in-flight")
+	}
+	if err := fs.UpdateReplicaStatusInactive("fn", "a", "test"); err != nil {
+		t.Fatalf("want no error, got: %s", err)
+	}
+	if _, ok := fn.activeReplicas["a"]; ok || fn.idleReplicas.size() != 1 {
+		t.Fatalf("want replica 'a' back in the idle pool")
+	}
+}
+
+func Test_DiscardActiveReplica(t *testing.T) {
+	tests := []struct {
+		name        string
+		concurrency int
+		invocations int // invocations sharing the replica
+	}{
+		{name: "sole invocation", concurrency: 1, invocations: 1},
+		{name: "shared replica is deleted by its last invocation", concurrency: 2, invocations: 2},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			rt := &resettingRuntime{}
+			registerTestRuntime(t, "resetting", rt)
+			fn := &Function{name: "fn", activeReplicas: make(map[string]*Replica)}
+			fn.policy.replicaConcurrency = tc.concurrency
+			fs := newTestStore(t, config.Config{}, fn)
+			fs.AddIdleReplica(&Replica{fname: "fn", uuid: "a", ctrType: "resetting"})
+			for i := 0; i < tc.invocations; i++ {
+				if name, _, _, err := fs.GetIdleReplica("fn", "test"); err != nil || name != "a" {
+					t.Fatalf("want replica 'a', got '%s' (err=%v)", name, err)
+				}
+			}
+
+			if err := fs.DiscardActiveReplica("fn", "a", "failed its request", "test"); err != nil {
+				t.Fatalf("want no error, got: %s", err)
+			}
+			if tc.invocations > 1 {
+				/* No new invocations for it while the others finish */
+				if name, _, _, err := fs.GetIdleReplica("fn", "test"); err == nil {
+					t.Fatalf("want no replica available, got '%s'", name)
+				}
+				if len(rt.deleted) != 0 {
+					t.Fatalf("want replica 'a' kept until its other invocation finishes")
+				}
+				if err := fs.UpdateReplicaStatusInactive("fn", "a", "test"); err != nil {
+					t.Fatalf("want no error, got: %s", err)
+				}
+			}
+			if len(fn.activeReplicas) != 0 || fn.idleReplicas.size() != 0 || len(rt.deleted) != 1 {
+				t.Fatalf("want replica 'a' deleted, got active=%d idle=%d deleted=%d", len(fn.activeReplicas), fn.idleReplicas.size(), len(rt.deleted))
+			}
+		})
+	}
+}
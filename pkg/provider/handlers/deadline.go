@@ -0,0 +1,131 @@
+package handlers
+
+import (
+	"fmt"
+	"time"
+
+	"github.gatech.edu/faasedge/fecore/pkg/timec"
+)
+
+/* Deadline-aware sandbox selection. A client can send its latency budget in
+ * the X-Fecore-Deadline-Ms header. The resolver estimates the service time of
+ * every way it could serve the request (warm if a Replica is idle, otherwise
+ * cold; for Hybrid Functions for each sandbox) from the Function's avgSvcWarm
+ * and avgSvcCold stats, and keeps the policy's choice if it is expected to
+ * meet the deadline, or switches to the fastest option that is. Options
+ * without stats yet are assumed to make it. If no option is expected to meet
+ * the deadline, the request fails fast with a DeadlineError instead of
+ * starting a sandbox. Admission applies the same estimate before queueing. */
+
+/* Header carrying a request's latency budget in ms */
+const DeadlineHeader = "X-Fecore-Deadline-Ms"
+
+/* Per-request settings passed from the proxy to the resolver */
+type InvokeOptions struct {
+	StartupType   string    // "cold" skips warm Replicas, "warm" never cold starts
+	ContainerType string    // sandbox type of a Hybrid Function to use ("native" or "wasm")
+	Replica       string    // name of the Replica to use (see override.go)
+	Deadline      time.Time // zero if the request has no deadline
+}
+
+/* Returned when no way of serving a request is expected to meet its deadline */
+type DeadlineError struct {
+	Reason    string
+	Estimated time.Duration // expected service time of the fastest option
+	Remaining time.Duration // time left until the deadline
+}
+
+func (e *DeadlineError) Error() string {
+	return e.Reason
+}
+
+/* One way of serving a request and its expected service time (0 if unknown) */
+type deadlineOption struct {
+	ctrType     string
+	startupType string
+	estimate    time.Duration
+}
+
+func (o deadlineOption) meets(remaining time.Duration) bool {
+	return o.estimate == 0 || o.estimate <= remaining
+}
+
+/* Returns the ways a sandbox Function could serve a request now: warm if it
+ * has an idle Replica, otherwise cold */
+func (fs *FunctionStore) deadlineOption(sandbox string, ctrType string, startupType string) deadlineOption {
+	option := deadlineOption{ctrType: ctrType, startupType: "cold"}
+	if startupType != "cold" && fs.idleCount(sandbox) > 0 {
+		option.startupType = "warm"
+	}
+	stats, ok := fs.lookupStats(sandbox)
+	if !ok {
+		return option
+	}
+	stats.statMu.RLock()
+	defer stats.statMu.RUnlock()
+	if option.startupType == "warm" {
+		option.estimate = time.Duration(stats.avgSvcWarm) * time.Millisecond
+	} else {
+		option.estimate = time.Duration(stats.avgSvcCold) * time.Millisecond
+	}
+	return option
+}
+
+/* Checks that a Function deployed on a single sandbox is expected to meet
+ * the request's deadline */
+func (fs *FunctionStore) planSandboxDeadline(function *Function, ctrType string, opts InvokeOptions) error {
+	if opts.Deadline.IsZero() || opts.Replica != "" {
+		return nil
+	}
+	remaining := time.Until(opts.Deadline)
+	option := fs.deadlineOption(function.name, ctrType, opts.StartupType)
+	if option.meets(remaining) {
+		return nil
+	}
+	return deadlineMiss(function.name, option, remaining)
+}
+
+/* Picks the sandbox of a Hybrid Function for a request with a deadline.
+ * Returns opts with ContainerType set if the policy's choice isn't expected
+ * to meet the deadline but another sandbox is. */
+func (fs *FunctionStore) planHybridDeadline(function *Function, policy Policy, opts InvokeOptions, requestID string) (InvokeOptions, error) {
+	if opts.Deadline.IsZero() || opts.ContainerType != "" || opts.Replica != "" {
+		return opts, nil
+	}
+	remaining := time.Until(opts.Deadline)
+
+	/* What the policy would do: warm in warmStartCtrType, else cold in
+	 * coldStartCtrType */
+	chosen := fs.deadlineOption(function.sandboxes[policy.warmStartCtrType], policy.warmStartCtrType, opts.StartupType)
+	if chosen.startupType == "cold" {
+		chosen = fs.deadlineOption(function.sandboxes[policy.coldStartCtrType], policy.coldStartCtrType, "cold")
+	}
+	if chosen.meets(remaining) {
+		return opts, nil
+	}
+
+	var best *deadlineOption
+	for ctrType, sandbox := range function.sandboxes {
+		option := fs.deadlineOption(sandbox, ctrType, opts.StartupType)
+		if !option.meets(remaining) {
+			continue
+		}
+		if best == nil || option.estimate < best.estimate {
+			best = &option
+		}
+	}
+	if best == nil {
+		return opts, deadlineMiss(function.name, chosen, remaining)
+	}
+	timec.LogEvent("deadline/planHybridDeadline", fmt.Sprintf("Using %s %s start for '%s' (est. %d ms) to meet deadline in %d ms <requestID=%s>", best.startupType, best.ctrType, function.name, best.estimate.Milliseconds(), remaining.Milliseconds(), requestID), 2)
+	opts.ContainerType = best.ctrType
+	return opts, nil
+}
+
+func deadlineMiss(fname string, option deadlineOption, remaining time.Duration) *DeadlineError {
+	return &DeadlineError{
+		Reason:    fmt.Sprintf("'%s' can't be served within the deadline: %s %s start takes about %d ms, %d ms left", fname, option.startupType, option.ctrType, option.estimate.Milliseconds(), remaining.Milliseconds()),
+		Estimated: option.estimate,
+		Remaining: remaining,
+	}
+}
@@ -0,0 +1,99 @@
+package handlers
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.gatech.edu/faasedge/fecore/pkg/provider/config"
+)
+
+func Test_planHybridDeadline(t *testing.T) {
+	tests := []struct {
+		name       string
+		deadlineMs int
+		nativeIdle bool
+		nativeCold int // avgSvcCold of the native sandbox in ms
+		nativeWarm int
+		wasmCold   int
+		want       string // ContainerType the request is pinned to
+		wantErr    bool
+	}{
+		{name: "no deadline", nativeCold: 900, wasmCold: 50},
+		{name: "policy choice meets the deadline", deadlineMs: 1000, nativeCold: 900, wasmCold: 50},
+		{name: "switch to the faster cold start", deadlineMs: 200, nativeCold: 900, wasmCold: 50, want: "wasm"},
+		{name: "warm native is fast enough", deadlineMs: 200, nativeIdle: true, nativeCold: 900, nativeWarm: 10, wasmCold: 50},
+		{name: "no stats yet", deadlineMs: 200},
+		{name: "nothing meets the deadline", deadlineMs: 20, nativeCold: 900, wasmCold: 50, wantErr: true},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			hybrid := &Function{name: "fn", sandboxes: map[string]string{"native": "fn-native", "wasm": "fn-wasm"}}
+			fs := newTestStore(t, config.Config{}, hybrid, &Function{name: "fn-native"}, &Function{name: "fn-wasm"})
+			fs.functionStats["fn-native"].avgSvcCold = tc.nativeCold
+			fs.functionStats["fn-native"].avgSvcWarm = tc.nativeWarm
+			fs.functionStats["fn-wasm"].avgSvcCold = tc.wasmCold
+			if tc.nativeIdle {
+				fs.AddIdleReplica(&Replica{fname: "fn-native", uuid: "a", ctrType: "native"})
+			}
+			opts := InvokeOptions{}
+			if tc.deadlineMs > 0 {
+				opts.Deadline = time.Now().Add(time.Duration(tc.deadlineMs) * time.Millisecond)
+			}
+			policy := Policy{coldStartCtrType: "native", warmStartCtrType: "native"}
+
+			got, err := fs.planHybridDeadline(hybrid, policy, opts, "test")
+			if tc.wantErr {
+				var missed *DeadlineError
+				if !errors.As(err, &missed) {
+					t.Fatalf("want DeadlineError, got %v", err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("want no error, got: %s", err)
+			}
+			if got.ContainerType != tc.want {
+				t.Fatalf("want ContainerType '%s', got '%s'", tc.want, got.ContainerType)
+			}
+		})
+	}
+}
+
+func Test_AdmitDeadline(t *testing.T) {
+	tests := []struct {
+		name       string
+		avgSvcTime int
+		deadlineMs int
+		wantQueued bool
+	}{
+		{name: "rejected when the queue wait exceeds the deadline", avgSvcTime: 500, deadlineMs: 100},
+		{name: "queued until the deadline passes", avgSvcTime: 0, deadlineMs: 50, wantQueued: true},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			fs := newAdmissionTestStore(t, 1, 4, 5000)
+			fs.functionStats["fn"].avgSvcTime = tc.avgSvcTime
+			release, err := fs.Admit(context.Background(), "fn", "first")
+			if err != nil {
+				t.Fatalf("want first request admitted, got: %s", err)
+			}
+			defer release()
+
+			ctx, cancel := context.WithTimeout(context.Background(), time.Duration(tc.deadlineMs)*time.Millisecond)
+			defer cancel()
+			start := time.Now()
+			_, err = fs.Admit(ctx, "fn", "second")
+			var missed *DeadlineError
+			if !errors.As(err, &missed) {
+				t.Fatalf("want DeadlineError, got %v", err)
+			}
+			if queued := time.Since(start) >= time.Duration(tc.deadlineMs)*time.Millisecond; queued != tc.wantQueued {
+				t.Fatalf("want queued=%v, got %v after %s", tc.wantQueued, queued, time.Since(start))
+			}
+		})
+	}
+}
@@ -95,12 +95,11 @@ func MakeDeployHandler(client *containerd.Client, cni gocni.CNI, secretMountPath
 		fn.activeReplicas = make(map[string]*Replica)
 		fn.idleReplicas = idleReplicas
 		fn.idleReplicasTs = make(map[string]time.Time)
-		fn.activeReplicasLock = sync.RWMutex{}
-		fn.idleReplicasLock = sync.RWMutex{}
+		fn.poolMu = sync.RWMutex{}
 		fn.idleReplicasTsMu = sync.RWMutex{}
 		fn.fnMu = sync.RWMutex{}
 
-		deployErr := deploy(ctx, req, client, cni, namespaceSecretMountPath, alwaysPull, &fn, fs, false)
+		deployErr := deploy(ctx, req, client, cni, namespaceSecretMountPath, alwaysPull, &fn, fs)
 		if deployErr != nil {
 			timec.LogEvent("[deploy/MakeDeployHandler]", fmt.Sprintf("Error deploying %s: %s\n", name, deployErr), 1)
 			http.Error(w, deployErr.Error(), http.StatusBadRequest)
@@ -111,6 +110,9 @@ func MakeDeployHandler(client *containerd.Client, cni gocni.CNI, secretMountPath
 			http.Error(w, err.Error(), http.StatusBadRequest)
 			return
 		}
+
+		/* Fill the warm pool now rather than waiting for the next tick */
+		go fs.WarmPoolDaemon()
 	}
 }
 
@@ -143,7 +145,7 @@ func prepull(ctx context.Context, req types.FunctionDeployment, client *containe
 }
 
 func deploy(ctx context.Context, req types.FunctionDeployment, client *containerd.Client, cni gocni.CNI,
-	secretMountPath string, alwaysPull bool, fn *Function, fs *FunctionStore, prewarm bool) error {
+	secretMountPath string, alwaysPull bool, fn *Function, fs *FunctionStore) error {
 
 	labels, err := buildLabels(&req)
 	if err != nil {
@@ -153,41 +155,21 @@ func deploy(ctx context.Context, req types.FunctionDeployment, client *container
 	// TODO: Use a container image to store wasm data
 	// Current method expects a tarball at WASM_IMAGES_ROOT
 	if val, ok := labels["ctrType"]; ok && (val == "wasm") {
-		WASM_IMAGES_ROOT := "/mnt/faasedge/images"
-		r, err := os.Open(WASM_IMAGES_ROOT + "/" + req.Image + ".tgz")
+		r, err := os.Open(wasmImagesRoot + "/" + req.Image + ".tgz")
 		if err != nil {
 			return err
 		}
-		err = untar(WASM_IMAGES_ROOT, r)
+		err = untar(wasmImagesRoot, r)
 		if err != nil {
 			return err
 		}
-		fn.image = WASM_IMAGES_ROOT + "/" + fn.name
+		fn.image = wasmImagesRoot + "/" + fn.name
 		files, _ := os.ReadDir(fn.image + "/rootfs")
 		for _, file := range files {
 			fn.imageFiles = append(fn.imageFiles, file.Name())
 		}
-	} else if val, ok := labels["ctrType"]; ok && (val == "hybrid") {
-		if val, ok := labels["sandboxes"]; ok {
-			tokens := strings.Split(val, ",")
-			for _, v := range tokens {
-				if strings.Contains(v, "-n") {
-					fn.sandboxes["native"] = v
-				} else if strings.Contains(v, "-w") {
-					fn.sandboxes["wasm"] = v
-				}
-			}
-		} else {
-			return fmt.Errorf("[deploy] Sandboxes unspecified for hybrid")
-		}
-		/* Set default policy for Hybrid */
-		policy := Policy{}
-		policy.coldStartCtrType = "wasm"
-		policy.warmStartCtrType = "native"
-		policy.spawnAddlCtrs = 1
-		policy.keepaliveColdStartCtr = 0
-		fn.policy = policy
-	} else {
+	} else if val, ok := labels["ctrType"]; !ok || (val != "hybrid") {
+		/* Hybrid Functions run on their sandbox deployments' images */
 		image, err := prepull(ctx, req, client, alwaysPull)
 		if err != nil {
 			return err
@@ -195,6 +177,10 @@ func deploy(ctx context.Context, req types.FunctionDeployment, client *container
 		fn.image = image.Name()
 	}
 
+	if err := fs.configureFunction(fn, labels); err != nil {
+		return err
+	}
+
 	fn.envProcess = req.EnvProcess
 	fn.envVars = req.EnvVars
 	fn.labels = labels
@@ -215,20 +201,68 @@ func deploy(ctx context.Context, req types.FunctionDeployment, client *container
 	}
 	fn.memoryLimit = 50000000 // TODO probably bug in crun latest version
 
-	// if prewarm {
-	// 	startTime := time.Now()
-	// 	replicaName, replicaIP, createReplicaStatus := createReplica(client, cni, fs, fn, "Deploy", false)
-	// 	if createReplicaStatus == nil {
-	// 		coldStartTime := time.Since(startTime)
-	// 		fs.RecordColdStartTime(coldStartTime, "Deploy <"+fn.name+">")
-	// 		log.Printf("[deploy] Created container '%s' for Function '%s'", replicaName, fn.name)
-	// 	} else {
-	// 		log.Printf("[deploy] Failed to create container for Function '%s'", fn.name)
-	// 	}
-	// 	return createReplicaStatus
-	// } else {
 	return nil
-	//}
+}
+
+/* Sets up a Function's sandboxes and invocation policy from its labels. Used
+ * at deploy time and when restoring Functions from the DB on startup. */
+func (fs *FunctionStore) configureFunction(fn *Function, labels map[string]string) error {
+	if val, ok := labels["ctrType"]; ok && (val == "hybrid") {
+		if val, ok := labels["sandboxes"]; ok {
+			tokens := strings.Split(val, ",")
+			for _, v := range tokens {
+				if strings.Contains(v, "-n") {
+					fn.sandboxes["native"] = v
+				} else if strings.Contains(v, "-w") {
+					fn.sandboxes["wasm"] = v
+				}
+			}
+		} else {
+			return fmt.Errorf("[deploy] Sandboxes unspecified for hybrid")
+		}
+		fn.policy = defaultHybridPolicy()
+	}
+
+	for _, parse := range fs.policyLabelParsers() {
+		if err := parse(labels, &fn.policy); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+/* Default policy for Hybrid */
+func defaultHybridPolicy() Policy {
+	policy := Policy{}
+	policy.coldStartCtrType = "wasm"
+	policy.warmStartCtrType = "native"
+	policy.spawnAddlCtrs = 1
+	policy.keepaliveColdStartCtr = 0
+	return policy
+}
+
+/* Each reads its labels into a Function's policy, or sets the defaults of
+ * the settings whose labels are missing. Replicas of a Hybrid Function belong
+ * to its native and WASM sandbox Functions, so per-Replica settings
+ * (replicaConcurrency, recycling, idleMode, isolation, warmup) are taken from
+ * the policy of the sandbox Function serving the invocation. */
+func (fs *FunctionStore) policyLabelParsers() []func(map[string]string, *Policy) error {
+	return []func(map[string]string, *Policy) error{
+		fs.parseWarmPoolLabels,
+		parseKeepAliveLabels,
+		parseAdmissionLabels,
+		fs.parseConcurrencyLabels,
+		parseRecyclingLabels,
+		parseIdleModeLabels,
+		parseIsolationLabels,
+		parseHedgeLabels,
+		parseWarmupLabels,
+		parseReadinessLabels,
+		parseBodyLimitLabels,
+		parseEvaluatorLabels,
+		parseBanditLabels,
+		parseSLOLabels,
+	}
 }
 
 func buildLabels(request *types.FunctionDeployment) (map[string]string, error) {
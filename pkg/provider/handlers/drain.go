@@ -0,0 +1,165 @@
+package handlers
+
+import (
+	"fmt"
+	"time"
+
+	"github.gatech.edu/faasedge/fecore/pkg/timec"
+)
+
+/* Graceful drain. Once draining, the proxy turns new invocations away and the
+ * cleanup and warm pool daemons stop. Drain waits up to a deadline for the
+ * in-flight invocations (and background spawns and resets) to finish, then
+ * tears down or keeps the replicas according to the DrainReplicas config
+ * option and flushes pending stats. No Replica is created once draining.
+ * Kept replicas stay in the DB and are re-adopted by Reconcile on the next
+ * start, so they are only kept if the DB survives it (UseDatabase). */
+
+const (
+	defaultDrainTimeoutSec = 30
+	drainPollInterval      = 50 * time.Millisecond
+	statsFlushTimeout      = time.Second
+)
+
+type drainReport struct {
+	InFlight   int64 `json:"inFlight"`  // invocations running when the drain started
+	Abandoned  int64 `json:"abandoned"` // invocations still running at the deadline
+	Deleted    int   `json:"deleted"`   // replicas torn down
+	Kept       int   `json:"kept"`      // replicas left running for the next start
+	Snapshots  int   `json:"snapshots"` // stats snapshots written (see stats_snapshot.go)
+	DurationMs int64 `json:"durationMs"`
+}
+
+/* Counts an invocation accepted by the proxy. Returns false if the provider
+ * is draining; otherwise the caller must call EndInvocation when done. */
+func (fs *FunctionStore) BeginInvocation() bool {
+	fs.invocations.Add(1)
+	if fs.draining.Load() {
+		fs.invocations.Add(-1)
+		return false
+	}
+	return true
+}
+
+func (fs *FunctionStore) EndInvocation() {
+	fs.invocations.Add(-1)
+}
+
+/* Counts a Replica spawned outside of an invocation. Returns false if the
+ * provider is draining; otherwise the caller must call endSpawn when done. */
+func (fs *FunctionStore) beginSpawn() bool {
+	fs.spawns.Add(1)
+	if fs.draining.Load() {
+		fs.spawns.Add(-1)
+		return false
+	}
+	return true
+}
+
+func (fs *FunctionStore) endSpawn() {
+	fs.spawns.Add(-1)
+}
+
+func (fs *FunctionStore) Draining() bool {
+	return fs.draining.Load()
+}
+
+/* Deadline for in-flight invocations from the DrainTimeout config option */
+func (fs *FunctionStore) DrainTimeout() time.Duration {
+	if fs.cfg.DrainTimeout <= 0 {
+		return defaultDrainTimeoutSec * time.Second
+	}
+	return time.Duration(fs.cfg.DrainTimeout) * time.Second
+}
+
+/* Drains the provider. Only the first call drains; later calls wait for it and
+ * return the same report. */
+func (fs *FunctionStore) Drain(timeout time.Duration) drainReport {
+	fs.drainMu.Lock()
+	defer fs.drainMu.Unlock()
+	if fs.drained != nil {
+		return *fs.drained
+	}
+
+	start := time.Now()
+	fs.draining.Store(true)
+	report := drainReport{InFlight: fs.invocations.Load()}
+	timec.LogEvent("drain/Drain", fmt.Sprintf("Draining: waiting up to %s for %d in-flight invocations", timeout, report.InFlight), 2)
+
+	deadline := start.Add(timeout)
+	for (fs.invocations.Load() > 0 || fs.pendingSpawns() > 0 || fs.spawns.Load() > 0 || fs.pendingResets.Load() > 0) && time.Now().Before(deadline) {
+		time.Sleep(drainPollInterval)
+	}
+	report.Abandoned = fs.invocations.Load()
+	if report.Abandoned > 0 {
+		timec.LogEvent("drain/Drain", fmt.Sprintf("Drain deadline reached with %d invocations in-flight", report.Abandoned), 1)
+	}
+
+	/* Let a running cleanup/warm pool pass finish; later passes see the
+	 * draining flag and return */
+	fs.cleanupMu.Lock()
+	fs.cleanupMu.Unlock()
+	fs.warmPoolMu.Lock()
+	fs.warmPoolMu.Unlock()
+
+	keep := fs.cfg.DrainReplicas == "keep"
+	if keep && fs.cfg.UseDatabase != 1 {
+		/* The DB is removed on shutdown, so Reconcile couldn't re-adopt them */
+		timec.LogEvent("drain/Drain", "DrainReplicas is 'keep' but UseDatabase is not set; deleting replicas", 1)
+		keep = false
+	}
+	fns, _ := fs.GetDeployedFunctions()
+	for _, fn := range fns {
+		if keep {
+			report.Kept += fs.countReplicas(fn)
+			continue
+		}
+		for _, replica := range fs.takeReplicas(fn) {
+			fs.DeleteReplica(replica)
+			report.Deleted += 1
+		}
+	}
+
+	flushDeadline := time.Now().Add(statsFlushTimeout)
+	for len(fs.statsChan) > 0 && time.Now().Before(flushDeadline) {
+		time.Sleep(drainPollInterval)
+	}
+	report.Snapshots = fs.SnapshotStats()
+
+	report.DurationMs = time.Since(start).Milliseconds()
+	fs.drained = &report
+	timec.LogEvent("drain/Drain", fmt.Sprintf("Drained in %d ms: deleted=%d, kept=%d, abandoned=%d, snapshots=%d", report.DurationMs, report.Deleted, report.Kept, report.Abandoned, report.Snapshots), 2)
+	return report
+}
+
+/* Removes and returns all idle and active Replicas of a Function */
+func (fs *FunctionStore) takeReplicas(fn *Function) []*Replica {
+	replicas := make([]*Replica, 0)
+	fn.poolMu.Lock()
+	defer fn.poolMu.Unlock()
+	for replica := fn.idleReplicas.popLRU(); replica != nil; replica = fn.idleReplicas.popLRU() {
+		replicas = append(replicas, replica)
+	}
+	for name, replica := range fn.activeReplicas {
+		replicas = append(replicas, replica)
+		delete(fn.activeReplicas, name)
+	}
+	return replicas
+}
+
+func (fs *FunctionStore) countReplicas(fn *Function) int {
+	fn.poolMu.RLock()
+	defer fn.poolMu.RUnlock()
+	return fn.idleReplicas.size() + len(fn.activeReplicas)
+}
+
+/* Number of warm pool Replicas still being spawned */
+func (fs *FunctionStore) pendingSpawns() int {
+	fs.warmPoolPendingMu.Lock()
+	defer fs.warmPoolPendingMu.Unlock()
+	pending := 0
+	for _, n := range fs.warmPoolPending {
+		pending += n
+	}
+	return pending
+}
@@ -0,0 +1,164 @@
+package handlers
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+
+	"github.gatech.edu/faasedge/fecore/pkg/provider/config"
+)
+
+/* Sandbox runtime that records deleted replicas */
+type recordingRuntime struct {
+	fakeRuntime
+	mu      sync.Mutex
+	deleted []string
+}
+
+func (rt *recordingRuntime) Type() string {
+	return "recording"
+}
+
+func (rt *recordingRuntime) Delete(fs *FunctionStore, replica *Replica) error {
+	rt.mu.Lock()
+	defer rt.mu.Unlock()
+	rt.deleted = append(rt.deleted, replica.uuid)
+	return nil
+}
+
+func newDrainTestStore(t *testing.T, cfg config.Config) *FunctionStore {
+	fs := newTestStore(t, cfg, &Function{name: "fn"})
+	fs.AddIdleReplica(&Replica{fname: "fn", uuid: "a", ctrType: "recording"})
+	fs.AddIdleReplica(&Replica{fname: "fn", uuid: "b", ctrType: "recording"})
+	return fs
+}
+
+func Test_DrainWaitsAndDeletes(t *testing.T) {
+	rt := &recordingRuntime{}
+	registerTestRuntime(t, "recording", rt)
+	fs := newDrainTestStore(t, config.Config{DrainReplicas: "delete"})
+
+	if !fs.BeginInvocation() {
+		t.Fatalf("want invocation accepted before draining")
+	}
+	finished := make(chan struct{})
+	go func() {
+		time.Sleep(100 * time.Millisecond)
+		fs.EndInvocation()
+		close(finished)
+	}()
+
+	report := fs.Drain(5 * time.Second)
+	select {
+	case <-finished:
+	default:
+		t.Fatalf("want drain to wait for the in-flight invocation")
+	}
+	if fs.BeginInvocation() {
+		t.Fatalf("want new invocations rejected while draining")
+	}
+	if report.InFlight != 1 || report.Abandoned != 0 || report.Deleted != 2 || len(rt.deleted) != 2 {
+		t.Fatalf("unexpected drain report: %+v (deleted %v)", report, rt.deleted)
+	}
+	if fs.deployedFunctions["fn"].idleReplicas.size() != 0 {
+		t.Fatalf("want idle pool emptied")
+	}
+	if again := fs.Drain(time.Second); again != report {
+		t.Fatalf("want second drain to return the first report, got %+v", again)
+	}
+}
+
+func Test_DrainKeepsReplicas(t *testing.T) {
+	fs := newDrainTestStore(t, config.Config{DrainReplicas: "keep", UseDatabase: 1})
+	fs.BeginInvocation()
+
+	report := fs.Drain(50 * time.Millisecond)
+	if report.Abandoned != 1 || report.Kept != 2 || report.Deleted != 0 {
+		t.Fatalf("unexpected drain report: %+v", report)
+	}
+	if fs.deployedFunctions["fn"].idleReplicas.size() != 2 {
+		t.Fatalf("want idle replicas left in place")
+	}
+}
+
+func Test_DrainKeepWithoutDatabase(t *testing.T) {
+	rt := &recordingRuntime{}
+	registerTestRuntime(t, "recording", rt)
+	fs := newDrainTestStore(t, config.Config{DrainReplicas: "keep"})
+
+	/* The DB goes away on shutdown, so kept replicas could not be re-adopted */
+	report := fs.Drain(time.Second)
+	if report.Kept != 0 || report.Deleted != 2 || len(rt.deleted) != 2 {
+		t.Fatalf("want replicas deleted without UseDatabase, got %+v (deleted %v)", report, rt.deleted)
+	}
+}
+
+func Test_DrainWaitsForBackgroundSpawns(t *testing.T) {
+	fs := newDrainTestStore(t, config.Config{DrainReplicas: "keep", UseDatabase: 1})
+
+	if !fs.beginSpawn() {
+		t.Fatalf("want spawn accepted before draining")
+	}
+	finished := make(chan struct{})
+	go func() {
+		time.Sleep(100 * time.Millisecond)
+		fs.endSpawn()
+		close(finished)
+	}()
+
+	fs.Drain(5 * time.Second)
+	select {
+	case <-finished:
+	default:
+		t.Fatalf("want drain to wait for the background spawn")
+	}
+	if fs.beginSpawn() {
+		t.Fatalf("want background spawns rejected while draining")
+	}
+	if _, _, err := startReplica(fs, "fn", "recording", true, "test"); err == nil {
+		t.Fatalf("want no replica created while draining")
+	}
+}
+
+func Test_DrainHandlerRequiresAdminToken(t *testing.T) {
+	tests := []struct {
+		name       string
+		adminToken string
+		header     string
+		want       int
+	}{
+		{name: "admin API disabled", adminToken: "", header: "", want: http.StatusForbidden},
+		{name: "missing token", adminToken: "secret", header: "", want: http.StatusForbidden},
+		{name: "wrong token", adminToken: "secret", header: "guess", want: http.StatusForbidden},
+		{name: "valid token", adminToken: "secret", header: "secret", want: http.StatusOK},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			fs := newTestStore(t, config.Config{AdminToken: tc.adminToken, DrainReplicas: "keep"})
+			drained := make(chan struct{})
+			handler := MakeDrainHandler(fs, func() { close(drained) })
+
+			w := httptest.NewRecorder()
+			r := httptest.NewRequest(http.MethodPost, "/admin/drain?timeout=0", nil)
+			if tc.header != "" {
+				r.Header.Set(AdminTokenHeader, tc.header)
+			}
+			handler(w, r)
+
+			if w.Code != tc.want {
+				t.Fatalf("want status %d, got %d", tc.want, w.Code)
+			}
+			if tc.want == http.StatusOK {
+				<-drained
+				if !fs.Draining() {
+					t.Fatalf("want provider draining")
+				}
+			} else if fs.Draining() {
+				t.Fatalf("want provider not draining after rejected request")
+			}
+		})
+	}
+}
@@ -0,0 +1,459 @@
+package handlers
+
+import (
+	"fmt"
+	"sort"
+	"strconv"
+	"sync"
+
+	"github.gatech.edu/faasedge/fecore/pkg/timec"
+)
+
+/* Hybrid policy evaluators. The stats pipeline (ProcessFunctionStats)
+ * periodically re-evaluates the policy of every Hybrid Function: which
+ * sandbox serves cold starts (EvalColdStartPolicy), which serves warm starts
+ * (EvalWarmStartPolicy) and how many additional Replicas are spawned
+ * (EvalSpawnAddlCtrs). The decisions are made by the Function's
+ * PolicyEvaluator, selected with the policyEvaluator label or
+ * /policy?action=update:
+ *   greedy - the sandbox with the lowest average service time (default)
+ *   memory - the sandbox with the lowest memory-time cost (avg service time x
+ *            avg Replica memory); spawns fewer additional Replicas
+ *   slo    - the sandbox using the least memory among those whose average
+ *            service time meets the sloLatency label (ms)
+ *   bandit - explores both sandboxes per invocation (see bandit.go)
+ * Evaluators only see a HybridSnapshot of the stats and return decisions;
+ * the Function Store applies them to the policy. */
+
+const (
+	evaluatorGreedy = "greedy"
+	evaluatorMemory = "memory"
+	evaluatorSLO    = "slo"
+	/* Share of requests in the last epoch served from cold start at or
+	 * below/above which spawnAddlCtrs is decreased/increased */
+	coldRatioLow  = 0.10
+	coldRatioHigh = 0.25
+	/* Share of busy Replicas below which the memory evaluator scales down */
+	memoryUtilLow = 0.5
+)
+
+type SandboxSnapshot struct {
+	AvgSvcCold   int     // ms
+	AvgSvcWarm   int     // ms
+	HedgeWinRate float32 // share of hedged cold starts won (see hedge.go)
+	Hedged       bool    // enough hedged cold starts to go by HedgeWinRate
+	MemoryBytes  uint64  // average memory of a running Replica; 0 if unknown
+	/* Exponentially decayed service times (see bandit.go); 0 if no samples */
+	DecayedSvcCold float64 // ms
+	DecayedSvcWarm float64 // ms
+}
+
+type HybridSnapshot struct {
+	Function         string
+	Native           SandboxSnapshot
+	Wasm             SandboxSnapshot
+	Utilization      float32 // share of the Function's Replicas that are busy
+	ColdRatio        float32 // share of cold starts in the last epoch
+	ColdStartCtrType string  // current policy
+	WarmStartCtrType string
+	SLOLatency       int // ms; 0 = none
+}
+
+/* Decides a Hybrid Function's sandbox choice from its stats. Implementations
+ * must not keep references to the snapshot. */
+type PolicyEvaluator interface {
+	/* Name used in the policyEvaluator label */
+	Name() string
+	/* Sandbox for cold starts and the spawnAddlCtrs to go with it */
+	ColdStart(s HybridSnapshot) (string, int)
+	/* Sandbox for warm starts */
+	WarmStart(s HybridSnapshot) string
+	/* Change of spawnAddlCtrs after an epoch of invocations */
+	SpawnAddlCtrs(s HybridSnapshot) int
+}
+
+var (
+	policyEvaluators   = make(map[string]PolicyEvaluator)
+	policyEvaluatorsMu sync.RWMutex
+)
+
+func init() {
+	RegisterPolicyEvaluator(greedyEvaluator{})
+	RegisterPolicyEvaluator(memoryEvaluator{})
+	RegisterPolicyEvaluator(sloEvaluator{})
+}
+
+/* Makes a PolicyEvaluator available under its name. Registering a name twice
+ * replaces the previous evaluator. */
+func RegisterPolicyEvaluator(ev PolicyEvaluator) {
+	policyEvaluatorsMu.Lock()
+	defer policyEvaluatorsMu.Unlock()
+	policyEvaluators[ev.Name()] = ev
+}
+
+func GetPolicyEvaluator(name string) (PolicyEvaluator, error) {
+	policyEvaluatorsMu.RLock()
+	defer policyEvaluatorsMu.RUnlock()
+	if ev, ok := policyEvaluators[name]; ok {
+		return ev, nil
+	}
+	return nil, fmt.Errorf("[evaluator/GetPolicyEvaluator] No policy evaluator registered as '%s'", name)
+}
+
+/* Returns the names of all registered evaluators */
+func PolicyEvaluatorNames() []string {
+	policyEvaluatorsMu.RLock()
+	defer policyEvaluatorsMu.RUnlock()
+	names := make([]string, 0, len(policyEvaluators))
+	for name := range policyEvaluators {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+/* Reads the policyEvaluator and sloLatency labels into a Function's policy */
+func parseEvaluatorLabels(labels map[string]string, policy *Policy) error {
+	policy.evaluator = evaluatorGreedy
+	policy.sloLatency = 0
+	if v, ok := labels["policyEvaluator"]; ok {
+		if _, err := GetPolicyEvaluator(v); err != nil {
+			return fmt.Errorf("[evaluator/parseEvaluatorLabels] policyEvaluator must be one of %v, got '%s'", PolicyEvaluatorNames(), v)
+		}
+		policy.evaluator = v
+	}
+	if v, ok := labels["sloLatency"]; ok {
+		val, err := strconv.Atoi(v)
+		if err != nil || val < 0 {
+			return fmt.Errorf("[evaluator/parseEvaluatorLabels] sloLatency must be a non-negative number of ms, got '%s'", v)
+		}
+		policy.sloLatency = val
+	}
+	return nil
+}
+
+/* Returns a Function's evaluator; Functions deployed before evaluators were
+ * pluggable use greedy */
+func (p Policy) policyEvaluator() PolicyEvaluator {
+	if ev, err := GetPolicyEvaluator(p.evaluator); err == nil {
+		return ev
+	}
+	return greedyEvaluator{}
+}
+
+func (s HybridSnapshot) sandbox(ctrType string) SandboxSnapshot {
+	if ctrType == "native" {
+		return s.Native
+	}
+	return s.Wasm
+}
+
+/* Whether the stats of fn are those of a Hybrid Function, which drive its
+ * evaluator. Entries of a Hybrid Function carry the ctrType of the sandbox
+ * that served them, so the Function's label is checked instead. */
+func (fs *FunctionStore) isHybrid(fn string) bool {
+	f, ok := fs.lookupFunction(fn)
+	return ok && f.labels["ctrType"] == "hybrid"
+}
+
+/* Collects the stats a PolicyEvaluator decides on for a Hybrid Function */
+func (fs *FunctionStore) hybridSnapshot(fn string) (HybridSnapshot, *Function, bool) {
+	f, ok := fs.lookupFunction(fn)
+	if !ok || f.labels["ctrType"] != "hybrid" {
+		return HybridSnapshot{}, nil, false
+	}
+	nativeDeployment := f.sandboxes["native"]
+	wasmDeployment := f.sandboxes["wasm"]
+	native, nativeOk := fs.sandboxSnapshot(nativeDeployment)
+	wasm, wasmOk := fs.sandboxSnapshot(wasmDeployment)
+	if !nativeOk || !wasmOk {
+		return HybridSnapshot{}, nil, false
+	}
+	native.DecayedSvcCold = f.bandit.mean("cold", "native")
+	native.DecayedSvcWarm = f.bandit.mean("warm", "native")
+	wasm.DecayedSvcCold = f.bandit.mean("cold", "wasm")
+	wasm.DecayedSvcWarm = f.bandit.mean("warm", "wasm")
+	snapshot := HybridSnapshot{Function: fn, Native: native, Wasm: wasm}
+	if stats, ok := fs.lookupStats(fn); ok {
+		stats.statMu.RLock()
+		snapshot.Utilization = stats.sandboxUtil
+		snapshot.ColdRatio = stats.coldRatio
+		stats.statMu.RUnlock()
+	}
+	f.policyMu.RLock()
+	snapshot.ColdStartCtrType = f.policy.coldStartCtrType
+	snapshot.WarmStartCtrType = f.policy.warmStartCtrType
+	snapshot.SLOLatency = f.policy.sloLatency
+	f.policyMu.RUnlock()
+	return snapshot, f, true
+}
+
+func (fs *FunctionStore) sandboxSnapshot(sandbox string) (SandboxSnapshot, bool) {
+	stats, ok := fs.lookupStats(sandbox)
+	if !ok {
+		return SandboxSnapshot{}, false
+	}
+	snapshot := SandboxSnapshot{}
+	snapshot.HedgeWinRate, snapshot.Hedged = fs.hedgeWinRate(sandbox)
+	memory := fs.sampleMemory(sandbox)
+	stats.statMu.Lock()
+	if memory > 0 {
+		stats.avgMemoryBytes = memory
+	}
+	snapshot.AvgSvcCold = stats.avgSvcCold
+	snapshot.AvgSvcWarm = stats.avgSvcWarm
+	snapshot.MemoryBytes = stats.avgMemoryBytes
+	stats.statMu.Unlock()
+	return snapshot, true
+}
+
+/* Average memory of a sandbox Function's running Replicas; 0 if none of
+ * them reports its usage */
+func (fs *FunctionStore) sampleMemory(sandbox string) uint64 {
+	fn, ok := fs.lookupFunction(sandbox)
+	if !ok {
+		return 0
+	}
+	/* Snapshot the pool so the runtime Stats calls don't hold up
+	 * invocations */
+	fn.poolMu.RLock()
+	replicas := fn.idleReplicas.replicas()
+	for _, replica := range fn.activeReplicas {
+		replicas = append(replicas, replica)
+	}
+	fn.poolMu.RUnlock()
+
+	var total, sampled uint64
+	for _, replica := range replicas {
+		rt, err := GetSandboxRuntime(replica.ctrType)
+		if err != nil {
+			continue
+		}
+		if stats, err := rt.Stats(fs, replica); err == nil && stats.MemoryBytes > 0 {
+			total += stats.MemoryBytes
+			sampled += 1
+		}
+	}
+	if sampled == 0 {
+		return 0
+	}
+	return total / sampled
+}
+
+/* Sandbox to use alongside a cold start sandbox: spawning one more Replica
+ * only pays off if warm starts are served by the other sandbox */
+func addlCtrsFor(coldStartCtrType string, s HybridSnapshot) int {
+	if s.WarmStartCtrType != "" && coldStartCtrType != s.WarmStartCtrType {
+		return 1
+	}
+	return 0
+}
+
+/* The lowest average service time wins; for cold starts, once both sandboxes
+ * have enough hedged cold starts, the one that is ready first more often */
+type greedyEvaluator struct{}
+
+func (greedyEvaluator) Name() string { return evaluatorGreedy }
+
+func (greedyEvaluator) ColdStart(s HybridSnapshot) (string, int) {
+	if s.Native.Hedged && s.Wasm.Hedged {
+		if s.Native.HedgeWinRate >= s.Wasm.HedgeWinRate {
+			return "native", 0
+		}
+		return "wasm", 1
+	}
+	if s.Native.AvgSvcCold < s.Wasm.AvgSvcCold {
+		return "native", 0
+	}
+	return "wasm", 1
+}
+
+func (greedyEvaluator) WarmStart(s HybridSnapshot) string {
+	if s.Native.AvgSvcWarm < s.Wasm.AvgSvcWarm {
+		return "native"
+	}
+	return "wasm"
+}
+
+func (greedyEvaluator) SpawnAddlCtrs(s HybridSnapshot) int {
+	/* Cold starts are low; decrease spawnAddlCtrs */
+	if s.ColdRatio <= coldRatioLow {
+		return -1
+	}
+	/* Lots of cold starts; increase spawnAddlCtrs */
+	if s.ColdRatio >= coldRatioHigh {
+		return 1
+	}
+	return 0
+}
+
+/* Minimizes memory held over time: the sandbox with the lower product of
+ * average service time and average Replica memory wins. Falls back to greedy
+ * until both sandboxes have run Replicas. */
+type memoryEvaluator struct{}
+
+func (memoryEvaluator) Name() string { return evaluatorMemory }
+
+func memoryCost(svcTime int, memory uint64) float64 {
+	return float64(svcTime) * float64(memory)
+}
+
+func (memoryEvaluator) ColdStart(s HybridSnapshot) (string, int) {
+	if s.Native.MemoryBytes == 0 || s.Wasm.MemoryBytes == 0 {
+		return greedyEvaluator{}.ColdStart(s)
+	}
+	ctrType := "wasm"
+	if memoryCost(s.Native.AvgSvcCold, s.Native.MemoryBytes) < memoryCost(s.Wasm.AvgSvcCold, s.Wasm.MemoryBytes) {
+		ctrType = "native"
+	}
+	return ctrType, addlCtrsFor(ctrType, s)
+}
+
+func (memoryEvaluator) WarmStart(s HybridSnapshot) string {
+	if s.Native.MemoryBytes == 0 || s.Wasm.MemoryBytes == 0 {
+		return greedyEvaluator{}.WarmStart(s)
+	}
+	if memoryCost(s.Native.AvgSvcWarm, s.Native.MemoryBytes) < memoryCost(s.Wasm.AvgSvcWarm, s.Wasm.MemoryBytes) {
+		return "native"
+	}
+	return "wasm"
+}
+
+/* Only grows while most Replicas are busy, and shrinks as soon as they are
+ * not */
+func (memoryEvaluator) SpawnAddlCtrs(s HybridSnapshot) int {
+	if s.ColdRatio <= coldRatioLow || s.Utilization < memoryUtilLow {
+		return -1
+	}
+	if s.ColdRatio >= coldRatioHigh {
+		return 1
+	}
+	return 0
+}
+
+/* Meets a latency target at the lowest memory: among the sandboxes whose
+ * average service time is within sloLatency, the one using less memory (the
+ * faster one if memory is unknown); if neither meets it, the faster one.
+ * Behaves like greedy without a target. */
+type sloEvaluator struct{}
+
+func (sloEvaluator) Name() string { return evaluatorSLO }
+
+func sloChoice(target int, nativeSvc int, wasmSvc int, s HybridSnapshot) string {
+	nativeMeets := nativeSvc > 0 && nativeSvc <= target
+	wasmMeets := wasmSvc > 0 && wasmSvc <= target
+	switch {
+	case nativeMeets && wasmMeets && s.Native.MemoryBytes > 0 && s.Wasm.MemoryBytes > 0:
+		if s.Native.MemoryBytes < s.Wasm.MemoryBytes {
+			return "native"
+		}
+		return "wasm"
+	case nativeMeets && !wasmMeets:
+		return "native"
+	case wasmMeets && !nativeMeets:
+		return "wasm"
+	}
+	if nativeSvc < wasmSvc {
+		return "native"
+	}
+	return "wasm"
+}
+
+func (sloEvaluator) ColdStart(s HybridSnapshot) (string, int) {
+	if s.SLOLatency == 0 {
+		return greedyEvaluator{}.ColdStart(s)
+	}
+	ctrType := sloChoice(s.SLOLatency, s.Native.AvgSvcCold, s.Wasm.AvgSvcCold, s)
+	return ctrType, addlCtrsFor(ctrType, s)
+}
+
+func (sloEvaluator) WarmStart(s HybridSnapshot) string {
+	if s.SLOLatency == 0 {
+		return greedyEvaluator{}.WarmStart(s)
+	}
+	return sloChoice(s.SLOLatency, s.Native.AvgSvcWarm, s.Wasm.AvgSvcWarm, s)
+}
+
+/* Spawns more Replicas while cold starts miss the target, fewer once cold
+ * starts are rare */
+func (sloEvaluator) SpawnAddlCtrs(s HybridSnapshot) int {
+	if s.SLOLatency == 0 {
+		return greedyEvaluator{}.SpawnAddlCtrs(s)
+	}
+	if s.ColdRatio <= coldRatioLow {
+		return -1
+	}
+	if s.sandbox(s.ColdStartCtrType).AvgSvcCold > s.SLOLatency {
+		return 1
+	}
+	return 0
+}
+
+/* Applies a Hybrid Function's evaluator to its cold start policy */
+func (fs *FunctionStore) EvalColdStartPolicy(fn string) {
+	snapshot, f, ok := fs.hybridSnapshot(fn)
+	if !ok {
+		timec.LogEvent("evaluator/EvalColdStartPolicy", fmt.Sprintf("Function %s is not hybrid. Cannot eval cold start policy.", fn), 3)
+		return
+	}
+	f.policyMu.RLock()
+	ev := f.policy.policyEvaluator()
+	f.policyMu.RUnlock()
+	coldStartCtrType, spawnAddlCtrs := ev.ColdStart(snapshot)
+	timec.LogEvent("evaluator/EvalColdStartPolicy", fmt.Sprintf("%s (%s): native_avgSvcCold=%d wasm_avgSvcCold=%d -> %s (spawnAddlCtrs=%d)", fn, ev.Name(), snapshot.Native.AvgSvcCold, snapshot.Wasm.AvgSvcCold, coldStartCtrType, spawnAddlCtrs), 4)
+
+	/* Update the policy */
+	f.policyMu.Lock()
+	f.policy.coldStartCtrType = coldStartCtrType
+	if coldStartCtrType == f.policy.warmStartCtrType {
+		f.policy.keepaliveColdStartCtr = fs.MAX_KEEPALIVE_TIME
+	}
+	f.policy.spawnAddlCtrs = fs.clampAddlCtrs(spawnAddlCtrs)
+	f.policyMu.Unlock()
+}
+
+/* Applies a Hybrid Function's evaluator to its warm start policy */
+func (fs *FunctionStore) EvalWarmStartPolicy(fn string) {
+	snapshot, f, ok := fs.hybridSnapshot(fn)
+	if !ok {
+		timec.LogEvent("evaluator/EvalWarmStartPolicy", fmt.Sprintf("Function %s is not hybrid. Cannot eval warm start policy.", fn), 3)
+		return
+	}
+	f.policyMu.RLock()
+	ev := f.policy.policyEvaluator()
+	f.policyMu.RUnlock()
+	warmStartCtrType := ev.WarmStart(snapshot)
+	timec.LogEvent("evaluator/EvalWarmStartPolicy", fmt.Sprintf("%s (%s): native_avgSvcWarm=%d wasm_avgSvcWarm=%d -> %s", fn, ev.Name(), snapshot.Native.AvgSvcWarm, snapshot.Wasm.AvgSvcWarm, warmStartCtrType), 4)
+
+	f.policyMu.Lock()
+	f.policy.warmStartCtrType = warmStartCtrType
+	f.policyMu.Unlock()
+}
+
+/* Applies a Hybrid Function's evaluator to spawnAddlCtrs after an epoch */
+func (fs *FunctionStore) EvalSpawnAddlCtrs(fn string) {
+	snapshot, f, ok := fs.hybridSnapshot(fn)
+	if !ok {
+		return
+	}
+	f.policyMu.RLock()
+	ev := f.policy.policyEvaluator()
+	f.policyMu.RUnlock()
+	spawnAddlCtrs := ev.SpawnAddlCtrs(snapshot)
+	timec.LogEvent("evaluator/EvalSpawnAddlCtrs", fmt.Sprintf("Updating %s policy.SpawnAddlCtrs by %d (%s; coldRatio=%.2f, utilization=%.2f)", fn, spawnAddlCtrs, ev.Name(), snapshot.ColdRatio, snapshot.Utilization), 4)
+	f.policyMu.Lock()
+	f.policy.spawnAddlCtrs = fs.clampAddlCtrs(f.policy.spawnAddlCtrs + spawnAddlCtrs)
+	f.policyMu.Unlock()
+}
+
+/* Keeps an evaluated spawnAddlCtrs within the range policy documents accept,
+ * so an evaluated policy can always be stored and applied again */
+func (fs *FunctionStore) clampAddlCtrs(n int) int {
+	if n >= fs.MAX_ADDL_CTRS {
+		n = fs.MAX_ADDL_CTRS - 1
+	}
+	if n < 0 {
+		return 0
+	}
+	return n
+}
@@ -0,0 +1,194 @@
+package handlers
+
+import (
+	"testing"
+	"time"
+
+	"github.gatech.edu/faasedge/fecore/pkg/provider/config"
+)
+
+func Test_parseEvaluatorLabels(t *testing.T) {
+	tests := []struct {
+		name      string
+		labels    map[string]string
+		want      string
+		wantSLO   int
+		wantError bool
+	}{
+		{name: "greedy by default", labels: map[string]string{}, want: evaluatorGreedy},
+		{name: "memory", labels: map[string]string{"policyEvaluator": "memory"}, want: evaluatorMemory},
+		{name: "slo with target", labels: map[string]string{"policyEvaluator": "slo", "sloLatency": "200"}, want: evaluatorSLO, wantSLO: 200},
+		{name: "unknown evaluator", labels: map[string]string{"policyEvaluator": "random"}, wantError: true},
+		{name: "negative target", labels: map[string]string{"sloLatency": "-5"}, wantError: true},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			policy := Policy{}
+			err := parseEvaluatorLabels(tc.labels, &policy)
+			if tc.wantError {
+				if err == nil {
+					t.Fatalf("want error, got evaluator '%s'", policy.evaluator)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("want no error, got: %s", err)
+			}
+			if policy.policyEvaluator().Name() != tc.want || policy.sloLatency != tc.wantSLO {
+				t.Fatalf("want evaluator '%s' (sloLatency=%d), got '%s' (sloLatency=%d)", tc.want, tc.wantSLO, policy.policyEvaluator().Name(), policy.sloLatency)
+			}
+		})
+	}
+}
+
+func Test_PolicyEvaluators(t *testing.T) {
+	const mb = 1 << 20
+	/* Native is faster, WASM uses far less memory */
+	fastNative := HybridSnapshot{
+		Native:           SandboxSnapshot{AvgSvcCold: 300, AvgSvcWarm: 20, MemoryBytes: 100 * mb},
+		Wasm:             SandboxSnapshot{AvgSvcCold: 400, AvgSvcWarm: 30, MemoryBytes: 10 * mb},
+		WarmStartCtrType: "native",
+		ColdStartCtrType: "native",
+	}
+	withSLO := func(s HybridSnapshot, target int) HybridSnapshot {
+		s.SLOLatency = target
+		return s
+	}
+	hedged := fastNative
+	hedged.Native.Hedged, hedged.Native.HedgeWinRate = true, 0.3
+	hedged.Wasm.Hedged, hedged.Wasm.HedgeWinRate = true, 0.7
+	noMemory := fastNative
+	noMemory.Wasm.MemoryBytes = 0
+
+	tests := []struct {
+		name          string
+		evaluator     PolicyEvaluator
+		snapshot      HybridSnapshot
+		wantCold      string
+		wantAddl      int
+		wantWarm      string
+		wantAddlDelta int
+		coldRatio     float32
+		utilization   float32
+	}{
+		{name: "greedy takes the fastest", evaluator: greedyEvaluator{}, snapshot: fastNative, wantCold: "native", wantAddl: 0, wantWarm: "native", coldRatio: 0.3, utilization: 0.9, wantAddlDelta: 1},
+		{name: "greedy prefers hedge winners", evaluator: greedyEvaluator{}, snapshot: hedged, wantCold: "wasm", wantAddl: 1, wantWarm: "native", coldRatio: 0.05, wantAddlDelta: -1},
+		{name: "memory takes the cheapest", evaluator: memoryEvaluator{}, snapshot: fastNative, wantCold: "wasm", wantAddl: 1, wantWarm: "wasm", coldRatio: 0.3, utilization: 0.9, wantAddlDelta: 1},
+		{name: "memory scales down when replicas are idle", evaluator: memoryEvaluator{}, snapshot: fastNative, wantCold: "wasm", wantAddl: 1, wantWarm: "wasm", coldRatio: 0.3, utilization: 0.2, wantAddlDelta: -1},
+		{name: "memory without samples is greedy", evaluator: memoryEvaluator{}, snapshot: noMemory, wantCold: "native", wantAddl: 0, wantWarm: "native", coldRatio: 0.15, utilization: 0.9},
+		{name: "slo takes the cheapest that meets the target", evaluator: sloEvaluator{}, snapshot: withSLO(fastNative, 500), wantCold: "wasm", wantAddl: 1, wantWarm: "wasm", coldRatio: 0.15},
+		{name: "slo takes the only one that meets the target", evaluator: sloEvaluator{}, snapshot: withSLO(fastNative, 350), wantCold: "native", wantAddl: 0, wantWarm: "wasm", coldRatio: 0.15},
+		{name: "slo spawns more while cold starts miss the target", evaluator: sloEvaluator{}, snapshot: withSLO(fastNative, 200), wantCold: "native", wantAddl: 0, wantWarm: "wasm", coldRatio: 0.15, wantAddlDelta: 1},
+		{name: "slo without a target is greedy", evaluator: sloEvaluator{}, snapshot: fastNative, wantCold: "native", wantAddl: 0, wantWarm: "native", coldRatio: 0.15},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			s := tc.snapshot
+			s.ColdRatio = tc.coldRatio
+			s.Utilization = tc.utilization
+			cold, addl := tc.evaluator.ColdStart(s)
+			if cold != tc.wantCold || addl != tc.wantAddl {
+				t.Fatalf("want cold start on %s (spawnAddlCtrs=%d), got %s (%d)", tc.wantCold, tc.wantAddl, cold, addl)
+			}
+			if warm := tc.evaluator.WarmStart(s); warm != tc.wantWarm {
+				t.Fatalf("want warm start on %s, got %s", tc.wantWarm, warm)
+			}
+			if delta := tc.evaluator.SpawnAddlCtrs(s); delta != tc.wantAddlDelta {
+				t.Fatalf("want spawnAddlCtrs change %d, got %d", tc.wantAddlDelta, delta)
+			}
+		})
+	}
+}
+
+func Test_EvalColdStartPolicy(t *testing.T) {
+	tests := []struct {
+		name      string
+		evaluator string
+		wantCold  string
+	}{
+		{name: "greedy", evaluator: evaluatorGreedy, wantCold: "native"},
+		{name: "memory", evaluator: evaluatorMemory, wantCold: "wasm"},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			hybrid := &Function{name: "fn", labels: map[string]string{"ctrType": "hybrid"}, sandboxes: map[string]string{"native": "fn-n", "wasm": "fn-w"}}
+			hybrid.policy.evaluator = tc.evaluator
+			hybrid.policy.coldStartCtrType = "native"
+			hybrid.policy.warmStartCtrType = "native"
+			fs := newTestStore(t, config.Config{}, hybrid, &Function{name: "fn-n"}, &Function{name: "fn-w"})
+			for name, svc := range map[string]int{"fn-n": 300, "fn-w": 400} {
+				stats, _ := fs.lookupStats(name)
+				stats.avgSvcCold = svc
+				stats.avgMemoryBytes = uint64(svc) << 20
+				if name == "fn-w" {
+					stats.avgMemoryBytes = 10 << 20
+				}
+			}
+
+			fs.EvalColdStartPolicy("fn")
+			if got := fs.GetInvocationPolicy("fn").coldStartCtrType; got != tc.wantCold {
+				t.Fatalf("want cold start on %s, got %s", tc.wantCold, got)
+			}
+		})
+	}
+}
+
+/* Evaluator that reports the Functions it decides cold starts for */
+type recordingEvaluator struct {
+	coldStarts chan string
+}
+
+func (ev recordingEvaluator) Name() string { return "recording" }
+func (ev recordingEvaluator) ColdStart(s HybridSnapshot) (string, int) {
+	ev.coldStarts <- s.Function
+	return s.ColdStartCtrType, 0
+}
+func (ev recordingEvaluator) WarmStart(s HybridSnapshot) string  { return s.WarmStartCtrType }
+func (ev recordingEvaluator) SpawnAddlCtrs(s HybridSnapshot) int { return 0 }
+
+func Test_ProcessFunctionStatsRunsEvaluator(t *testing.T) {
+	tests := []struct {
+		name    string
+		fn      string
+		ctrType string
+		wantRun bool
+	}{
+		{name: "hybrid function", fn: "fn", ctrType: "hybrid", wantRun: true},
+		{name: "sandbox of a hybrid function", fn: "fn-n", ctrType: "native"},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			ev := recordingEvaluator{coldStarts: make(chan string, 10)}
+			RegisterPolicyEvaluator(ev)
+			t.Cleanup(func() {
+				policyEvaluatorsMu.Lock()
+				defer policyEvaluatorsMu.Unlock()
+				delete(policyEvaluators, ev.Name())
+			})
+			hybrid := &Function{name: "fn", labels: map[string]string{"ctrType": "hybrid"}, sandboxes: map[string]string{"native": "fn-n", "wasm": "fn-w"}}
+			hybrid.policy.evaluator = ev.Name()
+			fs := newTestStore(t, config.Config{}, hybrid, &Function{name: "fn-n", labels: map[string]string{"ctrType": "native"}}, &Function{name: "fn-w", labels: map[string]string{"ctrType": "wasm"}})
+			go fs.ProcessFunctionStats()
+
+			/* Every 10th cold start re-evaluates the cold start policy */
+			for i := 0; i < 10; i++ {
+				fs.UpdateFunctionStats(tc.fn, tc.ctrType, "fn-n_r_n", 300, 20, "cold")
+			}
+
+			select {
+			case fn := <-ev.coldStarts:
+				if !tc.wantRun || fn != "fn" {
+					t.Fatalf("want evaluator run=%v, got a run for %s", tc.wantRun, fn)
+				}
+			case <-time.After(200 * time.Millisecond):
+				if tc.wantRun {
+					t.Fatalf("want the evaluator to run after 10 cold starts")
+				}
+			}
+		})
+	}
+}
@@ -0,0 +1,146 @@
+package handlers
+
+import (
+	"fmt"
+	"sort"
+	"strconv"
+	"time"
+
+	"github.gatech.edu/faasedge/fecore/pkg/timec"
+)
+
+/* Cross-Function eviction. When a sandbox runtime is at its container limit,
+ * idle Replicas of other Functions keep holding the slots until CleanupDaemon
+ * expires them. Instead of waiting, createReplica reclaims one of those idle
+ * Replicas so the invocation can proceed. The victim is chosen according to
+ * the EvictionPolicy config option:
+ *   - "lru" (default): the Replica idle for the longest time
+ *   - "weighted": idle time divided by the Function's priority label and its
+ *     cold start cost, so cheap low-priority Replicas go first
+ *   - "off": never evict; wait for a slot to free up */
+
+type evictionCandidate struct {
+	fname   string
+	replica *Replica
+	score   float64 // higher is evicted first
+}
+
+type evictionReport struct {
+	Policy     string           `json:"policy"`
+	Total      int64            `json:"total"`
+	ByFunction map[string]int64 `json:"byFunction"`
+}
+
+func (fs *FunctionStore) evictionPolicy() string {
+	if fs.cfg.EvictionPolicy == "" {
+		return "lru"
+	}
+	return fs.cfg.EvictionPolicy
+}
+
+/* Priority of a Function from its priority label; higher is kept longer */
+func functionPriority(labels map[string]string) float64 {
+	if v, ok := labels["priority"]; ok {
+		if p, err := strconv.Atoi(v); err == nil && p > 0 {
+			return float64(p)
+		}
+	}
+	return 1
+}
+
+/* Scores the oldest idle Replica of every Function running on the given
+ * sandbox runtime, skipping the requesting Function and pools at their
+ * minIdle floor */
+func (fs *FunctionStore) evictionCandidates(ctrType string, requester string, now time.Time) []evictionCandidate {
+	floors := fs.warmPoolFloors()
+	weighted := fs.evictionPolicy() == "weighted"
+	candidates := make([]evictionCandidate, 0)
+
+	fs.dfMu.RLock()
+	defer fs.dfMu.RUnlock()
+	for name, fn := range fs.deployedFunctions {
+		if name == requester {
+			continue
+		}
+		fn.poolMu.RLock()
+		replica := fn.idleReplicas.peekLRU()
+		size := fn.idleReplicas.size()
+		var lastAccess time.Time
+		if replica != nil {
+			lastAccess = replica.lastAccess
+		}
+		fn.poolMu.RUnlock()
+		if replica == nil || replica.ctrType != ctrType || size <= floors[name] {
+			continue
+		}
+
+		score := now.Sub(lastAccess).Seconds()
+		if weighted {
+			/* Cold start cost in seconds; a Replica that is expensive to
+			 * bring back is worth keeping longer */
+			var coldStartMs int64
+			if stats, ok := fs.functionStats[name]; ok {
+				stats.statMu.RLock()
+				coldStartMs = stats.avgStartupTime
+				stats.statMu.RUnlock()
+			}
+			score = score / (functionPriority(fn.labels) * (1 + float64(coldStartMs)/1000))
+		}
+		candidates = append(candidates, evictionCandidate{fname: name, replica: replica, score: score})
+	}
+	return candidates
+}
+
+/* Reclaims one idle Replica of another Function that runs on the given
+ * sandbox runtime. Returns true if a Replica was evicted (and its slot
+ * released). */
+func (fs *FunctionStore) EvictIdleReplica(ctrType string, requester string, requestID string) bool {
+	if fs.evictionPolicy() == "off" {
+		return false
+	}
+
+	candidates := fs.evictionCandidates(ctrType, requester, time.Now())
+	sort.Slice(candidates, func(i, j int) bool { return candidates[i].score > candidates[j].score })
+
+	for _, victim := range candidates {
+		fn, ok := fs.lookupFunction(victim.fname)
+		if !ok {
+			continue
+		}
+
+		/* The pool may have changed since it was scored; only evict the
+		 * Replica if it is still the oldest idle one */
+		fn.poolMu.Lock()
+		if fn.idleReplicas.peekLRU() != victim.replica {
+			fn.poolMu.Unlock()
+			continue
+		}
+		fn.idleReplicas.popLRU()
+		fn.poolMu.Unlock()
+
+		err := fs.DeleteReplica(victim.replica)
+		if err != nil {
+			timec.LogEvent("eviction/EvictIdleReplica", fmt.Sprintf("Unable to evict replica '%s': %s <requestID=%s>", victim.replica.uuid, err, requestID), 1)
+			continue
+		}
+
+		fs.evictionMu.Lock()
+		fs.evictions[victim.fname] += 1
+		fs.evictionMu.Unlock()
+		timec.LogEvent("eviction/EvictIdleReplica", fmt.Sprintf("Evicted idle replica '%s' of '%s' (policy=%s, score=%.2f) to make room for '%s' <requestID=%s>", victim.replica.uuid, victim.fname, fs.evictionPolicy(), victim.score, requester, requestID), 2)
+		return true
+	}
+	return false
+}
+
+/* Returns the number of Replicas evicted so far, per victim Function */
+func GetEvictionReport(fs *FunctionStore) evictionReport {
+	report := evictionReport{Policy: fs.evictionPolicy(), ByFunction: make(map[string]int64)}
+	fs.evictionMu.Lock()
+	defer fs.evictionMu.Unlock()
+	for fname, count := range fs.evictions {
+		report.ByFunction[fname] = count
+		report.Total += count
+	}
+	return report
+}
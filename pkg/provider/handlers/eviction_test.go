@@ -0,0 +1,75 @@
+package handlers
+
+import (
+	"sort"
+	"testing"
+	"time"
+
+	"github.gatech.edu/faasedge/fecore/pkg/provider/config"
+)
+
+func newEvictionTestStore(t *testing.T, policy string, now time.Time) *FunctionStore {
+	fs := newTestStore(t, config.Config{EvictionPolicy: policy})
+	functions := []struct {
+		name        string
+		ctrType     string
+		priority    string
+		idleFor     time.Duration
+		coldStartMs int64
+	}{
+		{name: "old-cheap", ctrType: "native", priority: "1", idleFor: 50 * time.Second, coldStartMs: 0},
+		{name: "older-expensive", ctrType: "native", priority: "1", idleFor: 60 * time.Second, coldStartMs: 3000},
+		{name: "oldest-important", ctrType: "native", priority: "10", idleFor: 90 * time.Second, coldStartMs: 0},
+		{name: "wasm", ctrType: "wasm", priority: "1", idleFor: 500 * time.Second, coldStartMs: 0},
+	}
+	for _, f := range functions {
+		fs.deployedFunctions[f.name] = &Function{name: f.name, labels: map[string]string{"priority": f.priority}}
+		fs.initFunctionStats(f.name)
+		fs.functionStats[f.name].avgStartupTime = f.coldStartMs
+		fs.AddIdleReplica(&Replica{fname: f.name, uuid: f.name + "_1", ctrType: f.ctrType})
+		fs.deployedFunctions[f.name].idleReplicas.MRU.lastAccess = now.Add(-f.idleFor)
+	}
+	return fs
+}
+
+func Test_evictionCandidates(t *testing.T) {
+	now := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	tests := []struct {
+		name      string
+		policy    string
+		requester string
+		want      []string
+	}{
+		{name: "lru evicts longest idle first", policy: "lru", requester: "new", want: []string{"oldest-important", "older-expensive", "old-cheap"}},
+		{name: "default policy is lru", policy: "", requester: "new", want: []string{"oldest-important", "older-expensive", "old-cheap"}},
+		{name: "weighted favors cheap low-priority replicas", policy: "weighted", requester: "new", want: []string{"old-cheap", "older-expensive", "oldest-important"}},
+		{name: "requester is never a victim", policy: "lru", requester: "oldest-important", want: []string{"older-expensive", "old-cheap"}},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			fs := newEvictionTestStore(t, tc.policy, now)
+			candidates := fs.evictionCandidates("native", tc.requester, now)
+			sort.Slice(candidates, func(i, j int) bool { return candidates[i].score > candidates[j].score })
+			if len(candidates) != len(tc.want) {
+				t.Fatalf("want %d candidates, got %d", len(tc.want), len(candidates))
+			}
+			for i, want := range tc.want {
+				if candidates[i].fname != want {
+					t.Fatalf("want candidate %d to be '%s', got '%s'", i, want, candidates[i].fname)
+				}
+			}
+		})
+	}
+}
+
+func Test_EvictIdleReplicaOff(t *testing.T) {
+	now := time.Now()
+	fs := newEvictionTestStore(t, "off", now)
+	if fs.EvictIdleReplica("native", "new", "test") {
+		t.Fatalf("want no eviction with EvictionPolicy=off")
+	}
+	if report := GetEvictionReport(fs); report.Total != 0 || report.Policy != "off" {
+		t.Fatalf("want empty report for policy off, got %+v", report)
+	}
+}
@@ -0,0 +1,64 @@
+package handlers
+
+import (
+	"fmt"
+	"time"
+
+	"github.gatech.edu/faasedge/fecore/pkg/timec"
+)
+
+/* Frozen idle Replicas. A Function with idleMode=frozen pauses its Replicas
+ * while they sit in the idle pool, so they stop using CPU and firing timers:
+ * native containers are paused through containerd (cgroup freezer) and runw
+ * processes are stopped with SIGSTOP. GetIdleReplica thaws a frozen Replica
+ * before handing out its IP and reports the invocation as a "thawed" start,
+ * whose setup time (mostly thaw latency) is tracked apart from warm and cold
+ * starts. */
+
+const (
+	idleModeRunning = "running"
+	idleModeFrozen  = "frozen"
+)
+
+/* Reads the idleMode label into a Function's policy */
+func parseIdleModeLabels(labels map[string]string, policy *Policy) error {
+	policy.idleMode = idleModeRunning
+	if v, ok := labels["idleMode"]; ok {
+		if v != idleModeRunning && v != idleModeFrozen {
+			return fmt.Errorf("[freeze/parseIdleModeLabels] idleMode must be '%s' or '%s', got '%s'", idleModeRunning, idleModeFrozen, v)
+		}
+		policy.idleMode = v
+	}
+	return nil
+}
+
+/* Pauses a Replica that is about to enter the idle pool. A Replica that
+ * can't be frozen stays idle running. */
+func (fs *FunctionStore) freezeReplica(replica *Replica) {
+	rt, err := GetSandboxRuntime(replica.ctrType)
+	if err != nil {
+		return
+	}
+	if err := rt.Freeze(fs, replica); err != nil {
+		timec.LogEvent("freeze/freezeReplica", fmt.Sprintf("Unable to freeze replica '%s'; keeping it running: %s", replica.uuid, err), 1)
+		return
+	}
+	replica.frozen.Store(true)
+	timec.LogEvent("freeze/freezeReplica", fmt.Sprintf("Froze idle replica '%s'", replica.uuid), 3)
+}
+
+/* Resumes a frozen Replica */
+func (fs *FunctionStore) thawReplica(replica *Replica, requestID string) error {
+	defer timec.RecordDuration("(freeze.go) thawReplica() <requestID="+requestID+">", time.Now())
+	rt, err := GetSandboxRuntime(replica.ctrType)
+	if err != nil {
+		return err
+	}
+	start := time.Now()
+	if err := rt.Thaw(fs, replica); err != nil {
+		return err
+	}
+	replica.frozen.Store(false)
+	timec.LogEvent("freeze/thawReplica", fmt.Sprintf("Thawed replica '%s' in %d us <requestID=%s>", replica.uuid, time.Since(start).Microseconds(), requestID), 3)
+	return nil
+}
@@ -0,0 +1,150 @@
+package handlers
+
+import (
+	"errors"
+	"sync"
+	"testing"
+
+	"github.gatech.edu/faasedge/fecore/pkg/provider/config"
+)
+
+func Test_parseIdleModeLabels(t *testing.T) {
+	tests := []struct {
+		name    string
+		labels  map[string]string
+		want    string
+		wantErr bool
+	}{
+		{name: "running by default", labels: map[string]string{}, want: idleModeRunning},
+		{name: "running", labels: map[string]string{"idleMode": "running"}, want: idleModeRunning},
+		{name: "frozen", labels: map[string]string{"idleMode": "frozen"}, want: idleModeFrozen},
+		{name: "unknown mode", labels: map[string]string{"idleMode": "asleep"}, wantErr: true},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			policy := Policy{}
+			err := parseIdleModeLabels(tc.labels, &policy)
+			if tc.wantErr {
+				if err == nil {
+					t.Fatalf("want error, got idleMode '%s'", policy.idleMode)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("want no error, got: %s", err)
+			}
+			if policy.idleMode != tc.want {
+				t.Fatalf("want idleMode '%s', got '%s'", tc.want, policy.idleMode)
+			}
+		})
+	}
+}
+
+type freezingRuntime struct {
+	fakeRuntime
+	mu        sync.Mutex
+	frozen    map[string]bool
+	failThaw  bool
+	thaws     int
+	deleted   []string
+	frozenDel []string // replicas that were still frozen when deleted
+}
+
+func (rt *freezingRuntime) Type() string {
+	return "freezing"
+}
+
+func (rt *freezingRuntime) Freeze(fs *FunctionStore, replica *Replica) error {
+	rt.mu.Lock()
+	defer rt.mu.Unlock()
+	rt.frozen[replica.uuid] = true
+	return nil
+}
+
+func (rt *freezingRuntime) Thaw(fs *FunctionStore, replica *Replica) error {
+	rt.mu.Lock()
+	defer rt.mu.Unlock()
+	rt.thaws += 1
+	if rt.failThaw {
+		return errors.New("thaw failed")
+	}
+	rt.frozen[replica.uuid] = false
+	return nil
+}
+
+func (rt *freezingRuntime) Delete(fs *FunctionStore, replica *Replica) error {
+	rt.mu.Lock()
+	defer rt.mu.Unlock()
+	rt.deleted = append(rt.deleted, replica.uuid)
+	if rt.frozen[replica.uuid] {
+		rt.frozenDel = append(rt.frozenDel, replica.uuid)
+	}
+	return nil
+}
+
+func Test_FrozenIdleReplicas(t *testing.T) {
+	tests := []struct {
+		name        string
+		idleMode    string
+		failThaw    bool
+		wantFrozen  bool
+		wantStartup string
+		wantErr     bool
+	}{
+		{name: "running idle replicas are warm started", idleMode: idleModeRunning, wantStartup: "warm"},
+		{name: "frozen idle replicas are thawed", idleMode: idleModeFrozen, wantFrozen: true, wantStartup: "thawed"},
+		{name: "replica that can't be thawed is deleted", idleMode: idleModeFrozen, failThaw: true, wantFrozen: true, wantErr: true},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			rt := &freezingRuntime{frozen: make(map[string]bool), failThaw: tc.failThaw}
+			registerTestRuntime(t, "freezing", rt)
+
+			fn := &Function{name: "fn", activeReplicas: make(map[string]*Replica)}
+			fn.policy.idleMode = tc.idleMode
+			fs := newTestStore(t, config.Config{}, fn)
+			replica := &Replica{fname: "fn", uuid: "a", ctrType: "freezing"}
+			fs.AddIdleReplica(replica)
+			if replica.frozen.Load() != tc.wantFrozen || rt.frozen["a"] != tc.wantFrozen {
+				t.Fatalf("want frozen=%v after returning to the idle pool, got %v", tc.wantFrozen, replica.frozen.Load())
+			}
+
+			name, _, startupType, err := fs.GetIdleReplica("fn", "test")
+			if tc.wantErr {
+				if err == nil {
+					t.Fatalf("want error, got replica '%s'", name)
+				}
+				/* Thawed (best effort) before the sandbox is killed */
+				if len(rt.deleted) != 1 || rt.deleted[0] != "a" || rt.thaws != 2 {
+					t.Fatalf("want replica 'a' deleted after a failed thaw, got deleted=%v thaws=%d", rt.deleted, rt.thaws)
+				}
+				if fn.idleReplicas.size() != 0 || len(fn.activeReplicas) != 0 {
+					t.Fatalf("want replica 'a' out of the pool, got idle=%d active=%d", fn.idleReplicas.size(), len(fn.activeReplicas))
+				}
+				return
+			}
+			if err != nil || name != "a" || startupType != tc.wantStartup {
+				t.Fatalf("want replica 'a' with startup type '%s', got '%s' '%s' (err=%v)", tc.wantStartup, name, startupType, err)
+			}
+			if replica.frozen.Load() || rt.frozen["a"] {
+				t.Fatalf("want replica 'a' running while active")
+			}
+
+			/* Frozen again once it is back in the idle pool */
+			if err := fs.UpdateReplicaStatusInactive("fn", "a", "test"); err != nil {
+				t.Fatalf("want no error, got: %s", err)
+			}
+			if replica.frozen.Load() != tc.wantFrozen {
+				t.Fatalf("want frozen=%v after the invocation, got %v", tc.wantFrozen, replica.frozen.Load())
+			}
+
+			/* A frozen sandbox is resumed before it is deleted */
+			fs.DeleteReplica(replica)
+			if len(rt.frozenDel) != 0 {
+				t.Fatalf("want replica 'a' thawed before delete, got frozen deletes %v", rt.frozenDel)
+			}
+		})
+	}
+}
@@ -3,21 +3,18 @@ package handlers
 import (
 	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"net"
 	"os"
-	"strings"
 	"sync"
+	"sync/atomic"
 	"time"
 
 	"github.com/containerd/containerd"
-	"github.com/containerd/containerd/namespaces"
 	gocni "github.com/containerd/go-cni"
-	fecore "github.gatech.edu/faasedge/fecore/pkg"
-	cninetwork "github.gatech.edu/faasedge/fecore/pkg/cninetwork"
 	"github.gatech.edu/faasedge/fecore/pkg/provider/config"
 	"github.gatech.edu/faasedge/fecore/pkg/provider/storage"
-	"github.gatech.edu/faasedge/fecore/pkg/service"
 	"github.gatech.edu/faasedge/fecore/pkg/timec"
 )
 
@@ -26,6 +23,9 @@ import (
  * All write functions should update the storage
  */
 
+/* Returned (wrapped) when a Function isn't deployed */
+var ErrFunctionNotFound = errors.New("function not found")
+
 /* In-memory map for keeping track of functions that have been created */
 type FunctionStore struct {
 	deployedFunctions map[string]*Function
@@ -40,6 +40,9 @@ type FunctionStore struct {
 
 	MAX_ADDL_CTRS      int
 	MAX_KEEPALIVE_TIME int
+	MAX_IDLE_CTRS      int
+
+	MAX_REPLICA_CONCURRENCY int
 
 	nextIP             net.IP
 	containerCount     int64
@@ -56,6 +59,31 @@ type FunctionStore struct {
 
 	statsChan chan FunctionStat
 
+	/* Begin warm pool */
+	warmPoolMu        sync.Mutex
+	warmPoolPendingMu sync.Mutex
+	warmPoolPending   map[string]int // replicas being spawned per pool
+	/* End warm pool */
+
+	evictionMu sync.Mutex
+	evictions  map[string]int64 // idle replicas evicted per Function
+
+	snapshotMu   sync.Mutex
+	lastSnapshot map[string]int64 // totalInvocations at the last stats snapshot (see stats_snapshot.go)
+
+	/* Begin drain */
+	draining      atomic.Bool
+	invocations   atomic.Int64 // invocations accepted by the proxy and not yet finished
+	pendingResets atomic.Int64 // replicas being reset after an invocation (see isolation.go)
+	spawns        atomic.Int64 // replicas spawned outside of an invocation (SPAWN_ADDL, hedge losers)
+	drainMu       sync.Mutex
+	drained       *drainReport
+	/* End drain */
+
+	livenessMu    sync.Mutex
+	replicaDeaths map[string]int64    // replicas that died unexpectedly per Function
+	replicaExits  []replicaExitRecord // most recent unexpected exits
+
 	/* Begin mutexes */
 	mu        sync.RWMutex // Added a rw mutex and things like reading the whole map require a global map anyways, TODO check if there are other strategies
 	metricMu  sync.RWMutex
@@ -87,6 +115,13 @@ func InitFunctionStore(storageManager storage.StorageManager, fecoreConfig confi
 		statsChan:          make(chan FunctionStat, 100),
 		MAX_ADDL_CTRS:      5,
 		MAX_KEEPALIVE_TIME: 60,
+		MAX_IDLE_CTRS:      20,
+		warmPoolPending:    make(map[string]int),
+		evictions:          make(map[string]int64),
+		replicaDeaths:      make(map[string]int64),
+		lastSnapshot:       make(map[string]int64),
+
+		MAX_REPLICA_CONCURRENCY: 64,
 	}
 
 	fs.cfg = fecoreConfig
@@ -96,24 +131,30 @@ func InitFunctionStore(storageManager storage.StorageManager, fecoreConfig confi
 		return nil, err
 	}
 
+	/* Replicas are re-adopted by Reconcile once containerd and the WASM
+	 * network namespaces are available */
 	for _, sFn := range sFns {
 		fn := getFunction(&sFn)
-		cns, err := storageManager.GetContainersForFunction(fn.name)
-		if err != nil {
-			return nil, err
+		if err := fs.configureFunction(&fn, fn.labels); err != nil {
+			timec.LogEvent("function_store/InitFunctionStore", fmt.Sprintf("Unable to restore policy of Function '%s': %s", fn.name, err), 1)
 		}
-		for _, c := range cns {
-			replica := Replica{}
-			replica.fname = fn.name
-			replica.ctrType = "" // TODO: Populate this from DB
-			replica.uuid = c.Name
-			replica.PID = 1 // TODO: Populate this from DB
-			replica.IP = c.Ip
-			replica.lastAccess = time.Now()
-			fs.AddIdleReplica(&replica)
+		if fn.labels["ctrType"] == "wasm" {
+			files, _ := os.ReadDir(fn.image + "/rootfs")
+			for _, file := range files {
+				fn.imageFiles = append(fn.imageFiles, file.Name())
+			}
 		}
 		fs.deployedFunctions[fn.name] = &fn
+		fs.initFunctionStats(fn.name)
+		timec.LogEvent("function_store/InitFunctionStore", fmt.Sprintf("Restored Function '%s' in namespace '%s'", fn.name, fn.namespace), 2)
+	}
+	fs.restorePolicies()
+	if fs.cfg.PolicyDir != "" {
+		if err := fs.loadPolicyDir(fs.cfg.PolicyDir); err != nil {
+			timec.LogEvent("function_store/InitFunctionStore", fmt.Sprintf("Unable to load policy documents from %s: %s", fs.cfg.PolicyDir, err), 1)
+		}
 	}
+	fs.restoreStatsSnapshots()
 
 	fs.nextIP = net.IPv4(10, 62, 0, 1)
 
@@ -136,42 +177,48 @@ func (fs *FunctionStore) CleanupDaemon(client *containerd.Client, cni gocni.CNI)
 		return
 	}
 	defer fs.cleanupMu.Unlock()
+	if fs.Draining() {
+		return
+	}
 
 	currTs := time.Now()
+	floors := fs.warmPoolFloors()
+	windows := fs.keepAliveWindows()
+	extensions := fs.sloKeepAlive()
+	fixedExpiration := time.Duration(fs.cfg.ContainerExpirationTime) * time.Second
 
-	for name, fn := range fs.deployedFunctions {
+	fns, _ := fs.GetDeployedFunctions()
+	for _, fn := range fns {
 		var cleanupCount int
-		fn.idleReplicasLock.Lock()
+		minIdle := floors[fn.name]
+		window := windows[fn.name].extended(extensions[fn.name])
+		expiration := fixedExpiration + extensions[fn.name]
+		fn.poolMu.Lock()
 
+		/* Idle replicas are ordered by last access, so stop at the first one
+		 * that hasn't expired */
 		for cleanupCount = 0; fn.idleReplicas.LRU != nil; cleanupCount++ {
 			replica := fn.idleReplicas.LRU
-			if currTs.Sub(replica.lastAccess).Seconds() >= float64(fs.cfg.ContainerExpirationTime) {
-				timec.LogEvent("function_store/CleanupDaemon", fmt.Sprintf("Removing expired replica '%s'", replica.uuid), 2)
-				// Remove from idle replicas list
-				fs.RemoveIdleReplica(fn.name, "Cleanup")
-				fn.idleReplicasLock.Unlock()
-				// Delete the actual replica container
-				fs.DeleteReplica(replica)
-				// Grace period to avoid bogging down the system with deletes
-				time.Sleep(10 * time.Millisecond)
-				fn.idleReplicasLock.Lock()
-			} else {
+			if fn.idleReplicas.size() <= minIdle {
 				break
 			}
-		}
-		// See if MRU expired
-		if fn.idleReplicas.MRU != nil {
-			replica := fn.idleReplicas.MRU
-			if currTs.Sub(replica.lastAccess).Seconds() >= float64(fs.cfg.ContainerExpirationTime) {
-				timec.LogEvent("function_store/CleanupDaemon", fmt.Sprintf("Removing expired MRU replica '%s'", replica.uuid), 2)
-				fn.idleReplicas.MRU = nil
-				fs.DeleteReplica(replica)
+			if !window.expired(replica, currTs, expiration) {
+				break
 			}
+			timec.LogEvent("function_store/CleanupDaemon", fmt.Sprintf("Removing expired replica '%s'", replica.uuid), 2)
+			// Remove from idle replicas list
+			fn.idleReplicas.popLRU()
+			fn.poolMu.Unlock()
+			// Delete the actual replica container
+			fs.DeleteReplica(replica)
+			// Grace period to avoid bogging down the system with deletes
+			time.Sleep(10 * time.Millisecond)
+			fn.poolMu.Lock()
 		}
 
-		fn.idleReplicasLock.Unlock()
+		fn.poolMu.Unlock()
 		if cleanupCount > 0 {
-			timec.LogEvent("function_store/CleanupDaemon", fmt.Sprintf("Cleaned up %d expired replicas for Function '%s'", cleanupCount, name), 2)
+			timec.LogEvent("function_store/CleanupDaemon", fmt.Sprintf("Cleaned up %d expired replicas for Function '%s'", cleanupCount, fn.name), 2)
 		}
 	}
 }
@@ -213,7 +260,7 @@ func (fs *FunctionStore) GetDeployedFunction(name string, tmp *Function, request
 		tmp.createdAt = fn.createdAt
 		return nil
 	}
-	return fmt.Errorf("[GetDeployedFunction] Unable to get function '%s' from FunctionStore", name)
+	return fmt.Errorf("[GetDeployedFunction] Unable to get function '%s' from FunctionStore: %w", name, ErrFunctionNotFound)
 }
 
 /* Add deployed function to the store */
@@ -228,10 +275,17 @@ func (fs *FunctionStore) AddDeployedFunction(fn *Function) error {
 			return err
 		}
 	}
+	/* Stats first, so a visible Function always has them */
+	fs.initFunctionStats(fn.name)
 	fs.dfMu.Lock()
 	fs.deployedFunctions[fn.name] = fn
 	fs.dfMu.Unlock()
 
+	timec.LogEvent("function_store/AddDeployedFunction", fmt.Sprintf("Added deployed Function '%s' in namespace '%s'", fn.name, fn.namespace), 2)
+	return nil
+}
+
+func (fs *FunctionStore) initFunctionStats(name string) {
 	/* Begin init stats for this fn */
 	fnStats := FunctionStats{}
 	fnStats.entryPos = 0
@@ -251,11 +305,10 @@ func (fs *FunctionStore) AddDeployedFunction(fn *Function) error {
 	fnStats.activeCount = 0
 	fnStats.idleCount = 0
 	fnStats.statMu = sync.RWMutex{}
-	fs.functionStats[fn.name] = &fnStats
+	fs.dfMu.Lock()
+	fs.functionStats[name] = &fnStats
+	fs.dfMu.Unlock()
 	/* End init stats for this fn */
-
-	timec.LogEvent("function_store/AddDeployedFunction", fmt.Sprintf("Added deployed Function '%s' in namespace '%s'", fn.name, fn.namespace), 2)
-	return nil
 }
 
 /* Updates a function's metadata in DB */
@@ -277,6 +330,8 @@ func (fs *FunctionStore) RemoveDeployedFunction(name string) error {
 	for _, c := range getContainers(fn) {
 		fs.storageManager.DeleteContainer(c.Name)
 	}
+	fs.storageManager.DeletePolicy(name)
+	fs.forgetStatsSnapshot(name)
 
 	fs.dfMu.Lock()
 	defer fs.dfMu.Unlock()
@@ -306,119 +361,121 @@ func (fs *FunctionStore) GetFunctionImage(name string) (image string, imageFiles
 	return "", nil, fmt.Errorf("[GetFunctionImage] Unable to get image name for Function '%s'", name)
 }
 
-/* Add a Replica to the collection of IdleReplicas for a Function */
+/* Add a Replica to the collection of IdleReplicas for a Function. The
+ * Replica is frozen first if the Function's idleMode is frozen. */
 func (fs *FunctionStore) AddIdleReplica(replica *Replica) error {
-	replica.lastAccess = time.Now()
-	fs.deployedFunctions[replica.fname].idleReplicasLock.Lock()
-	defer fs.deployedFunctions[replica.fname].idleReplicasLock.Unlock()
-
-	if fs.deployedFunctions[replica.fname].idleReplicas.MRU == nil {
-		fs.deployedFunctions[replica.fname].idleReplicas.MRU = replica
-		fs.deployedFunctions[replica.fname].idleReplicas.count += 1
-		return nil
+	fn, ok := fs.lookupFunction(replica.fname)
+	if !ok {
+		return fmt.Errorf("[function_store/AddIdleReplica] Unable to locate function %s", replica.fname)
 	}
-
-	if fs.deployedFunctions[replica.fname].idleReplicas.LRU == nil &&
-		len(fs.deployedFunctions[replica.fname].idleReplicas.containers) == 0 {
-		fs.deployedFunctions[replica.fname].idleReplicas.LRU = fs.deployedFunctions[replica.fname].idleReplicas.MRU
-		fs.deployedFunctions[replica.fname].idleReplicas.MRU = replica
-		fs.deployedFunctions[replica.fname].idleReplicas.count += 1
-		return nil
+	if !replica.frozen.Load() && fs.GetInvocationPolicy(replica.fname).idleMode == idleModeFrozen {
+		fs.freezeReplica(replica)
 	}
-
-	// Push current MRU to containers[] slice
-	fs.deployedFunctions[replica.fname].idleReplicas.containers = append(fs.deployedFunctions[replica.fname].idleReplicas.containers, fs.deployedFunctions[replica.fname].idleReplicas.MRU)
-	// Replace current MRU with replica
-	fs.deployedFunctions[replica.fname].idleReplicas.MRU = replica
-	fs.deployedFunctions[replica.fname].idleReplicas.count += 1
+	fn.poolMu.Lock()
+	defer fn.poolMu.Unlock()
+	replica.lastAccess = time.Now()
+	fn.idleReplicas.pushMRU(replica)
 	return nil
 }
 
-/* Gets the container name of an idle (warm) Function replica and sets the
- * container replica to Active */
-func (fs *FunctionStore) GetIdleReplica(fn string, requestID string) (string, string, error) {
+/* Gets the container name and IP of an idle (warm) Function replica, sets
+ * the container replica to Active and returns the startup type: "warm", or
+ * "thawed" if the replica was frozen. If the Function allows several
+ * invocations per replica, an active replica with spare capacity is handed
+ * out first. */
+func (fs *FunctionStore) GetIdleReplica(fname string, requestID string) (string, string, string, error) {
 	defer timec.RecordDuration("(function_store.go) GetIdleReplica() <requestID="+requestID+">", time.Now())
-	timec.LogEvent("function_store/GetIdleReplica", fmt.Sprintf("Looking for idle replica for '%s' <requestID=%s>", fn, requestID), 2)
-	fs.deployedFunctions[fn].idleReplicasLock.Lock()
-	defer fs.deployedFunctions[fn].idleReplicasLock.Unlock()
-
-	if fs.deployedFunctions[fn].idleReplicas.MRU == nil {
-		timec.LogEvent("function_store/GetIdleReplica", fmt.Sprintf("No idle replicas available for '%s' <requestID=%s>", fn, requestID), 2)
-		return "", "", fmt.Errorf("[function_store/GetIdleReplica] No idle replicas available for '%s' <requestID=%s>", fn, requestID)
-	}
-
-	var name string
-	var ip string
-	recycledContainer := fs.deployedFunctions[fn].idleReplicas.MRU
-	name = recycledContainer.uuid
-	ip = recycledContainer.IP
-
-	fs.deployedFunctions[fn].activeReplicasLock.Lock()
-	fs.deployedFunctions[fn].activeReplicas[name] = recycledContainer
-	fs.deployedFunctions[fn].activeReplicasLock.Unlock()
-
-	idleReplicasLen := len(fs.deployedFunctions[fn].idleReplicas.containers)
-	if idleReplicasLen > 0 {
-		// If there's still a container in the slice, assign it to MRU
-		fs.deployedFunctions[fn].idleReplicas.MRU = fs.deployedFunctions[fn].idleReplicas.containers[idleReplicasLen-1]
-		fs.deployedFunctions[fn].idleReplicas.count -= 1
-		fs.deployedFunctions[fn].idleReplicas.containers = fs.deployedFunctions[fn].idleReplicas.containers[:idleReplicasLen-1]
-	} else {
-		// Otherwise, assign MRU to nil since there are no more idle containers
-		fs.deployedFunctions[fn].idleReplicas.MRU = nil
-		fs.deployedFunctions[fn].idleReplicas.count = 0
+	timec.LogEvent("function_store/GetIdleReplica", fmt.Sprintf("Looking for idle replica for '%s' <requestID=%s>", fname, requestID), 2)
+	fn, ok := fs.lookupFunction(fname)
+	if !ok {
+		return "", "", "", fmt.Errorf("[function_store/GetIdleReplica] Unable to locate function %s <requestID=%s>", fname, requestID)
+	}
+	policy := fs.GetInvocationPolicy(fname)
+	concurrency := policy.concurrency()
+
+	for {
+		replica := fn.checkoutReplica(concurrency, policy.maxInvocations, requestID)
+		if replica == nil {
+			timec.LogEvent("function_store/GetIdleReplica", fmt.Sprintf("No idle replicas available for '%s' <requestID=%s>", fname, requestID), 2)
+			return "", "", "", fmt.Errorf("[function_store/GetIdleReplica] No idle replicas available for '%s' <requestID=%s>", fname, requestID)
+		}
+		if !replica.frozen.Load() {
+			return replica.uuid, replica.IP, "warm", nil
+		}
+		/* Shared Replicas are never frozen, so nobody else is using it yet */
+		if err := fs.thawReplica(replica, requestID); err != nil {
+			timec.LogEvent("function_store/GetIdleReplica", fmt.Sprintf("Unable to thaw replica '%s'; deleting it: %s <requestID=%s>", replica.uuid, err, requestID), 1)
+			fs.purgeReplica(replica)
+			fs.DeleteReplica(replica)
+			continue
+		}
+		return replica.uuid, replica.IP, "thawed", nil
 	}
-
-	return name, ip, nil
 }
 
-/* Removes idle replica from idleReplicas.containers[]
- * 	- Caller must hold idleReplicasLock when calling this function
- * 	- Caller must call DeleteReplica() to fully remove the replica */
-func (fs *FunctionStore) RemoveIdleReplica(fn string, requestID string) {
+/* Hands out an active Replica with spare capacity (if concurrency > 1) or
+ * moves the MRU idle Replica to activeReplicas. Returns nil if there is
+ * neither. */
+func (fn *Function) checkoutReplica(concurrency int, maxInvocations int, requestID string) *Replica {
+	fn.poolMu.Lock()
+	defer fn.poolMu.Unlock()
+
+	if concurrency > 1 {
+		shared := fn.sharedReplica(concurrency, maxInvocations)
+		if shared != nil {
+			shared.inflight += 1
+			shared.accessCount += 1
+			shared.lastAccess = time.Now()
+			timec.LogEvent("function_store/GetIdleReplica", fmt.Sprintf("Sharing active replica '%s' (%d/%d in-flight) for '%s' <requestID=%s>", shared.uuid, shared.inflight, concurrency, fn.name, requestID), 3)
+			return shared
+		}
+	}
 
-	if len(fs.deployedFunctions[fn].idleReplicas.containers) > 0 {
-		fs.deployedFunctions[fn].idleReplicas.LRU = fs.deployedFunctions[fn].idleReplicas.containers[0]
-		fs.deployedFunctions[fn].idleReplicas.containers = fs.deployedFunctions[fn].idleReplicas.containers[1:]
-		fs.deployedFunctions[fn].idleReplicas.count -= 1
-	} else {
-		fs.deployedFunctions[fn].idleReplicas.LRU = nil
-		fs.deployedFunctions[fn].idleReplicas.count = 0
+	replica := fn.idleReplicas.popMRU()
+	if replica == nil {
+		return nil
 	}
+	replica.inflight = 1
+	replica.accessCount += 1
+	fn.activeReplicas[replica.uuid] = replica
+	return replica
 }
 
 /* Add a Replica to the collection of ActiveReplicas for a Function */
 func (fs *FunctionStore) AddActiveReplica(replica *Replica) error {
-	fs.deployedFunctions[replica.fname].activeReplicasLock.Lock()
-	defer fs.deployedFunctions[replica.fname].activeReplicasLock.Unlock()
-	fs.deployedFunctions[replica.fname].activeReplicas[replica.uuid] = replica
-
-	fs.functionStats[replica.fname].statMu.Lock()
-	fs.functionStats[replica.fname].activeCount += 1
-	fs.functionStats[replica.fname].statMu.Unlock()
+	fn, ok := fs.lookupFunction(replica.fname)
+	if !ok {
+		return fmt.Errorf("[function_store/AddActiveReplica] Unable to locate function %s", replica.fname)
+	}
+	fn.poolMu.Lock()
+	replica.inflight = 1
+	replica.accessCount += 1
+	fn.activeReplicas[replica.uuid] = replica
+	fn.poolMu.Unlock()
 
+	if stats, ok := fs.lookupStats(replica.fname); ok {
+		stats.statMu.Lock()
+		stats.activeCount += 1
+		stats.statMu.Unlock()
+	}
 	return nil
 }
 
 /* Deletes all Replicas for a Function */
-func (fs *FunctionStore) DeleteAllReplicas(ctx context.Context, client *containerd.Client, cni gocni.CNI, fn string) error {
+func (fs *FunctionStore) DeleteAllReplicas(ctx context.Context, client *containerd.Client, cni gocni.CNI, fname string) error {
+	fn, ok := fs.lookupFunction(fname)
+	if !ok {
+		return fmt.Errorf("[function_store/DeleteAllReplicas] Unable to locate function %s", fname)
+	}
 	/* Delete Idle Replicas */
-	fs.deployedFunctions[fn].idleReplicasLock.Lock()
-	defer fs.deployedFunctions[fn].idleReplicasLock.Unlock()
-	// TODO: Update this so we handle empty idleReplicas more gracefully
-	if fs.deployedFunctions[fn].idleReplicas.count <= 0 {
-		return nil
+	fn.poolMu.Lock()
+	idle := make([]*Replica, 0, fn.idleReplicas.size())
+	for replica := fn.idleReplicas.popLRU(); replica != nil; replica = fn.idleReplicas.popLRU() {
+		idle = append(idle, replica)
 	}
-	// Delete all Replicas stored in containers slice
-	for _, replica := range fs.deployedFunctions[fn].idleReplicas.containers {
-		switch replica.ctrType {
-		case "native":
-			fs.DeleteNativeReplica(ctx, client, cni, replica)
-		case "wasm":
-			fs.DeleteWasmReplica(ctx, client, cni, replica)
-		default:
-			timec.LogEvent("function_store/DeleteAllReplicas", fmt.Sprintf("Could not find matching delete operation for Replica '%s' with ctrType '%s'\n", replica.uuid, replica.ctrType), 1)
-		}
+	fn.poolMu.Unlock()
+	for _, replica := range idle {
+		fs.DeleteReplica(replica)
 	}
 	/* TODO: Code for Delete Active Replicas */
 	/* One consideration: How do we handle response to clients if we kill an active replica before it finishes execution? */
@@ -429,19 +486,23 @@ func (fs *FunctionStore) DeleteAllReplicas(ctx context.Context, client *containe
 func (fs *FunctionStore) UpdateReplicaCount(fn string, status string, count int) {
 	var currActive int
 	var currIdle int
+	stats, ok := fs.lookupStats(fn)
+	if !ok {
+		return
+	}
 	switch status {
 	case "idle":
-		fs.functionStats[fn].statMu.Lock()
-		fs.functionStats[fn].idleCount += count
-		currIdle = fs.functionStats[fn].idleCount
-		currActive = fs.functionStats[fn].activeCount
-		fs.functionStats[fn].statMu.Unlock()
+		stats.statMu.Lock()
+		stats.idleCount += count
+		currIdle = stats.idleCount
+		currActive = stats.activeCount
+		stats.statMu.Unlock()
 	case "active":
-		fs.functionStats[fn].statMu.Lock()
-		fs.functionStats[fn].activeCount += count
-		currIdle = fs.functionStats[fn].idleCount
-		currActive = fs.functionStats[fn].activeCount
-		fs.functionStats[fn].statMu.Unlock()
+		stats.statMu.Lock()
+		stats.activeCount += count
+		currIdle = stats.idleCount
+		currActive = stats.activeCount
+		stats.statMu.Unlock()
 	}
 	timec.LogEvent("function_store/UpdateReplicaCount", fmt.Sprintf("<%s> active=%d, idle=%d", fn, currActive, currIdle), 3)
 }
@@ -461,7 +522,7 @@ func (fs *FunctionStore) AddWasmContainerCount() bool {
 func (fs *FunctionStore) AddContainerCount() bool {
 	fs.ccMu.Lock()
 	defer fs.ccMu.Unlock()
-	if fs.containerCount < 1000 {
+	if fs.containerCount < maxNativeContainers {
 		fs.containerCount += 1
 		timec.LogEvent("function_store/AddContainerCount", fmt.Sprintf("Native container count: %d", fs.containerCount), 3)
 		return true
@@ -493,80 +554,28 @@ func (fs *FunctionStore) DelWasmContainerCount() bool {
 }
 
 func (fs *FunctionStore) DeleteReplica(replica *Replica) error {
-	ctx := namespaces.WithNamespace(context.Background(), fecore.DefaultFunctionNamespace)
-	cni := *fs.CNI
-	client := fs.Client
-	switch replica.ctrType {
-	case "native":
-		fs.DeleteNativeReplica(ctx, client, cni, replica)
-	case "wasm":
-		fs.DeleteWasmReplica(ctx, client, cni, replica)
-	default:
-		timec.LogEvent("function_store/DeleteReplica", fmt.Sprintf("Could not find matching delete operation for Replica '%s' with ctrType '%s'\n", replica.uuid, replica.ctrType), 1)
-	}
-	return nil
-}
-
-func (fs *FunctionStore) DeleteNativeReplica(ctx context.Context, client *containerd.Client, cni gocni.CNI, replica *Replica) error {
-	name := replica.uuid
-	networkErr := cninetwork.DeleteCNINetwork(ctx, cni, client, name)
-	if networkErr != nil {
-		timec.LogEvent("function_store/DeleteNativeReplica", fmt.Sprintf("Error removing network for Function '%s': %s", name, networkErr), 1)
-	}
-	containerErr := service.Remove(ctx, client, name)
-	if containerErr != nil {
-		timec.LogEvent("function_store/DeleteNativeReplica", fmt.Sprintf("Error removing replica container '%s': %s", name, containerErr), 1)
-		return containerErr
-	}
-	fs.DelContainerCount()
-	return nil
-}
-
-func (fs *FunctionStore) DeleteWasmReplica(ctx context.Context, client *containerd.Client, cni gocni.CNI, replica *Replica) error {
-	fn := replica.fname
-	image := fs.deployedFunctions[fn].image
-	name := replica.uuid
-	pid := int(replica.PID)
-	networkErr := cninetwork.DeleteWasmCNINetwork(ctx, cni, client, replica.uuid, int(pid))
-	if networkErr != nil {
-		timec.LogEvent("function_store/DeleteWasmReplica", fmt.Sprintf("Error removing network for Function '%s': %s", name, networkErr), 1)
-	}
-	proc, err := os.FindProcess(pid)
-	if err != nil {
-		timec.LogEvent("function_store/DeleteWasmReplica", fmt.Sprintf("Could not find WASM container process (PID=%d)", pid), 1)
-		return err
-	} else {
-		proc.Kill()
-		timec.LogEvent("function_store/DeleteWasmReplica", fmt.Sprintf("Killed WASM container process (PID=%d)", pid), 2)
+	/* The liveness watcher may already be purging a Replica that died */
+	if !replica.terminating.CompareAndSwap(false, true) {
+		return nil
 	}
-	err = os.RemoveAll(image + "/replicas/" + name)
+	fs.forgetReplica(replica)
+	rt, err := GetSandboxRuntime(replica.ctrType)
 	if err != nil {
-		timec.LogEvent("function_store/DeleteWasmReplica", fmt.Sprintf("Could not delete rootfs for WASM replica '%s'", name), 1)
+		timec.LogEvent("function_store/DeleteReplica", fmt.Sprintf("Could not find matching delete operation for Replica '%s' with ctrType '%s'\n", replica.uuid, replica.ctrType), 1)
 		return err
-	} else {
-		timec.LogEvent("function_store/DeleteWasmReplica", fmt.Sprintf("Deleted rootfs for WASM replica '%s'", name), 2)
 	}
-
-	/* Remove wasm replica's cgroups */
-	wasm_cg_cpu_path := "/sys/fs/cgroup/cpu/fewasm"
-	wasm_cg_mem_path := "/sys/fs/cgroup/memory/fewasm"
-
-	/* Add wasmPid to its own cpu cgroup */
-	cg_cpu_err := os.RemoveAll(wasm_cg_cpu_path + "/" + name)
-	if cg_cpu_err != nil {
-		timec.LogEvent("function_store/DeleteWasmReplica", fmt.Sprintf("Unable to remove CPU cgroup for %s", name), 1)
-	} else {
-		timec.LogEvent("function_store/DeleteWasmReplica", fmt.Sprintf("Deleted CPU cgroup for %s", name), 2)
-	}
-	cg_mem_err := os.RemoveAll(wasm_cg_mem_path + "/" + name)
-	if cg_mem_err != nil {
-		timec.LogEvent("function_store/DeleteWasmReplica", fmt.Sprintf("Unable to remove MEMORY cgroup for %s", name), 1)
-	} else {
-		timec.LogEvent("function_store/DeleteWasmReplica", fmt.Sprintf("Deleted MEMORY cgroup for %s", name), 2)
+	/* Let a reset in progress finish, so it can't start a new sandbox for
+	 * a Replica that is already torn down (see isolation.go) */
+	replica.resetMu.Lock()
+	defer replica.resetMu.Unlock()
+	/* A paused sandbox can't handle the kill signal */
+	if replica.frozen.Load() {
+		if err := rt.Thaw(fs, replica); err != nil {
+			timec.LogEvent("function_store/DeleteReplica", fmt.Sprintf("Unable to thaw replica '%s' before deleting it: %s", replica.uuid, err), 1)
+		}
+		replica.frozen.Store(false)
 	}
-
-	fs.DelWasmContainerCount()
-	return nil
+	return rt.Delete(fs, replica)
 }
 
 /* Change container from active to inactive
@@ -575,27 +584,37 @@ func (fs *FunctionStore) DeleteWasmReplica(ctx context.Context, client *containe
 func (fs *FunctionStore) UpdateReplicaStatusInactive(fn string, replicaName string, requestID string) error {
 	defer timec.RecordDuration("(function_store.go) UpdateReplicaStatusInactive() Returning replica "+replicaName+" to inactive pool <requestID="+requestID+">", time.Now())
 
-	var replicaType string
-	var effectiveFname string
-	effectiveFname = fn
-	if strings.Contains(replicaName, "_n") {
-		replicaType = "native"
+	effectiveFname, pool := fs.findActiveReplicaPool(fn, replicaName)
+	if pool == nil {
+		return fmt.Errorf("[function_store/UpdateReplicaStatusInactive] Replica '%s' is not active for Function '%s' <requestID=%s>", replicaName, fn, requestID)
 	}
-	if strings.Contains(replicaName, "_w") {
-		replicaType = "wasm"
+	timec.LogEvent("function_store/UpdateReplicaStatusInactive", fmt.Sprintf("effectiveFname is %s; replicaName is %s <requestID=%s>", effectiveFname, replicaName, requestID), 3)
+
+	pool.poolMu.Lock()
+	replica, ok := pool.activeReplicas[replicaName]
+	if !ok {
+		// Purged (or drained) since it was looked up
+		pool.poolMu.Unlock()
+		return fmt.Errorf("[function_store/UpdateReplicaStatusInactive] Replica '%s' is not active for Function '%s' <requestID=%s>", replicaName, fn, requestID)
 	}
-	fs.dfMu.RLock()
-	ftype := fs.deployedFunctions[fn].labels["ctrType"]
-	if ftype == "hybrid" {
-		effectiveFname = fs.deployedFunctions[fn].sandboxes[replicaType]
-		timec.LogEvent("function_store/UpdateReplicaStatusInactive", fmt.Sprintf("Function type is Hybrid; replicaType is %s; effectiveFname is %s; replicaName is %s <requestID=%s>", replicaType, effectiveFname, replicaName, requestID), 3)
+	replica.inflight -= 1
+	if replica.inflight > 0 {
+		// Replica is still serving other invocations; keep it active
+		pool.poolMu.Unlock()
+		return nil
 	}
-	fs.dfMu.RUnlock()
+	delete(pool.activeReplicas, replicaName)
+	broken := replica.broken
+	pool.poolMu.Unlock()
 
-	fs.deployedFunctions[effectiveFname].activeReplicasLock.Lock()
-	replica := fs.deployedFunctions[effectiveFname].activeReplicas[replicaName]
-	delete(fs.deployedFunctions[effectiveFname].activeReplicas, replicaName)
-	fs.deployedFunctions[effectiveFname].activeReplicasLock.Unlock()
+	if broken {
+		timec.LogEvent("function_store/UpdateReplicaStatusInactive", fmt.Sprintf("Deleting broken replica '%s' <requestID=%s>", replicaName, requestID), 2)
+		fs.DeleteReplica(replica)
+		return nil
+	}
+	if fs.retireReplica(effectiveFname, replica, requestID) {
+		return nil
+	}
 
 	policy := fs.GetInvocationPolicy(fn)
 	if policy.keepaliveColdStartCtr != 0 && replica.ctrType == policy.coldStartCtrType {
@@ -609,10 +628,74 @@ func (fs *FunctionStore) UpdateReplicaStatusInactive(fn string, replicaName stri
 		}
 		timec.LogEvent("function/UpdateReplicaStatusInactive", fmt.Sprintf("Did not remove container for <requestID=%s>: policy.keepaliveColdStartCtr=%s, policy.coldStartCtrType=%s, policy.warmStartCtrType=%s", requestID, keepaliveStatus, policy.coldStartCtrType, policy.warmStartCtrType), 4)
 	}
+	if fs.GetInvocationPolicy(effectiveFname).isolation == isolationReset {
+		fs.pendingResets.Add(1)
+		go fs.resetReplica(effectiveFname, replica, requestID)
+		return nil
+	}
 	fs.AddIdleReplica(replica)
 	return nil
 }
 
+/* Ends an invocation whose Replica can't be trusted any more (the request to
+ * it failed, timed out or was cancelled mid-flight) and deletes the Replica
+ * once no other invocation is using it */
+func (fs *FunctionStore) DiscardActiveReplica(fn string, replicaName string, reason string, requestID string) error {
+	_, pool := fs.findActiveReplicaPool(fn, replicaName)
+	if pool == nil {
+		return fmt.Errorf("[function_store/DiscardActiveReplica] Replica '%s' is not active for Function '%s' <requestID=%s>", replicaName, fn, requestID)
+	}
+	pool.poolMu.Lock()
+	replica, ok := pool.activeReplicas[replicaName]
+	if !ok {
+		pool.poolMu.Unlock()
+		return fmt.Errorf("[function_store/DiscardActiveReplica] Replica '%s' is not active for Function '%s' <requestID=%s>", replicaName, fn, requestID)
+	}
+	replica.broken = true
+	replica.inflight -= 1
+	if replica.inflight > 0 {
+		/* Deleted by the last invocation still using it */
+		pool.poolMu.Unlock()
+		timec.LogEvent("function_store/DiscardActiveReplica", fmt.Sprintf("Replica '%s' %s; deleting it once idle <requestID=%s>", replicaName, reason, requestID), 2)
+		return nil
+	}
+	delete(pool.activeReplicas, replicaName)
+	pool.poolMu.Unlock()
+
+	timec.LogEvent("function_store/DiscardActiveReplica", fmt.Sprintf("Replica '%s' %s; deleting it <requestID=%s>", replicaName, reason, requestID), 2)
+	return fs.DeleteReplica(replica)
+}
+
+/* Returns the Function (and its name) whose activeReplicas holds
+ * replicaName. For Hybrid Functions this is one of its sandbox deployments. */
+func (fs *FunctionStore) findActiveReplicaPool(fname string, replicaName string) (string, *Function) {
+	fn, ok := fs.lookupFunction(fname)
+	if !ok {
+		return "", nil
+	}
+	candidates := []string{fname}
+	if fn.labels["ctrType"] == "hybrid" {
+		candidates = candidates[:0]
+		for _, sandbox := range fn.sandboxes {
+			candidates = append(candidates, sandbox)
+		}
+	}
+
+	for _, name := range candidates {
+		pool, ok := fs.lookupFunction(name)
+		if !ok {
+			continue
+		}
+		pool.poolMu.RLock()
+		_, found := pool.activeReplicas[replicaName]
+		pool.poolMu.RUnlock()
+		if found {
+			return name, pool
+		}
+	}
+	return "", nil
+}
+
 func (fs *FunctionStore) GetNetNS(requestID string) (int, string) {
 	fs.nsMu.Lock()
 	defer fs.nsMu.Unlock()
@@ -628,6 +711,21 @@ func (fs *FunctionStore) GetNetNS(requestID string) (int, string) {
 	return thisNS, thisIP
 }
 
+/* Takes a specific netns out of the pool, e.g. for a Replica re-adopted after
+ * a restart. Returns false if it isn't in the pool. */
+func (fs *FunctionStore) claimNetNS(nsNum int, IP string) bool {
+	fs.nsMu.Lock()
+	defer fs.nsMu.Unlock()
+	for i, ipInfo := range fs.netnsList {
+		if ipInfo.netnsNum == nsNum && ipInfo.IP == IP {
+			fs.netnsList = append(fs.netnsList[:i], fs.netnsList[i+1:]...)
+			timec.LogEvent("function_store/claimNetNS", fmt.Sprintf("Claimed WASM ns=%d, IP=%s from pool", nsNum, IP), 2)
+			return true
+		}
+	}
+	return false
+}
+
 func (fs *FunctionStore) ReturnNetNS(nsNum int, IP string) {
 	fs.nsMu.Lock()
 	defer fs.nsMu.Unlock()
@@ -647,15 +745,18 @@ func getFunction(f *storage.Function) Function {
 	json.Unmarshal([]byte(f.Annotations), &annotations)
 
 	envVars := map[string]string{}
-	json.Unmarshal([]byte(f.EnvVars), &annotations)
+	json.Unmarshal([]byte(f.EnvVars), &envVars)
 
 	secrets := []string{}
-	json.Unmarshal([]byte(f.Secrets), &annotations)
+	json.Unmarshal([]byte(f.Secrets), &secrets)
 
 	return Function{
 		name:           f.Name,
 		namespace:      f.Namespace,
 		image:          f.Image,
+		imageFiles:     make([]string, 0),
+		pid:            make(map[string]uint32),
+		sandboxes:      make(map[string]string),
 		labels:         labels,
 		idleReplicas:   IdleReplicas{},
 		idleReplicasTs: make(map[string]time.Time),
@@ -691,11 +792,46 @@ func getStorageFunction(f *Function) storage.Function {
 
 func getContainers(f *Function) []storage.Container {
 	var cns []storage.Container
-	for cname, replica := range f.activeReplicas {
-		cns = append(cns, storage.Container{Name: cname, ParentFunction: f.name, Ip: replica.IP})
+	f.poolMu.RLock()
+	defer f.poolMu.RUnlock()
+	for _, replica := range f.activeReplicas {
+		cns = append(cns, getStorageContainer(replica))
 	}
-	for _, replica := range f.idleReplicas.containers {
-		cns = append(cns, storage.Container{Name: replica.uuid, ParentFunction: f.name, Ip: replica.IP})
+	for _, replica := range f.idleReplicas.replicas() {
+		cns = append(cns, getStorageContainer(replica))
 	}
 	return cns
 }
+
+func getStorageContainer(r *Replica) storage.Container {
+	return storage.Container{
+		Name:           r.uuid,
+		ParentFunction: r.fname,
+		Ip:             r.IP,
+		CtrType:        r.ctrType,
+		Pid:            int64(r.PID),
+		NetNS:          r.netNS,
+		Image:          r.image,
+		Namespace:      r.namespace,
+	}
+}
+
+/* Records a running Replica in the DB so it can be re-adopted after a
+ * restart (see reconcile.go) */
+func (fs *FunctionStore) persistReplica(replica *Replica) {
+	if fs.storageManager == nil {
+		return
+	}
+	if err := fs.storageManager.InsertContainer(getStorageContainer(replica)); err != nil {
+		timec.LogEvent("function_store/persistReplica", fmt.Sprintf("Unable to store replica '%s': %s", replica.uuid, err), 1)
+	}
+}
+
+func (fs *FunctionStore) forgetReplica(replica *Replica) {
+	if fs.storageManager == nil {
+		return
+	}
+	if err := fs.storageManager.DeleteContainer(replica.uuid); err != nil {
+		timec.LogEvent("function_store/forgetReplica", fmt.Sprintf("Unable to remove replica '%s' from DB: %s", replica.uuid, err), 1)
+	}
+}
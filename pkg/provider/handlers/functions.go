@@ -3,8 +3,10 @@ package handlers
 import (
 	"context"
 	"log"
+	"os/exec"
 	"strings"
 	"sync"
+	"sync/atomic"
 	"time"
 
 	"github.com/opencontainers/runtime-spec/specs-go"
@@ -35,14 +37,17 @@ type Function struct {
 	envVars         map[string]string
 	envProcess      string
 	memoryLimit     int64
-	createdAt       time.Time // not used
+	createdAt       time.Time        // not used
+	arrivals        arrivalHistogram // inter-arrival times (see keepalive.go)
+	admission       admissionQueue   // concurrency limit and request queue (see admission.go)
+	bandit          banditState      // decayed service times per sandbox (see bandit.go)
+	slo             sloState         // objectives' attainment and added resources (see slo.go)
 	/* Mutexes */
-	fnMu               sync.RWMutex //lock for entire Function struct
-	activeReplicasLock sync.RWMutex //lock for activeReplicas map
-	idleReplicasLock   sync.RWMutex //lock for idleReplicas struct
-	idleReplicasTsMu   sync.RWMutex //lock for idleReplicas timestamps map
-	expiredReplicasMu  sync.RWMutex
-	policyMu           sync.RWMutex
+	fnMu              sync.RWMutex //lock for entire Function struct
+	poolMu            sync.RWMutex //lock for activeReplicas and idleReplicas (see replica_pool.go)
+	idleReplicasTsMu  sync.RWMutex //lock for idleReplicas timestamps map
+	expiredReplicasMu sync.RWMutex
+	policyMu          sync.RWMutex
 }
 
 type wasmIPInfo struct {
@@ -51,23 +56,31 @@ type wasmIPInfo struct {
 }
 
 type Replica struct {
-	fname       string    // name of parent Function
-	ctrType     string    // native or wasm container
-	uuid        string    // unique ID
-	PID         uint32    // PID of container
-	IP          string    // IP of container
-	netNS       int       // network namespace num of container
-	lastAccess  time.Time // last time used
-	accessCount int       // how many times container was used
-}
-
-type IdleReplicas struct {
-	fname      string     // name of parent Function
-	containers []*Replica // list of idle containers for Function
-	count      uint32     // number of idle replicas for Function
-	next       *Replica   // next idle replica to use
-	LRU        *Replica
-	MRU        *Replica
+	fname       string        // name of parent Function
+	ctrType     string        // sandbox runtime type (see sandbox_runtime.go)
+	uuid        string        // unique ID
+	PID         uint32        // PID of container
+	IP          string        // IP of container
+	netNS       int           // network namespace num of container
+	lastAccess  time.Time     // last time used
+	accessCount int           // invocations served (guarded by poolMu)
+	createdAt   time.Time     // when the Replica started (or was re-adopted)
+	readyTime   time.Duration // from sandbox start until ready (see readiness.go)
+	inflight    int           // invocations currently served (guarded by poolMu)
+	broken      bool          // deleted once idle; no new invocations (guarded by poolMu)
+	terminating atomic.Bool   // set once the Replica is being torn down (see liveness.go)
+	frozen      atomic.Bool   // paused while idle (see freeze.go)
+	resetMu     sync.Mutex    // held while the sandbox is reset (see isolation.go)
+	resets      int           // sandbox resets so far (guarded by resetMu)
+	/* Idle pool links (guarded by poolMu; see replica_pool.go) */
+	idlePool *IdleReplicas // pool the Replica is linked into, nil if not idle
+	idlePrev *Replica      // next older idle Replica
+	idleNext *Replica      // next newer idle Replica
+	/* Runtime-specific handles */
+	namespace string               // containerd namespace (native)
+	container containerd.Container // containerd container (native)
+	image     string               // image dir holding replica rootfs (wasm)
+	cmd       *exec.Cmd            // runw process (wasm)
 }
 
 // // ListFunctions returns a map of all functions with running tasks on namespace
@@ -0,0 +1,145 @@
+package handlers
+
+import (
+	"fmt"
+	"time"
+
+	"github.gatech.edu/faasedge/fecore/pkg/timec"
+)
+
+/* Hedged cold starts for Hybrid Functions. With hedgeColdStart set, a cold
+ * start launches a Replica of every sandbox (native and WASM) in parallel and
+ * the request goes to whichever is ready (see readiness.go) first. The
+ * candidates are kept out of the pools until the race is decided, so no
+ * other invocation can share the slower one; it is warmed up and joins its
+ * idle pool (keep) or is torn down (delete) once it is up. Each sandbox
+ * Function counts its hedge wins and losses; EvalColdStartPolicy prefers the
+ * variant that wins more often. */
+
+const (
+	hedgeOff    = "off"
+	hedgeKeep   = "keep"
+	hedgeDelete = "delete"
+
+	minHedgeSamples = 10 // hedges per variant before EvalColdStartPolicy uses win rates
+)
+
+type hedgeResult struct {
+	ctrType string
+	sandbox string // sandbox Function the Replica belongs to
+	rt      SandboxRuntime
+	replica *Replica
+	err     error
+}
+
+/* Reads the hedgeColdStart label into a Function's policy */
+func parseHedgeLabels(labels map[string]string, policy *Policy) error {
+	policy.hedgeColdStart = hedgeOff
+	if v, ok := labels["hedgeColdStart"]; ok {
+		if v != hedgeOff && v != hedgeKeep && v != hedgeDelete {
+			return fmt.Errorf("[hedge/parseHedgeLabels] hedgeColdStart must be '%s', '%s' or '%s', got '%s'", hedgeOff, hedgeKeep, hedgeDelete, v)
+		}
+		policy.hedgeColdStart = v
+	}
+	return nil
+}
+
+/* Cold starts a Replica of every sandbox of a Hybrid Function and returns the
+ * first one that is ready. The others are settled in the background according
+ * to mode. */
+func (i *InvokeResolver) resolveHedged(function *Function, mode string, requestID string) (string, string, error) {
+	defer timec.RecordDuration("(hedge.go) resolveHedged() <requestID="+requestID+">", time.Now())
+	results := make(chan hedgeResult, len(function.sandboxes))
+	for ctrType, sandbox := range function.sandboxes {
+		go func(ctrType string, sandbox string) {
+			/* startReplica returns once the Replica is ready */
+			rt, replica, err := startReplica(i.fs, sandbox, ctrType, true, requestID)
+			results <- hedgeResult{ctrType: ctrType, sandbox: sandbox, rt: rt, replica: replica, err: err}
+		}(ctrType, sandbox)
+	}
+
+	var winner *hedgeResult
+	pending := len(function.sandboxes)
+	for winner == nil && pending > 0 {
+		result := <-results
+		pending -= 1
+		if result.err != nil {
+			timec.LogEvent("hedge/resolveHedged", fmt.Sprintf("Hedged %s replica for '%s' failed: %s <requestID=%s>", result.ctrType, function.name, result.err, requestID), 1)
+			i.fs.recordHedge(result.sandbox, "failed")
+			continue
+		}
+		winner = &result
+	}
+	if winner == nil {
+		return "", "", fmt.Errorf("[hedge/resolveHedged] No sandbox of '%s' could be started <requestID=%s>", function.name, requestID)
+	}
+	i.fs.placeReplica(winner.rt, winner.replica, true)
+	i.fs.recordHedge(winner.sandbox, "won")
+	timec.LogEvent("hedge/resolveHedged", fmt.Sprintf("Hedged cold start of '%s' won by %s replica '%s' <requestID=%s>", function.name, winner.ctrType, winner.replica.uuid, requestID), 2)
+
+	/* Counted so a drain waits for the losers to be placed or deleted */
+	i.fs.spawns.Add(1)
+	go func() {
+		defer i.fs.spawns.Add(-1)
+		for ; pending > 0; pending-- {
+			result := <-results
+			if result.err != nil {
+				i.fs.recordHedge(result.sandbox, "failed")
+				continue
+			}
+			i.fs.recordHedge(result.sandbox, "lost")
+			i.fs.settleHedgeLoser(result, mode, requestID)
+		}
+	}()
+	return winner.replica.uuid, winner.replica.IP, nil
+}
+
+/* Warms up the slower Replica of a hedged cold start and moves it to its
+ * idle pool, or deletes it */
+func (fs *FunctionStore) settleHedgeLoser(loser hedgeResult, mode string, requestID string) {
+	name := loser.replica.uuid
+	if mode == hedgeKeep && !fs.Draining() {
+		if err := fs.warmupReplica(loser.replica, requestID); err != nil {
+			fs.discardNewReplica(loser.rt, loser.replica)
+			return
+		}
+		timec.LogEvent("hedge/settleHedgeLoser", fmt.Sprintf("Keeping %s replica '%s' idle <requestID=%s>", loser.ctrType, name, requestID), 3)
+		fs.placeReplica(loser.rt, loser.replica, false)
+		return
+	}
+	timec.LogEvent("hedge/settleHedgeLoser", fmt.Sprintf("Deleting %s replica '%s' <requestID=%s>", loser.ctrType, name, requestID), 3)
+	fs.discardNewReplica(loser.rt, loser.replica)
+}
+
+func (fs *FunctionStore) recordHedge(sandbox string, outcome string) {
+	stats, ok := fs.lookupStats(sandbox)
+	if !ok {
+		return
+	}
+	stats.statMu.Lock()
+	defer stats.statMu.Unlock()
+	switch outcome {
+	case "won":
+		stats.hedgeWins += 1
+	case "lost":
+		stats.hedgeLosses += 1
+	default:
+		stats.hedgeFailures += 1
+	}
+}
+
+/* Returns the share of hedged cold starts a sandbox Function won and whether
+ * there are enough of them to go by */
+func (fs *FunctionStore) hedgeWinRate(sandbox string) (float32, bool) {
+	stats, ok := fs.lookupStats(sandbox)
+	if !ok {
+		return 0, false
+	}
+	stats.statMu.RLock()
+	defer stats.statMu.RUnlock()
+	hedges := stats.hedgeWins + stats.hedgeLosses + stats.hedgeFailures
+	if hedges < minHedgeSamples {
+		return 0, false
+	}
+	return float32(stats.hedgeWins) / float32(hedges), true
+}
@@ -0,0 +1,240 @@
+package handlers
+
+import (
+	"errors"
+	"sync"
+	"testing"
+	"time"
+
+	"github.gatech.edu/faasedge/fecore/pkg/provider/config"
+)
+
+func Test_parseHedgeLabels(t *testing.T) {
+	tests := []struct {
+		name    string
+		labels  map[string]string
+		want    string
+		wantErr bool
+	}{
+		{name: "off by default", labels: map[string]string{}, want: hedgeOff},
+		{name: "keep the loser", labels: map[string]string{"hedgeColdStart": "keep"}, want: hedgeKeep},
+		{name: "delete the loser", labels: map[string]string{"hedgeColdStart": "delete"}, want: hedgeDelete},
+		{name: "unknown mode", labels: map[string]string{"hedgeColdStart": "both"}, wantErr: true},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			policy := Policy{}
+			err := parseHedgeLabels(tc.labels, &policy)
+			if tc.wantErr {
+				if err == nil {
+					t.Fatalf("want error, got hedgeColdStart '%s'", policy.hedgeColdStart)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("want no error, got: %s", err)
+			}
+			if policy.hedgeColdStart != tc.want {
+				t.Fatalf("want hedgeColdStart '%s', got '%s'", tc.want, policy.hedgeColdStart)
+			}
+		})
+	}
+}
+
+/* Starts Replicas after a delay; their IP is the runtime type so the probe
+ * stub can tell them apart */
+type hedgeRuntime struct {
+	fakeRuntime
+	ctrType   string
+	delay     time.Duration
+	failStart bool
+	stop      chan struct{}
+	mu        sync.Mutex
+	deleted   []string
+}
+
+func (rt *hedgeRuntime) Type() string {
+	return rt.ctrType
+}
+
+func (rt *hedgeRuntime) Reserve(fs *FunctionStore) bool {
+	return true
+}
+
+func (rt *hedgeRuntime) Release(fs *FunctionStore) {}
+
+func (rt *hedgeRuntime) Create(fs *FunctionStore, fname string, replicaName string, requestID string) (*Replica, error) {
+	return &Replica{fname: fname, ctrType: rt.ctrType, uuid: replicaName}, nil
+}
+
+func (rt *hedgeRuntime) Start(fs *FunctionStore, replica *Replica, requestID string) error {
+	time.Sleep(rt.delay)
+	if rt.failStart {
+		return errors.New("start failed")
+	}
+	replica.IP = rt.ctrType
+	return nil
+}
+
+func (rt *hedgeRuntime) Wait(fs *FunctionStore, replica *Replica) ReplicaExit {
+	<-rt.stop
+	return ReplicaExit{ExitCode: 0, Reason: "test finished"}
+}
+
+func (rt *hedgeRuntime) Delete(fs *FunctionStore, replica *Replica) error {
+	rt.mu.Lock()
+	defer rt.mu.Unlock()
+	rt.deleted = append(rt.deleted, replica.uuid)
+	return nil
+}
+
+func (rt *hedgeRuntime) deletedCount() int {
+	rt.mu.Lock()
+	defer rt.mu.Unlock()
+	return len(rt.deleted)
+}
+
+func Test_resolveHedged(t *testing.T) {
+	tests := []struct {
+		name       string
+		mode       string
+		slowFails  bool
+		fastFails  bool
+		wantWinner string // sandbox Function of the winning replica
+		wantIdle   int    // replicas of the slow sandbox idle afterwards
+		wantDelete int    // replicas of the slow sandbox deleted afterwards
+		wantErr    bool
+	}{
+		{name: "faster sandbox wins and the loser is kept", mode: hedgeKeep, wantWinner: "fn-fast", wantIdle: 1},
+		{name: "faster sandbox wins and the loser is deleted", mode: hedgeDelete, wantWinner: "fn-fast", wantDelete: 1},
+		{name: "slower sandbox wins if the faster fails", mode: hedgeKeep, fastFails: true, wantWinner: "fn-slow"},
+		{name: "both fail", mode: hedgeKeep, fastFails: true, slowFails: true, wantErr: true},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			stop := make(chan struct{})
+			defer close(stop)
+			fast := &hedgeRuntime{ctrType: "hedge-fast", failStart: tc.fastFails, stop: stop}
+			slow := &hedgeRuntime{ctrType: "hedge-slow", delay: 20 * time.Millisecond, failStart: tc.slowFails, stop: stop}
+			registerTestRuntime(t, "hedge-fast", fast)
+			registerTestRuntime(t, "hedge-slow", slow)
+			readinessCheck = func(addr string, probe readinessProbe) error { return nil }
+			defer func() { readinessCheck = probeReplica }()
+
+			hybrid := &Function{name: "fn", sandboxes: map[string]string{"hedge-fast": "fn-fast", "hedge-slow": "fn-slow"}}
+			fs := newTestStore(t, config.Config{ContainerExpirationTime: 3600}, hybrid, &Function{name: "fn-fast"}, &Function{name: "fn-slow"})
+			i := &InvokeResolver{fs: fs}
+
+			name, ip, err := i.resolveHedged(hybrid, tc.mode, "test")
+			if tc.wantErr {
+				if err == nil {
+					t.Fatalf("want error, got replica '%s'", name)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("want no error, got: %s", err)
+			}
+			winner, _ := fs.lookupFunction(tc.wantWinner)
+			if _, active := winner.activeReplicas[name]; !active || ip == "" {
+				t.Fatalf("want active replica of '%s', got '%s' (%s)", tc.wantWinner, name, ip)
+			}
+
+			/* The loser is settled once it is up */
+			deadline := time.Now().Add(5 * time.Second)
+			for fs.idleCount("fn-slow")+slow.deletedCount() < tc.wantIdle+tc.wantDelete && time.Now().Before(deadline) {
+				time.Sleep(time.Millisecond)
+			}
+			loser, _ := fs.lookupFunction("fn-slow")
+			loser.poolMu.RLock()
+			active := len(loser.activeReplicas)
+			loser.poolMu.RUnlock()
+			if tc.wantWinner == "fn-fast" && (fs.idleCount("fn-slow") != tc.wantIdle || slow.deletedCount() != tc.wantDelete || active != 0) {
+				t.Fatalf("want slow replica idle=%d deleted=%d, got idle=%d deleted=%d active=%d", tc.wantIdle, tc.wantDelete, fs.idleCount("fn-slow"), slow.deletedCount(), active)
+			}
+
+			fastStats, _ := fs.lookupStats("fn-fast")
+			slowStats, _ := fs.lookupStats("fn-slow")
+			fastStats.statMu.RLock()
+			defer fastStats.statMu.RUnlock()
+			slowStats.statMu.RLock()
+			defer slowStats.statMu.RUnlock()
+			if tc.wantWinner == "fn-fast" && (fastStats.hedgeWins != 1 || slowStats.hedgeLosses != 1) {
+				t.Fatalf("want a win for fn-fast and a loss for fn-slow, got %d and %d", fastStats.hedgeWins, slowStats.hedgeLosses)
+			}
+			if tc.wantWinner == "fn-slow" && (slowStats.hedgeWins != 1 || fastStats.hedgeFailures != 1) {
+				t.Fatalf("want a win for fn-slow and a failure for fn-fast, got %d and %d", slowStats.hedgeWins, fastStats.hedgeFailures)
+			}
+		})
+	}
+}
+
+/* With replicaConcurrency above 1, a request arriving while the hedge loser
+ * is settled must not be handed the loser */
+func Test_hedgeLoserIsNotShared(t *testing.T) {
+	stop := make(chan struct{})
+	defer close(stop)
+	fast := &hedgeRuntime{ctrType: "hedge-fast", stop: stop}
+	slow := &hedgeRuntime{ctrType: "hedge-slow", delay: 20 * time.Millisecond, stop: stop}
+	registerTestRuntime(t, "hedge-fast", fast)
+	registerTestRuntime(t, "hedge-slow", slow)
+	readinessCheck = func(addr string, probe readinessProbe) error { return nil }
+	defer func() { readinessCheck = probeReplica }()
+	warming := make(chan string, 1)
+	proceed := make(chan struct{})
+	warmupCall = func(ip string, path string, timeout time.Duration) error {
+		warming <- ip
+		<-proceed
+		return nil
+	}
+	defer func() { warmupCall = callWarmup }()
+
+	hybrid := &Function{name: "fn", sandboxes: map[string]string{"hedge-fast": "fn-fast", "hedge-slow": "fn-slow"}}
+	fs := newTestStore(t, config.Config{ContainerExpirationTime: 3600}, hybrid)
+	for _, name := range []string{"fn-fast", "fn-slow"} {
+		fn := &Function{name: name, activeReplicas: make(map[string]*Replica)}
+		fn.policy.replicaConcurrency = 2
+		fn.policy.warmupPath = "/_/warmup"
+		fn.policy.warmupTimeout = defaultWarmupTimeoutMs
+		fs.AddDeployedFunction(fn)
+	}
+	i := &InvokeResolver{fs: fs}
+
+	if _, _, err := i.resolveHedged(hybrid, hedgeKeep, "test"); err != nil {
+		t.Fatalf("want no error, got: %s", err)
+	}
+	select {
+	case ip := <-warming:
+		if ip != "hedge-slow" {
+			t.Fatalf("want the loser warmed up, got a warmup of '%s'", ip)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatalf("want the loser warmed up before it goes idle")
+	}
+
+	/* Settlement is in progress */
+	if name, _, _, err := fs.GetIdleReplica("fn-slow", "other"); err == nil {
+		t.Fatalf("want no replica of 'fn-slow' handed out while the loser is settled, got '%s'", name)
+	}
+
+	close(proceed)
+	deadline := time.Now().Add(5 * time.Second)
+	for fs.idleCount("fn-slow") != 1 {
+		if time.Now().After(deadline) {
+			t.Fatalf("want the loser idle after its warmup")
+		}
+		time.Sleep(time.Millisecond)
+	}
+	name, _, _, err := fs.GetIdleReplica("fn-slow", "other")
+	if err != nil {
+		t.Fatalf("want the kept loser handed out, got: %s", err)
+	}
+	loser, _ := fs.lookupFunction("fn-slow")
+	loser.poolMu.RLock()
+	defer loser.poolMu.RUnlock()
+	if replica := loser.activeReplicas[name]; replica == nil || replica.inflight != 1 || replica.accessCount != 1 {
+		t.Fatalf("want the loser active with one invocation, got %+v", replica)
+	}
+}
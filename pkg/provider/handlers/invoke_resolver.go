@@ -2,6 +2,7 @@ package handlers
 
 import (
 	"fmt"
+	"net/http"
 	"net/url"
 	"strings"
 	"time"
@@ -24,7 +25,7 @@ func NewInvokeResolver(client *containerd.Client, cni gocni.CNI, fs *FunctionSto
 	return &InvokeResolver{client: client, cni: cni, fs: fs}
 }
 
-func (i *InvokeResolver) Resolve(functionName string, requestID string, reqStartupType string, reqContainerType string) (url.URL, string, string, string, error) {
+func (i *InvokeResolver) Resolve(functionName string, requestID string, opts InvokeOptions) (url.URL, string, string, string, error) {
 	defer timec.RecordDuration("(invoke_resolver.go) Resolve() <requestID="+requestID+">", time.Now())
 	var startupType = ""
 	var containerType = ""
@@ -47,40 +48,28 @@ func (i *InvokeResolver) Resolve(functionName string, requestID string, reqStart
 		return url.URL{}, startupType, containerType, replicaName, er
 	}
 
-	if val, ok := function.labels["ctrType"]; ok {
-		/* TODO: Should return an error if we fail to resolve container */
-		switch val {
-		case "native":
-			containerType = "native"
-			replicaIP, startupType, replicaName, err = i.ResolveNative(&function, requestID, reqStartupType)
-			if err != nil {
-				timec.LogEvent("invoke_resolver/Resolve", "Unable to resolve Native container type for "+function.name+"<requestID="+requestID+">", 1)
-			}
-		case "wasm":
-			containerType = "wasm"
-			replicaIP, startupType, replicaName, err = i.ResolveWasm(&function, requestID, reqStartupType)
-			if err != nil {
-				timec.LogEvent("invoke_resolver/Resolve", "Unable to resolve WASM container type for "+function.name+"<requestID="+requestID+">", 1)
-			}
-		case "hybrid":
-			containerType = "hybrid"
-			replicaIP, startupType, replicaName, err = i.ResolveHybrid(&function, requestID, reqStartupType)
-			if err != nil {
-				timec.LogEvent("invoke_resolver/Resolve", "Unable to resolve Hybrid container type for "+function.name+"<requestID="+requestID+">", 1)
-			}
-		default:
-			containerType = "native"
-			replicaIP, startupType, replicaName, err = i.ResolveNative(&function, requestID, reqStartupType)
-			if err != nil {
-				timec.LogEvent("invoke_resolver/Resolve", "Unable to resolve default container type (Native) for "+function.name+"<requestID="+requestID+">", 1)
-			}
+	ctrType := function.labels["ctrType"]
+	if ctrType != "hybrid" {
+		/* Function deployments with no (or an unknown) ctrType label default to native */
+		if _, rtErr := GetSandboxRuntime(ctrType); rtErr != nil {
+			ctrType = "native"
+		}
+	}
+	if err = checkContainerOverride(&function, ctrType, opts); err != nil {
+		return url.URL{}, startupType, containerType, replicaName, err
+	}
+	if ctrType == "hybrid" {
+		containerType = "hybrid"
+		replicaIP, startupType, replicaName, err = i.ResolveHybrid(&function, requestID, opts)
+		if err != nil {
+			timec.LogEvent("invoke_resolver/Resolve", "Unable to resolve Hybrid container type for "+function.name+"<requestID="+requestID+">", 1)
+			return url.URL{}, startupType, containerType, replicaName, err
 		}
 	} else {
-		containerType = "native"
-		/* Function deployment has no ctrType label; default to native */
-		replicaIP, startupType, replicaName, err = i.ResolveNative(&function, requestID, reqStartupType)
+		containerType = ctrType
+		replicaIP, startupType, replicaName, err = i.ResolveSandbox(&function, ctrType, requestID, opts)
 		if err != nil {
-			timec.LogEvent("invoke_resolver/Resolve", "Unable to resolve default container type (Native) for "+function.name+" <requestID="+requestID+">", 1)
+			timec.LogEvent("invoke_resolver/Resolve", "Unable to resolve "+ctrType+" container type for "+function.name+" <requestID="+requestID+">", 1)
 			return url.URL{}, startupType, containerType, replicaName, err
 		}
 	}
@@ -95,88 +84,106 @@ func (i *InvokeResolver) Resolve(functionName string, requestID string, reqStart
 	return *urlRes, startupType, containerType, replicaName, nil
 }
 
-func (i *InvokeResolver) ResolveNative(function *Function, requestID string, reqStartupType string) (string, string, string, error) {
+/* Resolves an invocation for a Function deployed on a single sandbox runtime */
+func (i *InvokeResolver) ResolveSandbox(function *Function, ctrType string, requestID string, opts InvokeOptions) (string, string, string, error) {
 	var startupType = ""
 	var replicaName = ""
 	var replicaIP = ""
 	var err error
-	/* Try to get a warm container; if successful, return; otherwise, continue to cold start */
-	if reqStartupType != "cold" {
-		/* Get the next idle replica if there is at least 1 available (warm start); */
-		replicaName, replicaIP, err = i.fs.GetIdleReplica(function.name, requestID)
-		if err == nil {
-			startupType = "warm"
-			timec.LogEvent("invoke_resolver/ResolveNative", fmt.Sprintf("Using idle replica '%s' (%s) for Function '%s' <requestID=%s>", replicaName, replicaIP, function.name, requestID), 2)
-			return replicaIP, startupType, replicaName, err
-		}
+	if opts.Replica != "" {
+		replicaIP, startupType, err = i.fs.GetNamedReplica([]string{function.name}, opts.Replica, requestID)
+		return replicaIP, startupType, opts.Replica, err
 	}
-	startupType = "cold"
-	startTime := time.Now()
-	timec.LogEvent("invoke_resolver/ResolveNative", fmt.Sprintf("Creating new replica for Function '%s' <requestID=%s>", function.name, requestID), 2)
-	replicaName, replicaIP, err = createReplica(i.fs, i.client, i.cni, function.name, "native", true, requestID)
-	if err != nil {
-		timec.LogEvent("invoke_resolver/ResolveNative", fmt.Sprintf("Error creating new replica for Function %s: %s", function.name, err), 1)
+	if err = i.fs.planSandboxDeadline(function, ctrType, opts); err != nil {
 		return replicaIP, startupType, replicaName, err
 	}
-	coldStartTime := time.Since(startTime)
-	i.fs.RecordColdStartTime(coldStartTime, requestID)
-	timec.LogEvent("invoke_resolver/ResolveNative", fmt.Sprintf("Using new replica '%s' (%s) for Function '%s' <requestID=%s>", replicaName, replicaIP, function.name, requestID), 2)
-	return replicaIP, startupType, replicaName, err
-}
-
-func (i *InvokeResolver) ResolveWasm(function *Function, requestID string, reqStartupType string) (string, string, string, error) {
-	var startupType = ""
-	var replicaName = ""
-	var replicaIP = ""
-	var err error
 	/* Try to get a warm container; if successful, return; otherwise, continue to cold start */
-	if reqStartupType != "cold" {
+	if opts.StartupType != "cold" {
 		/* Get the next idle replica if there is at least 1 available (warm start); */
-		replicaName, replicaIP, err = i.fs.GetIdleReplica(function.name, requestID)
+		replicaName, replicaIP, startupType, err = i.fs.GetIdleReplica(function.name, requestID)
 		if err == nil {
-			startupType = "warm"
-			timec.LogEvent("invoke_resolver/ResolveWasm", fmt.Sprintf("Using idle WASM replica '%s' (%s) for Function '%s' <requestID=%s>", replicaName, replicaIP, function.name, requestID), 2)
+			timec.LogEvent("invoke_resolver/ResolveSandbox", fmt.Sprintf("Using idle %s replica '%s' (%s) for Function '%s' <requestID=%s>", ctrType, replicaName, replicaIP, function.name, requestID), 2)
 			return replicaIP, startupType, replicaName, err
 		}
+		if opts.StartupType == "warm" {
+			return replicaIP, startupType, replicaName, &OverrideError{Reason: fmt.Sprintf("No idle replica of '%s' for a warm start", function.name), Status: http.StatusConflict}
+		}
 	}
 	startupType = "cold"
 	startTime := time.Now()
-	timec.LogEvent("invoke_resolver/ResolveWasm", fmt.Sprintf("Creating new WASM replica for Function '%s' <requestID=%s>", function.name, requestID), 2)
-	replicaName, replicaIP, err = createReplica(i.fs, i.client, i.cni, function.name, "wasm", true, requestID)
+	timec.LogEvent("invoke_resolver/ResolveSandbox", fmt.Sprintf("Creating new %s replica for Function '%s' <requestID=%s>", ctrType, function.name, requestID), 2)
+	replicaName, replicaIP, err = createReplica(i.fs, function.name, ctrType, true, requestID)
 	if err != nil {
-		timec.LogEvent("invoke_resolver/ResolveWasm", fmt.Sprintf("Error creating new WASM replica for Function %s: %s", function.name, err), 1)
+		timec.LogEvent("invoke_resolver/ResolveSandbox", fmt.Sprintf("Error creating new %s replica for Function %s: %s", ctrType, function.name, err), 1)
 		return replicaIP, startupType, replicaName, err
 	}
 	coldStartTime := time.Since(startTime)
 	i.fs.RecordColdStartTime(coldStartTime, requestID)
-	timec.LogEvent("invoke_resolver/ResolveWasm", fmt.Sprintf("Using new replica '%s' (%s) for Function '%s' <requestID=%s>", replicaName, replicaIP, function.name, requestID), 2)
+	timec.LogEvent("invoke_resolver/ResolveSandbox", fmt.Sprintf("Using new replica '%s' (%s) for Function '%s' <requestID=%s>", replicaName, replicaIP, function.name, requestID), 2)
 	return replicaIP, startupType, replicaName, err
 }
 
-func (i *InvokeResolver) ResolveHybrid(function *Function, requestID string, reqStartupType string) (string, string, string, error) {
+func (i *InvokeResolver) ResolveHybrid(function *Function, requestID string, opts InvokeOptions) (string, string, string, error) {
 	var startupType = ""
 	var replicaName = ""
 	var replicaIP = ""
 	var err error
 	policy := i.fs.GetInvocationPolicy(function.name)
+	if opts.Replica != "" {
+		pools := make([]string, 0, len(function.sandboxes))
+		for ctrType, sandbox := range function.sandboxes {
+			if opts.ContainerType == "" || opts.ContainerType == ctrType {
+				pools = append(pools, sandbox)
+			}
+		}
+		replicaIP, startupType, err = i.fs.GetNamedReplica(pools, opts.Replica, requestID)
+		return replicaIP, startupType, opts.Replica, err
+	}
+	if opts, err = i.fs.planHybridDeadline(function, policy, opts, requestID); err != nil {
+		return replicaIP, startupType, replicaName, err
+	}
 	/* Check policies to determine what kind of container to use */
 	coldStartType := policy.coldStartCtrType
 	warmStartType := policy.warmStartCtrType
+	hedgeMode := policy.hedgeColdStart
+	/* A request pinned to one sandbox (e.g. to meet its deadline) is served
+	 * by that sandbox only */
+	if _, ok := function.sandboxes[opts.ContainerType]; ok {
+		coldStartType = opts.ContainerType
+		warmStartType = opts.ContainerType
+		hedgeMode = hedgeOff
+	} else {
+		/* The bandit evaluator re-picks the sandbox on every invocation */
+		coldStartType = i.fs.banditChoice(function.name, policy, "cold", coldStartType)
+		warmStartType = i.fs.banditChoice(function.name, policy, "warm", warmStartType)
+	}
 	warmStartSandbox := function.sandboxes[warmStartType]
 	coldStartSandbox := function.sandboxes[coldStartType]
 	timec.LogEvent("invoke_resolver/ResolveHybrid", fmt.Sprintf("coldStartType: %s; coldStartSandbox: %s; warmStartType: %s; warmStartSandbox: %s", coldStartType, coldStartSandbox, warmStartType, warmStartSandbox), 3)
 
 	// First see if we have a warm container
-	replicaName, replicaIP, err = i.fs.GetIdleReplica(warmStartSandbox, requestID)
-	if err == nil {
-		startupType = "warm"
-		timec.LogEvent("invoke_resolver/ResolveHybrid", fmt.Sprintf("Using idle replica '%s' (%s) for Function '%s' <requestID=%s>", replicaName, replicaIP, function.name, requestID), 2)
-		return replicaIP, startupType, replicaName, err
+	if opts.StartupType != "cold" {
+		replicaName, replicaIP, startupType, err = i.fs.GetIdleReplica(warmStartSandbox, requestID)
+		/* Exploring a sandbox without idle Replicas isn't worth a cold start */
+		if err != nil && warmStartType != policy.warmStartCtrType && opts.ContainerType == "" {
+			replicaName, replicaIP, startupType, err = i.fs.GetIdleReplica(function.sandboxes[policy.warmStartCtrType], requestID)
+		}
+		if err == nil {
+			timec.LogEvent("invoke_resolver/ResolveHybrid", fmt.Sprintf("Using idle replica '%s' (%s) for Function '%s' <requestID=%s>", replicaName, replicaIP, function.name, requestID), 2)
+			return replicaIP, startupType, replicaName, err
+		}
+		if opts.StartupType == "warm" {
+			return replicaIP, startupType, replicaName, &OverrideError{Reason: fmt.Sprintf("No idle %s replica of '%s' for a warm start", warmStartType, function.name), Status: http.StatusConflict}
+		}
 	}
-	// If no warm native containers, spawn coldStartCtrType; optionally spawn warmStartCtrType in background, per policy
+	// If no warm native containers, spawn coldStartCtrType (or every sandbox type when hedging); optionally spawn warmStartCtrType in background, per policy
 	startupType = "cold"
 	timec.LogEvent("invoke_resolver/ResolveHybrid", fmt.Sprintf("Creating new replica for Function '%s' <requestID=%s>", function.name, requestID), 2)
-	replicaName, replicaIP, err = createReplica(i.fs, i.client, i.cni, coldStartSandbox, coldStartType, true, requestID)
+	if hedgeMode == hedgeKeep || hedgeMode == hedgeDelete {
+		replicaName, replicaIP, err = i.resolveHedged(function, hedgeMode, requestID)
+	} else {
+		replicaName, replicaIP, err = createReplica(i.fs, coldStartSandbox, coldStartType, true, requestID)
+	}
 	if err != nil {
 		timec.LogEvent("invoke_resolver/ResolveHybrid", fmt.Sprintf("Error creating new replica for Function %s: %s", function.name, err), 1)
 		return replicaIP, startupType, replicaName, err
@@ -185,8 +192,12 @@ func (i *InvokeResolver) ResolveHybrid(function *Function, requestID string, req
 	// Spawn additional container(s) using a Go func to avoid blocking
 	if policy.spawnAddlCtrs > 0 {
 		for c := 0; c < policy.spawnAddlCtrs; c++ {
+			if !i.fs.beginSpawn() {
+				break
+			}
 			go func() {
-				createReplica(i.fs, i.client, i.cni, function.sandboxes[policy.warmStartCtrType], policy.warmStartCtrType, false, "SPAWN_ADDL")
+				defer i.fs.endSpawn()
+				createReplica(i.fs, function.sandboxes[policy.warmStartCtrType], policy.warmStartCtrType, false, "SPAWN_ADDL")
 			}()
 		}
 	}
@@ -0,0 +1,125 @@
+package handlers
+
+import (
+	"fmt"
+	"strings"
+	"time"
+
+	"github.gatech.edu/faasedge/fecore/pkg/timec"
+)
+
+/* Per-invocation reset isolation. Warm reuse lets a Replica's /tmp, its WASM
+ * preopened dir (image/replicas/<name>) and its in-memory state leak from one
+ * invocation to the next. A Function with isolation=per-invocation-reset
+ * resets a Replica's sandbox once its invocation finishes, before it re-enters
+ * the idle pool: WASM Replicas get a freshly reflinked rootfs and a new runw
+ * process, native Replicas get a new task, whose writablePaths (default /tmp)
+ * are fresh tmpfs mounts over a read-only rootfs. The image, container and
+ * network namespace are kept, so warm starts stay cheap; the reset runs in the
+ * background after the response and its cost is recorded in FunctionStats.
+ * Such Replicas serve one invocation at a time. */
+
+const (
+	isolationNone  = "none"
+	isolationReset = "per-invocation-reset"
+
+	defaultWritablePaths = "/tmp"
+)
+
+/* Reads the isolation label into a Function's policy */
+func parseIsolationLabels(labels map[string]string, policy *Policy) error {
+	policy.isolation = isolationNone
+	if v, ok := labels["isolation"]; ok {
+		if v != isolationNone && v != isolationReset {
+			return fmt.Errorf("[isolation/parseIsolationLabels] isolation must be '%s' or '%s', got '%s'", isolationNone, isolationReset, v)
+		}
+		policy.isolation = v
+	}
+	for _, p := range writablePaths(labels) {
+		if !strings.HasPrefix(p, "/") {
+			return fmt.Errorf("[isolation/parseIsolationLabels] writablePaths must be absolute paths, got '%s'", p)
+		}
+	}
+	return nil
+}
+
+/* Paths that get a fresh tmpfs on every reset of a native Replica, from the
+ * comma-separated writablePaths label */
+func writablePaths(labels map[string]string) []string {
+	v, ok := labels["writablePaths"]
+	if !ok {
+		v = defaultWritablePaths
+	}
+	paths := make([]string, 0)
+	for _, p := range strings.Split(v, ",") {
+		if p = strings.TrimSpace(p); p != "" {
+			paths = append(paths, p)
+		}
+	}
+	return paths
+}
+
+/* Number of times a Replica's sandbox was reset */
+func (replica *Replica) resetCount() int {
+	replica.resetMu.Lock()
+	defer replica.resetMu.Unlock()
+	return replica.resets
+}
+
+/* Resets a Replica that finished its invocation and returns it to the idle
+ * pool of pool. A Replica that can't be reset is deleted. Runs in the
+ * background; the caller must have added it to fs.pendingResets. */
+func (fs *FunctionStore) resetReplica(pool string, replica *Replica, requestID string) {
+	defer fs.pendingResets.Add(-1)
+	rt, err := GetSandboxRuntime(replica.ctrType)
+	if err != nil {
+		fs.DeleteReplica(replica)
+		return
+	}
+
+	/* The liveness watcher sees the old sandbox exit; it waits for the
+	 * reset and then watches the new one (see waitReplica) */
+	replica.resetMu.Lock()
+	if replica.terminating.Load() {
+		replica.resetMu.Unlock()
+		return
+	}
+	replica.resets += 1
+	start := time.Now()
+	err = rt.Reset(fs, replica, requestID)
+	elapsed := time.Since(start)
+	/* DeleteReplica waits on resetMu; a Replica it is tearing down doesn't
+	 * go back to the idle pool */
+	deleted := replica.terminating.Load()
+	replica.resetMu.Unlock()
+	fs.recordReset(pool, elapsed, err == nil)
+	if err != nil {
+		timec.LogEvent("isolation/resetReplica", fmt.Sprintf("Unable to reset replica '%s'; deleting it: %s <requestID=%s>", replica.uuid, err, requestID), 1)
+		fs.DeleteReplica(replica)
+		return
+	}
+	if deleted {
+		return
+	}
+
+	timec.LogEvent("isolation/resetReplica", fmt.Sprintf("Reset replica '%s' of '%s' in %d us <requestID=%s>", replica.uuid, pool, elapsed.Microseconds(), requestID), 3)
+	/* The IP/PID may have changed */
+	fs.persistReplica(replica)
+	fs.AddIdleReplica(replica)
+}
+
+func (fs *FunctionStore) recordReset(pool string, elapsed time.Duration, ok bool) {
+	stats, found := fs.lookupStats(pool)
+	if !found {
+		return
+	}
+	stats.statMu.Lock()
+	defer stats.statMu.Unlock()
+	if !ok {
+		stats.resetFailures += 1
+		return
+	}
+	stats.resets += 1
+	stats.totalResetTime += elapsed.Microseconds()
+	stats.avgResetTime = stats.totalResetTime / int64(stats.resets)
+}
@@ -0,0 +1,226 @@
+package handlers
+
+import (
+	"errors"
+	"fmt"
+	"sync"
+	"testing"
+	"time"
+
+	"github.gatech.edu/faasedge/fecore/pkg/provider/config"
+)
+
+func Test_parseIsolationLabels(t *testing.T) {
+	tests := []struct {
+		name      string
+		labels    map[string]string
+		want      string
+		wantPaths []string
+		wantErr   bool
+	}{
+		{name: "no isolation by default", labels: map[string]string{}, want: isolationNone, wantPaths: []string{"/tmp"}},
+		{name: "per-invocation reset", labels: map[string]string{"isolation": "per-invocation-reset"}, want: isolationReset, wantPaths: []string{"/tmp"}},
+		{name: "writable paths", labels: map[string]string{"isolation": "per-invocation-reset", "writablePaths": "/tmp, /var/cache"}, want: isolationReset, wantPaths: []string{"/tmp", "/var/cache"}},
+		{name: "unknown mode", labels: map[string]string{"isolation": "vm"}, wantErr: true},
+		{name: "relative writable path", labels: map[string]string{"writablePaths": "tmp"}, wantErr: true},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			policy := Policy{}
+			err := parseIsolationLabels(tc.labels, &policy)
+			if tc.wantErr {
+				if err == nil {
+					t.Fatalf("want error, got isolation '%s'", policy.isolation)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("want no error, got: %s", err)
+			}
+			if policy.isolation != tc.want {
+				t.Fatalf("want isolation '%s', got '%s'", tc.want, policy.isolation)
+			}
+			if fmt.Sprint(writablePaths(tc.labels)) != fmt.Sprint(tc.wantPaths) {
+				t.Fatalf("want writable paths %v, got %v", tc.wantPaths, writablePaths(tc.labels))
+			}
+			if policy.isolation == isolationReset && (Policy{replicaConcurrency: 4, isolation: policy.isolation}).concurrency() != 1 {
+				t.Fatalf("want reset replicas to serve one invocation at a time")
+			}
+		})
+	}
+}
+
+type resettingRuntime struct {
+	fakeRuntime
+	mu        sync.Mutex
+	failReset bool
+	resets    int
+	deleted   []string
+	exits     chan ReplicaExit // delivers the exit of the current sandbox to Wait
+	waiting   chan struct{}    // signalled when Wait starts watching a sandbox
+	started   chan struct{}    // signalled when a reset starts
+	proceed   chan struct{}    // a started reset finishes once closed
+	order     []string         // finished resets and deletes, in order
+}
+
+func (rt *resettingRuntime) Type() string {
+	return "resetting"
+}
+
+func (rt *resettingRuntime) Reset(fs *FunctionStore, replica *Replica, requestID string) error {
+	if rt.started != nil {
+		rt.started <- struct{}{}
+		<-rt.proceed
+	}
+	rt.mu.Lock()
+	defer rt.mu.Unlock()
+	defer func() { rt.order = append(rt.order, "reset") }()
+	rt.resets += 1
+	/* The old sandbox goes away */
+	if rt.exits != nil {
+		rt.exits <- ReplicaExit{ExitCode: -1, Reason: "killed by signal 9"}
+	}
+	if rt.failReset {
+		return errors.New("reset failed")
+	}
+	replica.PID += 1
+	return nil
+}
+
+func (rt *resettingRuntime) Wait(fs *FunctionStore, replica *Replica) ReplicaExit {
+	rt.waiting <- struct{}{}
+	return <-rt.exits
+}
+
+func (rt *resettingRuntime) Delete(fs *FunctionStore, replica *Replica) error {
+	rt.mu.Lock()
+	defer rt.mu.Unlock()
+	rt.deleted = append(rt.deleted, replica.uuid)
+	rt.order = append(rt.order, "delete")
+	return nil
+}
+
+func waitForResets(t *testing.T, fs *FunctionStore) {
+	deadline := time.Now().Add(5 * time.Second)
+	for fs.pendingResets.Load() > 0 {
+		if time.Now().After(deadline) {
+			t.Fatalf("want resets to finish")
+		}
+		time.Sleep(time.Millisecond)
+	}
+}
+
+func Test_ResetReplicaAfterInvocation(t *testing.T) {
+	tests := []struct {
+		name        string
+		isolation   string
+		failReset   bool
+		wantResets  int
+		wantIdle    int
+		wantDeleted int
+	}{
+		{name: "no isolation", isolation: isolationNone, wantIdle: 1},
+		{name: "reset before returning to the idle pool", isolation: isolationReset, wantResets: 1, wantIdle: 1},
+		{name: "replica that can't be reset is deleted", isolation: isolationReset, failReset: true, wantResets: 1, wantDeleted: 1},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			rt := &resettingRuntime{failReset: tc.failReset}
+			registerTestRuntime(t, "resetting", rt)
+
+			fn := &Function{name: "fn", activeReplicas: make(map[string]*Replica)}
+			fn.policy.isolation = tc.isolation
+			fs := newTestStore(t, config.Config{}, fn)
+			fs.AddIdleReplica(&Replica{fname: "fn", uuid: "a", ctrType: "resetting", PID: 100})
+
+			if name, _, _, err := fs.GetIdleReplica("fn", "test"); err != nil || name != "a" {
+				t.Fatalf("want replica 'a', got '%s' (err=%v)", name, err)
+			}
+			if err := fs.UpdateReplicaStatusInactive("fn", "a", "test"); err != nil {
+				t.Fatalf("want no error, got: %s", err)
+			}
+			waitForResets(t, fs)
+
+			if rt.resets != tc.wantResets || fn.idleReplicas.size() != tc.wantIdle || len(rt.deleted) != tc.wantDeleted {
+				t.Fatalf("want resets=%d idle=%d deleted=%d, got resets=%d idle=%d deleted=%d", tc.wantResets, tc.wantIdle, tc.wantDeleted, rt.resets, fn.idleReplicas.size(), len(rt.deleted))
+			}
+			stats := fs.functionStats["fn"]
+			if stats.resets+stats.resetFailures != tc.wantResets || stats.resetFailures != tc.wantDeleted {
+				t.Fatalf("want %d resets (%d failed) in stats, got %d (%d failed)", tc.wantResets, tc.wantDeleted, stats.resets+stats.resetFailures, stats.resetFailures)
+			}
+		})
+	}
+}
+
+/* The liveness watcher keeps watching a Replica whose sandbox was restarted
+ * by a reset and only reports the exit of the new sandbox */
+func Test_waitReplicaAcrossResets(t *testing.T) {
+	rt := &resettingRuntime{exits: make(chan ReplicaExit), waiting: make(chan struct{}, 2)}
+	registerTestRuntime(t, "resetting", rt)
+
+	fn := &Function{name: "fn", activeReplicas: make(map[string]*Replica)}
+	fs := newTestStore(t, config.Config{}, fn)
+	replica := &Replica{fname: "fn", uuid: "a", ctrType: "resetting", PID: 100}
+	exited := make(chan ReplicaExit, 1)
+	go func() {
+		exited <- waitReplica(fs, rt, replica)
+	}()
+
+	<-rt.waiting
+	fs.pendingResets.Add(1)
+	fs.resetReplica("fn", replica, "test")
+	<-rt.waiting
+	select {
+	case exit := <-exited:
+		t.Fatalf("want the reset to go unnoticed, got exit '%s'", exit.Reason)
+	case <-time.After(50 * time.Millisecond):
+	}
+	if fn.idleReplicas.size() != 1 {
+		t.Fatalf("want replica 'a' idle after the reset")
+	}
+
+	rt.exits <- ReplicaExit{ExitCode: 1, Reason: "exited with code 1"}
+	if exit := <-exited; exit.ExitCode != 1 {
+		t.Fatalf("want the exit of the new sandbox, got '%s'", exit.Reason)
+	}
+}
+
+/* A Replica deleted while it is being reset is torn down once the reset
+ * finishes and doesn't go back to the idle pool */
+func Test_DeleteReplicaDuringReset(t *testing.T) {
+	rt := &resettingRuntime{started: make(chan struct{}), proceed: make(chan struct{})}
+	registerTestRuntime(t, "resetting", rt)
+
+	fn := &Function{name: "fn", activeReplicas: make(map[string]*Replica)}
+	fs := newTestStore(t, config.Config{}, fn)
+	replica := &Replica{fname: "fn", uuid: "a", ctrType: "resetting", PID: 100}
+	fs.pendingResets.Add(1)
+	go fs.resetReplica("fn", replica, "test")
+	<-rt.started
+
+	deleted := make(chan error, 1)
+	go func() {
+		deleted <- fs.DeleteReplica(replica)
+	}()
+	select {
+	case <-deleted:
+		t.Fatalf("want the delete to wait for the reset")
+	case <-time.After(50 * time.Millisecond):
+	}
+
+	close(rt.proceed)
+	if err := <-deleted; err != nil {
+		t.Fatalf("want no error, got: %s", err)
+	}
+	waitForResets(t, fs)
+	rt.mu.Lock()
+	defer rt.mu.Unlock()
+	if fmt.Sprint(rt.order) != "[reset delete]" {
+		t.Fatalf("want the delete after the reset, got %v", rt.order)
+	}
+	if fn.idleReplicas.size() != 0 {
+		t.Fatalf("want the deleted replica kept out of the idle pool")
+	}
+}
@@ -0,0 +1,250 @@
+package handlers
+
+import (
+	"fmt"
+	"math"
+	"sync"
+	"time"
+)
+
+/* Adaptive keep-alive. Instead of expiring every idle Replica after the global
+ * ContainerExpirationTime, a Function with keepalivePolicy=adaptive learns the
+ * distribution of its inter-arrival times (IATs) and derives two windows from
+ * it, following the hybrid-histogram policy (Shahrad et al., ATC'20):
+ *   - prewarm:   head of the distribution; no request is expected before
+ *                this, so idle Replicas are released right after use
+ *   - keepAlive: tail of the distribution; no request is expected after
+ *                this, so idle Replicas are released
+ * Between the two windows the warm pool keeps one Replica ready. Until enough
+ * IATs are seen (or most of them fall outside the histogram) the Function
+ * falls back to the fixed ContainerExpirationTime. */
+
+const (
+	arrivalHistogramRange      = 600  // seconds covered by the histogram (1s bins)
+	arrivalHistogramMinSamples = 10   // IATs needed before the histogram is trusted
+	arrivalHeadPercentile      = 0.05 // percentile used for the prewarm window
+	arrivalTailPercentile      = 0.99 // percentile used for the keep-alive window
+	arrivalWindowMargin        = 0.10 // safety margin applied to both windows
+	arrivalOutOfBoundsLimit    = 0.5  // max fraction of IATs beyond the histogram range
+)
+
+type arrivalHistogram struct {
+	mu          sync.Mutex
+	lastArrival time.Time
+	bins        []uint64 // bins[i] counts IATs in [i, i+1) seconds
+	outOfBounds uint64   // IATs longer than arrivalHistogramRange
+	samples     uint64
+}
+
+type arrivalHistogramJSON struct {
+	Fname           string   `json:"fname"`
+	KeepalivePolicy string   `json:"keepalivePolicy"`
+	Adaptive        bool     `json:"adaptive"` // false while falling back to the fixed keep-alive
+	Samples         uint64   `json:"samples"`
+	OutOfBounds     uint64   `json:"outOfBounds"`
+	BinSeconds      int      `json:"binSeconds"`
+	Bins            []uint64 `json:"bins"`
+	PrewarmMs       int64    `json:"prewarmMs"`
+	KeepAliveMs     int64    `json:"keepAliveMs"`
+}
+
+type keepAliveWindow struct {
+	adaptive    bool // false means use the fixed ContainerExpirationTime
+	prewarm     time.Duration
+	keepAlive   time.Duration
+	lastArrival time.Time
+}
+
+/* Records an invocation arriving at time now */
+func (h *arrivalHistogram) record(now time.Time) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	if !h.lastArrival.IsZero() {
+		if h.bins == nil {
+			h.bins = make([]uint64, arrivalHistogramRange)
+		}
+		bin := int(now.Sub(h.lastArrival) / time.Second)
+		if bin < arrivalHistogramRange {
+			h.bins[bin] += 1
+		} else {
+			h.outOfBounds += 1
+		}
+		h.samples += 1
+	}
+	h.lastArrival = now
+}
+
+/* Returns the bin holding the p-th percentile IAT, or -1 if it lies beyond
+ * the histogram range. Caller must hold h.mu */
+func (h *arrivalHistogram) percentileBin(p float64) int {
+	rank := uint64(math.Ceil(p * float64(h.samples)))
+	if rank == 0 {
+		rank = 1
+	}
+	var cum uint64
+	for i, count := range h.bins {
+		cum += count
+		if cum >= rank {
+			return i
+		}
+	}
+	return -1
+}
+
+/* Derives the keep-alive windows from the histogram. Prewarm windows shorter
+ * than minPrewarm aren't worth releasing Replicas for and are dropped. Returns
+ * false if the histogram can't be trusted yet. */
+func (h *arrivalHistogram) window(minPrewarm time.Duration) (keepAliveWindow, bool) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	w := keepAliveWindow{lastArrival: h.lastArrival}
+	if h.samples < arrivalHistogramMinSamples || float64(h.outOfBounds)/float64(h.samples) > arrivalOutOfBoundsLimit {
+		return w, false
+	}
+	tail := h.percentileBin(arrivalTailPercentile)
+	if tail < 0 {
+		return w, false
+	}
+	head := h.percentileBin(arrivalHeadPercentile)
+
+	w.adaptive = true
+	w.prewarm = time.Duration(float64(head) * (1 - arrivalWindowMargin) * float64(time.Second))
+	if w.prewarm < minPrewarm {
+		w.prewarm = 0
+	}
+	w.keepAlive = time.Duration(float64(tail+1) * (1 + arrivalWindowMargin) * float64(time.Second))
+	return w, true
+}
+
+/* Reports whether an idle Replica should be released */
+func (w keepAliveWindow) expired(replica *Replica, now time.Time, fixed time.Duration) bool {
+	if !w.adaptive {
+		return now.Sub(replica.lastAccess) >= fixed
+	}
+	since := now.Sub(w.lastArrival)
+	return since < w.prewarm || since >= w.keepAlive
+}
+
+/* Returns the window with idle Replicas kept d longer (see slo.go) */
+func (w keepAliveWindow) extended(d time.Duration) keepAliveWindow {
+	w.keepAlive += d
+	return w
+}
+
+/* Reports whether the next request is expected soon, so one Replica should be
+ * kept warm */
+func (w keepAliveWindow) prewarming(now time.Time) bool {
+	if !w.adaptive || w.prewarm == 0 {
+		return false
+	}
+	since := now.Sub(w.lastArrival)
+	return since >= w.prewarm && since < w.keepAlive
+}
+
+/* Reads the keepalivePolicy label into a Function's policy */
+func parseKeepAliveLabels(labels map[string]string, policy *Policy) error {
+	policy.keepalivePolicy = "fixed"
+	if v, ok := labels["keepalivePolicy"]; ok {
+		if v != "fixed" && v != "adaptive" {
+			return fmt.Errorf("[keepalive/parseKeepAliveLabels] keepalivePolicy must be 'fixed' or 'adaptive', got '%s'", v)
+		}
+		policy.keepalivePolicy = v
+	}
+	return nil
+}
+
+/* Records an invocation of a Function; called by the proxy for every request */
+func (fs *FunctionStore) RecordArrival(fname string) {
+	fs.dfMu.RLock()
+	fn, ok := fs.deployedFunctions[fname]
+	fs.dfMu.RUnlock()
+	if ok {
+		fn.arrivals.record(time.Now())
+	}
+}
+
+func (fs *FunctionStore) minPrewarmWindow() time.Duration {
+	return time.Duration(fs.cfg.ContainerCleanupInterval) * time.Second
+}
+
+/* Collects the learned keep-alive windows, keyed by the Function whose
+ * idleReplicas they apply to. Hybrid Functions apply theirs to both sandboxes.
+ * Functions not listed use the fixed ContainerExpirationTime. */
+func (fs *FunctionStore) keepAliveWindows() map[string]keepAliveWindow {
+	windows := make(map[string]keepAliveWindow)
+	fs.dfMu.RLock()
+	defer fs.dfMu.RUnlock()
+	for name, fn := range fs.deployedFunctions {
+		fn.policyMu.RLock()
+		keepalivePolicy := fn.policy.keepalivePolicy
+		fn.policyMu.RUnlock()
+		if keepalivePolicy != "adaptive" {
+			continue
+		}
+		w, ok := fn.arrivals.window(fs.minPrewarmWindow())
+		if !ok {
+			continue
+		}
+
+		pools := []string{name}
+		if fn.labels["ctrType"] == "hybrid" {
+			pools = make([]string, 0, len(fn.sandboxes))
+			for _, sandbox := range fn.sandboxes {
+				pools = append(pools, sandbox)
+			}
+		}
+		for _, pool := range pools {
+			/* A sandbox may back several Hybrid Functions; keep Replicas
+			 * for whichever expects a request */
+			if prev, ok := windows[pool]; ok {
+				if prev.lastArrival.After(w.lastArrival) {
+					w.lastArrival = prev.lastArrival
+				}
+				if prev.prewarm < w.prewarm {
+					w.prewarm = prev.prewarm
+				}
+				if prev.keepAlive > w.keepAlive {
+					w.keepAlive = prev.keepAlive
+				}
+			}
+			windows[pool] = w
+		}
+	}
+	return windows
+}
+
+/* Returns the learned inter-arrival histogram of a Function */
+func GetKeepAliveReport(fs *FunctionStore, fname string) (arrivalHistogramJSON, error) {
+	fs.dfMu.RLock()
+	fn, ok := fs.deployedFunctions[fname]
+	fs.dfMu.RUnlock()
+	if !ok {
+		return arrivalHistogramJSON{}, fmt.Errorf("[keepalive/GetKeepAliveReport] Function '%s' not found", fname)
+	}
+
+	fn.policyMu.RLock()
+	report := arrivalHistogramJSON{Fname: fname, KeepalivePolicy: fn.policy.keepalivePolicy, BinSeconds: 1}
+	fn.policyMu.RUnlock()
+
+	w, ok := fn.arrivals.window(fs.minPrewarmWindow())
+	report.Adaptive = ok && report.KeepalivePolicy == "adaptive"
+	if report.Adaptive {
+		report.PrewarmMs = w.prewarm.Milliseconds()
+		report.KeepAliveMs = w.keepAlive.Milliseconds()
+	} else {
+		report.KeepAliveMs = int64(fs.cfg.ContainerExpirationTime) * 1000
+	}
+
+	fn.arrivals.mu.Lock()
+	report.Samples = fn.arrivals.samples
+	report.OutOfBounds = fn.arrivals.outOfBounds
+	/* Trim empty bins past the longest IAT seen */
+	last := len(fn.arrivals.bins)
+	for last > 0 && fn.arrivals.bins[last-1] == 0 {
+		last--
+	}
+	report.Bins = append([]uint64{}, fn.arrivals.bins[:last]...)
+	fn.arrivals.mu.Unlock()
+
+	return report, nil
+}
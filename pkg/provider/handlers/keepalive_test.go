@@ -0,0 +1,117 @@
+package handlers
+
+import (
+	"testing"
+	"time"
+)
+
+/* Records arrivals spaced by the given inter-arrival times */
+func recordArrivals(h *arrivalHistogram, start time.Time, iats []time.Duration) time.Time {
+	now := start
+	h.record(now)
+	for _, iat := range iats {
+		now = now.Add(iat)
+		h.record(now)
+	}
+	return now
+}
+
+func repeatIAT(iat time.Duration, n int) []time.Duration {
+	iats := make([]time.Duration, n)
+	for i := range iats {
+		iats[i] = iat
+	}
+	return iats
+}
+
+func Test_arrivalHistogramWindow(t *testing.T) {
+	start := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	tests := []struct {
+		name          string
+		iats          []time.Duration
+		minPrewarm    time.Duration
+		wantAdaptive  bool
+		wantPrewarm   time.Duration
+		wantKeepAlive time.Duration
+	}{
+		{
+			name:         "too few samples",
+			iats:         repeatIAT(30*time.Second, arrivalHistogramMinSamples-1),
+			wantAdaptive: false,
+		},
+		{
+			name:         "mostly out of range",
+			iats:         repeatIAT((arrivalHistogramRange+1)*time.Second, 20),
+			wantAdaptive: false,
+		},
+		{
+			name:          "periodic arrivals",
+			iats:          repeatIAT(30*time.Second, 20),
+			minPrewarm:    10 * time.Second,
+			wantAdaptive:  true,
+			wantPrewarm:   27 * time.Second,
+			wantKeepAlive: time.Duration(31 * 1.1 * float64(time.Second)),
+		},
+		{
+			name:          "short prewarm is dropped",
+			iats:          repeatIAT(5*time.Second, 20),
+			minPrewarm:    10 * time.Second,
+			wantAdaptive:  true,
+			wantPrewarm:   0,
+			wantKeepAlive: time.Duration(6 * 1.1 * float64(time.Second)),
+		},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			h := &arrivalHistogram{}
+			recordArrivals(h, start, tc.iats)
+			w, ok := h.window(tc.minPrewarm)
+			if ok != tc.wantAdaptive {
+				t.Fatalf("want adaptive=%v, got %v", tc.wantAdaptive, ok)
+			}
+			if !ok {
+				return
+			}
+			if w.prewarm != tc.wantPrewarm {
+				t.Fatalf("want prewarm %s, got %s", tc.wantPrewarm, w.prewarm)
+			}
+			if w.keepAlive != tc.wantKeepAlive {
+				t.Fatalf("want keepAlive %s, got %s", tc.wantKeepAlive, w.keepAlive)
+			}
+		})
+	}
+}
+
+func Test_keepAliveWindowExpired(t *testing.T) {
+	last := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	replica := &Replica{lastAccess: last}
+	fixed := 60 * time.Second
+	adaptive := keepAliveWindow{adaptive: true, prewarm: 20 * time.Second, keepAlive: 40 * time.Second, lastArrival: last}
+
+	tests := []struct {
+		name          string
+		window        keepAliveWindow
+		since         time.Duration
+		wantExpired   bool
+		wantPrewarmed bool
+	}{
+		{name: "fixed before expiration", window: keepAliveWindow{}, since: 59 * time.Second, wantExpired: false},
+		{name: "fixed after expiration", window: keepAliveWindow{}, since: 60 * time.Second, wantExpired: true},
+		{name: "adaptive before prewarm", window: adaptive, since: 5 * time.Second, wantExpired: true},
+		{name: "adaptive within window", window: adaptive, since: 30 * time.Second, wantExpired: false, wantPrewarmed: true},
+		{name: "adaptive after keep-alive", window: adaptive, since: 40 * time.Second, wantExpired: true},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			now := last.Add(tc.since)
+			if got := tc.window.expired(replica, now, fixed); got != tc.wantExpired {
+				t.Fatalf("want expired=%v, got %v", tc.wantExpired, got)
+			}
+			if got := tc.window.prewarming(now); got != tc.wantPrewarmed {
+				t.Fatalf("want prewarming=%v, got %v", tc.wantPrewarmed, got)
+			}
+		})
+	}
+}
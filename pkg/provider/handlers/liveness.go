@@ -0,0 +1,121 @@
+package handlers
+
+import (
+	"fmt"
+	"time"
+
+	"github.gatech.edu/faasedge/fecore/pkg/timec"
+)
+
+/* Liveness tracking. Every Replica created by createReplica gets a watcher
+ * that blocks on its sandbox (runw process or containerd task) until it
+ * exits. Replicas torn down by fecore are marked terminating first, so their
+ * exit is expected. Any other exit means the Replica died: it is removed from
+ * the idle/active pools so no request is routed to a dead IP, its resources
+ * (netns/IP, cgroups, rootfs, container slot) are released and the exit
+ * reason is recorded. */
+
+const maxReplicaExits = 100 // recent unexpected exits kept for the metrics API
+
+type replicaExitRecord struct {
+	Replica  string    `json:"replica"`
+	Fname    string    `json:"fname"`
+	CtrType  string    `json:"ctrType"`
+	ExitCode int       `json:"exitCode"`
+	Reason   string    `json:"reason"`
+	WasBusy  bool      `json:"wasBusy"` // Replica died while serving invocations
+	Time     time.Time `json:"time"`
+}
+
+type replicaExitReport struct {
+	Fname  string              `json:"fname,omitempty"`
+	Deaths int64               `json:"deaths"`
+	Recent []replicaExitRecord `json:"recent"`
+}
+
+/* Waits for a Replica's sandbox to exit and purges the Replica if fecore
+ * didn't tear it down itself */
+func (fs *FunctionStore) watchReplica(rt SandboxRuntime, replica *Replica) {
+	exit := waitReplica(fs, rt, replica)
+
+	if !replica.terminating.CompareAndSwap(false, true) {
+		timec.LogEvent("liveness/watchReplica", fmt.Sprintf("Replica '%s' stopped: %s", replica.uuid, exit.Reason), 3)
+		return
+	}
+
+	wasBusy := fs.purgeReplica(replica)
+	fs.forgetReplica(replica)
+	timec.LogEvent("liveness/watchReplica", fmt.Sprintf("Replica '%s' of '%s' died (%s); purging", replica.uuid, replica.fname, exit.Reason), 1)
+	if err := rt.Delete(fs, replica); err != nil {
+		timec.LogEvent("liveness/watchReplica", fmt.Sprintf("Unable to release resources of dead replica '%s': %s", replica.uuid, err), 1)
+	}
+
+	fs.recordReplicaExit(replicaExitRecord{
+		Replica:  replica.uuid,
+		Fname:    replica.fname,
+		CtrType:  replica.ctrType,
+		ExitCode: exit.ExitCode,
+		Reason:   exit.Reason,
+		WasBusy:  wasBusy,
+		Time:     time.Now(),
+	})
+}
+
+/* Blocks until a Replica's sandbox exits. A sandbox restarted by a reset
+ * (see isolation.go) is watched again. */
+func waitReplica(fs *FunctionStore, rt SandboxRuntime, replica *Replica) ReplicaExit {
+	for {
+		resets := replica.resetCount()
+		exit := rt.Wait(fs, replica)
+		if replica.resetCount() == resets {
+			return exit
+		}
+	}
+}
+
+/* Removes a Replica from its Function's idle and active pools. Returns true
+ * if it was active. */
+func (fs *FunctionStore) purgeReplica(replica *Replica) bool {
+	fn, ok := fs.lookupFunction(replica.fname)
+	if !ok {
+		return false
+	}
+
+	fn.poolMu.Lock()
+	defer fn.poolMu.Unlock()
+	fn.idleReplicas.remove(replica)
+	if _, active := fn.activeReplicas[replica.uuid]; active {
+		delete(fn.activeReplicas, replica.uuid)
+		return true
+	}
+	return false
+}
+
+func (fs *FunctionStore) recordReplicaExit(record replicaExitRecord) {
+	fs.livenessMu.Lock()
+	defer fs.livenessMu.Unlock()
+	fs.replicaDeaths[record.Fname] += 1
+	fs.replicaExits = append(fs.replicaExits, record)
+	if len(fs.replicaExits) > maxReplicaExits {
+		fs.replicaExits = fs.replicaExits[len(fs.replicaExits)-maxReplicaExits:]
+	}
+}
+
+/* Returns the Replicas that died unexpectedly, for one Function or (with an
+ * empty fname) for all of them */
+func GetReplicaExitReport(fs *FunctionStore, fname string) replicaExitReport {
+	report := replicaExitReport{Fname: fname, Recent: make([]replicaExitRecord, 0)}
+	fs.livenessMu.Lock()
+	defer fs.livenessMu.Unlock()
+	for name, deaths := range fs.replicaDeaths {
+		if fname == "" || name == fname {
+			report.Deaths += deaths
+		}
+	}
+	for _, record := range fs.replicaExits {
+		if fname == "" || record.Fname == fname {
+			report.Recent = append(report.Recent, record)
+		}
+	}
+	return report
+}
@@ -0,0 +1,127 @@
+package handlers
+
+import (
+	"testing"
+
+	"github.gatech.edu/faasedge/fecore/pkg/provider/config"
+)
+
+/* Sandbox runtime whose replicas exit when told to */
+type exitingRuntime struct {
+	wasmRuntime
+	exit    chan ReplicaExit
+	deleted chan string
+}
+
+func (rt *exitingRuntime) Wait(fs *FunctionStore, replica *Replica) ReplicaExit {
+	return <-rt.exit
+}
+
+func (rt *exitingRuntime) Delete(fs *FunctionStore, replica *Replica) error {
+	rt.deleted <- replica.uuid
+	return nil
+}
+
+func newLivenessTestStore(t *testing.T) (*FunctionStore, *exitingRuntime) {
+	fs := newTestStore(t, config.Config{}, &Function{name: "fn"})
+	rt := &exitingRuntime{exit: make(chan ReplicaExit), deleted: make(chan string, 1)}
+	return fs, rt
+}
+
+func Test_watchReplicaPurgesDeadReplica(t *testing.T) {
+	fs, rt := newLivenessTestStore(t)
+	dead := &Replica{fname: "fn", uuid: "dead"}
+	fs.AddIdleReplica(&Replica{fname: "fn", uuid: "a"})
+	fs.AddIdleReplica(dead)
+	fs.AddIdleReplica(&Replica{fname: "fn", uuid: "b"})
+
+	done := make(chan struct{})
+	go func() {
+		fs.watchReplica(rt, dead)
+		close(done)
+	}()
+	rt.exit <- ReplicaExit{ExitCode: -1, Reason: "killed by signal 9"}
+	<-done
+
+	if deleted := <-rt.deleted; deleted != "dead" {
+		t.Fatalf("want resources of 'dead' released, got '%s'", deleted)
+	}
+	idle := fs.deployedFunctions["fn"].idleReplicas
+	if idle.size() != 2 || idle.LRU.uuid != "a" || idle.MRU.uuid != "b" {
+		t.Fatalf("want idle pool [a b], got size=%d", idle.size())
+	}
+	report := GetReplicaExitReport(fs, "fn")
+	if report.Deaths != 1 || len(report.Recent) != 1 || report.Recent[0].Reason != "killed by signal 9" {
+		t.Fatalf("unexpected exit report: %+v", report)
+	}
+}
+
+func Test_watchReplicaIgnoresDeletedReplica(t *testing.T) {
+	fs, rt := newLivenessTestStore(t)
+	replica := &Replica{fname: "fn", uuid: "a"}
+	fs.AddIdleReplica(replica)
+
+	done := make(chan struct{})
+	go func() {
+		fs.watchReplica(rt, replica)
+		close(done)
+	}()
+	/* fecore tears the replica down itself */
+	replica.terminating.Store(true)
+	rt.exit <- ReplicaExit{ExitCode: -1, Reason: "killed by signal 9"}
+	<-done
+
+	if report := GetReplicaExitReport(fs, ""); report.Deaths != 0 {
+		t.Fatalf("want no deaths recorded for a deleted replica, got %+v", report)
+	}
+	select {
+	case deleted := <-rt.deleted:
+		t.Fatalf("want no second delete, got delete of '%s'", deleted)
+	default:
+	}
+}
+
+func Test_IdleReplicasRemove(t *testing.T) {
+	tests := []struct {
+		name   string
+		pool   []string
+		remove string
+		want   []string
+	}{
+		{name: "remove LRU", pool: []string{"a", "b", "c"}, remove: "a", want: []string{"b", "c"}},
+		{name: "remove MRU", pool: []string{"a", "b", "c"}, remove: "c", want: []string{"a", "b"}},
+		{name: "remove middle", pool: []string{"a", "b", "c", "d"}, remove: "b", want: []string{"a", "c", "d"}},
+		{name: "remove only", pool: []string{"a"}, remove: "a", want: []string{}},
+		{name: "not idle", pool: []string{"a", "b"}, remove: "x", want: []string{"a", "b"}},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			fs := newTestStore(t, config.Config{}, &Function{name: "fn"})
+			replicas := make(map[string]*Replica)
+			for _, name := range tc.pool {
+				replicas[name] = &Replica{fname: "fn", uuid: name}
+				fs.AddIdleReplica(replicas[name])
+			}
+			pool := &fs.deployedFunctions["fn"].idleReplicas
+			target := replicas[tc.remove]
+			if target == nil {
+				target = &Replica{uuid: tc.remove}
+			}
+			pool.remove(target)
+
+			got := make([]string, 0)
+			for replica := pool.popLRU(); replica != nil; replica = pool.popLRU() {
+				got = append(got, replica.uuid)
+			}
+			if len(got) != len(tc.want) {
+				t.Fatalf("want %v, got %v", tc.want, got)
+			}
+			for i := range got {
+				if got[i] != tc.want[i] {
+					t.Fatalf("want %v, got %v", tc.want, got)
+				}
+			}
+		})
+	}
+}
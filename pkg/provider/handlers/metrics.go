@@ -4,6 +4,7 @@ import (
 	"encoding/json"
 
 	"fmt"
+	"html"
 	"net/http"
 	"strconv"
 
@@ -40,6 +41,40 @@ func MakeMetricsHandler(fs *FunctionStore) http.HandlerFunc {
 			fname := r.URL.Query().Get("fname")
 			fstat := GetMetricsLog(fs, fname)
 			jsonOut, marshalErr = json.Marshal(fstat)
+		case "keepalive":
+			returnType = "json"
+			fname := r.URL.Query().Get("fname")
+			report, err := GetKeepAliveReport(fs, fname)
+			if err != nil {
+				http.Error(w, err.Error(), http.StatusNotFound)
+				return
+			}
+			jsonOut, marshalErr = json.Marshal(report)
+		case "admission":
+			returnType = "json"
+			fname := r.URL.Query().Get("fname")
+			report, err := GetAdmissionReport(fs, fname)
+			if err != nil {
+				http.Error(w, err.Error(), http.StatusNotFound)
+				return
+			}
+			jsonOut, marshalErr = json.Marshal(report)
+		case "exits":
+			returnType = "json"
+			fname := r.URL.Query().Get("fname")
+			jsonOut, marshalErr = json.Marshal(GetReplicaExitReport(fs, fname))
+		case "slo":
+			returnType = "json"
+			fname := r.URL.Query().Get("fname")
+			report, err := GetSLOReport(fs, fname)
+			if err != nil {
+				http.Error(w, err.Error(), http.StatusNotFound)
+				return
+			}
+			jsonOut, marshalErr = json.Marshal(report)
+		case "evictions":
+			returnType = "json"
+			jsonOut, marshalErr = json.Marshal(GetEvictionReport(fs))
 		case "stats":
 			returnType = "html"
 			fname := r.URL.Query().Get("fname")
@@ -47,7 +82,7 @@ func MakeMetricsHandler(fs *FunctionStore) http.HandlerFunc {
 		default:
 			returnType = "json"
 			timec.WriteDurationLog()
-			jsonOut, marshalErr = json.Marshal(timec.TimeLog)
+			jsonOut, marshalErr = json.Marshal(timec.DurationLog())
 		}
 
 		if marshalErr != nil {
@@ -68,46 +103,76 @@ func MakeMetricsHandler(fs *FunctionStore) http.HandlerFunc {
 }
 
 func GetMetricsLog(fs *FunctionStore, fname string) [100]FunctionStat {
-	fstat := fs.functionStats[fname].Entries
+	stats, ok := fs.lookupStats(fname)
+	if !ok {
+		return [100]FunctionStat{}
+	}
+	stats.statMu.RLock()
+	defer stats.statMu.RUnlock()
+	fstat := stats.Entries
 	return fstat
 }
 
 func GetMetricsReport(fs *FunctionStore, fname string) string {
+	stats, ok := fs.lookupStats(fname)
+	fn, fnOk := fs.lookupFunction(fname)
+	if !ok || !fnOk {
+		return "<!DOCTYPE html><html><body>Unable to find Function '" + html.EscapeString(fname) + "'</body></html>"
+	}
 
-	fs.functionStats[fname].statMu.RLock()
-	totalInvocations := strconv.FormatInt(fs.functionStats[fname].totalInvocations, 10)
-	numStats := strconv.Itoa(fs.functionStats[fname].entryPos)
+	stats.statMu.RLock()
+	totalInvocations := strconv.FormatInt(stats.totalInvocations, 10)
+	numStats := strconv.Itoa(stats.entryPos)
 	var totalSetupTime int
 	var totalExecTime int
-	for _, startupTime := range fs.functionStats[fname].startupTimes {
+	for _, startupTime := range stats.startupTimes {
 		totalSetupTime += startupTime
 	}
-	for _, execTime := range fs.functionStats[fname].execTimes {
+	for _, execTime := range stats.execTimes {
 		totalExecTime += execTime
 	}
 
-	avgExecTime := fmt.Sprintf("%d", fs.functionStats[fname].avgExecTime)
-	avgStartupTime := fmt.Sprintf("%d", fs.functionStats[fname].avgStartupTime)
+	avgExecTime := fmt.Sprintf("%d", stats.avgExecTime)
+	avgStartupTime := fmt.Sprintf("%d", stats.avgStartupTime)
 	var avgServiceTime string
-	if fs.functionStats[fname].totalInvocations > 0 {
+	if stats.totalInvocations == 0 {
 		avgServiceTime = "0"
 	} else {
-		avgServiceTime = fmt.Sprintf("%d", (fs.functionStats[fname].totalExecTime+fs.functionStats[fname].totalStartupTime)/fs.functionStats[fname].totalInvocations)
+		avgServiceTime = fmt.Sprintf("%d", (stats.totalExecTime+stats.totalStartupTime)/stats.totalInvocations)
+	}
+	p50ServiceTime := fmt.Sprintf("%d", stats.p50SvcTime)
+	p99ServiceTime := fmt.Sprintf("%d", stats.p99SvcTime)
+	sandboxUtilization := fmt.Sprintf("%.2f", stats.sandboxUtil)
+	avgSvcCold := fmt.Sprintf("%d", stats.avgSvcCold)
+	avgSvcWarm := fmt.Sprintf("%d", stats.avgSvcWarm)
+	thawedStarts := strconv.Itoa(stats.thawedStarts)
+	avgThawTime := fmt.Sprintf("%d", stats.avgThawTime)
+	avgSvcThawed := fmt.Sprintf("%d", stats.avgSvcThawed)
+	recycledReplicas := strconv.Itoa(stats.recycledReplicas)
+	resets := strconv.Itoa(stats.resets)
+	resetFailures := strconv.Itoa(stats.resetFailures)
+	avgResetTime := strconv.FormatInt(stats.avgResetTime, 10)
+	hedges := fmt.Sprintf("%d won / %d lost / %d failed", stats.hedgeWins, stats.hedgeLosses, stats.hedgeFailures)
+	warmups := strconv.Itoa(stats.warmups)
+	warmupFailures := strconv.Itoa(stats.warmupFailures)
+	avgWarmupTime := strconv.FormatInt(stats.avgWarmupTime, 10)
+	readyFailures := strconv.Itoa(stats.readyFailures)
+	avgReadyTime := strconv.FormatInt(stats.avgReadyTime, 10)
+	stats.statMu.RUnlock()
+
+	fn.policyMu.Lock()
+	policy := fn.policy
+	slo := "none"
+	if policy.hasSLO() {
+		report := fs.sloReport(fn, policy)
+		slo = fmt.Sprintf("p99 &le; %d ms: %.2f%% attained, %.0f%% budget left, burn %.2f; cold ratio &le; %d%%: %.2f%% of epochs, burn %.2f", report.SLOLatency, report.LatencyAttainment*100, report.LatencyBudgetRemaining*100, report.LatencyBurnRate, report.SLOColdRatio, report.ColdRatioAttainment*100, report.ColdRatioBurnRate)
 	}
-	p50ServiceTime := fmt.Sprintf("%d", fs.functionStats[fname].p50SvcTime)
-	p99ServiceTime := fmt.Sprintf("%d", fs.functionStats[fname].p99SvcTime)
-	sandboxUtilization := fmt.Sprintf("%.2f", fs.functionStats[fname].sandboxUtil)
-	avgSvcCold := fmt.Sprintf("%d", fs.functionStats[fname].avgSvcCold)
-	avgSvcWarm := fmt.Sprintf("%d", fs.functionStats[fname].avgSvcWarm)
-	fs.functionStats[fname].statMu.RUnlock()
-
-	fs.deployedFunctions[fname].policyMu.Lock()
-	policy := fs.deployedFunctions[fname].policy
 	coldStartCtrType := policy.coldStartCtrType
 	warmStartCtrType := policy.warmStartCtrType
 	spawnAddlCtr := fmt.Sprintf("%d", policy.spawnAddlCtrs)
+	concurrency := policy.concurrency()
 	/* Update the policy */
-	fs.deployedFunctions[fname].policyMu.Unlock()
+	fn.policyMu.Unlock()
 
 	reportHeader := "<!DOCTYPE html><html><head><title>" + fname + `</title>
 	<style>
@@ -153,6 +218,17 @@ table.stats {
 <tr><td align="right">Sandbox Utilization: </td><td>` + sandboxUtilization + `</td></tr>
 <tr><td>Avg. Svc. Cold: </td><td>` + avgSvcCold + `</td></tr>
 <tr><td>Avg. Svc. Warm: </td><td>` + avgSvcWarm + `</td></tr>
+<tr><td>Thawed Starts: </td><td>` + thawedStarts + `</td></tr>
+<tr><td>Avg. Thaw Time: </td><td>` + avgThawTime + `</td></tr>
+<tr><td>Avg. Svc. Thawed: </td><td>` + avgSvcThawed + `</td></tr>
+<tr><td>Recycled Replicas: </td><td>` + recycledReplicas + `</td></tr>
+<tr><td>Replica Resets: </td><td>` + resets + ` (` + resetFailures + ` failed)</td></tr>
+<tr><td>Avg. Reset Time (us): </td><td>` + avgResetTime + `</td></tr>
+<tr><td>Hedged Cold Starts: </td><td>` + hedges + `</td></tr>
+<tr><td>Replica Warmups: </td><td>` + warmups + ` (` + warmupFailures + ` failed)</td></tr>
+<tr><td>Avg. Warmup Time (ms): </td><td>` + avgWarmupTime + `</td></tr>
+<tr><td>Avg. Readiness Time (ms): </td><td>` + avgReadyTime + ` (` + readyFailures + ` never ready)</td></tr>
+<tr><td>SLO: </td><td>` + slo + `</td></tr>
 </table>
 <hr>
 <h2>Policy</h2>
@@ -160,47 +236,69 @@ table.stats {
 <tr><td>Cold Start Sandbox: </td><td>` + coldStartCtrType + `</td></tr>
 <tr><td>Warm Start Sandbox: </td><td>` + warmStartCtrType + `</td></tr>
 <tr><td>Spawn Addl Sandbox: </td><td>` + spawnAddlCtr + `</td></tr>
+<tr><td>Replica Concurrency: </td><td>` + strconv.Itoa(concurrency) + `</td></tr>
 </table>
 <hr>`
 	reportFooter := "</body></html>"
 	nativeReplicaCount := 0
 	wasmReplicaCount := 0
-	fn := fs.deployedFunctions[fname]
-	/* Get idle replicas */
-	reportIdleReplicas := `<br><p><h2>Idle Replicas</h2><table class="replicas"><tr><th>UUID</th><th>PID</th><th>IP</th><th>Type</th></tr>`
-
-	if fn.idleReplicas.LRU != nil {
-		replica := fn.idleReplicas.LRU
-		reportIdleReplicas += "<tr><td>" + replica.uuid + " (LRU) </td><td>" + strconv.FormatInt(int64(replica.PID), 10) + "</td><td>" + replica.IP + "</td><td>" + replica.ctrType + "</td></tr>"
+	/* Snapshot the pool so the runtime Stats calls below don't hold up
+	 * invocations of this Function */
+	fn.poolMu.RLock()
+	idle := fn.idleReplicas.replicas()
+	active := make([]*Replica, 0, len(fn.activeReplicas))
+	inflight := make([]int, 0, len(fn.activeReplicas))
+	for _, replica := range fn.activeReplicas {
+		active = append(active, replica)
+		inflight = append(inflight, replica.inflight)
 	}
+	fn.poolMu.RUnlock()
 
-	if fn.idleReplicas.MRU != nil {
-		replica := fn.idleReplicas.MRU
-		reportIdleReplicas += "<tr><td>" + replica.uuid + " (MRU) </td><td>" + strconv.FormatInt(int64(replica.PID), 10) + "</td><td>" + replica.IP + "</td><td>" + replica.ctrType + "</td></tr>"
-		for _, replica = range fn.idleReplicas.containers {
-			if replica.ctrType == "native" {
-				nativeReplicaCount += 1
-			}
-			if replica.ctrType == "wasm" {
-				wasmReplicaCount += 1
-			}
-			reportIdleReplicas += "<tr><td>" + replica.uuid + "</td><td>" + strconv.FormatInt(int64(replica.PID), 10) + "</td><td>" + replica.IP + "</td><td>" + replica.ctrType + "</td></tr>"
+	/* Get idle replicas */
+	reportIdleReplicas := `<br><p><h2>Idle Replicas</h2><table class="replicas"><tr><th>UUID</th><th>PID</th><th>IP</th><th>Type</th><th>Mem (KB)</th></tr>`
+	for i, replica := range idle {
+		if replica.ctrType == "native" {
+			nativeReplicaCount += 1
 		}
-		reportIdleReplicas += "</table>"
+		if replica.ctrType == "wasm" {
+			wasmReplicaCount += 1
+		}
+		tag := ""
+		if i == len(idle)-1 {
+			tag = " (MRU) "
+		} else if i == 0 {
+			tag = " (LRU) "
+		}
+		if replica.frozen.Load() {
+			tag += " (frozen) "
+		}
+		reportIdleReplicas += replicaReportRow(fs, replica, tag)
 	}
+	reportIdleReplicas += "</table>"
 
 	/* Get active replicas */
-	reportActiveReplicas := `<br><p><h2>Active Replicas</h2><table class="replicas"><tr><th>UUID</th><th>PID</th><th>IP</th><th>Type</th></tr>`
-	for _, replica := range fn.activeReplicas {
+	reportActiveReplicas := `<br><p><h2>Active Replicas</h2><table class="replicas"><tr><th>UUID</th><th>PID</th><th>IP</th><th>Type</th><th>Mem (KB)</th></tr>`
+	for i, replica := range active {
 		if replica.ctrType == "native" {
 			nativeReplicaCount += 1
 		}
 		if replica.ctrType == "wasm" {
 			wasmReplicaCount += 1
 		}
-		reportActiveReplicas += "<tr><td>" + replica.uuid + "</td><td>" + strconv.FormatInt(int64(replica.PID), 10) + "</td><td>" + replica.IP + "</td><td>" + replica.ctrType + "</td></tr>"
+		reportActiveReplicas += replicaReportRow(fs, replica, fmt.Sprintf(" (%d/%d in-flight) ", inflight[i], concurrency))
 	}
 	reportActiveReplicas += "</table>"
 
 	return reportHeader + reportActiveReplicas + reportIdleReplicas + reportFooter
 }
+
+/* Renders one row of the replica tables in the metrics report */
+func replicaReportRow(fs *FunctionStore, replica *Replica, tag string) string {
+	mem := "-"
+	if rt, err := GetSandboxRuntime(replica.ctrType); err == nil {
+		if stats, err := rt.Stats(fs, replica); err == nil {
+			mem = strconv.FormatUint(stats.MemoryBytes/1024, 10)
+		}
+	}
+	return "<tr><td>" + replica.uuid + tag + "</td><td>" + strconv.FormatInt(int64(replica.PID), 10) + "</td><td>" + replica.IP + "</td><td>" + replica.ctrType + "</td><td>" + mem + "</td></tr>"
+}
@@ -0,0 +1,334 @@
+package handlers
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"path"
+	"strings"
+	"time"
+
+	"github.com/containerd/containerd"
+	"github.com/containerd/containerd/namespaces"
+	"github.com/containerd/containerd/oci"
+	"github.com/opencontainers/runtime-spec/specs-go"
+	fecore "github.gatech.edu/faasedge/fecore/pkg"
+	cninetwork "github.gatech.edu/faasedge/fecore/pkg/cninetwork"
+	"github.gatech.edu/faasedge/fecore/pkg/service"
+	"github.gatech.edu/faasedge/fecore/pkg/timec"
+)
+
+const (
+	nativeCgroupMemPath = "/sys/fs/cgroup/memory"
+	nativeCgroupCPUPath = "/sys/fs/cgroup/cpuacct"
+	maxNativeContainers = 1000
+)
+
+/* Runs Function replicas as containerd containers */
+type nativeRuntime struct{}
+
+func (rt *nativeRuntime) Type() string {
+	return "native"
+}
+
+func (rt *nativeRuntime) Suffix() string {
+	return "_n"
+}
+
+func (rt *nativeRuntime) Reserve(fs *FunctionStore) bool {
+	return fs.AddContainerCount()
+}
+
+func (rt *nativeRuntime) Release(fs *FunctionStore) {
+	fs.DelContainerCount()
+}
+
+func (rt *nativeRuntime) Create(fs *FunctionStore, fname string, replicaName string, requestID string) (*Replica, error) {
+	defer timec.RecordDuration("(native_runtime.go).Create <requestID="+requestID+">", time.Now())
+
+	fn := Function{}
+	err := fs.GetDeployedFunction(fname, &fn, requestID)
+	if err != nil {
+		return nil, err
+	}
+	ctx := namespaces.WithNamespace(context.Background(), fn.namespace)
+
+	// snapshotter := "overlay"
+	snapshotter := ""
+	if val, ok := os.LookupEnv("snapshotter"); ok {
+		snapshotter = val
+	}
+
+	image, err := service.PrepareImage(ctx, fs.Client, fn.image, requestID, snapshotter, false)
+	if err != nil {
+		return nil, fmt.Errorf("[native_runtime/Create] Unable to pull image %s, %w", fn.image, err)
+	}
+
+	envs := prepareEnv(fn.envProcess, fn.envVars)
+
+	mounts := getOSMounts()
+
+	for _, secret := range fn.secrets {
+		mounts = append(mounts, specs.Mount{
+			Destination: path.Join("/var/openfaas/secrets", secret),
+			Type:        "bind",
+			Source:      path.Join(fn.secretsPath, secret),
+			Options:     []string{"rbind", "ro"},
+		})
+	}
+
+	labels := fn.labels
+
+	//var memory *specs.LinuxMemory
+	var memory = &specs.LinuxMemory{}
+	memory.Limit = &fn.memoryLimit
+
+	specOpts := []oci.SpecOpts{}
+	/* Every task of a reset Replica starts with empty writable paths over a
+	 * read-only rootfs (see isolation.go) */
+	if fs.GetInvocationPolicy(fname).isolation == isolationReset {
+		for _, p := range writablePaths(labels) {
+			mounts = append(mounts, specs.Mount{
+				Destination: p,
+				Type:        "tmpfs",
+				Source:      "tmpfs",
+				Options:     []string{"nosuid", "nodev", "mode=1777"},
+			})
+		}
+		specOpts = append(specOpts, oci.WithRootFSReadonly())
+	}
+
+	container, err := fs.Client.NewContainer(
+		ctx,
+		replicaName,
+		// requestID,
+		containerd.WithImage(image),
+		containerd.WithSnapshotter(snapshotter),
+		containerd.WithNewSnapshot(replicaName+"-snapshot", image),
+		containerd.WithNewSpec(append([]oci.SpecOpts{oci.WithImageConfig(image),
+			oci.WithHostname(replicaName),
+			oci.WithCapabilities([]string{"CAP_NET_RAW"}),
+			oci.WithMounts(mounts),
+			oci.WithAnnotations(labels),
+			oci.WithEnv(envs),
+			oci.WithCPUShares(1024),
+			oci.WithCPUs("5-15"),
+			withMemory(memory)}, specOpts...)...),
+		containerd.WithContainerLabels(labels),
+	)
+
+	if err != nil {
+		return nil, fmt.Errorf("[native_runtime/Create] Unable to create container '%s': %w", replicaName, err)
+	}
+
+	replica := Replica{}
+	replica.fname = fn.name
+	replica.ctrType = rt.Type()
+	replica.uuid = replicaName
+	replica.namespace = fn.namespace
+	replica.container = container
+	return &replica, nil
+}
+
+func (rt *nativeRuntime) Start(fs *FunctionStore, replica *Replica, requestID string) error {
+	defer timec.RecordDuration("(native_runtime.go).Start <requestID="+requestID+">", time.Now())
+	ctx := namespaces.WithNamespace(context.Background(), replica.namespace)
+
+	ip, createTaskStatus := createTask(ctx, replica.container, requestID, *fs.CNI)
+	if createTaskStatus != nil {
+		return fmt.Errorf("[native_runtime/Start] Unable to create task for container '%s': %w", replica.uuid, createTaskStatus)
+	}
+	task, err := replica.container.Task(ctx, nil)
+	if err != nil {
+		return err
+	}
+	// Task for container exists
+	_, err = task.Status(ctx)
+	if err != nil {
+		return fmt.Errorf("[native_runtime/Start] Unable to get task status for container '%s': %w", replica.uuid, err)
+	}
+	replica.PID = task.Pid()
+	replica.IP = ip
+	replica.lastAccess = time.Now()
+	timec.LogEvent("native_runtime/Start", fmt.Sprintf("Created native container for Function '%s' <requestID=%s>", replica.uuid, requestID), 2)
+	return nil
+}
+
+func (rt *nativeRuntime) Freeze(fs *FunctionStore, replica *Replica) error {
+	task, ctx, err := rt.task(replica)
+	if err != nil {
+		return err
+	}
+	return task.Pause(ctx)
+}
+
+func (rt *nativeRuntime) Thaw(fs *FunctionStore, replica *Replica) error {
+	task, ctx, err := rt.task(replica)
+	if err != nil {
+		return err
+	}
+	return task.Resume(ctx)
+}
+
+/* Replaces the Replica's task with a new one, which clears its memory and
+ * remounts its tmpfs writable paths. The container and snapshot are kept. */
+func (rt *nativeRuntime) Reset(fs *FunctionStore, replica *Replica, requestID string) error {
+	defer timec.RecordDuration("(native_runtime.go).Reset <requestID="+requestID+">", time.Now())
+	task, ctx, err := rt.task(replica)
+	if err != nil {
+		return err
+	}
+	if err := cninetwork.DeleteCNINetwork(ctx, *fs.CNI, fs.Client, replica.uuid); err != nil {
+		timec.LogEvent("native_runtime/Reset", fmt.Sprintf("Error removing network for replica '%s': %s <requestID=%s>", replica.uuid, err, requestID), 1)
+	}
+	if _, err := task.Delete(ctx, containerd.WithProcessKill); err != nil {
+		return fmt.Errorf("[native_runtime/Reset] Unable to delete task for container '%s': %w", replica.uuid, err)
+	}
+	ip, err := createTask(ctx, replica.container, requestID, *fs.CNI)
+	if err != nil {
+		return fmt.Errorf("[native_runtime/Reset] Unable to create task for container '%s': %w", replica.uuid, err)
+	}
+	task, err = replica.container.Task(ctx, nil)
+	if err != nil {
+		return err
+	}
+	replica.PID = task.Pid()
+	replica.IP = ip
+	return nil
+}
+
+/* Returns the containerd task of a started Replica */
+func (rt *nativeRuntime) task(replica *Replica) (containerd.Task, context.Context, error) {
+	if replica.container == nil {
+		return nil, nil, fmt.Errorf("[native_runtime/task] Replica '%s' has no container", replica.uuid)
+	}
+	ctx := namespaces.WithNamespace(context.Background(), replica.namespace)
+	task, err := replica.container.Task(ctx, nil)
+	if err != nil {
+		return nil, nil, fmt.Errorf("[native_runtime/task] No task for container '%s': %w", replica.uuid, err)
+	}
+	return task, ctx, nil
+}
+
+func (rt *nativeRuntime) Wait(fs *FunctionStore, replica *Replica) ReplicaExit {
+	if replica.container == nil {
+		return ReplicaExit{ExitCode: -1, Reason: "no container"}
+	}
+	ctx := namespaces.WithNamespace(context.Background(), replica.namespace)
+	task, err := replica.container.Task(ctx, nil)
+	if err != nil {
+		return ReplicaExit{ExitCode: -1, Reason: fmt.Sprintf("task not found: %s", err)}
+	}
+	exitCh, err := task.Wait(ctx)
+	if err != nil {
+		return ReplicaExit{ExitCode: -1, Reason: fmt.Sprintf("wait failed: %s", err)}
+	}
+	status := <-exitCh
+	code, _, err := status.Result()
+	if err != nil {
+		return ReplicaExit{ExitCode: -1, Reason: fmt.Sprintf("wait failed: %s", err)}
+	}
+	/* Tasks killed by a signal exit with 128+signal */
+	if code > 128 && code < 128+65 {
+		return ReplicaExit{ExitCode: -1, Reason: fmt.Sprintf("killed by signal %d", code-128)}
+	}
+	return ReplicaExit{ExitCode: int(code), Reason: fmt.Sprintf("exited with code %d", code)}
+}
+
+func (rt *nativeRuntime) Adopt(fs *FunctionStore, replica *Replica) error {
+	if replica.namespace == "" {
+		replica.namespace = fecore.DefaultFunctionNamespace
+	}
+	if replica.IP == "" {
+		return fmt.Errorf("[native_runtime/Adopt] No IP recorded for replica '%s'", replica.uuid)
+	}
+	ctx := namespaces.WithNamespace(context.Background(), replica.namespace)
+	container, err := fs.Client.LoadContainer(ctx, replica.uuid)
+	if err != nil {
+		return fmt.Errorf("[native_runtime/Adopt] Unable to load container '%s': %w", replica.uuid, err)
+	}
+	task, err := container.Task(ctx, nil)
+	if err != nil {
+		return fmt.Errorf("[native_runtime/Adopt] No task for container '%s': %w", replica.uuid, err)
+	}
+	status, err := task.Status(ctx)
+	if err != nil {
+		return fmt.Errorf("[native_runtime/Adopt] Unable to get task status for container '%s': %w", replica.uuid, err)
+	}
+	/* Replicas kept frozen by a drain are resumed */
+	if status.Status == containerd.Paused {
+		if err := task.Resume(ctx); err != nil {
+			return fmt.Errorf("[native_runtime/Adopt] Unable to resume container '%s': %w", replica.uuid, err)
+		}
+	} else if status.Status != containerd.Running {
+		return fmt.Errorf("[native_runtime/Adopt] Task for container '%s' is '%s'", replica.uuid, status.Status)
+	}
+	replica.container = container
+	replica.PID = task.Pid()
+	return nil
+}
+
+/* Removes containers of the function namespaces that carry the native replica
+ * suffix but aren't in keep */
+func (rt *nativeRuntime) Sweep(fs *FunctionStore, keep map[string]bool) []string {
+	removed := make([]string, 0)
+	if fs.Client == nil {
+		return removed
+	}
+	for _, namespace := range ListNamespaces(fs.Client) {
+		ctx := namespaces.WithNamespace(context.Background(), namespace)
+		containers, err := fs.Client.Containers(ctx)
+		if err != nil {
+			timec.LogEvent("native_runtime/Sweep", fmt.Sprintf("Unable to list containers in namespace '%s': %s", namespace, err), 1)
+			continue
+		}
+		for _, container := range containers {
+			name := container.ID()
+			if !strings.HasSuffix(name, rt.Suffix()) || keep[name] {
+				continue
+			}
+			if err := cninetwork.DeleteCNINetwork(ctx, *fs.CNI, fs.Client, name); err != nil {
+				timec.LogEvent("native_runtime/Sweep", fmt.Sprintf("Error removing network for orphaned container '%s': %s", name, err), 1)
+			}
+			if err := service.Remove(ctx, fs.Client, name); err != nil {
+				timec.LogEvent("native_runtime/Sweep", fmt.Sprintf("Unable to remove orphaned container '%s': %s", name, err), 1)
+				continue
+			}
+			removed = append(removed, "container "+name)
+		}
+	}
+	return removed
+}
+
+func (rt *nativeRuntime) Delete(fs *FunctionStore, replica *Replica) error {
+	ctx := namespaces.WithNamespace(context.Background(), replica.namespace)
+	name := replica.uuid
+	networkErr := cninetwork.DeleteCNINetwork(ctx, *fs.CNI, fs.Client, name)
+	if networkErr != nil {
+		timec.LogEvent("native_runtime/Delete", fmt.Sprintf("Error removing network for Function '%s': %s", name, networkErr), 1)
+	}
+	containerErr := service.Remove(ctx, fs.Client, name)
+	if containerErr != nil {
+		timec.LogEvent("native_runtime/Delete", fmt.Sprintf("Error removing replica container '%s': %s", name, containerErr), 1)
+	}
+	/* The slot is handed back even if removal failed; the sweep removes
+	 * leftover containers on the next start */
+	rt.Release(fs)
+	return containerErr
+}
+
+func (rt *nativeRuntime) Stats(fs *FunctionStore, replica *Replica) (ReplicaStats, error) {
+	stats := ReplicaStats{}
+	cgroup := path.Join(replica.namespace, replica.uuid)
+	mem, err := readCgroupValue(path.Join(nativeCgroupMemPath, cgroup), "memory.usage_in_bytes")
+	if err != nil {
+		return stats, err
+	}
+	cpu, err := readCgroupValue(path.Join(nativeCgroupCPUPath, cgroup), "cpuacct.usage")
+	if err != nil {
+		return stats, err
+	}
+	stats.MemoryBytes = mem
+	stats.CPUNanos = cpu
+	return stats, nil
+}
@@ -0,0 +1,139 @@
+package handlers
+
+import (
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.gatech.edu/faasedge/fecore/pkg/timec"
+)
+
+/* Request overrides for benchmarking. An admin (a request carrying the admin
+ * token) can bypass the Function's policy for a single invocation:
+ *  - containerType: the sandbox a Hybrid Function uses ("native" or "wasm");
+ *    for other Functions it must match the Function's ctrType
+ *  - startupType: "cold" always creates a new Replica, "warm" fails instead of
+ *    cold starting if no idle Replica is available
+ *  - replica: the name of the Replica to use; it must be idle (or have spare
+ *    capacity if the Function's Replicas are shared)
+ * Overrides take precedence over deadline planning and hedging. Requests
+ * with override headers but without the admin token are rejected. */
+
+const (
+	ContainerTypeHeader = "containerType"
+	StartupTypeHeader   = "startupType"
+	ReplicaHeader       = "replica"
+)
+
+/* Returned when a request's overrides are not allowed, invalid, or can't be
+ * honoured; Status is the HTTP status the proxy answers with */
+type OverrideError struct {
+	Reason string
+	Status int
+}
+
+func (e *OverrideError) Error() string {
+	return e.Reason
+}
+
+/* Reads the override headers of an invocation request */
+func (fs *FunctionStore) InvokeOverrides(r *http.Request) (InvokeOptions, error) {
+	opts := InvokeOptions{
+		ContainerType: r.Header.Get(ContainerTypeHeader),
+		StartupType:   r.Header.Get(StartupTypeHeader),
+		Replica:       r.Header.Get(ReplicaHeader),
+	}
+	if opts.ContainerType == "" && opts.StartupType == "" && opts.Replica == "" {
+		return opts, nil
+	}
+	if !fs.IsAdmin(r) {
+		return InvokeOptions{}, &OverrideError{Reason: "admin token required to override containerType, startupType or replica", Status: http.StatusForbidden}
+	}
+	if opts.StartupType != "" && opts.StartupType != "cold" && opts.StartupType != "warm" {
+		return InvokeOptions{}, &OverrideError{Reason: fmt.Sprintf("startupType must be 'cold' or 'warm', got '%s'", opts.StartupType), Status: http.StatusBadRequest}
+	}
+	if opts.Replica != "" && opts.StartupType == "cold" {
+		return InvokeOptions{}, &OverrideError{Reason: "a cold start can't use an existing replica", Status: http.StatusBadRequest}
+	}
+	return opts, nil
+}
+
+/* Checks a request's containerType override against the Function */
+func checkContainerOverride(function *Function, ctrType string, opts InvokeOptions) error {
+	if opts.ContainerType == "" {
+		return nil
+	}
+	if ctrType == "hybrid" {
+		if _, ok := function.sandboxes[opts.ContainerType]; !ok {
+			return &OverrideError{Reason: fmt.Sprintf("'%s' has no %s sandbox", function.name, opts.ContainerType), Status: http.StatusBadRequest}
+		}
+		return nil
+	}
+	if opts.ContainerType != ctrType {
+		return &OverrideError{Reason: fmt.Sprintf("'%s' is a %s Function, can't use containerType '%s'", function.name, ctrType, opts.ContainerType), Status: http.StatusBadRequest}
+	}
+	return nil
+}
+
+/* Hands out the Replica named by a request's replica override from the first
+ * of pools that has it */
+func (fs *FunctionStore) GetNamedReplica(pools []string, name string, requestID string) (string, string, error) {
+	for _, fname := range pools {
+		fn, ok := fs.lookupFunction(fname)
+		if !ok {
+			continue
+		}
+		policy := fs.GetInvocationPolicy(fname)
+		replica, found := fn.checkoutNamedReplica(name, policy.concurrency(), policy.maxInvocations)
+		if !found {
+			continue
+		}
+		if replica == nil {
+			return "", "", &OverrideError{Reason: fmt.Sprintf("replica '%s' of '%s' is busy", name, fname), Status: http.StatusConflict}
+		}
+		if !replica.frozen.Load() {
+			timec.LogEvent("override/GetNamedReplica", fmt.Sprintf("Using requested replica '%s' of '%s' <requestID=%s>", name, fname, requestID), 2)
+			return replica.IP, "warm", nil
+		}
+		if err := fs.thawReplica(replica, requestID); err != nil {
+			timec.LogEvent("override/GetNamedReplica", fmt.Sprintf("Unable to thaw replica '%s'; deleting it: %s <requestID=%s>", name, err, requestID), 1)
+			fs.purgeReplica(replica)
+			fs.DeleteReplica(replica)
+			return "", "", &OverrideError{Reason: fmt.Sprintf("replica '%s' of '%s' could not be thawed", name, fname), Status: http.StatusConflict}
+		}
+		return replica.IP, "thawed", nil
+	}
+	return "", "", &OverrideError{Reason: fmt.Sprintf("replica '%s' not found", name), Status: http.StatusNotFound}
+}
+
+/* Checks out a specific Replica: an idle one, or an active one with spare
+ * capacity. found is false if the Function has no such Replica; the Replica
+ * is nil if it exists but can't take another invocation. */
+func (fn *Function) checkoutNamedReplica(name string, concurrency int, maxInvocations int) (replica *Replica, found bool) {
+	fn.poolMu.Lock()
+	defer fn.poolMu.Unlock()
+
+	if active, ok := fn.activeReplicas[name]; ok {
+		if concurrency <= 1 || active.inflight >= concurrency || active.frozen.Load() || active.broken {
+			return nil, true
+		}
+		if maxInvocations > 0 && active.accessCount >= maxInvocations {
+			return nil, true
+		}
+		active.inflight += 1
+		active.accessCount += 1
+		active.lastAccess = time.Now()
+		return active, true
+	}
+	for _, idle := range fn.idleReplicas.replicas() {
+		if idle.uuid != name {
+			continue
+		}
+		fn.idleReplicas.remove(idle)
+		idle.inflight = 1
+		idle.accessCount += 1
+		fn.activeReplicas[idle.uuid] = idle
+		return idle, true
+	}
+	return nil, false
+}
@@ -0,0 +1,120 @@
+package handlers
+
+import (
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.gatech.edu/faasedge/fecore/pkg/provider/config"
+)
+
+func Test_InvokeOverrides(t *testing.T) {
+	tests := []struct {
+		name       string
+		headers    map[string]string
+		want       InvokeOptions
+		wantStatus int // status of the OverrideError; 0 for none
+	}{
+		{name: "no overrides", headers: map[string]string{}},
+		{name: "no overrides from normal clients", headers: map[string]string{StartupTypeHeader: "cold"}, wantStatus: http.StatusForbidden},
+		{name: "wrong admin token", headers: map[string]string{ContainerTypeHeader: "wasm", AdminTokenHeader: "guess"}, wantStatus: http.StatusForbidden},
+		{name: "admin overrides", headers: map[string]string{ContainerTypeHeader: "wasm", StartupTypeHeader: "warm", ReplicaHeader: "fn-a", AdminTokenHeader: "secret"}, want: InvokeOptions{ContainerType: "wasm", StartupType: "warm", Replica: "fn-a"}},
+		{name: "unknown startup type", headers: map[string]string{StartupTypeHeader: "hot", AdminTokenHeader: "secret"}, wantStatus: http.StatusBadRequest},
+		{name: "cold start of an existing replica", headers: map[string]string{StartupTypeHeader: "cold", ReplicaHeader: "fn-a", AdminTokenHeader: "secret"}, wantStatus: http.StatusBadRequest},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			fs := newTestStore(t, config.Config{AdminToken: "secret"})
+			r := httptest.NewRequest(http.MethodPost, "/function/fn", nil)
+			for k, v := range tc.headers {
+				r.Header.Set(k, v)
+			}
+
+			got, err := fs.InvokeOverrides(r)
+			if tc.wantStatus != 0 {
+				var denied *OverrideError
+				if !errors.As(err, &denied) || denied.Status != tc.wantStatus {
+					t.Fatalf("want OverrideError with status %d, got %v", tc.wantStatus, err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("want no error, got: %s", err)
+			}
+			if got != tc.want {
+				t.Fatalf("want %+v, got %+v", tc.want, got)
+			}
+		})
+	}
+}
+
+func Test_checkContainerOverride(t *testing.T) {
+	hybrid := &Function{name: "fn", sandboxes: map[string]string{"native": "fn-native", "wasm": "fn-wasm"}}
+	tests := []struct {
+		name     string
+		function *Function
+		ctrType  string
+		override string
+		wantErr  bool
+	}{
+		{name: "no override", function: &Function{name: "fn"}, ctrType: "native"},
+		{name: "hybrid sandbox", function: hybrid, ctrType: "hybrid", override: "wasm"},
+		{name: "unknown hybrid sandbox", function: hybrid, ctrType: "hybrid", override: "vm", wantErr: true},
+		{name: "matching sandbox", function: &Function{name: "fn"}, ctrType: "wasm", override: "wasm"},
+		{name: "other sandbox", function: &Function{name: "fn"}, ctrType: "native", override: "wasm", wantErr: true},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			err := checkContainerOverride(tc.function, tc.ctrType, InvokeOptions{ContainerType: tc.override})
+			if (err != nil) != tc.wantErr {
+				t.Fatalf("want error=%v, got %v", tc.wantErr, err)
+			}
+		})
+	}
+}
+
+func Test_GetNamedReplica(t *testing.T) {
+	tests := []struct {
+		name        string
+		replica     string
+		concurrency int
+		wantStatus  int // status of the OverrideError; 0 for none
+	}{
+		{name: "idle replica", replica: "b"},
+		{name: "busy replica", replica: "a", wantStatus: http.StatusConflict},
+		{name: "shared replica with spare capacity", replica: "a", concurrency: 2},
+		{name: "unknown replica", replica: "z", wantStatus: http.StatusNotFound},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			fn := &Function{name: "fn", activeReplicas: make(map[string]*Replica)}
+			fn.policy.replicaConcurrency = tc.concurrency
+			fs := newTestStore(t, config.Config{}, fn)
+			fs.AddIdleReplica(&Replica{fname: "fn", uuid: "a", IP: "10.0.0.1"})
+			fs.AddIdleReplica(&Replica{fname: "fn", uuid: "b", IP: "10.0.0.2"})
+			fs.AddIdleReplica(&Replica{fname: "fn", uuid: "c", IP: "10.0.0.3"})
+			if _, _, err := fs.GetNamedReplica([]string{"fn"}, "a", "first"); err != nil {
+				t.Fatalf("want replica 'a' checked out, got: %s", err)
+			}
+
+			_, startupType, err := fs.GetNamedReplica([]string{"fn"}, tc.replica, "test")
+			if tc.wantStatus != 0 {
+				var denied *OverrideError
+				if !errors.As(err, &denied) || denied.Status != tc.wantStatus {
+					t.Fatalf("want OverrideError with status %d, got %v", tc.wantStatus, err)
+				}
+				return
+			}
+			if err != nil || startupType != "warm" {
+				t.Fatalf("want warm start, got '%s' (err=%v)", startupType, err)
+			}
+			if _, active := fn.activeReplicas[tc.replica]; !active {
+				t.Fatalf("want replica '%s' active", tc.replica)
+			}
+		})
+	}
+}
@@ -3,10 +3,13 @@ package handlers
 import (
 	"encoding/json"
 	"fmt"
+	"io"
 	"net/http"
 	"strconv"
+	"strings"
 
 	"github.gatech.edu/faasedge/fecore/pkg/timec"
+	"gopkg.in/yaml.v2"
 )
 
 type Policy struct {
@@ -14,6 +17,31 @@ type Policy struct {
 	warmStartCtrType      string
 	spawnAddlCtrs         int
 	keepaliveColdStartCtr int
+	minIdle               int
+	maxIdle               int
+	keepalivePolicy       string
+	maxInflight           int
+	maxQueue              int
+	queueTimeout          int    // ms
+	replicaConcurrency    int    // max invocations served by one Replica at a time
+	maxInvocations        int    // invocations after which a Replica is retired (0 = no limit)
+	maxReplicaAge         int    // seconds after which a Replica is retired (0 = no limit)
+	idleMode              string // "running" or "frozen" (see freeze.go)
+	isolation             string // "none" or "per-invocation-reset" (see isolation.go)
+	hedgeColdStart        string // "off", "keep" or "delete" (see hedge.go)
+	warmupPath            string // GET before a pre-spawned Replica goes idle; "" = none (see warmup.go)
+	warmupTimeout         int    // ms
+	readinessProbe        string // "tcp" or "http" (see readiness.go)
+	readinessPath         string
+	readinessTimeout      int    // ms
+	maxRequestBody        int    // bytes; 0 = node-wide limit (see body_limits.go)
+	maxResponseBody       int    // bytes; 0 = node-wide limit
+	evaluator             string // Hybrid policy evaluator (see evaluator.go)
+	sloLatency            int    // ms; p99 service time objective, 0 = none (see slo.go)
+	sloColdRatio          int    // percent; cold start ratio objective, 0 = none
+	banditStrategy        string // "thompson" or "epsilon" (see bandit.go)
+	explorationRate       int    // percent of invocations the epsilon strategy explores
+	banditHalfLife        int    // observations after which one loses half its weight
 }
 
 type policyJSON struct {
@@ -21,6 +49,33 @@ type policyJSON struct {
 	WarmStartCtrType      string `json:"warmStartCtrType"`
 	SpawnAddlCtrs         int    `json:"spawnAddlCtrs"`
 	KeepaliveColdStartCtr int    `json:"keepaliveColdStartCtr"`
+	MinIdle               int    `json:"minIdle"`
+	MaxIdle               int    `json:"maxIdle"`
+	KeepalivePolicy       string `json:"keepalivePolicy"`
+	MaxInflight           int    `json:"maxInflight"`
+	MaxQueue              int    `json:"maxQueue"`
+	QueueTimeout          int    `json:"queueTimeout"`
+	ReplicaConcurrency    int    `json:"replicaConcurrency"`
+	MaxInvocations        int    `json:"maxInvocationsPerReplica"`
+	MaxReplicaAge         int    `json:"maxReplicaAge"`
+	IdleMode              string `json:"idleMode"`
+	Isolation             string `json:"isolation"`
+	HedgeColdStart        string `json:"hedgeColdStart"`
+	WarmupPath            string `json:"warmupPath"`
+	WarmupTimeout         int    `json:"warmupTimeout"`
+	ReadinessProbe        string `json:"readinessProbe"`
+	ReadinessPath         string `json:"readinessPath"`
+	ReadinessTimeout      int    `json:"readinessTimeout"`
+	MaxRequestBody        int    `json:"maxRequestBody"`
+	MaxResponseBody       int    `json:"maxResponseBody"`
+	PolicyEvaluator       string `json:"policyEvaluator"`
+	SLOLatency            int    `json:"sloLatency"`
+	SLOColdRatio          int    `json:"sloColdRatio"`
+	BanditStrategy        string `json:"banditStrategy"`
+	ExplorationRate       int    `json:"explorationRate"`
+	BanditHalfLife        int    `json:"banditHalfLife"`
+	/* Per-arm means and confidence while policyEvaluator is bandit */
+	Bandit map[string]banditArmJSON `json:"bandit,omitempty"`
 }
 
 /* Handles Policy API endpoint */
@@ -36,7 +91,15 @@ func MakePolicyHandler(fs *FunctionStore) http.HandlerFunc {
 
 		var returnType string
 
-		switch action := r.URL.Query().Get("action"); action {
+		action := r.URL.Query().Get("action")
+		if action == "view" || action == "update" || action == "document" {
+			if _, ok := fs.lookupFunction(r.URL.Query().Get("fname")); !ok {
+				http.Error(w, fmt.Sprintf("Function '%s' not found", r.URL.Query().Get("fname")), http.StatusNotFound)
+				return
+			}
+		}
+
+		switch action {
 		case "view":
 			returnType = "json"
 			fname := r.URL.Query().Get("fname")
@@ -47,6 +110,17 @@ func MakePolicyHandler(fs *FunctionStore) http.HandlerFunc {
 			var updatedPolicy Policy
 			returnType = "json"
 			fname := r.URL.Query().Get("fname")
+			/* Rejects what a policy document with these values would reject */
+			doc, problems := policyUpdateDocument(fs, fname, r.URL.Query())
+			if len(problems) == 0 {
+				f, _ := fs.lookupFunction(fname)
+				_, problems = fs.policyFromDocument(f, doc)
+			}
+			if len(problems) > 0 {
+				timec.LogEvent("MakePolicyHandler", fmt.Sprintf("Rejected policy update for %s: %s", fname, strings.Join(problems, "; ")), 2)
+				writePolicyDocumentErrors(w, problems)
+				return
+			}
 			if v := r.URL.Query().Get("coldStartCtrType"); v != "" {
 				updatedPolicy.coldStartCtrType = v
 			} else {
@@ -75,13 +149,83 @@ func MakePolicyHandler(fs *FunctionStore) http.HandlerFunc {
 			} else {
 				updatedPolicy.keepaliveColdStartCtr = -1
 			}
+			if v := r.URL.Query().Get("minIdle"); v != "" {
+				val, err := strconv.Atoi(v)
+				if err != nil {
+					val = -1
+				}
+				updatedPolicy.minIdle = val
+			} else {
+				updatedPolicy.minIdle = -1
+			}
+			if v := r.URL.Query().Get("maxIdle"); v != "" {
+				val, err := strconv.Atoi(v)
+				if err != nil {
+					val = -1
+				}
+				updatedPolicy.maxIdle = val
+			} else {
+				updatedPolicy.maxIdle = -1
+			}
+			updatedPolicy.keepalivePolicy = r.URL.Query().Get("keepalivePolicy")
+			for param, field := range map[string]*int{"maxInflight": &updatedPolicy.maxInflight, "maxQueue": &updatedPolicy.maxQueue, "queueTimeout": &updatedPolicy.queueTimeout, "replicaConcurrency": &updatedPolicy.replicaConcurrency, "maxInvocationsPerReplica": &updatedPolicy.maxInvocations, "maxReplicaAge": &updatedPolicy.maxReplicaAge, "warmupTimeout": &updatedPolicy.warmupTimeout, "readinessTimeout": &updatedPolicy.readinessTimeout, "maxRequestBody": &updatedPolicy.maxRequestBody, "maxResponseBody": &updatedPolicy.maxResponseBody, "sloLatency": &updatedPolicy.sloLatency, "sloColdRatio": &updatedPolicy.sloColdRatio, "explorationRate": &updatedPolicy.explorationRate, "banditHalfLife": &updatedPolicy.banditHalfLife} {
+				*field = -1
+				if v := r.URL.Query().Get(param); v != "" {
+					if val, err := strconv.Atoi(v); err == nil {
+						*field = val
+					}
+				}
+			}
+			updatedPolicy.idleMode = r.URL.Query().Get("idleMode")
+			updatedPolicy.isolation = r.URL.Query().Get("isolation")
+			updatedPolicy.hedgeColdStart = r.URL.Query().Get("hedgeColdStart")
+			updatedPolicy.warmupPath = r.URL.Query().Get("warmupPath")
+			updatedPolicy.readinessProbe = r.URL.Query().Get("readinessProbe")
+			updatedPolicy.readinessPath = r.URL.Query().Get("readinessPath")
+			updatedPolicy.evaluator = r.URL.Query().Get("policyEvaluator")
+			updatedPolicy.banditStrategy = r.URL.Query().Get("banditStrategy")
 			policy := UpdatePolicy(fs, fname, updatedPolicy)
 			jsonOut, marshalErr = json.Marshal(policy)
+		case "document":
+			/* Whole policy documents (see policy_doc.go). GET returns YAML
+			 * if asked for with format=yaml or the Accept header. */
+			returnType = "json"
+			fname := r.URL.Query().Get("fname")
+			switch r.Method {
+			case http.MethodGet:
+				doc, _ := GetPolicyDocument(fs, fname)
+				if policyDocumentFormat(r.URL.Query().Get("format")+r.Header.Get("Accept")) == "yaml" {
+					returnType = "yaml"
+					jsonOut, marshalErr = yaml.Marshal(doc)
+				} else {
+					jsonOut, marshalErr = json.Marshal(doc)
+				}
+			case http.MethodPut:
+				body, err := io.ReadAll(http.MaxBytesReader(w, r.Body, maxPolicyDocumentBytes))
+				if err != nil {
+					http.Error(w, fmt.Sprintf("Unable to read policy document: %s", err), http.StatusBadRequest)
+					return
+				}
+				doc, problems := decodePolicyDocument(body, policyDocumentFormat(r.Header.Get("Content-Type")))
+				var policy policyJSON
+				if len(problems) == 0 {
+					policy, problems = ApplyPolicyDocument(fs, fname, doc)
+				}
+				if len(problems) > 0 {
+					writePolicyDocumentErrors(w, problems)
+					return
+				}
+				jsonOut, marshalErr = json.Marshal(policy)
+			default:
+				w.Header().Set("Allow", "GET, PUT")
+				http.Error(w, "Policy documents support GET and PUT", http.StatusMethodNotAllowed)
+				return
+			}
 		default:
 			// TODO: Should return default policy as JSON
 			returnType = "json"
 			timec.WriteDurationLog()
-			jsonOut, marshalErr = json.Marshal(timec.TimeLog)
+			jsonOut, marshalErr = json.Marshal(timec.DurationLog())
 		}
 
 		if marshalErr != nil {
@@ -93,6 +237,10 @@ func MakePolicyHandler(fs *FunctionStore) http.HandlerFunc {
 			w.Header().Set("Content-Type", "application/json")
 			w.WriteHeader(http.StatusOK)
 			w.Write(jsonOut)
+		} else if returnType == "yaml" {
+			w.Header().Set("Content-Type", "application/yaml")
+			w.WriteHeader(http.StatusOK)
+			w.Write(jsonOut)
 		} else {
 			w.Header().Set("Content-Type", "text/html")
 			w.WriteHeader(http.StatusOK)
@@ -102,215 +250,318 @@ func MakePolicyHandler(fs *FunctionStore) http.HandlerFunc {
 }
 
 func GetPolicyView(fs *FunctionStore, fn string) policyJSON {
-	defer fs.deployedFunctions[fn].policyMu.RUnlock()
-	fs.deployedFunctions[fn].policyMu.RLock()
-	policy := fs.deployedFunctions[fn].policy
+	f, ok := fs.lookupFunction(fn)
+	if !ok {
+		return policyJSON{}
+	}
+	defer f.policyMu.RUnlock()
+	f.policyMu.RLock()
+	policy := f.policy
 	view := policyJSON{
 		ColdStartCtrType:      policy.coldStartCtrType,
 		WarmStartCtrType:      policy.warmStartCtrType,
 		SpawnAddlCtrs:         policy.spawnAddlCtrs,
 		KeepaliveColdStartCtr: policy.keepaliveColdStartCtr,
+		MinIdle:               policy.minIdle,
+		MaxIdle:               policy.maxIdle,
+		KeepalivePolicy:       policy.keepalivePolicy,
+		MaxInflight:           policy.maxInflight,
+		MaxQueue:              policy.maxQueue,
+		QueueTimeout:          policy.queueTimeout,
+		ReplicaConcurrency:    policy.replicaConcurrency,
+		MaxInvocations:        policy.maxInvocations,
+		MaxReplicaAge:         policy.maxReplicaAge,
+		IdleMode:              policy.idleMode,
+		Isolation:             policy.isolation,
+		HedgeColdStart:        policy.hedgeColdStart,
+		WarmupPath:            policy.warmupPath,
+		WarmupTimeout:         policy.warmupTimeout,
+		ReadinessProbe:        policy.readinessProbe,
+		ReadinessPath:         policy.readinessPath,
+		ReadinessTimeout:      policy.readinessTimeout,
+		MaxRequestBody:        policy.maxRequestBody,
+		MaxResponseBody:       policy.maxResponseBody,
+		PolicyEvaluator:       policy.policyEvaluator().Name(),
+		SLOLatency:            policy.sloLatency,
+		SLOColdRatio:          policy.sloColdRatio,
+		BanditStrategy:        policy.banditStrategy,
+		ExplorationRate:       policy.explorationRate,
+		BanditHalfLife:        policy.banditHalfLife,
+	}
+	if policy.evaluator == evaluatorBandit {
+		view.Bandit = f.bandit.view()
 	}
 	timec.LogEvent("GetPolicy", fmt.Sprintf("Got policy for %s", fn), 3)
 	return view
 }
 
+/* Answers 400 with the problems found in a policy document or update */
+func writePolicyDocumentErrors(w http.ResponseWriter, problems []string) {
+	out, _ := json.Marshal(policyDocumentErrors{Errors: problems})
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusBadRequest)
+	w.Write(out)
+}
+
 func UpdatePolicy(fs *FunctionStore, fn string, updatedPolicy Policy) policyJSON {
-	defer fs.deployedFunctions[fn].policyMu.RUnlock()
-	fs.deployedFunctions[fn].policyMu.RLock()
+	f, ok := fs.lookupFunction(fn)
+	if !ok {
+		return policyJSON{}
+	}
+	defer f.policyMu.Unlock()
+	f.policyMu.Lock()
 	// var jsonOut []byte
 	// var marshalErr error
 	// TODO: Should add a helper function to validate ctrTypes based on what the platform accepts
 	if updatedPolicy.coldStartCtrType == "native" || updatedPolicy.coldStartCtrType == "wasm" {
-		fs.deployedFunctions[fn].policy.coldStartCtrType = updatedPolicy.coldStartCtrType
+		f.policy.coldStartCtrType = updatedPolicy.coldStartCtrType
 		timec.LogEvent("UpdatedPolicy", fmt.Sprintf("Changed coldStartCtrType to %s for %s", updatedPolicy.coldStartCtrType, fn), 3)
 	}
 
 	if updatedPolicy.warmStartCtrType == "native" || updatedPolicy.warmStartCtrType == "wasm" {
-		fs.deployedFunctions[fn].policy.warmStartCtrType = updatedPolicy.warmStartCtrType
+		f.policy.warmStartCtrType = updatedPolicy.warmStartCtrType
 		timec.LogEvent("UpdatedPolicy", fmt.Sprintf("Changed warmStartCtrType to %s for %s", updatedPolicy.warmStartCtrType, fn), 3)
 	}
 
 	if updatedPolicy.spawnAddlCtrs != -1 && updatedPolicy.spawnAddlCtrs < fs.MAX_ADDL_CTRS {
-		fs.deployedFunctions[fn].policy.spawnAddlCtrs = updatedPolicy.spawnAddlCtrs
+		f.policy.spawnAddlCtrs = updatedPolicy.spawnAddlCtrs
+	}
+
+	if updatedPolicy.keepaliveColdStartCtr >= 0 && updatedPolicy.keepaliveColdStartCtr <= fs.MAX_KEEPALIVE_TIME {
+		f.policy.keepaliveColdStartCtr = updatedPolicy.keepaliveColdStartCtr
+	}
+
+	/* maxIdle of 0 means the warm pool has no upper bound */
+	maxIdle := f.policy.maxIdle
+	if updatedPolicy.maxIdle >= 0 {
+		maxIdle = updatedPolicy.maxIdle
+	}
+	minIdle := f.policy.minIdle
+	if updatedPolicy.minIdle >= 0 && updatedPolicy.minIdle <= fs.MAX_IDLE_CTRS {
+		minIdle = updatedPolicy.minIdle
+	}
+	if maxIdle == 0 || minIdle <= maxIdle {
+		f.policy.minIdle = minIdle
+		f.policy.maxIdle = maxIdle
+		timec.LogEvent("UpdatedPolicy", fmt.Sprintf("Warm pool for %s is now minIdle=%d, maxIdle=%d", fn, minIdle, maxIdle), 3)
 	}
 
-	if updatedPolicy.keepaliveColdStartCtr >= 0 && updatedPolicy.keepaliveColdStartCtr < fs.MAX_KEEPALIVE_TIME {
-		fs.deployedFunctions[fn].policy.keepaliveColdStartCtr = updatedPolicy.keepaliveColdStartCtr
+	if updatedPolicy.keepalivePolicy == "fixed" || updatedPolicy.keepalivePolicy == "adaptive" {
+		f.policy.keepalivePolicy = updatedPolicy.keepalivePolicy
+		timec.LogEvent("UpdatedPolicy", fmt.Sprintf("Changed keepalivePolicy to %s for %s", updatedPolicy.keepalivePolicy, fn), 3)
 	}
 
-	currentPolicy := fs.deployedFunctions[fn].policy
+	if updatedPolicy.maxInflight >= 0 {
+		f.policy.maxInflight = updatedPolicy.maxInflight
+	}
+	if updatedPolicy.maxQueue >= 0 {
+		f.policy.maxQueue = updatedPolicy.maxQueue
+	}
+	if updatedPolicy.queueTimeout >= 0 {
+		f.policy.queueTimeout = updatedPolicy.queueTimeout
+	}
+
+	if updatedPolicy.replicaConcurrency >= 1 && updatedPolicy.replicaConcurrency <= fs.MAX_REPLICA_CONCURRENCY {
+		f.policy.replicaConcurrency = updatedPolicy.replicaConcurrency
+		timec.LogEvent("UpdatedPolicy", fmt.Sprintf("Changed replicaConcurrency to %d for %s", updatedPolicy.replicaConcurrency, fn), 3)
+	}
+
+	if updatedPolicy.maxInvocations >= 0 {
+		f.policy.maxInvocations = updatedPolicy.maxInvocations
+	}
+	if updatedPolicy.maxReplicaAge >= 0 {
+		f.policy.maxReplicaAge = updatedPolicy.maxReplicaAge
+	}
+
+	/* Replicas already idle keep their state; the new mode applies to
+	 * Replicas as they return to the idle pool */
+	if updatedPolicy.idleMode == idleModeRunning || updatedPolicy.idleMode == idleModeFrozen {
+		f.policy.idleMode = updatedPolicy.idleMode
+		timec.LogEvent("UpdatedPolicy", fmt.Sprintf("Changed idleMode to %s for %s", updatedPolicy.idleMode, fn), 3)
+	}
+
+	if updatedPolicy.isolation == isolationNone || updatedPolicy.isolation == isolationReset {
+		f.policy.isolation = updatedPolicy.isolation
+		timec.LogEvent("UpdatedPolicy", fmt.Sprintf("Changed isolation to %s for %s", updatedPolicy.isolation, fn), 3)
+	}
+
+	if updatedPolicy.hedgeColdStart == hedgeOff || updatedPolicy.hedgeColdStart == hedgeKeep || updatedPolicy.hedgeColdStart == hedgeDelete {
+		f.policy.hedgeColdStart = updatedPolicy.hedgeColdStart
+		timec.LogEvent("UpdatedPolicy", fmt.Sprintf("Changed hedgeColdStart to %s for %s", updatedPolicy.hedgeColdStart, fn), 3)
+	}
+
+	/* warmupPath=off disables warmup */
+	if updatedPolicy.warmupPath == "off" {
+		f.policy.warmupPath = ""
+		timec.LogEvent("UpdatedPolicy", fmt.Sprintf("Disabled warmup for %s", fn), 3)
+	} else if strings.HasPrefix(updatedPolicy.warmupPath, "/") {
+		f.policy.warmupPath = updatedPolicy.warmupPath
+		timec.LogEvent("UpdatedPolicy", fmt.Sprintf("Changed warmupPath to %s for %s", updatedPolicy.warmupPath, fn), 3)
+	}
+	if updatedPolicy.warmupTimeout >= 1 {
+		f.policy.warmupTimeout = updatedPolicy.warmupTimeout
+	}
+
+	if updatedPolicy.readinessProbe == readinessTCP || updatedPolicy.readinessProbe == readinessHTTP {
+		f.policy.readinessProbe = updatedPolicy.readinessProbe
+		timec.LogEvent("UpdatedPolicy", fmt.Sprintf("Changed readinessProbe to %s for %s", updatedPolicy.readinessProbe, fn), 3)
+	}
+	if strings.HasPrefix(updatedPolicy.readinessPath, "/") {
+		f.policy.readinessPath = updatedPolicy.readinessPath
+	}
+	if updatedPolicy.readinessTimeout >= 1 {
+		f.policy.readinessTimeout = updatedPolicy.readinessTimeout
+	}
+
+	if updatedPolicy.maxRequestBody >= 0 {
+		f.policy.maxRequestBody = updatedPolicy.maxRequestBody
+	}
+	if updatedPolicy.maxResponseBody >= 0 {
+		f.policy.maxResponseBody = updatedPolicy.maxResponseBody
+	}
+
+	/* Takes effect at the next evaluation of the Function's stats */
+	if _, err := GetPolicyEvaluator(updatedPolicy.evaluator); err == nil {
+		f.policy.evaluator = updatedPolicy.evaluator
+		timec.LogEvent("UpdatedPolicy", fmt.Sprintf("Changed policyEvaluator to %s for %s", updatedPolicy.evaluator, fn), 3)
+	}
+	if updatedPolicy.sloLatency >= 0 {
+		f.policy.sloLatency = updatedPolicy.sloLatency
+	}
+	if updatedPolicy.sloColdRatio >= 0 && updatedPolicy.sloColdRatio <= 100 {
+		f.policy.sloColdRatio = updatedPolicy.sloColdRatio
+	}
+
+	if updatedPolicy.banditStrategy == banditThompson || updatedPolicy.banditStrategy == banditEpsilon {
+		f.policy.banditStrategy = updatedPolicy.banditStrategy
+		timec.LogEvent("UpdatedPolicy", fmt.Sprintf("Changed banditStrategy to %s for %s", updatedPolicy.banditStrategy, fn), 3)
+	}
+	if updatedPolicy.explorationRate >= 0 && updatedPolicy.explorationRate <= 100 {
+		f.policy.explorationRate = updatedPolicy.explorationRate
+	}
+	if updatedPolicy.banditHalfLife >= 1 {
+		f.policy.banditHalfLife = updatedPolicy.banditHalfLife
+	}
+
+	currentPolicy := f.policy
 	view := policyJSON{
 		ColdStartCtrType:      currentPolicy.coldStartCtrType,
 		WarmStartCtrType:      currentPolicy.warmStartCtrType,
 		SpawnAddlCtrs:         currentPolicy.spawnAddlCtrs,
 		KeepaliveColdStartCtr: currentPolicy.keepaliveColdStartCtr,
+		MinIdle:               currentPolicy.minIdle,
+		MaxIdle:               currentPolicy.maxIdle,
+		KeepalivePolicy:       currentPolicy.keepalivePolicy,
+		MaxInflight:           currentPolicy.maxInflight,
+		MaxQueue:              currentPolicy.maxQueue,
+		QueueTimeout:          currentPolicy.queueTimeout,
+		ReplicaConcurrency:    currentPolicy.replicaConcurrency,
+		MaxInvocations:        currentPolicy.maxInvocations,
+		MaxReplicaAge:         currentPolicy.maxReplicaAge,
+		IdleMode:              currentPolicy.idleMode,
+		Isolation:             currentPolicy.isolation,
+		HedgeColdStart:        currentPolicy.hedgeColdStart,
+		WarmupPath:            currentPolicy.warmupPath,
+		WarmupTimeout:         currentPolicy.warmupTimeout,
+		ReadinessProbe:        currentPolicy.readinessProbe,
+		ReadinessPath:         currentPolicy.readinessPath,
+		ReadinessTimeout:      currentPolicy.readinessTimeout,
+		MaxRequestBody:        currentPolicy.maxRequestBody,
+		MaxResponseBody:       currentPolicy.maxResponseBody,
+		PolicyEvaluator:       currentPolicy.policyEvaluator().Name(),
+		SLOLatency:            currentPolicy.sloLatency,
+		SLOColdRatio:          currentPolicy.sloColdRatio,
+		BanditStrategy:        currentPolicy.banditStrategy,
+		ExplorationRate:       currentPolicy.explorationRate,
+		BanditHalfLife:        currentPolicy.banditHalfLife,
 	}
+	if currentPolicy.evaluator == evaluatorBandit {
+		view.Bandit = f.bandit.view()
+	}
+	fs.persistPolicy(fn, policyToDocument(currentPolicy, f.labels["ctrType"] == "hybrid"))
 	return view
 }
 
-func (fs *FunctionStore) EvalSpawnAddlCtrs(fn string, utilizationRatio float32, coldRatio float32) {
-	timec.LogEvent("policy/EvalSpawnAddlCtrs", fmt.Sprintf("Cold ratio for %s is %.2f", fn, coldRatio), 5)
-	var COLD_RATIO_LOW float32
-	var COLD_RATIO_HIGH float32
-	var spawnAddlCtrs int
-	/* Thresholds to use when considering percentage of requests in the
-	 * last epoch that were served from cold start */
-	COLD_RATIO_LOW = 0.10
-	COLD_RATIO_HIGH = 0.25
-
-	spawnAddlCtrs = 0
-
-	/* Cold starts are low; decrease spawnAddlCtrs */
-	if coldRatio <= COLD_RATIO_LOW {
-		spawnAddlCtrs = -1
-		/* Lots of cold starts; increase spawnAddlCtrs */
-	} else if coldRatio >= COLD_RATIO_HIGH {
-		spawnAddlCtrs = 1
-	}
-
-	timec.LogEvent("policy/EvalSpawnAddlCtrs", fmt.Sprintf("Updating %s policy.SpawnAddlCtrs by %d", fn, spawnAddlCtrs), 4)
-	fs.deployedFunctions[fn].policyMu.Lock()
-	fs.deployedFunctions[fn].policy.spawnAddlCtrs += spawnAddlCtrs
-	fs.deployedFunctions[fn].policyMu.Unlock()
-}
-
 func (fs *FunctionStore) EvalSandboxUtilization(fn string) {
-	var nativeDeployment string
-	var wasmDeployment string
-	fs.dfMu.RLock()
-	if fs.deployedFunctions[fn].labels["ctrType"] == "hybrid" {
-		nativeDeployment = fs.deployedFunctions[fn].sandboxes["native"]
-		wasmDeployment = fs.deployedFunctions[fn].sandboxes["wasm"]
-	} else {
+	f, ok := fs.lookupFunction(fn)
+	if !ok || f.labels["ctrType"] != "hybrid" {
 		timec.LogEvent("function_store/EvalSandboxUtilization", fmt.Sprintf("Function %s is not hybrid. Cannot eval sandbox utilization.", fn), 3)
+		return
 	}
-	fs.dfMu.RUnlock()
+	nativeDeployment := f.sandboxes["native"]
+	wasmDeployment := f.sandboxes["wasm"]
 
 	var nativeIdleCount int
 	var nativeActiveCount int
 	var wasmIdleCount int
 	var wasmActiveCount int
-	var coldRatio float32
-
-	fs.deployedFunctions[nativeDeployment].activeReplicasLock.RLock()
-	nativeActiveCount = len(fs.deployedFunctions[nativeDeployment].activeReplicas)
-	fs.deployedFunctions[nativeDeployment].activeReplicasLock.RUnlock()
-	fs.deployedFunctions[nativeDeployment].idleReplicasLock.RLock()
-	nativeIdleCount = int(fs.deployedFunctions[nativeDeployment].idleReplicas.count)
-	fs.deployedFunctions[nativeDeployment].idleReplicasLock.RUnlock()
-
-	fs.deployedFunctions[wasmDeployment].activeReplicasLock.RLock()
-	wasmActiveCount = len(fs.deployedFunctions[wasmDeployment].activeReplicas)
-	fs.deployedFunctions[wasmDeployment].activeReplicasLock.RUnlock()
-	fs.deployedFunctions[wasmDeployment].idleReplicasLock.RLock()
-	wasmIdleCount = int(fs.deployedFunctions[wasmDeployment].idleReplicas.count)
-	fs.deployedFunctions[wasmDeployment].idleReplicasLock.RUnlock()
+
+	var nativeLoad float32
+	var wasmLoad float32
+
+	nativeActiveCount, nativeLoad = fs.activeLoad(nativeDeployment)
+	nativeIdleCount = fs.idleCount(nativeDeployment)
+
+	wasmActiveCount, wasmLoad = fs.activeLoad(wasmDeployment)
+	wasmIdleCount = fs.idleCount(wasmDeployment)
 
 	totalNative := nativeIdleCount + nativeActiveCount
 	totalWasm := wasmIdleCount + wasmActiveCount
-	utilizationRatio := float32(nativeActiveCount+wasmActiveCount) / float32(totalNative+totalWasm)
+	/* Partially busy replicas (replicaConcurrency > 1) count by their load */
+	utilizationRatio := (nativeLoad + wasmLoad) / float32(totalNative+totalWasm)
 
-	fs.functionStats[fn].statMu.Lock()
-	fs.functionStats[fn].sandboxUtil = utilizationRatio
-	fs.functionStats[fn].activeCount = nativeActiveCount + wasmActiveCount
-	fs.functionStats[fn].idleCount = nativeIdleCount + wasmIdleCount
-	coldRatio = fs.functionStats[fn].coldRatio
-	fs.functionStats[fn].statMu.Unlock()
+	stats, ok := fs.lookupStats(fn)
+	if !ok {
+		return
+	}
+	stats.statMu.Lock()
+	stats.sandboxUtil = utilizationRatio
+	stats.activeCount = nativeActiveCount + wasmActiveCount
+	stats.idleCount = nativeIdleCount + wasmIdleCount
+	stats.statMu.Unlock()
 
-	fs.EvalSpawnAddlCtrs(fn, utilizationRatio, coldRatio)
+	fs.EvalSpawnAddlCtrs(fn)
 
 	timec.LogEvent("policy/EvalSandboxUtilization", fmt.Sprintf("%s has sandbox utilization ratio of %.2f (NATIVE: %d/%d; WASM: %d/%d)", fn, utilizationRatio, nativeActiveCount, nativeIdleCount, wasmActiveCount, wasmIdleCount), 4)
 }
 
-func (fs *FunctionStore) EvalColdStartPolicy(fn string) {
-	var nativeDeployment string
-	var wasmDeployment string
-	fs.dfMu.RLock()
-	if fs.deployedFunctions[fn].labels["ctrType"] == "hybrid" {
-		nativeDeployment = fs.deployedFunctions[fn].sandboxes["native"]
-		wasmDeployment = fs.deployedFunctions[fn].sandboxes["wasm"]
-	} else {
-		timec.LogEvent("function_store/EvalColdStartPolicy", fmt.Sprintf("Function %s is not hybrid. Cannot eval cold start policy.", fn), 3)
-	}
-	fs.dfMu.RUnlock()
-
-	/* Retrieve stats for Hybrid's Native and WASM sandboxes */
-	fs.functionStats[nativeDeployment].statMu.RLock()
-	native_avgSvcCold := fs.functionStats[nativeDeployment].avgSvcCold
-	fs.functionStats[nativeDeployment].statMu.RUnlock()
-
-	fs.functionStats[wasmDeployment].statMu.RLock()
-	wasm_avgSvcCold := fs.functionStats[wasmDeployment].avgSvcCold
-	fs.functionStats[wasmDeployment].statMu.RUnlock()
-	/* Compare stats to determine the policy */
-	var coldStartCtrType string
-	var spawnAddlCtrs int
-
-	timec.LogEvent("====> EvalColdStartPolicy", fmt.Sprintf("native_avgSvcCold: %d vs. wasm_avgSvcCold: %d", native_avgSvcCold, wasm_avgSvcCold), 4)
-	/* For cold starts, we want lowest avg service time */
-	if native_avgSvcCold < wasm_avgSvcCold {
-		coldStartCtrType = "native"
-		spawnAddlCtrs = 0
-	} else {
-		coldStartCtrType = "wasm"
-		spawnAddlCtrs = 1
-	}
-
-	/* Update the policy */
-	fs.deployedFunctions[fn].policyMu.Lock()
-	fs.deployedFunctions[fn].policy.coldStartCtrType = coldStartCtrType
-	if coldStartCtrType == fs.deployedFunctions[fn].policy.warmStartCtrType {
-		fs.deployedFunctions[fn].policy.keepaliveColdStartCtr = 60
-	}
-	fs.deployedFunctions[fn].policy.spawnAddlCtrs = spawnAddlCtrs
-	fs.deployedFunctions[fn].policyMu.Unlock()
-}
-
-func (fs *FunctionStore) EvalWarmStartPolicy(fn string) {
-	var nativeDeployment string
-	var wasmDeployment string
-	fs.dfMu.RLock()
-	if fs.deployedFunctions[fn].labels["ctrType"] == "hybrid" {
-		nativeDeployment = fs.deployedFunctions[fn].sandboxes["native"]
-		wasmDeployment = fs.deployedFunctions[fn].sandboxes["wasm"]
-	} else {
-		timec.LogEvent("function_store/EvalColdStartPolicy", fmt.Sprintf("Function %s is not hybrid. Cannot eval warm start policy.", fn), 3)
-	}
-	fs.dfMu.RUnlock()
-
-	/* Retrieve stats for Hybrid's Native and WASM sandboxes */
-	fs.functionStats[nativeDeployment].statMu.RLock()
-	native_avgSvcWarm := fs.functionStats[nativeDeployment].avgSvcWarm
-	fs.functionStats[nativeDeployment].statMu.RUnlock()
-
-	fs.functionStats[wasmDeployment].statMu.RLock()
-	wasm_avgSvcWarm := fs.functionStats[wasmDeployment].avgSvcWarm
-	fs.functionStats[wasmDeployment].statMu.RUnlock()
-	/* Compare stats to determine the policy */
-	var warmStartCtrType string
-
-	timec.LogEvent("====> EvalWarmStartPolicy", fmt.Sprintf("native_avgSvcWarm: %d vs. wasm_avgSvcWarm: %d", native_avgSvcWarm, wasm_avgSvcWarm), 4)
-	/* For warm starts, we want lowest avg service time */
-	if native_avgSvcWarm < wasm_avgSvcWarm {
-		warmStartCtrType = "native"
-	} else {
-		warmStartCtrType = "wasm"
-	}
-	/* Update the policy */
-	fs.deployedFunctions[fn].policyMu.Lock()
-	fs.deployedFunctions[fn].policy.warmStartCtrType = warmStartCtrType
-	fs.deployedFunctions[fn].policyMu.Unlock()
-}
-
 func (fs *FunctionStore) GetInvocationPolicy(fn string) Policy {
-	defer fs.deployedFunctions[fn].policyMu.RUnlock()
-	fs.deployedFunctions[fn].policyMu.RLock()
-	policy := fs.deployedFunctions[fn].policy
+	f, ok := fs.lookupFunction(fn)
+	if !ok {
+		return Policy{}
+	}
+	defer f.policyMu.RUnlock()
+	f.policyMu.RLock()
+	policy := f.policy
 	tmp := Policy{}
 	tmp.coldStartCtrType = policy.coldStartCtrType
 	tmp.warmStartCtrType = policy.warmStartCtrType
 	tmp.spawnAddlCtrs = policy.spawnAddlCtrs
 	tmp.keepaliveColdStartCtr = policy.keepaliveColdStartCtr
+	tmp.minIdle = policy.minIdle
+	tmp.maxIdle = policy.maxIdle
+	tmp.keepalivePolicy = policy.keepalivePolicy
+	tmp.maxInflight = policy.maxInflight
+	tmp.maxQueue = policy.maxQueue
+	tmp.queueTimeout = policy.queueTimeout
+	tmp.replicaConcurrency = policy.replicaConcurrency
+	tmp.maxInvocations = policy.maxInvocations
+	tmp.maxReplicaAge = policy.maxReplicaAge
+	tmp.idleMode = policy.idleMode
+	tmp.isolation = policy.isolation
+	tmp.hedgeColdStart = policy.hedgeColdStart
+	tmp.warmupPath = policy.warmupPath
+	tmp.warmupTimeout = policy.warmupTimeout
+	tmp.readinessProbe = policy.readinessProbe
+	tmp.readinessPath = policy.readinessPath
+	tmp.readinessTimeout = policy.readinessTimeout
+	tmp.maxRequestBody = policy.maxRequestBody
+	tmp.maxResponseBody = policy.maxResponseBody
+	tmp.evaluator = policy.evaluator
+	tmp.sloLatency = policy.sloLatency
+	tmp.sloColdRatio = policy.sloColdRatio
+	tmp.banditStrategy = policy.banditStrategy
+	tmp.explorationRate = policy.explorationRate
+	tmp.banditHalfLife = policy.banditHalfLife
 	return tmp
 }
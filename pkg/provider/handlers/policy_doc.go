@@ -0,0 +1,377 @@
+package handlers
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"net/url"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.gatech.edu/faasedge/fecore/pkg/provider/storage"
+	"github.gatech.edu/faasedge/fecore/pkg/timec"
+	"gopkg.in/yaml.v2"
+)
+
+/* Declarative policy documents. A document sets a Function's whole policy at
+ * once, as JSON or YAML with the keys of the policy view, e.g.
+ *   minIdle: 1
+ *   maxIdle: 4
+ *   keepalivePolicy: adaptive
+ * Keys left out take the value the Function's labels give them, or their
+ * default, so a document replaces the policy rather than patching it.
+ * coldStartCtrType, warmStartCtrType, spawnAddlCtrs and keepaliveColdStartCtr
+ * only apply to Hybrid Functions; warmupPath: off disables warmup. A
+ * document is validated as a whole: unknown keys, wrong types and out of
+ * range values are all reported and leave the policy unchanged.
+ *
+ * Accepted documents, and policies changed with /policy?action=update, are
+ * stored in the DB and applied again when the Function is restored on
+ * startup. Documents in PolicyDir, named <fname>.json, .yaml or .yml, are
+ * applied after that, so the directory wins over changes made via the API. */
+
+const maxPolicyDocumentBytes = 1 << 20
+
+type policyDocument struct {
+	ColdStartCtrType      *string `json:"coldStartCtrType,omitempty" yaml:"coldStartCtrType,omitempty"`
+	WarmStartCtrType      *string `json:"warmStartCtrType,omitempty" yaml:"warmStartCtrType,omitempty"`
+	SpawnAddlCtrs         *int    `json:"spawnAddlCtrs,omitempty" yaml:"spawnAddlCtrs,omitempty"`
+	KeepaliveColdStartCtr *int    `json:"keepaliveColdStartCtr,omitempty" yaml:"keepaliveColdStartCtr,omitempty"`
+	MinIdle               *int    `json:"minIdle,omitempty" yaml:"minIdle,omitempty"`
+	MaxIdle               *int    `json:"maxIdle,omitempty" yaml:"maxIdle,omitempty"`
+	KeepalivePolicy       *string `json:"keepalivePolicy,omitempty" yaml:"keepalivePolicy,omitempty"`
+	MaxInflight           *int    `json:"maxInflight,omitempty" yaml:"maxInflight,omitempty"`
+	MaxQueue              *int    `json:"maxQueue,omitempty" yaml:"maxQueue,omitempty"`
+	QueueTimeout          *int    `json:"queueTimeout,omitempty" yaml:"queueTimeout,omitempty"`
+	ReplicaConcurrency    *int    `json:"replicaConcurrency,omitempty" yaml:"replicaConcurrency,omitempty"`
+	MaxInvocations        *int    `json:"maxInvocationsPerReplica,omitempty" yaml:"maxInvocationsPerReplica,omitempty"`
+	MaxReplicaAge         *int    `json:"maxReplicaAge,omitempty" yaml:"maxReplicaAge,omitempty"`
+	IdleMode              *string `json:"idleMode,omitempty" yaml:"idleMode,omitempty"`
+	Isolation             *string `json:"isolation,omitempty" yaml:"isolation,omitempty"`
+	HedgeColdStart        *string `json:"hedgeColdStart,omitempty" yaml:"hedgeColdStart,omitempty"`
+	WarmupPath            *string `json:"warmupPath,omitempty" yaml:"warmupPath,omitempty"`
+	WarmupTimeout         *int    `json:"warmupTimeout,omitempty" yaml:"warmupTimeout,omitempty"`
+	ReadinessProbe        *string `json:"readinessProbe,omitempty" yaml:"readinessProbe,omitempty"`
+	ReadinessPath         *string `json:"readinessPath,omitempty" yaml:"readinessPath,omitempty"`
+	ReadinessTimeout      *int    `json:"readinessTimeout,omitempty" yaml:"readinessTimeout,omitempty"`
+	MaxRequestBody        *int    `json:"maxRequestBody,omitempty" yaml:"maxRequestBody,omitempty"`
+	MaxResponseBody       *int    `json:"maxResponseBody,omitempty" yaml:"maxResponseBody,omitempty"`
+	PolicyEvaluator       *string `json:"policyEvaluator,omitempty" yaml:"policyEvaluator,omitempty"`
+	SLOLatency            *int    `json:"sloLatency,omitempty" yaml:"sloLatency,omitempty"`
+	SLOColdRatio          *int    `json:"sloColdRatio,omitempty" yaml:"sloColdRatio,omitempty"`
+	BanditStrategy        *string `json:"banditStrategy,omitempty" yaml:"banditStrategy,omitempty"`
+	ExplorationRate       *int    `json:"explorationRate,omitempty" yaml:"explorationRate,omitempty"`
+	BanditHalfLife        *int    `json:"banditHalfLife,omitempty" yaml:"banditHalfLife,omitempty"`
+}
+
+/* Returned with status 400 when a document is rejected */
+type policyDocumentErrors struct {
+	Errors []string `json:"errors"`
+}
+
+/* Parses a JSON or YAML document. Returns every problem found. */
+func decodePolicyDocument(body []byte, format string) (policyDocument, []string) {
+	var doc policyDocument
+	if format == "yaml" {
+		if err := yaml.UnmarshalStrict(body, &doc); err != nil {
+			if typeErr, ok := err.(*yaml.TypeError); ok {
+				return doc, typeErr.Errors
+			}
+			return doc, []string{err.Error()}
+		}
+		return doc, nil
+	}
+	dec := json.NewDecoder(bytes.NewReader(body))
+	dec.DisallowUnknownFields()
+	if err := dec.Decode(&doc); err != nil {
+		return doc, []string{strings.TrimPrefix(err.Error(), "json: ")}
+	}
+	return doc, nil
+}
+
+/* Returns "yaml" for YAML media types and file names, "json" otherwise */
+func policyDocumentFormat(s string) string {
+	if strings.Contains(s, "yaml") || strings.HasSuffix(s, ".yml") {
+		return "yaml"
+	}
+	return "json"
+}
+
+func (d policyDocument) labels() map[string]string {
+	labels := make(map[string]string)
+	ints := map[string]*int{"minIdle": d.MinIdle, "maxIdle": d.MaxIdle, "maxInflight": d.MaxInflight, "maxQueue": d.MaxQueue, "queueTimeout": d.QueueTimeout, "replicaConcurrency": d.ReplicaConcurrency, "maxInvocationsPerReplica": d.MaxInvocations, "maxReplicaAge": d.MaxReplicaAge, "warmupTimeout": d.WarmupTimeout, "readinessTimeout": d.ReadinessTimeout, "maxRequestBody": d.MaxRequestBody, "maxResponseBody": d.MaxResponseBody, "sloLatency": d.SLOLatency, "sloColdRatio": d.SLOColdRatio, "explorationRate": d.ExplorationRate, "banditHalfLife": d.BanditHalfLife}
+	strs := map[string]*string{"keepalivePolicy": d.KeepalivePolicy, "idleMode": d.IdleMode, "isolation": d.Isolation, "hedgeColdStart": d.HedgeColdStart, "warmupPath": d.WarmupPath, "readinessProbe": d.ReadinessProbe, "readinessPath": d.ReadinessPath, "policyEvaluator": d.PolicyEvaluator, "banditStrategy": d.BanditStrategy}
+	for key, v := range ints {
+		if v != nil {
+			labels[key] = strconv.Itoa(*v)
+		}
+	}
+	for key, v := range strs {
+		if v != nil {
+			labels[key] = *v
+		}
+	}
+	return labels
+}
+
+var labelErrorPrefix = regexp.MustCompile(`^\[[^\]]*\] `)
+
+/* Drops the "[file/Func] " prefix of a label parser's error */
+func documentError(err error) string {
+	return labelErrorPrefix.ReplaceAllString(err.Error(), "")
+}
+
+/* Builds the policy a document describes for a Function. Returns every
+ * problem found in the document. */
+func (fs *FunctionStore) policyFromDocument(fn *Function, doc policyDocument) (Policy, []string) {
+	var problems []string
+	labels := make(map[string]string)
+	for k, v := range fn.labels {
+		labels[k] = v
+	}
+	for k, v := range doc.labels() {
+		labels[k] = v
+	}
+	/* warmupPath=off disables warmup the labels set up */
+	if doc.WarmupPath != nil && *doc.WarmupPath == "off" {
+		delete(labels, "warmupPath")
+	}
+
+	policy := Policy{}
+	hybrid := fn.labels["ctrType"] == "hybrid"
+	if hybrid {
+		policy = defaultHybridPolicy()
+	}
+	for _, parse := range fs.policyLabelParsers() {
+		if err := parse(labels, &policy); err != nil {
+			problems = append(problems, documentError(err))
+		}
+	}
+
+	for key, v := range map[string]*string{"coldStartCtrType": doc.ColdStartCtrType, "warmStartCtrType": doc.WarmStartCtrType} {
+		if v == nil {
+			continue
+		}
+		if !hybrid {
+			problems = append(problems, fmt.Sprintf("%s only applies to Hybrid Functions", key))
+		} else if *v != "native" && *v != "wasm" {
+			problems = append(problems, fmt.Sprintf("%s must be 'native' or 'wasm', got '%s'", key, *v))
+		}
+	}
+	if !hybrid && (doc.SpawnAddlCtrs != nil || doc.KeepaliveColdStartCtr != nil) {
+		problems = append(problems, "spawnAddlCtrs and keepaliveColdStartCtr only apply to Hybrid Functions")
+	}
+	if v := doc.SpawnAddlCtrs; hybrid && v != nil && (*v < 0 || *v >= fs.MAX_ADDL_CTRS) {
+		problems = append(problems, fmt.Sprintf("spawnAddlCtrs must be between 0 and %d, got '%d'", fs.MAX_ADDL_CTRS-1, *v))
+	}
+	if v := doc.KeepaliveColdStartCtr; hybrid && v != nil && (*v < 0 || *v > fs.MAX_KEEPALIVE_TIME) {
+		problems = append(problems, fmt.Sprintf("keepaliveColdStartCtr must be between 0 and %d, got '%d'", fs.MAX_KEEPALIVE_TIME, *v))
+	}
+	if len(problems) > 0 {
+		sort.Strings(problems)
+		return policy, problems
+	}
+
+	if doc.ColdStartCtrType != nil {
+		policy.coldStartCtrType = *doc.ColdStartCtrType
+	}
+	if doc.WarmStartCtrType != nil {
+		policy.warmStartCtrType = *doc.WarmStartCtrType
+	}
+	if doc.SpawnAddlCtrs != nil {
+		policy.spawnAddlCtrs = *doc.SpawnAddlCtrs
+	}
+	if doc.KeepaliveColdStartCtr != nil {
+		policy.keepaliveColdStartCtr = *doc.KeepaliveColdStartCtr
+	}
+	return policy, nil
+}
+
+/* Renders a policy as a document with every key set */
+func policyToDocument(policy Policy, hybrid bool) policyDocument {
+	str := func(v string) *string { return &v }
+	num := func(v int) *int { return &v }
+	doc := policyDocument{
+		MinIdle:            num(policy.minIdle),
+		MaxIdle:            num(policy.maxIdle),
+		KeepalivePolicy:    str(policy.keepalivePolicy),
+		MaxInflight:        num(policy.maxInflight),
+		MaxQueue:           num(policy.maxQueue),
+		QueueTimeout:       num(policy.queueTimeout),
+		ReplicaConcurrency: num(policy.replicaConcurrency),
+		MaxInvocations:     num(policy.maxInvocations),
+		MaxReplicaAge:      num(policy.maxReplicaAge),
+		IdleMode:           str(policy.idleMode),
+		Isolation:          str(policy.isolation),
+		HedgeColdStart:     str(policy.hedgeColdStart),
+		WarmupPath:         str(policy.warmupPath),
+		WarmupTimeout:      num(policy.warmupTimeout),
+		ReadinessProbe:     str(policy.readinessProbe),
+		ReadinessPath:      str(policy.readinessPath),
+		ReadinessTimeout:   num(policy.readinessTimeout),
+		MaxRequestBody:     num(policy.maxRequestBody),
+		MaxResponseBody:    num(policy.maxResponseBody),
+		PolicyEvaluator:    str(policy.policyEvaluator().Name()),
+		SLOLatency:         num(policy.sloLatency),
+		SLOColdRatio:       num(policy.sloColdRatio),
+		BanditStrategy:     str(policy.banditStrategy),
+		ExplorationRate:    num(policy.explorationRate),
+		BanditHalfLife:     num(policy.banditHalfLife),
+	}
+	if policy.warmupPath == "" {
+		doc.WarmupPath = str("off")
+	}
+	if hybrid {
+		doc.ColdStartCtrType = str(policy.coldStartCtrType)
+		doc.WarmStartCtrType = str(policy.warmStartCtrType)
+		doc.SpawnAddlCtrs = num(policy.spawnAddlCtrs)
+		doc.KeepaliveColdStartCtr = num(policy.keepaliveColdStartCtr)
+	}
+	return doc
+}
+
+/* Returns a Function's current policy as a document */
+func GetPolicyDocument(fs *FunctionStore, fname string) (policyDocument, error) {
+	f, ok := fs.lookupFunction(fname)
+	if !ok {
+		return policyDocument{}, fmt.Errorf("[policy_doc/GetPolicyDocument] Function '%s' not found", fname)
+	}
+	f.policyMu.RLock()
+	policy := f.policy
+	f.policyMu.RUnlock()
+	return policyToDocument(policy, f.labels["ctrType"] == "hybrid"), nil
+}
+
+/* Replaces a Function's policy with the one a document describes and stores
+ * the document. Returns the problems found if the document is rejected. */
+func ApplyPolicyDocument(fs *FunctionStore, fname string, doc policyDocument) (policyJSON, []string) {
+	f, ok := fs.lookupFunction(fname)
+	if !ok {
+		return policyJSON{}, []string{fmt.Sprintf("Function '%s' not found", fname)}
+	}
+	policy, problems := fs.policyFromDocument(f, doc)
+	if len(problems) > 0 {
+		timec.LogEvent("policy_doc/ApplyPolicyDocument", fmt.Sprintf("Rejected policy document for %s: %s", fname, strings.Join(problems, "; ")), 2)
+		return policyJSON{}, problems
+	}
+	f.policyMu.Lock()
+	f.policy = policy
+	f.policyMu.Unlock()
+	fs.persistPolicy(fname, doc)
+	timec.LogEvent("policy_doc/ApplyPolicyDocument", fmt.Sprintf("Applied policy document for %s", fname), 2)
+	return GetPolicyView(fs, fname), nil
+}
+
+/* Builds the document a /policy?action=update request describes: the
+ * Function's current policy with the given parameters replacing their keys,
+ * so updates are validated like documents */
+func policyUpdateDocument(fs *FunctionStore, fname string, params url.Values) (policyDocument, []string) {
+	current, err := GetPolicyDocument(fs, fname)
+	if err != nil {
+		return current, []string{documentError(err)}
+	}
+	body, _ := json.Marshal(current)
+	values := make(map[string]interface{})
+	json.Unmarshal(body, &values)
+	var problems []string
+	for key, old := range values {
+		v := params.Get(key)
+		if v == "" {
+			continue
+		}
+		if _, isNumber := old.(float64); !isNumber {
+			values[key] = v
+		} else if n, err := strconv.Atoi(v); err != nil {
+			problems = append(problems, fmt.Sprintf("%s must be an integer, got '%s'", key, v))
+		} else {
+			values[key] = n
+		}
+	}
+	if len(problems) > 0 {
+		sort.Strings(problems)
+		return current, problems
+	}
+	body, _ = json.Marshal(values)
+	return decodePolicyDocument(body, "json")
+}
+
+/* Stores a Function's policy document so it survives a restart */
+func (fs *FunctionStore) persistPolicy(fname string, doc policyDocument) {
+	body, err := json.Marshal(doc)
+	if err == nil {
+		err = fs.storageManager.InsertPolicy(storage.Policy{Function: fname, Document: string(body), Updated: time.Now().Unix()})
+	}
+	if err != nil {
+		timec.LogEvent("policy_doc/persistPolicy", fmt.Sprintf("Unable to store policy of %s: %s", fname, err), 1)
+	}
+}
+
+/* Applies the stored policy documents of the restored Functions. A document
+ * that no longer validates, e.g. after a limit was lowered, is skipped and
+ * the Function keeps the policy of its labels. */
+func (fs *FunctionStore) restorePolicies() {
+	policies, err := fs.storageManager.GetAllPolicies()
+	if err != nil {
+		timec.LogEvent("policy_doc/restorePolicies", fmt.Sprintf("Unable to read stored policies: %s", err), 1)
+		return
+	}
+	for _, p := range policies {
+		fn, ok := fs.deployedFunctions[p.Function]
+		if !ok {
+			continue
+		}
+		doc, problems := decodePolicyDocument([]byte(p.Document), "json")
+		if len(problems) == 0 {
+			fn.policy, problems = fs.policyFromDocument(fn, doc)
+		}
+		if len(problems) > 0 {
+			timec.LogEvent("policy_doc/restorePolicies", fmt.Sprintf("Unable to restore policy of %s: %s", p.Function, strings.Join(problems, "; ")), 1)
+			continue
+		}
+		timec.LogEvent("policy_doc/restorePolicies", fmt.Sprintf("Restored policy of %s", p.Function), 2)
+	}
+}
+
+/* Applies the documents in PolicyDir to the restored Functions and stores
+ * them. Called from InitFunctionStore after restorePolicies. */
+func (fs *FunctionStore) loadPolicyDir(dir string) error {
+	files, err := os.ReadDir(dir)
+	if err != nil {
+		return err
+	}
+	for _, file := range files {
+		ext := filepath.Ext(file.Name())
+		if file.IsDir() || (ext != ".json" && ext != ".yaml" && ext != ".yml") {
+			continue
+		}
+		fname := strings.TrimSuffix(file.Name(), ext)
+		fn, ok := fs.deployedFunctions[fname]
+		if !ok {
+			timec.LogEvent("policy_doc/loadPolicyDir", fmt.Sprintf("Skipping %s: Function '%s' is not deployed", file.Name(), fname), 2)
+			continue
+		}
+		body, err := os.ReadFile(filepath.Join(dir, file.Name()))
+		if err != nil {
+			timec.LogEvent("policy_doc/loadPolicyDir", fmt.Sprintf("Unable to read %s: %s", file.Name(), err), 1)
+			continue
+		}
+		doc, problems := decodePolicyDocument(body, policyDocumentFormat(file.Name()))
+		var policy Policy
+		if len(problems) == 0 {
+			policy, problems = fs.policyFromDocument(fn, doc)
+		}
+		if len(problems) > 0 {
+			timec.LogEvent("policy_doc/loadPolicyDir", fmt.Sprintf("Rejected %s: %s", file.Name(), strings.Join(problems, "; ")), 1)
+			continue
+		}
+		fn.policy = policy
+		fs.persistPolicy(fname, doc)
+		timec.LogEvent("policy_doc/loadPolicyDir", fmt.Sprintf("Applied %s to %s", file.Name(), fname), 2)
+	}
+	return nil
+}
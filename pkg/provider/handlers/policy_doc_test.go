@@ -0,0 +1,292 @@
+package handlers
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.gatech.edu/faasedge/fecore/pkg/provider/config"
+	"github.gatech.edu/faasedge/fecore/pkg/provider/storage"
+)
+
+/* Store with a native and a Hybrid Function; ms is the DB of a store it
+ * replaces after a restart, or nil for a new one */
+func newPolicyDocStore(t *testing.T, ms *memStorage) (*FunctionStore, *Function, *Function) {
+	fs := newTestStore(t, config.Config{})
+	if ms != nil {
+		fs.storageManager = ms
+	}
+	native := &Function{name: "fn", activeReplicas: make(map[string]*Replica), labels: map[string]string{"ctrType": "native", "minIdle": "2", "warmupPath": "/warm"}}
+	hybrid := &Function{name: "hy", activeReplicas: make(map[string]*Replica), labels: map[string]string{"ctrType": "hybrid", "sandboxes": "hy-n,hy-w"}, sandboxes: make(map[string]string)}
+	for _, fn := range []*Function{native, hybrid} {
+		fs.configureFunction(fn, fn.labels)
+		fs.AddDeployedFunction(fn)
+	}
+	return fs, native, hybrid
+}
+
+func Test_policyFromDocument(t *testing.T) {
+	tests := []struct {
+		name       string
+		fname      string
+		format     string
+		doc        string
+		wantErrs   []string
+		wantPolicy func(p Policy) bool
+	}{
+		{
+			name: "yaml document", fname: "fn", format: "yaml",
+			doc:        "maxIdle: 4\nkeepalivePolicy: adaptive\nmaxInflight: 8\n",
+			wantPolicy: func(p Policy) bool { return p.maxIdle == 4 && p.keepalivePolicy == "adaptive" && p.maxInflight == 8 },
+		},
+		{
+			name: "left out keys take the labels", fname: "fn", format: "json",
+			doc:        `{"maxIdle": 4}`,
+			wantPolicy: func(p Policy) bool { return p.minIdle == 2 && p.warmupPath == "/warm" && p.replicaConcurrency == 1 },
+		},
+		{
+			name: "warmup turned off", fname: "fn", format: "json",
+			doc:        `{"warmupPath": "off"}`,
+			wantPolicy: func(p Policy) bool { return p.warmupPath == "" },
+		},
+		{
+			name: "hybrid sandboxes", fname: "hy", format: "json",
+			doc: `{"coldStartCtrType": "native", "spawnAddlCtrs": 3}`,
+			wantPolicy: func(p Policy) bool {
+				return p.coldStartCtrType == "native" && p.warmStartCtrType == "native" && p.spawnAddlCtrs == 3
+			},
+		},
+		{name: "unknown key", fname: "fn", format: "json", doc: `{"minIdel": 1}`, wantErrs: []string{`unknown field "minIdel"`}},
+		{name: "unknown yaml key", fname: "fn", format: "yaml", doc: "minIdel: 1\n", wantErrs: []string{"field minIdel not found"}},
+		{name: "wrong type", fname: "fn", format: "json", doc: `{"minIdle": "one"}`, wantErrs: []string{"minIdle"}},
+		{
+			name: "every out of range value is reported", fname: "fn", format: "json",
+			doc:      `{"minIdle": 5, "maxIdle": 2, "maxQueue": -1, "idleMode": "paused"}`,
+			wantErrs: []string{"idleMode must be", "maxQueue must be a non-negative integer", "minIdle (5) cannot exceed maxIdle (2)"},
+		},
+		{name: "hybrid keys on a native Function", fname: "fn", format: "json", doc: `{"spawnAddlCtrs": 1}`, wantErrs: []string{"only apply to Hybrid Functions"}},
+		{name: "spawnAddlCtrs over the limit", fname: "hy", format: "json", doc: `{"spawnAddlCtrs": 5, "warmStartCtrType": "vm"}`, wantErrs: []string{"spawnAddlCtrs must be between 0 and 4", "warmStartCtrType must be 'native' or 'wasm'"}},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			fs, _, _ := newPolicyDocStore(t, nil)
+			fn, _ := fs.lookupFunction(tc.fname)
+			doc, problems := decodePolicyDocument([]byte(tc.doc), tc.format)
+			var policy Policy
+			if len(problems) == 0 {
+				policy, problems = fs.policyFromDocument(fn, doc)
+			}
+			if len(problems) != len(tc.wantErrs) {
+				t.Fatalf("want %d errors %v, got %v", len(tc.wantErrs), tc.wantErrs, problems)
+			}
+			for i, want := range tc.wantErrs {
+				if !strings.Contains(problems[i], want) {
+					t.Fatalf("want error %d to mention '%s', got '%s'", i, want, problems[i])
+				}
+			}
+			if tc.wantPolicy != nil && !tc.wantPolicy(policy) {
+				t.Fatalf("got unexpected policy %+v", policy)
+			}
+		})
+	}
+}
+
+func Test_policyDocumentRoundTrip(t *testing.T) {
+	fs, _, hybrid := newPolicyDocStore(t, nil)
+	hybrid.policy.spawnAddlCtrs = 2
+	hybrid.policy.sloLatency = 100
+	hybrid.policy.warmupPath = ""
+
+	doc, err := GetPolicyDocument(fs, "hy")
+	if err != nil {
+		t.Fatalf("want no error, got: %s", err)
+	}
+	policy, problems := fs.policyFromDocument(hybrid, doc)
+	if len(problems) > 0 {
+		t.Fatalf("want the rendered document to validate, got %v", problems)
+	}
+	if !reflect.DeepEqual(policy, hybrid.policy) {
+		t.Fatalf("want %+v, got %+v", hybrid.policy, policy)
+	}
+}
+
+/* Policies the evaluators arrive at must be accepted when stored and put back */
+func Test_evaluatedPolicyDocumentRoundTrip(t *testing.T) {
+	fs, _, hybrid := newPolicyDocStore(t, nil)
+	ms := testDB(fs)
+	for name, svc := range map[string]int{"hy-n": 300, "hy-w": 400} {
+		fs.AddDeployedFunction(&Function{name: name, activeReplicas: make(map[string]*Replica)})
+		stats, _ := fs.lookupStats(name)
+		stats.avgSvcCold = svc
+	}
+	stats, _ := fs.lookupStats("hy")
+	stats.coldRatio = 0.9
+
+	/* Cold starts move to the warm start sandbox, and spawnAddlCtrs keeps rising */
+	fs.EvalColdStartPolicy("hy")
+	for i := 0; i < 2*fs.MAX_ADDL_CTRS; i++ {
+		fs.EvalSpawnAddlCtrs("hy")
+	}
+	if hybrid.policy.keepaliveColdStartCtr != fs.MAX_KEEPALIVE_TIME || hybrid.policy.spawnAddlCtrs != fs.MAX_ADDL_CTRS-1 {
+		t.Fatalf("want keepaliveColdStartCtr=%d spawnAddlCtrs=%d, got %+v", fs.MAX_KEEPALIVE_TIME, fs.MAX_ADDL_CTRS-1, hybrid.policy)
+	}
+
+	/* Any update stores the evaluated values along with it */
+	handler := MakePolicyHandler(fs)
+	handler(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, "/policy?action=update&fname=hy&maxIdle=4", nil))
+	if _, ok := ms.policies["hy"]; !ok {
+		t.Fatalf("want the policy stored")
+	}
+	rr := httptest.NewRecorder()
+	handler(rr, httptest.NewRequest(http.MethodGet, "/policy?action=document&fname=hy", nil))
+	if rr.Code != http.StatusOK {
+		t.Fatalf("want 200, got %d %s", rr.Code, rr.Body.String())
+	}
+	rr2 := httptest.NewRecorder()
+	handler(rr2, httptest.NewRequest(http.MethodPut, "/policy?action=document&fname=hy", strings.NewReader(rr.Body.String())))
+	if rr2.Code != http.StatusOK {
+		t.Fatalf("want the evaluated policy accepted, got %d %s", rr2.Code, rr2.Body.String())
+	}
+
+	restored, _, restoredHybrid := newPolicyDocStore(t, ms)
+	restored.restorePolicies()
+	if restoredHybrid.policy.keepaliveColdStartCtr != fs.MAX_KEEPALIVE_TIME || restoredHybrid.policy.spawnAddlCtrs != fs.MAX_ADDL_CTRS-1 {
+		t.Fatalf("want the stored policy restored, got %+v", restoredHybrid.policy)
+	}
+}
+
+func Test_policyDocumentHandler(t *testing.T) {
+	fs, native, _ := newPolicyDocStore(t, nil)
+	ms := testDB(fs)
+	handler := MakePolicyHandler(fs)
+
+	/* Rejected documents leave the policy unchanged */
+	req := httptest.NewRequest(http.MethodPut, "/policy?action=document&fname=fn", strings.NewReader("minIdle: 30\nisolation: none\n"))
+	req.Header.Set("Content-Type", "application/yaml")
+	rr := httptest.NewRecorder()
+	handler(rr, req)
+	var rejected policyDocumentErrors
+	if rr.Code != http.StatusBadRequest || json.Unmarshal(rr.Body.Bytes(), &rejected) != nil || len(rejected.Errors) != 1 {
+		t.Fatalf("want 400 with one error, got %d %s", rr.Code, rr.Body.String())
+	}
+	if native.policy.minIdle != 2 || len(ms.policies) != 0 {
+		t.Fatalf("want the policy unchanged and nothing stored, got minIdle=%d stored=%v", native.policy.minIdle, ms.policies)
+	}
+
+	req = httptest.NewRequest(http.MethodPut, "/policy?action=document&fname=fn", strings.NewReader(`{"minIdle": 3, "maxIdle": 6}`))
+	rr = httptest.NewRecorder()
+	handler(rr, req)
+	if rr.Code != http.StatusOK || native.policy.minIdle != 3 || native.policy.maxIdle != 6 {
+		t.Fatalf("want 200 and minIdle=3 maxIdle=6, got %d %s", rr.Code, rr.Body.String())
+	}
+	if stored := ms.policies["fn"].Document; stored != `{"minIdle":3,"maxIdle":6}` {
+		t.Fatalf("want the document stored, got '%s'", stored)
+	}
+
+	req = httptest.NewRequest(http.MethodGet, "/policy?action=document&fname=fn&format=yaml", nil)
+	rr = httptest.NewRecorder()
+	handler(rr, req)
+	if rr.Code != http.StatusOK || rr.Header().Get("Content-Type") != "application/yaml" || !strings.Contains(rr.Body.String(), "minIdle: 3\n") {
+		t.Fatalf("want the YAML document, got %d %s", rr.Code, rr.Body.String())
+	}
+
+	/* Updates via query parameters are stored too */
+	req = httptest.NewRequest(http.MethodGet, "/policy?action=update&fname=fn&maxIdle=8", nil)
+	handler(httptest.NewRecorder(), req)
+	if !strings.Contains(ms.policies["fn"].Document, `"maxIdle":8`) {
+		t.Fatalf("want the updated policy stored, got '%s'", ms.policies["fn"].Document)
+	}
+
+	req = httptest.NewRequest(http.MethodDelete, "/policy?action=document&fname=fn", nil)
+	rr = httptest.NewRecorder()
+	handler(rr, req)
+	if rr.Code != http.StatusMethodNotAllowed {
+		t.Fatalf("want 405, got %d", rr.Code)
+	}
+}
+
+func Test_restorePolicies(t *testing.T) {
+	ms := &memStorage{containers: make(map[string]storage.Container)}
+	ms.InsertPolicy(storage.Policy{Function: "fn", Document: `{"maxIdle": 5, "idleMode": "frozen"}`})
+	ms.InsertPolicy(storage.Policy{Function: "hy", Document: `{"spawnAddlCtrs": 99}`})
+	fs, native, hybrid := newPolicyDocStore(t, ms)
+
+	dir := t.TempDir()
+	os.WriteFile(filepath.Join(dir, "fn.yaml"), []byte("maxIdle: 7\n"), 0644)
+	os.WriteFile(filepath.Join(dir, "gone.json"), []byte(`{"maxIdle": 1}`), 0644)
+	os.WriteFile(filepath.Join(dir, "README"), []byte("not a document"), 0644)
+
+	fs.restorePolicies()
+	if native.policy.maxIdle != 5 || native.policy.idleMode != idleModeFrozen {
+		t.Fatalf("want the stored policy restored, got %+v", native.policy)
+	}
+	if hybrid.policy.spawnAddlCtrs != 1 {
+		t.Fatalf("want an invalid stored document skipped, got spawnAddlCtrs=%d", hybrid.policy.spawnAddlCtrs)
+	}
+
+	if err := fs.loadPolicyDir(dir); err != nil {
+		t.Fatalf("want no error, got: %s", err)
+	}
+	/* The directory wins over the stored document */
+	if native.policy.maxIdle != 7 || native.policy.idleMode != idleModeRunning || native.policy.minIdle != 2 {
+		t.Fatalf("want the directory's document applied over the labels, got %+v", native.policy)
+	}
+	if stored := ms.policies["fn"].Document; stored != `{"maxIdle":7}` {
+		t.Fatalf("want the directory's document stored, got '%s'", stored)
+	}
+}
+
+func Test_policyUpdateValidation(t *testing.T) {
+	tests := []struct {
+		name     string
+		query    string
+		wantCode int
+		wantErrs []string
+		want     func(p Policy) bool
+	}{
+		{name: "not a number", query: "minIdle=abc", wantCode: http.StatusBadRequest, wantErrs: []string{"minIdle must be an integer, got 'abc'"}},
+		{name: "out of range", query: "replicaConcurrency=999&idleMode=paused", wantCode: http.StatusBadRequest, wantErrs: []string{"idleMode must be", "replicaConcurrency must be"}},
+		{name: "checked against the current policy", query: "minIdle=5", wantCode: http.StatusBadRequest, wantErrs: []string{"minIdle (5) cannot exceed maxIdle (4)"}},
+		{name: "hybrid keys on a native Function", query: "maxIdle=6&spawnAddlCtrs=99", wantCode: http.StatusOK, want: func(p Policy) bool { return p.maxIdle == 6 && p.spawnAddlCtrs == 0 }},
+		{name: "valid update", query: "minIdle=3&maxInflight=2&warmupPath=off", wantCode: http.StatusOK, want: func(p Policy) bool {
+			return p.minIdle == 3 && p.maxIdle == 4 && p.maxInflight == 2 && p.warmupPath == ""
+		}},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			fs, native, _ := newPolicyDocStore(t, nil)
+			native.policy.maxIdle = 4
+			before := native.policy
+			rr := httptest.NewRecorder()
+			MakePolicyHandler(fs)(rr, httptest.NewRequest(http.MethodGet, "/policy?action=update&fname=fn&"+tc.query, nil))
+			if rr.Code != tc.wantCode {
+				t.Fatalf("want %d, got %d %s", tc.wantCode, rr.Code, rr.Body.String())
+			}
+			if tc.wantCode != http.StatusOK {
+				var rejected policyDocumentErrors
+				if err := json.Unmarshal(rr.Body.Bytes(), &rejected); err != nil || len(rejected.Errors) != len(tc.wantErrs) {
+					t.Fatalf("want errors %v, got %s", tc.wantErrs, rr.Body.String())
+				}
+				for i, want := range tc.wantErrs {
+					if !strings.Contains(rejected.Errors[i], want) {
+						t.Fatalf("want error %d to mention '%s', got '%s'", i, want, rejected.Errors[i])
+					}
+				}
+				if !reflect.DeepEqual(native.policy, before) {
+					t.Fatalf("want the policy unchanged, got %+v", native.policy)
+				}
+				return
+			}
+			if !tc.want(native.policy) {
+				t.Fatalf("got unexpected policy %+v", native.policy)
+			}
+		})
+	}
+}
@@ -0,0 +1,180 @@
+package handlers
+
+import (
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.gatech.edu/faasedge/fecore/pkg/timec"
+)
+
+/* Replica readiness. A new Replica is only handed out (or put in the idle
+ * pool) once its watchdog is ready: by default once it accepts TCP
+ * connections, or with readinessProbe=http once a GET on readinessPath
+ * (default /_/health) answers 2xx. The probe is retried with exponential
+ * backoff for up to readinessTimeout ms (default 10000); Replicas that don't
+ * become ready in time are deleted. The proxy then sends the request exactly
+ * once. The time from sandbox start to ready is recorded per Replica and in
+ * FunctionStats, and reported to clients apart from setup and exec time. */
+
+const (
+	readinessTCP  = "tcp"
+	readinessHTTP = "http"
+
+	defaultReadinessPath      = "/_/health"
+	defaultReadinessTimeoutMs = 10000
+
+	readyBackoffMin    = time.Millisecond
+	readyBackoffMax    = 100 * time.Millisecond
+	readyProbeDialTime = 100 * time.Millisecond
+	readyProbeHTTPTime = time.Second
+)
+
+/* Waits until a Replica is ready; replaced in tests */
+var readinessCheck = probeReplica
+
+type readinessProbe struct {
+	kind    string // "tcp" or "http"
+	path    string
+	timeout time.Duration
+}
+
+/* Reads the readinessProbe, readinessPath and readinessTimeout labels into a
+ * Function's policy */
+func parseReadinessLabels(labels map[string]string, policy *Policy) error {
+	policy.readinessProbe = readinessTCP
+	policy.readinessPath = defaultReadinessPath
+	policy.readinessTimeout = defaultReadinessTimeoutMs
+	if v, ok := labels["readinessProbe"]; ok {
+		if v != readinessTCP && v != readinessHTTP {
+			return fmt.Errorf("[readiness/parseReadinessLabels] readinessProbe must be '%s' or '%s', got '%s'", readinessTCP, readinessHTTP, v)
+		}
+		policy.readinessProbe = v
+	}
+	if v, ok := labels["readinessPath"]; ok {
+		if len(v) == 0 || v[0] != '/' {
+			return fmt.Errorf("[readiness/parseReadinessLabels] readinessPath must start with '/', got '%s'", v)
+		}
+		policy.readinessPath = v
+	}
+	if v, ok := labels["readinessTimeout"]; ok {
+		val, err := strconv.Atoi(v)
+		if err != nil || val < 1 {
+			return fmt.Errorf("[readiness/parseReadinessLabels] readinessTimeout must be a positive number of ms, got '%s'", v)
+		}
+		policy.readinessTimeout = val
+	}
+	return nil
+}
+
+/* Returns the readiness probe of a Function; Functions deployed before
+ * readiness probing fall back to the defaults */
+func (p Policy) readiness() readinessProbe {
+	probe := readinessProbe{kind: p.readinessProbe, path: p.readinessPath, timeout: time.Duration(p.readinessTimeout) * time.Millisecond}
+	if probe.kind == "" {
+		probe.kind = readinessTCP
+	}
+	if probe.path == "" {
+		probe.path = defaultReadinessPath
+	}
+	if probe.timeout <= 0 {
+		probe.timeout = defaultReadinessTimeoutMs * time.Millisecond
+	}
+	return probe
+}
+
+/* Probes addr until it is ready, backing off exponentially between attempts */
+func probeReplica(addr string, probe readinessProbe) error {
+	deadline := time.Now().Add(probe.timeout)
+	backoff := readyBackoffMin
+	for {
+		err := probeOnce(addr, probe)
+		if err == nil {
+			return nil
+		}
+		remaining := time.Until(deadline)
+		if remaining <= 0 {
+			return fmt.Errorf("[readiness/probeReplica] %s not ready after %s: %w", addr, probe.timeout, err)
+		}
+		if backoff > remaining {
+			backoff = remaining
+		}
+		time.Sleep(backoff)
+		if backoff *= 2; backoff > readyBackoffMax {
+			backoff = readyBackoffMax
+		}
+	}
+}
+
+func probeOnce(addr string, probe readinessProbe) error {
+	if probe.kind != readinessHTTP {
+		conn, err := net.DialTimeout("tcp", addr, readyProbeDialTime)
+		if err != nil {
+			return err
+		}
+		conn.Close()
+		return nil
+	}
+	client := http.Client{Timeout: readyProbeHTTPTime}
+	resp, err := client.Get("http://" + addr + probe.path)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	io.Copy(io.Discard, resp.Body)
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return fmt.Errorf("GET %s returned %d", probe.path, resp.StatusCode)
+	}
+	return nil
+}
+
+/* Waits for a newly started Replica to become ready and records how long it
+ * took. Returns an error if the Replica should be discarded. */
+func (fs *FunctionStore) waitReady(replica *Replica, started time.Time, requestID string) error {
+	probe := fs.GetInvocationPolicy(replica.fname).readiness()
+	addr := net.JoinHostPort(replica.IP, strconv.Itoa(watchdogPort))
+	err := readinessCheck(addr, probe)
+	elapsed := time.Since(started)
+	fs.recordReadiness(replica.fname, elapsed, err == nil)
+	if err != nil {
+		timec.LogEvent("readiness/waitReady", fmt.Sprintf("Replica '%s' not ready after %d ms: %s <requestID=%s>", replica.uuid, elapsed.Milliseconds(), err, requestID), 1)
+		return err
+	}
+	replica.readyTime = elapsed
+	timec.LogEvent("readiness/waitReady", fmt.Sprintf("Replica '%s' ready after %d ms <requestID=%s>", replica.uuid, elapsed.Milliseconds(), requestID), 3)
+	return nil
+}
+
+func (fs *FunctionStore) recordReadiness(fname string, elapsed time.Duration, ok bool) {
+	stats, found := fs.lookupStats(fname)
+	if !found {
+		return
+	}
+	stats.statMu.Lock()
+	defer stats.statMu.Unlock()
+	if !ok {
+		stats.readyFailures += 1
+		return
+	}
+	stats.readyReplicas += 1
+	stats.totalReadyTime += elapsed.Milliseconds()
+	stats.avgReadyTime = stats.totalReadyTime / int64(stats.readyReplicas)
+}
+
+/* Returns how long an active Replica of a Function took to become ready after
+ * its sandbox started, or 0 if unknown (e.g. it was adopted) */
+func (fs *FunctionStore) ReplicaReadyTime(fname string, replicaName string) time.Duration {
+	_, pool := fs.findActiveReplicaPool(fname, replicaName)
+	if pool == nil {
+		return 0
+	}
+	pool.poolMu.RLock()
+	defer pool.poolMu.RUnlock()
+	if replica, ok := pool.activeReplicas[replicaName]; ok {
+		return replica.readyTime
+	}
+	return 0
+}
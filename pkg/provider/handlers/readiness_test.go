@@ -0,0 +1,169 @@
+package handlers
+
+import (
+	"errors"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.gatech.edu/faasedge/fecore/pkg/provider/config"
+)
+
+func Test_parseReadinessLabels(t *testing.T) {
+	tests := []struct {
+		name    string
+		labels  map[string]string
+		want    readinessProbe
+		wantErr bool
+	}{
+		{name: "tcp by default", labels: map[string]string{}, want: readinessProbe{kind: readinessTCP, path: defaultReadinessPath, timeout: 10 * time.Second}},
+		{name: "http probe", labels: map[string]string{"readinessProbe": "http", "readinessPath": "/ready", "readinessTimeout": "500"}, want: readinessProbe{kind: readinessHTTP, path: "/ready", timeout: 500 * time.Millisecond}},
+		{name: "unknown probe", labels: map[string]string{"readinessProbe": "exec"}, wantErr: true},
+		{name: "relative path", labels: map[string]string{"readinessPath": "ready"}, wantErr: true},
+		{name: "zero timeout", labels: map[string]string{"readinessTimeout": "0"}, wantErr: true},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			policy := Policy{}
+			err := parseReadinessLabels(tc.labels, &policy)
+			if tc.wantErr {
+				if err == nil {
+					t.Fatalf("want error, got probe %+v", policy.readiness())
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("want no error, got: %s", err)
+			}
+			if policy.readiness() != tc.want {
+				t.Fatalf("want probe %+v, got %+v", tc.want, policy.readiness())
+			}
+		})
+	}
+}
+
+func Test_probeReplica(t *testing.T) {
+	/* Answers 503 until it has been probed failures times */
+	newServer := func(failures int32) *httptest.Server {
+		var probes atomic.Int32
+		return httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if r.URL.Path != "/_/health" || probes.Add(1) <= failures {
+				w.WriteHeader(http.StatusServiceUnavailable)
+			}
+		}))
+	}
+	closedAddr := func() string {
+		l, err := net.Listen("tcp", "127.0.0.1:0")
+		if err != nil {
+			t.Fatalf("want a free port, got: %s", err)
+		}
+		addr := l.Addr().String()
+		l.Close()
+		return addr
+	}
+
+	tests := []struct {
+		name     string
+		kind     string
+		failures int32 // 503s before the HTTP probe succeeds
+		closed   bool  // nothing listens on the port
+		wantErr  bool
+	}{
+		{name: "tcp ready", kind: readinessTCP},
+		{name: "tcp never ready", kind: readinessTCP, closed: true, wantErr: true},
+		{name: "http ready", kind: readinessHTTP},
+		{name: "http ready after retries", kind: readinessHTTP, failures: 3},
+		{name: "http never ready", kind: readinessHTTP, failures: 1 << 30, wantErr: true},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			server := newServer(tc.failures)
+			defer server.Close()
+			addr := strings.TrimPrefix(server.URL, "http://")
+			if tc.closed {
+				addr = closedAddr()
+			}
+
+			err := probeReplica(addr, readinessProbe{kind: tc.kind, path: "/_/health", timeout: 200 * time.Millisecond})
+			if (err != nil) != tc.wantErr {
+				t.Fatalf("want error=%v, got %v", tc.wantErr, err)
+			}
+		})
+	}
+}
+
+/* Sandbox runtime whose sandboxes are real child processes, like runw */
+type processRuntime struct {
+	fakeRuntime
+	pid int
+}
+
+func (rt *processRuntime) Type() string {
+	return "process"
+}
+
+func (rt *processRuntime) Reserve(fs *FunctionStore) bool {
+	return true
+}
+
+func (rt *processRuntime) Release(fs *FunctionStore) {}
+
+func (rt *processRuntime) Create(fs *FunctionStore, fname string, replicaName string, requestID string) (*Replica, error) {
+	return &Replica{fname: fname, uuid: replicaName, ctrType: rt.Type(), IP: "127.0.0.1"}, nil
+}
+
+func (rt *processRuntime) Start(fs *FunctionStore, replica *Replica, requestID string) error {
+	cmd := exec.Command("sleep", "60")
+	if err := cmd.Start(); err != nil {
+		return err
+	}
+	rt.pid = cmd.Process.Pid
+	replica.PID = uint32(rt.pid)
+	replica.cmd = cmd
+	return nil
+}
+
+func (rt *processRuntime) Delete(fs *FunctionStore, replica *Replica) error {
+	return replica.cmd.Process.Kill()
+}
+
+/* A Replica that never gets ready has no liveness watcher to reap its
+ * process, so createReplica must */
+func Test_createReplicaReapsUnreadyReplica(t *testing.T) {
+	if _, err := exec.LookPath("sleep"); err != nil {
+		t.Skip("sleep is not available")
+	}
+	rt := &processRuntime{}
+	registerTestRuntime(t, "process", rt)
+	readinessCheck = func(addr string, probe readinessProbe) error { return errors.New("connection refused") }
+	defer func() { readinessCheck = probeReplica }()
+
+	fs := newTestStore(t, config.Config{})
+	fs.AddDeployedFunction(&Function{name: "fn", activeReplicas: make(map[string]*Replica)})
+
+	if _, _, err := createReplica(fs, "fn", "process", true, "test"); err == nil {
+		t.Fatalf("want an error for a replica that never gets ready")
+	}
+	/* A killed but unreaped child stays in /proc as a zombie */
+	stat := filepath.Join("/proc", strconv.Itoa(rt.pid))
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		if _, err := os.Stat(stat); os.IsNotExist(err) {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("want the process (PID=%d) reaped", rt.pid)
+		}
+		time.Sleep(time.Millisecond)
+	}
+}
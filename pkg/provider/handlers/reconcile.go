@@ -0,0 +1,157 @@
+package handlers
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+
+	"github.gatech.edu/faasedge/fecore/pkg/provider/storage"
+	"github.gatech.edu/faasedge/fecore/pkg/timec"
+)
+
+/* Startup reconciliation. Replicas are stored in the DB while they run, so
+ * after a restart the DB may list replicas whose sandbox is gone, and
+ * containerd, the process table and cgroupfs may hold sandboxes the DB doesn't
+ * know about. Reconcile re-adopts stored replicas that are still healthy
+ * (back into their Function's idle pool, with a liveness watcher), tears down
+ * the rest and asks every sandbox runtime to sweep resources no replica owns.
+ * It must run after the containerd client, CNI and WASM netns pool are set up
+ * and before the warm pool and cleanup daemons start. */
+
+type reconcileReport struct {
+	Adopted []string `json:"adopted"` // stored replicas put back in their idle pools
+	Dropped []string `json:"dropped"` // stored replicas that were gone or unusable
+	Orphans []string `json:"orphans"` // sandbox resources owned by no replica
+}
+
+func (r reconcileReport) String() string {
+	var sb strings.Builder
+	fmt.Fprintf(&sb, "adopted %d replicas, dropped %d, removed %d orphaned resources", len(r.Adopted), len(r.Dropped), len(r.Orphans))
+	for _, name := range r.Dropped {
+		fmt.Fprintf(&sb, "\n  dropped %s", name)
+	}
+	for _, orphan := range r.Orphans {
+		fmt.Fprintf(&sb, "\n  removed %s", orphan)
+	}
+	return sb.String()
+}
+
+/* Reconciles stored replicas with the sandboxes that really exist */
+func (fs *FunctionStore) Reconcile() reconcileReport {
+	ctrTypes := SandboxRuntimeTypes()
+	sort.Strings(ctrTypes)
+	runtimes := make([]SandboxRuntime, 0, len(ctrTypes))
+	for _, ctrType := range ctrTypes {
+		rt, _ := GetSandboxRuntime(ctrType)
+		runtimes = append(runtimes, rt)
+	}
+	return fs.reconcile(runtimes)
+}
+
+func (fs *FunctionStore) reconcile(runtimes []SandboxRuntime) reconcileReport {
+	report := reconcileReport{Adopted: make([]string, 0), Dropped: make([]string, 0), Orphans: make([]string, 0)}
+
+	var stored []storage.Container
+	if fs.storageManager != nil {
+		var err error
+		stored, err = fs.storageManager.GetAllContainers()
+		if err != nil {
+			timec.LogEvent("reconcile/reconcile", fmt.Sprintf("Unable to read stored replicas: %s", err), 1)
+		}
+	}
+
+	keep := make(map[string]bool)
+	for i := range stored {
+		replica := getReplica(&stored[i])
+		if fs.adoptReplica(runtimes, replica) {
+			keep[replica.uuid] = true
+			report.Adopted = append(report.Adopted, replica.uuid)
+		} else {
+			report.Dropped = append(report.Dropped, replica.uuid)
+		}
+	}
+
+	for _, rt := range runtimes {
+		report.Orphans = append(report.Orphans, rt.Sweep(fs, keep)...)
+	}
+
+	timec.LogEvent("reconcile/reconcile", fmt.Sprintf("Reconciled stored state: %s", report), 2)
+	return report
+}
+
+/* Puts a stored replica back in its idle pool if its sandbox is still
+ * usable; otherwise tears it down and removes it from the DB */
+func (fs *FunctionStore) adoptReplica(runtimes []SandboxRuntime, replica *Replica) bool {
+	var rt SandboxRuntime
+	for _, candidate := range runtimes {
+		if candidate.Type() == replica.ctrType {
+			rt = candidate
+		}
+	}
+	if rt == nil {
+		/* Rows stored before the ctrType column existed */
+		for _, candidate := range runtimes {
+			if strings.HasSuffix(replica.uuid, candidate.Suffix()) {
+				rt = candidate
+				replica.ctrType = candidate.Type()
+			}
+		}
+	}
+	if rt == nil {
+		timec.LogEvent("reconcile/adoptReplica", fmt.Sprintf("No sandbox runtime for stored replica '%s'; dropping", replica.uuid), 1)
+		fs.forgetReplica(replica)
+		return false
+	}
+
+	/* A replica over the container limit holds no slot, so it is not torn
+	 * down here (Delete hands a slot back); it isn't kept, so the sweep
+	 * removes its sandbox */
+	if !rt.Reserve(fs) {
+		timec.LogEvent("reconcile/adoptReplica", fmt.Sprintf("Dropping stored replica '%s': container limit reached", replica.uuid), 2)
+		fs.forgetReplica(replica)
+		return false
+	}
+
+	fs.dfMu.RLock()
+	_, deployed := fs.deployedFunctions[replica.fname]
+	fs.dfMu.RUnlock()
+	var err error
+	if !deployed {
+		err = fmt.Errorf("[reconcile/adoptReplica] Function '%s' is no longer deployed", replica.fname)
+	} else {
+		err = rt.Adopt(fs, replica)
+	}
+	if err == nil {
+		replica.createdAt = time.Now()
+		fs.AddIdleReplica(replica)
+		go fs.watchReplica(rt, replica)
+		timec.LogEvent("reconcile/adoptReplica", fmt.Sprintf("Adopted %s replica '%s' of '%s' (PID=%d, IP=%s)", replica.ctrType, replica.uuid, replica.fname, replica.PID, replica.IP), 2)
+		return true
+	}
+
+	/* Never signal a PID or hand back a netns that wasn't verified; the
+	 * sweep kills stray processes by name */
+	replica.PID = 0
+	replica.IP = ""
+	timec.LogEvent("reconcile/adoptReplica", fmt.Sprintf("Dropping stored replica '%s': %s", replica.uuid, err), 2)
+	replica.terminating.Store(true)
+	if err := rt.Delete(fs, replica); err != nil {
+		timec.LogEvent("reconcile/adoptReplica", fmt.Sprintf("Unable to release resources of replica '%s': %s", replica.uuid, err), 1)
+	}
+	fs.forgetReplica(replica)
+	return false
+}
+
+func getReplica(c *storage.Container) *Replica {
+	return &Replica{
+		fname:     c.ParentFunction,
+		ctrType:   c.CtrType,
+		uuid:      c.Name,
+		PID:       uint32(c.Pid),
+		IP:        c.Ip,
+		netNS:     c.NetNS,
+		image:     c.Image,
+		namespace: c.Namespace,
+	}
+}
@@ -0,0 +1,120 @@
+package handlers
+
+import (
+	"fmt"
+	"sort"
+	"testing"
+
+	"github.gatech.edu/faasedge/fecore/pkg/provider/config"
+	"github.gatech.edu/faasedge/fecore/pkg/provider/storage"
+)
+
+/* Sandbox runtime with a fixed set of running sandboxes */
+type adoptingRuntime struct {
+	fakeRuntime
+	running map[string]bool
+	deleted []string
+	slots   int
+	limit   int // 0 = unlimited
+}
+
+func (rt *adoptingRuntime) Reserve(fs *FunctionStore) bool {
+	if rt.limit > 0 && rt.slots >= rt.limit {
+		return false
+	}
+	rt.slots += 1
+	return true
+}
+
+func (rt *adoptingRuntime) Release(fs *FunctionStore) {
+	rt.slots -= 1
+}
+
+func (rt *adoptingRuntime) Adopt(fs *FunctionStore, replica *Replica) error {
+	if !rt.running[replica.uuid] {
+		return fmt.Errorf("sandbox of '%s' is not running", replica.uuid)
+	}
+	return nil
+}
+
+func (rt *adoptingRuntime) Delete(fs *FunctionStore, replica *Replica) error {
+	rt.deleted = append(rt.deleted, replica.uuid)
+	rt.Release(fs)
+	return nil
+}
+
+func (rt *adoptingRuntime) Wait(fs *FunctionStore, replica *Replica) ReplicaExit {
+	select {}
+}
+
+func (rt *adoptingRuntime) Sweep(fs *FunctionStore, keep map[string]bool) []string {
+	removed := make([]string, 0)
+	for name := range rt.running {
+		if !keep[name] {
+			removed = append(removed, name)
+		}
+	}
+	sort.Strings(removed)
+	return removed
+}
+
+func Test_ReconcileAdoptsAndSweeps(t *testing.T) {
+	fs := newTestStore(t, config.Config{}, &Function{name: "fn"})
+	db := testDB(fs)
+	db.containers = map[string]storage.Container{
+		"fn_alive_f": {Name: "fn_alive_f", ParentFunction: "fn", Ip: "10.63.100.1", CtrType: "fake", Pid: 10},
+		"fn_dead_f":  {Name: "fn_dead_f", ParentFunction: "fn", Ip: "10.63.100.2", CtrType: "fake", Pid: 11},
+		"gone_old_f": {Name: "gone_old_f", ParentFunction: "gone", Ip: "10.63.100.3", Pid: 12},
+	}
+	rt := &adoptingRuntime{running: map[string]bool{"fn_alive_f": true, "gone_old_f": true, "fn_orphan_f": true}}
+
+	report := fs.reconcile([]SandboxRuntime{rt})
+
+	if len(report.Adopted) != 1 || report.Adopted[0] != "fn_alive_f" {
+		t.Fatalf("want 'fn_alive_f' adopted, got %v", report.Adopted)
+	}
+	sort.Strings(report.Dropped)
+	if len(report.Dropped) != 2 || report.Dropped[0] != "fn_dead_f" || report.Dropped[1] != "gone_old_f" {
+		t.Fatalf("want 'fn_dead_f' and 'gone_old_f' dropped, got %v", report.Dropped)
+	}
+	if len(report.Orphans) != 2 || report.Orphans[0] != "fn_orphan_f" || report.Orphans[1] != "gone_old_f" {
+		t.Fatalf("want 'fn_orphan_f' and 'gone_old_f' swept, got %v", report.Orphans)
+	}
+
+	idle := fs.deployedFunctions["fn"].idleReplicas
+	if idle.size() != 1 || idle.MRU.uuid != "fn_alive_f" || idle.MRU.ctrType != "fake" || idle.MRU.PID != 10 {
+		t.Fatalf("want adopted replica in the idle pool, got size=%d", idle.size())
+	}
+	if len(rt.deleted) != 2 || rt.slots != 1 {
+		t.Fatalf("want dropped replicas deleted and one slot held, got deleted=%v slots=%d", rt.deleted, rt.slots)
+	}
+	if _, ok := db.containers["fn_alive_f"]; !ok || len(db.containers) != 1 {
+		t.Fatalf("want only the adopted replica left in the DB, got %v", db.containers)
+	}
+}
+
+func Test_ReconcileOverContainerLimit(t *testing.T) {
+	fs := newTestStore(t, config.Config{}, &Function{name: "fn"})
+	db := testDB(fs)
+	db.containers = map[string]storage.Container{
+		"fn_a_f": {Name: "fn_a_f", ParentFunction: "fn", CtrType: "fake", Pid: 10},
+		"fn_b_f": {Name: "fn_b_f", ParentFunction: "fn", CtrType: "fake", Pid: 11},
+	}
+	rt := &adoptingRuntime{running: map[string]bool{"fn_a_f": true, "fn_b_f": true}, limit: 1}
+
+	report := fs.reconcile([]SandboxRuntime{rt})
+
+	if len(report.Adopted) != 1 || len(report.Dropped) != 1 {
+		t.Fatalf("want one replica adopted and one dropped, got %+v", report)
+	}
+	/* The dropped replica never held a slot, so it is left to the sweep */
+	if len(rt.deleted) != 0 || rt.slots != 1 {
+		t.Fatalf("want no delete and one slot held, got deleted=%v slots=%d", rt.deleted, rt.slots)
+	}
+	if len(report.Orphans) != 1 || report.Orphans[0] != report.Dropped[0] {
+		t.Fatalf("want the dropped replica swept, got %v", report.Orphans)
+	}
+	if len(db.containers) != 1 {
+		t.Fatalf("want only the adopted replica left in the DB, got %v", db.containers)
+	}
+}
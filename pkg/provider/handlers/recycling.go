@@ -0,0 +1,60 @@
+package handlers
+
+import (
+	"fmt"
+	"strconv"
+	"time"
+
+	"github.gatech.edu/faasedge/fecore/pkg/timec"
+)
+
+/* Replica recycling. Long-lived warm Replicas can build up state (e.g. leaked
+ * memory in Python/Node runtimes). A Function with maxInvocationsPerReplica
+ * or maxReplicaAge (seconds) set retires a Replica when its last invocation
+ * finishes and it has served that many invocations or is that old, instead of
+ * returning it to the idle pool. 0 means no limit. */
+
+/* Reads the maxInvocationsPerReplica and maxReplicaAge labels into a
+ * Function's policy */
+func parseRecyclingLabels(labels map[string]string, policy *Policy) error {
+	policy.maxInvocations = 0
+	policy.maxReplicaAge = 0
+	for label, field := range map[string]*int{"maxInvocationsPerReplica": &policy.maxInvocations, "maxReplicaAge": &policy.maxReplicaAge} {
+		if v, ok := labels[label]; ok {
+			val, err := strconv.Atoi(v)
+			if err != nil || val < 0 {
+				return fmt.Errorf("[recycling/parseRecyclingLabels] %s must be a non-negative integer, got '%s'", label, v)
+			}
+			*field = val
+		}
+	}
+	return nil
+}
+
+/* Returns why a Replica should be retired, or "" if it can be reused */
+func (p Policy) retirementReason(replica *Replica, now time.Time) string {
+	if p.maxInvocations > 0 && replica.accessCount >= p.maxInvocations {
+		return fmt.Sprintf("served %d invocations (maxInvocationsPerReplica=%d)", replica.accessCount, p.maxInvocations)
+	}
+	if p.maxReplicaAge > 0 && !replica.createdAt.IsZero() && now.Sub(replica.createdAt) >= time.Duration(p.maxReplicaAge)*time.Second {
+		return fmt.Sprintf("is %s old (maxReplicaAge=%ds)", now.Sub(replica.createdAt).Round(time.Second), p.maxReplicaAge)
+	}
+	return ""
+}
+
+/* Deletes a Replica that finished its last invocation if it reached its
+ * Function's recycling limits. Returns true if it was retired. */
+func (fs *FunctionStore) retireReplica(pool string, replica *Replica, requestID string) bool {
+	reason := fs.GetInvocationPolicy(pool).retirementReason(replica, time.Now())
+	if reason == "" {
+		return false
+	}
+	timec.LogEvent("recycling/retireReplica", fmt.Sprintf("Retiring replica '%s' of '%s': %s <requestID=%s>", replica.uuid, pool, reason, requestID), 2)
+	if stats, ok := fs.lookupStats(pool); ok {
+		stats.statMu.Lock()
+		stats.recycledReplicas += 1
+		stats.statMu.Unlock()
+	}
+	fs.DeleteReplica(replica)
+	return true
+}
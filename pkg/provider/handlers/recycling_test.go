@@ -0,0 +1,101 @@
+package handlers
+
+import (
+	"testing"
+	"time"
+
+	"github.gatech.edu/faasedge/fecore/pkg/provider/config"
+)
+
+func Test_parseRecyclingLabels(t *testing.T) {
+	tests := []struct {
+		name    string
+		labels  map[string]string
+		want    Policy
+		wantErr bool
+	}{
+		{name: "no limits by default", labels: map[string]string{}, want: Policy{}},
+		{name: "both limits", labels: map[string]string{"maxInvocationsPerReplica": "100", "maxReplicaAge": "3600"}, want: Policy{maxInvocations: 100, maxReplicaAge: 3600}},
+		{name: "negative", labels: map[string]string{"maxReplicaAge": "-5"}, wantErr: true},
+		{name: "not a number", labels: map[string]string{"maxInvocationsPerReplica": "lots"}, wantErr: true},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			policy := Policy{}
+			err := parseRecyclingLabels(tc.labels, &policy)
+			if tc.wantErr {
+				if err == nil {
+					t.Fatalf("want error, got policy %+v", policy)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("want no error, got: %s", err)
+			}
+			if policy != tc.want {
+				t.Fatalf("want %+v, got %+v", tc.want, policy)
+			}
+		})
+	}
+}
+
+func Test_retirementReason(t *testing.T) {
+	now := time.Now()
+	tests := []struct {
+		name    string
+		policy  Policy
+		replica *Replica
+		retire  bool
+	}{
+		{name: "no limits", policy: Policy{}, replica: &Replica{accessCount: 1000, createdAt: now.Add(-24 * time.Hour)}},
+		{name: "below invocation limit", policy: Policy{maxInvocations: 3}, replica: &Replica{accessCount: 2, createdAt: now}},
+		{name: "invocation limit reached", policy: Policy{maxInvocations: 3}, replica: &Replica{accessCount: 3, createdAt: now}, retire: true},
+		{name: "young replica", policy: Policy{maxReplicaAge: 60}, replica: &Replica{accessCount: 1, createdAt: now.Add(-30 * time.Second)}},
+		{name: "old replica", policy: Policy{maxReplicaAge: 60}, replica: &Replica{accessCount: 1, createdAt: now.Add(-2 * time.Minute)}, retire: true},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			reason := tc.policy.retirementReason(tc.replica, now)
+			if tc.retire != (reason != "") {
+				t.Fatalf("want retire=%v, got reason '%s'", tc.retire, reason)
+			}
+		})
+	}
+}
+
+func Test_UpdateReplicaStatusInactiveRetiresReplica(t *testing.T) {
+	rt := &recordingRuntime{}
+	registerTestRuntime(t, "recording", rt)
+
+	fn := &Function{name: "fn", activeReplicas: make(map[string]*Replica)}
+	fn.policy.maxInvocations = 2
+	fs := newTestStore(t, config.Config{}, fn)
+	fs.AddIdleReplica(&Replica{fname: "fn", uuid: "a", ctrType: "recording", createdAt: time.Now()})
+
+	/* First invocation: the replica goes back to the idle pool */
+	if name, _, _, err := fs.GetIdleReplica("fn", "first"); err != nil || name != "a" {
+		t.Fatalf("want replica 'a', got '%s' (err=%v)", name, err)
+	}
+	if err := fs.UpdateReplicaStatusInactive("fn", "a", "first"); err != nil {
+		t.Fatalf("want no error, got: %s", err)
+	}
+	if fn.idleReplicas.size() != 1 {
+		t.Fatalf("want replica 'a' back in the idle pool")
+	}
+
+	/* Second invocation uses up maxInvocationsPerReplica */
+	if name, _, _, err := fs.GetIdleReplica("fn", "second"); err != nil || name != "a" {
+		t.Fatalf("want replica 'a', got '%s' (err=%v)", name, err)
+	}
+	if err := fs.UpdateReplicaStatusInactive("fn", "a", "second"); err != nil {
+		t.Fatalf("want no error, got: %s", err)
+	}
+	if fn.idleReplicas.size() != 0 || len(rt.deleted) != 1 || rt.deleted[0] != "a" {
+		t.Fatalf("want replica 'a' retired, got idle=%d deleted=%v", fn.idleReplicas.size(), rt.deleted)
+	}
+	if fs.functionStats["fn"].recycledReplicas != 1 {
+		t.Fatalf("want 1 recycled replica, got %d", fs.functionStats["fn"].recycledReplicas)
+	}
+}
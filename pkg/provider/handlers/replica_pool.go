@@ -0,0 +1,130 @@
+package handlers
+
+/* Replica pools. Every Function is a shard of the FunctionStore: its idle pool,
+ * its activeReplicas map and the pool state of its Replicas (inflight,
+ * accessCount, lastAccess and the idle list links) are guarded by the
+ * Function's poolMu alone, so invocations of different Functions never
+ * contend. The maps from name to Function/FunctionStats are guarded by dfMu and
+ * only read through lookupFunction and lookupStats.
+ *
+ * Lock order: dfMu -> poolMu -> statMu. Never take dfMu while holding a poolMu.
+ *
+ * The idle pool is an intrusive doubly linked list ordered from LRU (head) to
+ * MRU (tail); warm starts take the MRU, cleanup and eviction take the LRU, and
+ * a Replica that dies is unlinked in place, all in O(1). */
+
+type IdleReplicas struct {
+	fname string   // name of parent Function
+	count uint32   // number of idle replicas for Function
+	LRU   *Replica // least recently used (head)
+	MRU   *Replica // most recently used (tail)
+}
+
+/* Links a Replica into the pool as its MRU. Caller must hold poolMu */
+func (ir *IdleReplicas) pushMRU(replica *Replica) {
+	replica.idlePool = ir
+	replica.idlePrev = ir.MRU
+	replica.idleNext = nil
+	if ir.MRU != nil {
+		ir.MRU.idleNext = replica
+	} else {
+		ir.LRU = replica
+	}
+	ir.MRU = replica
+	ir.count += 1
+}
+
+/* Unlinks a Replica from the pool. Caller must hold poolMu */
+func (ir *IdleReplicas) unlink(replica *Replica) {
+	if replica.idlePrev != nil {
+		replica.idlePrev.idleNext = replica.idleNext
+	} else {
+		ir.LRU = replica.idleNext
+	}
+	if replica.idleNext != nil {
+		replica.idleNext.idlePrev = replica.idlePrev
+	} else {
+		ir.MRU = replica.idlePrev
+	}
+	replica.idlePool = nil
+	replica.idlePrev = nil
+	replica.idleNext = nil
+	ir.count -= 1
+}
+
+/* Removes a specific Replica from the idle pool. Returns false if it wasn't
+ * idle. Caller must hold poolMu */
+func (ir *IdleReplicas) remove(replica *Replica) bool {
+	if replica.idlePool != ir {
+		return false
+	}
+	ir.unlink(replica)
+	return true
+}
+
+/* Removes and returns the most recently used idle replica, or nil if the
+ * pool is empty. Caller must hold poolMu */
+func (ir *IdleReplicas) popMRU() *Replica {
+	replica := ir.MRU
+	if replica != nil {
+		ir.unlink(replica)
+	}
+	return replica
+}
+
+/* Removes and returns the least recently used idle replica, or nil if the
+ * pool is empty. Caller must hold poolMu */
+func (ir *IdleReplicas) popLRU() *Replica {
+	replica := ir.LRU
+	if replica != nil {
+		ir.unlink(replica)
+	}
+	return replica
+}
+
+/* Returns the oldest Replica in the idle pool without removing it.
+ * Caller must hold poolMu */
+func (ir *IdleReplicas) peekLRU() *Replica {
+	return ir.LRU
+}
+
+/* Number of replicas held in the idle pool */
+func (ir *IdleReplicas) size() int {
+	return int(ir.count)
+}
+
+/* Returns the idle replicas from LRU to MRU. Caller must hold poolMu */
+func (ir *IdleReplicas) replicas() []*Replica {
+	replicas := make([]*Replica, 0, ir.size())
+	for replica := ir.LRU; replica != nil; replica = replica.idleNext {
+		replicas = append(replicas, replica)
+	}
+	return replicas
+}
+
+/* Returns a deployed Function */
+func (fs *FunctionStore) lookupFunction(name string) (*Function, bool) {
+	fs.dfMu.RLock()
+	defer fs.dfMu.RUnlock()
+	fn, ok := fs.deployedFunctions[name]
+	return fn, ok
+}
+
+/* Returns the stats of a deployed Function */
+func (fs *FunctionStore) lookupStats(name string) (*FunctionStats, bool) {
+	fs.dfMu.RLock()
+	defer fs.dfMu.RUnlock()
+	stats, ok := fs.functionStats[name]
+	return stats, ok
+}
+
+/* Returns the number of idle Replicas of a Function */
+func (fs *FunctionStore) idleCount(fname string) int {
+	fn, ok := fs.lookupFunction(fname)
+	if !ok {
+		return 0
+	}
+	fn.poolMu.RLock()
+	defer fn.poolMu.RUnlock()
+	return fn.idleReplicas.size()
+}
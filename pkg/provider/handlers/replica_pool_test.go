@@ -0,0 +1,184 @@
+package handlers
+
+import (
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"sync"
+	"sync/atomic"
+	"testing"
+
+	"github.gatech.edu/faasedge/fecore/pkg/provider/config"
+)
+
+func Test_IdleReplicas(t *testing.T) {
+	tests := []struct {
+		name    string
+		push    []string
+		remove  []string
+		want    []string // LRU to MRU
+		removed bool
+	}{
+		{name: "empty", want: []string{}},
+		{name: "single replica is both LRU and MRU", push: []string{"a"}, want: []string{"a"}},
+		{name: "ordered by push", push: []string{"a", "b", "c"}, want: []string{"a", "b", "c"}},
+		{name: "remove middle", push: []string{"a", "b", "c"}, remove: []string{"b"}, want: []string{"a", "c"}, removed: true},
+		{name: "remove LRU", push: []string{"a", "b", "c"}, remove: []string{"a"}, want: []string{"b", "c"}, removed: true},
+		{name: "remove MRU", push: []string{"a", "b", "c"}, remove: []string{"c"}, want: []string{"a", "b"}, removed: true},
+		{name: "remove last", push: []string{"a"}, remove: []string{"a"}, want: []string{}, removed: true},
+		{name: "remove replica that isn't idle", push: []string{"a"}, remove: []string{"x"}, want: []string{"a"}},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			ir := IdleReplicas{}
+			replicas := map[string]*Replica{"x": {uuid: "x"}}
+			for _, name := range tc.push {
+				replicas[name] = &Replica{uuid: name}
+				ir.pushMRU(replicas[name])
+			}
+			for _, name := range tc.remove {
+				if removed := ir.remove(replicas[name]); removed != tc.removed {
+					t.Fatalf("want remove('%s')=%v, got %v", name, tc.removed, removed)
+				}
+			}
+
+			got := make([]string, 0)
+			for _, replica := range ir.replicas() {
+				got = append(got, replica.uuid)
+			}
+			if fmt.Sprint(got) != fmt.Sprint(tc.want) || ir.size() != len(tc.want) {
+				t.Fatalf("want %v, got %v (size %d)", tc.want, got, ir.size())
+			}
+			if len(tc.want) > 0 && (ir.peekLRU().uuid != tc.want[0] || ir.MRU.uuid != tc.want[len(tc.want)-1]) {
+				t.Fatalf("want LRU '%s' and MRU '%s', got '%s' and '%s'", tc.want[0], tc.want[len(tc.want)-1], ir.LRU.uuid, ir.MRU.uuid)
+			}
+
+			/* Warm starts take the MRU, cleanup takes the LRU */
+			if len(tc.want) > 1 {
+				if mru := ir.popMRU(); mru.uuid != tc.want[len(tc.want)-1] || mru.idlePool != nil {
+					t.Fatalf("want popMRU '%s', got '%s'", tc.want[len(tc.want)-1], mru.uuid)
+				}
+				if lru := ir.popLRU(); lru.uuid != tc.want[0] {
+					t.Fatalf("want popLRU '%s', got '%s'", tc.want[0], lru.uuid)
+				}
+				if ir.size() != len(tc.want)-2 {
+					t.Fatalf("want size %d after pops, got %d", len(tc.want)-2, ir.size())
+				}
+			}
+		})
+	}
+}
+
+/* Builds a FunctionStore with the given number of Functions, each with an
+ * idle pool of replicasPerFn Replicas */
+func newPoolTestStore(t testing.TB, functions int, replicasPerFn int) (*FunctionStore, []string) {
+	fs := newTestStore(t, config.Config{ContainerExpirationTime: 3600})
+	names := make([]string, 0, functions)
+	for i := 0; i < functions; i++ {
+		name := fmt.Sprintf("fn-%d", i)
+		fn := &Function{name: name, activeReplicas: make(map[string]*Replica)}
+		/* Every other Function shares its Replicas between invocations */
+		fn.policy.replicaConcurrency = 1 + i%2
+		fs.AddDeployedFunction(fn)
+		for r := 0; r < replicasPerFn; r++ {
+			fs.AddIdleReplica(&Replica{fname: name, uuid: fmt.Sprintf("%s_%d", name, r), ctrType: "pool-test"})
+		}
+		names = append(names, name)
+	}
+	return fs, names
+}
+
+/* Invocations, the daemons, the metrics report and deploys run side by side.
+ * Run with -race. */
+func Test_ReplicaPoolConcurrentInvocations(t *testing.T) {
+	log.SetOutput(io.Discard)
+	defer log.SetOutput(os.Stderr)
+	fs, names := newPoolTestStore(t, 16, 4)
+
+	var wg sync.WaitGroup
+	stop := make(chan struct{})
+	var warmStarts atomic.Int64
+	for g := 0; g < 32; g++ {
+		wg.Add(1)
+		go func(g int) {
+			defer wg.Done()
+			for i := 0; i < 200; i++ {
+				fname := names[(g+i)%len(names)]
+				replicaName, _, _, err := fs.GetIdleReplica(fname, "test")
+				if err != nil {
+					continue
+				}
+				warmStarts.Add(1)
+				if err := fs.UpdateReplicaStatusInactive(fname, replicaName, "test"); err != nil {
+					t.Errorf("want no error returning '%s', got: %s", replicaName, err)
+				}
+			}
+		}(g)
+	}
+
+	var background sync.WaitGroup
+	background.Add(2)
+	go func() {
+		defer background.Done()
+		for i := 0; ; i++ {
+			select {
+			case <-stop:
+				return
+			default:
+			}
+			fs.CleanupDaemon(nil, nil)
+			GetMetricsReport(fs, names[i%len(names)])
+			fn, _ := fs.lookupFunction(names[i%len(names)])
+			fs.countReplicas(fn)
+		}
+	}()
+	go func() {
+		defer background.Done()
+		for i := 0; i < 50; i++ {
+			fs.AddDeployedFunction(&Function{name: fmt.Sprintf("late-%d", i), activeReplicas: make(map[string]*Replica)})
+		}
+	}()
+
+	wg.Wait()
+	close(stop)
+	background.Wait()
+
+	if warmStarts.Load() == 0 {
+		t.Fatalf("want some warm starts")
+	}
+	for _, name := range names {
+		fn, _ := fs.lookupFunction(name)
+		if len(fn.activeReplicas) != 0 || fn.idleReplicas.size() != 4 || len(fn.idleReplicas.replicas()) != 4 {
+			t.Fatalf("want all 4 replicas of '%s' back in the idle pool, got idle=%d active=%d", name, fn.idleReplicas.size(), len(fn.activeReplicas))
+		}
+	}
+}
+
+/* Warm start lookup throughput: every iteration checks a Replica out of a
+ * Function's idle pool and returns it, spread over many Functions */
+func BenchmarkWarmStartLookup(b *testing.B) {
+	for _, functions := range []int{1, 64, 1024} {
+		b.Run(fmt.Sprintf("functions=%d", functions), func(b *testing.B) {
+			log.SetOutput(io.Discard)
+			defer log.SetOutput(os.Stderr)
+			fs, names := newPoolTestStore(b, functions, 4)
+			var next atomic.Int64
+
+			b.ResetTimer()
+			b.RunParallel(func(pb *testing.PB) {
+				i := int(next.Add(1))
+				for pb.Next() {
+					fname := names[i%len(names)]
+					i += 7
+					replicaName, _, _, err := fs.GetIdleReplica(fname, "bench")
+					if err != nil {
+						continue
+					}
+					fs.UpdateReplicaStatusInactive(fname, replicaName, "bench")
+				}
+			})
+		})
+	}
+}
@@ -0,0 +1,116 @@
+package handlers
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+/* A SandboxRuntime knows how to manage Replicas of one kind of sandbox
+ * (e.g., native containers or WASM modules). Runtimes are registered under the
+ * ctrType label used at deploy time, so the resolver, Function Store and
+ * cleanup code never need to know which sandbox kinds exist. */
+type SandboxRuntime interface {
+	/* ctrType this runtime is registered under */
+	Type() string
+	/* Suffix appended to replica names (e.g. "_n") */
+	Suffix() string
+	/* Reserve/Release a slot against the node's container limit */
+	Reserve(fs *FunctionStore) bool
+	Release(fs *FunctionStore)
+	/* Prepare a new (not yet running) Replica for a Function */
+	Create(fs *FunctionStore, fname string, replicaName string, requestID string) (*Replica, error)
+	/* Start a created Replica; on success the Replica has a PID and IP */
+	Start(fs *FunctionStore, replica *Replica, requestID string) error
+	/* Pause/resume a running Replica while it is idle (see freeze.go) */
+	Freeze(fs *FunctionStore, replica *Replica) error
+	Thaw(fs *FunctionStore, replica *Replica) error
+	/* Restart a Replica's sandbox from a clean rootfs/state, keeping its
+	 * slot (see isolation.go); on success the Replica may have a new PID/IP */
+	Reset(fs *FunctionStore, replica *Replica, requestID string) error
+	/* Tear down the Replica and all resources held by it, including its slot;
+	 * teardown continues past errors and the first one is returned */
+	Delete(fs *FunctionStore, replica *Replica) error
+	/* Resource usage of a running Replica */
+	Stats(fs *FunctionStore, replica *Replica) (ReplicaStats, error)
+	/* Blocks until the Replica's sandbox exits and reports why */
+	Wait(fs *FunctionStore, replica *Replica) ReplicaExit
+	/* Re-attach to a Replica restored from the DB after a restart; returns
+	 * an error if its sandbox is no longer usable */
+	Adopt(fs *FunctionStore, replica *Replica) error
+	/* Remove sandboxes and resources not owned by any Replica in keep;
+	 * returns what was removed */
+	Sweep(fs *FunctionStore, keep map[string]bool) []string
+}
+
+type ReplicaStats struct {
+	MemoryBytes uint64 // current memory usage
+	CPUNanos    uint64 // cumulative CPU time
+}
+
+type ReplicaExit struct {
+	ExitCode int    // -1 if unknown or killed by a signal
+	Reason   string // e.g. "exited with code 1", "killed by signal 9"
+}
+
+var (
+	sandboxRuntimes   = make(map[string]SandboxRuntime)
+	sandboxRuntimesMu sync.RWMutex
+)
+
+func init() {
+	RegisterSandboxRuntime(&nativeRuntime{})
+	RegisterSandboxRuntime(&wasmRuntime{})
+}
+
+/* Makes a SandboxRuntime available under its ctrType. Registering a ctrType
+ * twice replaces the previous runtime. */
+func RegisterSandboxRuntime(rt SandboxRuntime) {
+	sandboxRuntimesMu.Lock()
+	defer sandboxRuntimesMu.Unlock()
+	sandboxRuntimes[rt.Type()] = rt
+}
+
+func GetSandboxRuntime(ctrType string) (SandboxRuntime, error) {
+	sandboxRuntimesMu.RLock()
+	defer sandboxRuntimesMu.RUnlock()
+	if rt, ok := sandboxRuntimes[ctrType]; ok {
+		return rt, nil
+	}
+	return nil, fmt.Errorf("[sandbox_runtime/GetSandboxRuntime] No sandbox runtime registered for ctrType '%s'", ctrType)
+}
+
+/* Returns the ctrTypes of all registered runtimes */
+func SandboxRuntimeTypes() []string {
+	sandboxRuntimesMu.RLock()
+	defer sandboxRuntimesMu.RUnlock()
+	types := make([]string, 0, len(sandboxRuntimes))
+	for ctrType := range sandboxRuntimes {
+		types = append(types, ctrType)
+	}
+	return types
+}
+
+/* Finds the runtime that created a replica based on its name suffix */
+func sandboxRuntimeForReplica(replicaName string) (SandboxRuntime, error) {
+	sandboxRuntimesMu.RLock()
+	defer sandboxRuntimesMu.RUnlock()
+	for _, rt := range sandboxRuntimes {
+		if strings.HasSuffix(replicaName, rt.Suffix()) {
+			return rt, nil
+		}
+	}
+	return nil, fmt.Errorf("[sandbox_runtime/sandboxRuntimeForReplica] No sandbox runtime matches replica '%s'", replicaName)
+}
+
+/* Reads a single integer value from a cgroup control file */
+func readCgroupValue(dir string, file string) (uint64, error) {
+	data, err := os.ReadFile(filepath.Join(dir, file))
+	if err != nil {
+		return 0, err
+	}
+	return strconv.ParseUint(strings.TrimSpace(string(data)), 10, 64)
+}
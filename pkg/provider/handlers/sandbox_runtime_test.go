@@ -0,0 +1,232 @@
+package handlers
+
+import (
+	"sort"
+	"sync"
+	"testing"
+
+	"github.gatech.edu/faasedge/fecore/pkg/provider/config"
+	"github.gatech.edu/faasedge/fecore/pkg/provider/storage"
+)
+
+/* Registers rt under ctrType for the duration of a test, restoring the
+ * runtime registered before (if any) */
+func registerTestRuntime(t testing.TB, ctrType string, rt SandboxRuntime) {
+	t.Helper()
+	sandboxRuntimesMu.Lock()
+	prev, hadPrev := sandboxRuntimes[ctrType]
+	sandboxRuntimes[ctrType] = rt
+	sandboxRuntimesMu.Unlock()
+	t.Cleanup(func() {
+		sandboxRuntimesMu.Lock()
+		defer sandboxRuntimesMu.Unlock()
+		if hadPrev {
+			sandboxRuntimes[ctrType] = prev
+		} else {
+			delete(sandboxRuntimes, ctrType)
+		}
+	})
+}
+
+/* Function Store built by InitFunctionStore on an empty in-memory DB (see
+ * testDB), with fns deployed */
+func newTestStore(t testing.TB, cfg config.Config, fns ...*Function) *FunctionStore {
+	t.Helper()
+	fs, err := InitFunctionStore(&memStorage{containers: make(map[string]storage.Container)}, cfg)
+	if err != nil {
+		t.Fatalf("unable to create Function Store: %s", err)
+	}
+	for _, fn := range fns {
+		if fn.activeReplicas == nil {
+			fn.activeReplicas = make(map[string]*Replica)
+		}
+		fs.deployedFunctions[fn.name] = fn
+		fs.initFunctionStats(fn.name)
+	}
+	return fs
+}
+
+/* In-memory DB of a store built by newTestStore */
+func testDB(fs *FunctionStore) *memStorage {
+	return fs.storageManager.(*memStorage)
+}
+
+/* In-memory StorageManager */
+type memStorage struct {
+	mu         sync.Mutex
+	containers map[string]storage.Container
+	policies   map[string]storage.Policy
+	snapshots  map[string]storage.StatsSnapshot
+}
+
+func (m *memStorage) InsertFunction(function storage.Function) error { return nil }
+func (m *memStorage) GetAllFunctions() ([]storage.Function, error)   { return nil, nil }
+func (m *memStorage) DeleteFunction(name string) error               { return nil }
+func (m *memStorage) InsertContainer(container storage.Container) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.containers[container.Name] = container
+	return nil
+}
+func (m *memStorage) GetContainersForFunction(name string) ([]storage.Container, error) {
+	return nil, nil
+}
+func (m *memStorage) GetAllContainers() ([]storage.Container, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	containers := make([]storage.Container, 0)
+	for _, c := range m.containers {
+		containers = append(containers, c)
+	}
+	return containers, nil
+}
+func (m *memStorage) DeleteContainer(name string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	delete(m.containers, name)
+	return nil
+}
+func (m *memStorage) InsertPolicy(policy storage.Policy) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if m.policies == nil {
+		m.policies = make(map[string]storage.Policy)
+	}
+	m.policies[policy.Function] = policy
+	return nil
+}
+func (m *memStorage) GetAllPolicies() ([]storage.Policy, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	policies := make([]storage.Policy, 0)
+	for _, p := range m.policies {
+		policies = append(policies, p)
+	}
+	return policies, nil
+}
+func (m *memStorage) DeletePolicy(function string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	delete(m.policies, function)
+	return nil
+}
+func (m *memStorage) InsertStatsSnapshot(snapshot storage.StatsSnapshot) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if m.snapshots == nil {
+		m.snapshots = make(map[string]storage.StatsSnapshot)
+	}
+	m.snapshots[snapshot.Function] = snapshot
+	return nil
+}
+func (m *memStorage) GetAllStatsSnapshots() ([]storage.StatsSnapshot, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	snapshots := make([]storage.StatsSnapshot, 0)
+	for _, s := range m.snapshots {
+		snapshots = append(snapshots, s)
+	}
+	return snapshots, nil
+}
+func (m *memStorage) DeleteStatsSnapshot(function string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	delete(m.snapshots, function)
+	return nil
+}
+func (m *memStorage) Close() error { return nil }
+
+func Test_GetSandboxRuntime(t *testing.T) {
+	tests := []struct {
+		name    string
+		ctrType string
+		wantErr bool
+	}{
+		{name: "native is registered", ctrType: "native", wantErr: false},
+		{name: "wasm is registered", ctrType: "wasm", wantErr: false},
+		{name: "hybrid is not a sandbox runtime", ctrType: "hybrid", wantErr: true},
+		{name: "empty ctrType", ctrType: "", wantErr: true},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			rt, err := GetSandboxRuntime(tc.ctrType)
+			if tc.wantErr {
+				if err == nil {
+					t.Fatalf("want error for ctrType %q, got runtime %q", tc.ctrType, rt.Type())
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("want no error, got: %s", err)
+			}
+			if rt.Type() != tc.ctrType {
+				t.Fatalf("want runtime %q, got %q", tc.ctrType, rt.Type())
+			}
+		})
+	}
+}
+
+func Test_sandboxRuntimeForReplica(t *testing.T) {
+	tests := []struct {
+		name        string
+		replicaName string
+		want        string
+		wantErr     bool
+	}{
+		{name: "native replica", replicaName: "encrypt-n_1f0e3a2c-5d4b-4c1e-9b7a-6a5c4d3b2a19_n", want: "native"},
+		{name: "wasm replica", replicaName: "encrypt-w_1f0e3a2c-5d4b-4c1e-9b7a-6a5c4d3b2a19_w", want: "wasm"},
+		{name: "unknown suffix", replicaName: "encrypt_1f0e3a2c", wantErr: true},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			rt, err := sandboxRuntimeForReplica(tc.replicaName)
+			if tc.wantErr {
+				if err == nil {
+					t.Fatalf("want error, got runtime %q", rt.Type())
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("want no error, got: %s", err)
+			}
+			if rt.Type() != tc.want {
+				t.Fatalf("want %q, got %q", tc.want, rt.Type())
+			}
+		})
+	}
+}
+
+type fakeRuntime struct {
+	wasmRuntime
+}
+
+func (rt *fakeRuntime) Type() string {
+	return "fake"
+}
+
+func (rt *fakeRuntime) Suffix() string {
+	return "_f"
+}
+
+func Test_RegisterSandboxRuntime(t *testing.T) {
+	/* Removes the registration below when the test ends */
+	registerTestRuntime(t, "fake", nil)
+	RegisterSandboxRuntime(&fakeRuntime{})
+
+	if _, err := GetSandboxRuntime("fake"); err != nil {
+		t.Fatalf("want registered runtime, got: %s", err)
+	}
+	types := SandboxRuntimeTypes()
+	sort.Strings(types)
+	want := []string{"fake", "native", "wasm"}
+	if len(types) != len(want) {
+		t.Fatalf("want %v, got %v", want, types)
+	}
+	for i := range want {
+		if types[i] != want[i] {
+			t.Fatalf("want %v, got %v", want, types)
+		}
+	}
+}
@@ -0,0 +1,270 @@
+package handlers
+
+import (
+	"fmt"
+	"sort"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.gatech.edu/faasedge/fecore/pkg/timec"
+)
+
+/* Service level objectives. A Function may declare
+ *   sloLatency   - p99 service time objective in ms: at most 1% of its
+ *                  invocations (the error budget) may take longer
+ *   sloColdRatio - objective for the share of invocations served by a cold
+ *                  start, in percent
+ * as labels or via /policy?action=update. Invocations are grouped into epochs
+ * of InvocationSampleThreshold. After each epoch the objectives' burn rate
+ * (misses relative to what the objective allows; above 1 the objective is
+ * missed) steers the Function's resources:
+ *   - missed: one more idle Replica in the warm pool (on top of minIdle),
+ *     idle Replicas kept ContainerExpirationTime longer, and for Hybrid
+ *     Functions one more additional Replica per cold start (spawnAddlCtrs)
+ *   - met with at least half the budget to spare: the extra warm pool
+ *     Replica, then the extra keep-alive, are given back one step at a time
+ * For Hybrid Functions, policyEvaluator=slo also picks the sandbox that meets
+ * sloLatency at the lowest memory (see evaluator.go). Attainment and budget
+ * burn are shown in the metrics output. */
+
+const (
+	sloLatencyBudget     = 0.01 // share of invocations allowed over sloLatency (p99)
+	sloSpareBurn         = 0.5  // burn rate below which resources are given back
+	sloMaxKeepAliveSteps = 4    // extra keep-alive, in multiples of ContainerExpirationTime
+	defaultSLOEpoch      = 100  // invocations, if InvocationSampleThreshold is unset
+)
+
+type sloState struct {
+	mu sync.Mutex
+	/* Current epoch */
+	invocations int
+	slow        int // invocations over sloLatency
+	coldStarts  int
+	/* Since deploy */
+	epochs          int
+	totalInvoked    int
+	totalSlow       int
+	coldEpochs      int // epochs with a cold ratio objective
+	coldEpochsMet   int
+	lastLatencyBurn float64
+	lastColdBurn    float64
+	/* Resources added to meet the objectives */
+	minIdleBoost   int
+	keepAliveSteps int
+}
+
+type sloJSON struct {
+	Fname                  string  `json:"fname"`
+	SLOLatency             int     `json:"sloLatency"`   // ms, p99; 0 = none
+	SLOColdRatio           int     `json:"sloColdRatio"` // percent; 0 = none
+	Epochs                 int     `json:"epochs"`
+	Invocations            int     `json:"invocations"`
+	LatencyAttainment      float64 `json:"latencyAttainment"`      // share of invocations within sloLatency
+	LatencyBudgetRemaining float64 `json:"latencyBudgetRemaining"` // share of the error budget left since deploy
+	LatencyBurnRate        float64 `json:"latencyBurnRate"`        // last epoch
+	ColdRatioAttainment    float64 `json:"coldRatioAttainment"`    // share of epochs within sloColdRatio
+	ColdRatioBurnRate      float64 `json:"coldRatioBurnRate"`      // last epoch
+	MinIdleBoost           int     `json:"minIdleBoost"`
+	KeepAliveBoostMs       int64   `json:"keepAliveBoostMs"`
+}
+
+/* Reads the sloColdRatio label into a Function's policy; sloLatency is read
+ * by parseEvaluatorLabels */
+func parseSLOLabels(labels map[string]string, policy *Policy) error {
+	policy.sloColdRatio = 0
+	if v, ok := labels["sloColdRatio"]; ok {
+		val, err := strconv.Atoi(v)
+		if err != nil || val < 0 || val > 100 {
+			return fmt.Errorf("[slo/parseSLOLabels] sloColdRatio must be a percentage between 0 and 100, got '%s'", v)
+		}
+		policy.sloColdRatio = val
+	}
+	return nil
+}
+
+func (p Policy) hasSLO() bool {
+	return p.sloLatency > 0 || p.sloColdRatio > 0
+}
+
+func (fs *FunctionStore) sloEpoch() int {
+	if fs.cfg.InvocationSampleThreshold > 0 {
+		return fs.cfg.InvocationSampleThreshold
+	}
+	return defaultSLOEpoch
+}
+
+/* Counts an invocation towards its Function's objectives and acts on them at
+ * the end of an epoch. Called from addStat before statMu is taken. */
+func (fs *FunctionStore) recordSLO(stat FunctionStat) {
+	f, ok := fs.lookupFunction(stat.Fn)
+	if !ok {
+		return
+	}
+	f.policyMu.RLock()
+	policy := f.policy
+	f.policyMu.RUnlock()
+	if !policy.hasSLO() {
+		return
+	}
+
+	s := &f.slo
+	s.mu.Lock()
+	s.invocations += 1
+	if policy.sloLatency > 0 && stat.StartupTime+stat.ExecTime > int64(policy.sloLatency) {
+		s.slow += 1
+	}
+	if stat.StartupType == "cold" {
+		s.coldStarts += 1
+	}
+	if s.invocations < fs.sloEpoch() {
+		s.mu.Unlock()
+		return
+	}
+	latencyBurn, coldBurn := s.closeEpoch(policy)
+	s.mu.Unlock()
+
+	fs.applySLO(f, policy, latencyBurn, coldBurn)
+}
+
+/* Ends the current epoch and returns the burn rate of each objective (0 for
+ * objectives not set). Caller holds s.mu. */
+func (s *sloState) closeEpoch(policy Policy) (float64, float64) {
+	var latencyBurn, coldBurn float64
+	if policy.sloLatency > 0 {
+		latencyBurn = float64(s.slow) / float64(s.invocations) / sloLatencyBudget
+		s.totalInvoked += s.invocations
+		s.totalSlow += s.slow
+	}
+	if policy.sloColdRatio > 0 {
+		coldBurn = float64(s.coldStarts) / float64(s.invocations) / (float64(policy.sloColdRatio) / 100)
+		s.coldEpochs += 1
+		if coldBurn <= 1 {
+			s.coldEpochsMet += 1
+		}
+	}
+	s.epochs += 1
+	s.lastLatencyBurn = latencyBurn
+	s.lastColdBurn = coldBurn
+	s.invocations = 0
+	s.slow = 0
+	s.coldStarts = 0
+	return latencyBurn, coldBurn
+}
+
+/* Adds resources while an objective is missed and gives them back, one step
+ * per epoch, while all objectives are met with budget to spare */
+func (fs *FunctionStore) applySLO(f *Function, policy Policy, latencyBurn float64, coldBurn float64) {
+	missed := latencyBurn > 1 || coldBurn > 1
+	spare := latencyBurn < sloSpareBurn && coldBurn < sloSpareBurn
+	hybrid := f.labels["ctrType"] == "hybrid"
+
+	s := &f.slo
+	s.mu.Lock()
+	switch {
+	case missed:
+		maxIdle := fs.MAX_IDLE_CTRS
+		if policy.maxIdle > 0 && policy.maxIdle < maxIdle {
+			maxIdle = policy.maxIdle
+		}
+		if policy.minIdle+s.minIdleBoost < maxIdle {
+			s.minIdleBoost += 1
+		}
+		if s.keepAliveSteps < sloMaxKeepAliveSteps {
+			s.keepAliveSteps += 1
+		}
+	case spare && s.minIdleBoost > 0:
+		s.minIdleBoost -= 1
+	case spare && s.keepAliveSteps > 0:
+		s.keepAliveSteps -= 1
+	}
+	minIdleBoost, keepAliveSteps := s.minIdleBoost, s.keepAliveSteps
+	s.mu.Unlock()
+
+	if missed && hybrid {
+		f.policyMu.Lock()
+		if f.policy.spawnAddlCtrs+1 < fs.MAX_ADDL_CTRS {
+			f.policy.spawnAddlCtrs += 1
+		}
+		f.policyMu.Unlock()
+	}
+	timec.LogEvent("slo/applySLO", fmt.Sprintf("%s: latency burn %.2f, cold ratio burn %.2f (missed=%v) -> minIdleBoost=%d, keepAliveSteps=%d", f.name, latencyBurn, coldBurn, missed, minIdleBoost, keepAliveSteps), 3)
+}
+
+func (s *sloState) boosts() (int, int) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.minIdleBoost, s.keepAliveSteps
+}
+
+/* Extra keep-alive granted to idle Replicas to meet objectives, keyed by the
+ * Function whose idleReplicas they apply to. Hybrid Functions apply theirs to
+ * both sandboxes. */
+func (fs *FunctionStore) sloKeepAlive() map[string]time.Duration {
+	extensions := make(map[string]time.Duration)
+	step := time.Duration(fs.cfg.ContainerExpirationTime) * time.Second
+	fs.dfMu.RLock()
+	defer fs.dfMu.RUnlock()
+	for name, fn := range fs.deployedFunctions {
+		_, steps := fn.slo.boosts()
+		if steps == 0 {
+			continue
+		}
+		pools := []string{name}
+		if fn.labels["ctrType"] == "hybrid" {
+			pools = make([]string, 0, len(fn.sandboxes))
+			for _, sandbox := range fn.sandboxes {
+				pools = append(pools, sandbox)
+			}
+		}
+		for _, pool := range pools {
+			if ext := time.Duration(steps) * step; ext > extensions[pool] {
+				extensions[pool] = ext
+			}
+		}
+	}
+	return extensions
+}
+
+func (fs *FunctionStore) sloReport(fn *Function, policy Policy) sloJSON {
+	report := sloJSON{Fname: fn.name, SLOLatency: policy.sloLatency, SLOColdRatio: policy.sloColdRatio}
+	s := &fn.slo
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	report.Epochs = s.epochs
+	report.Invocations = s.totalInvoked
+	if s.totalInvoked > 0 {
+		report.LatencyAttainment = 1 - float64(s.totalSlow)/float64(s.totalInvoked)
+		report.LatencyBudgetRemaining = 1 - float64(s.totalSlow)/(float64(s.totalInvoked)*sloLatencyBudget)
+	}
+	if s.coldEpochs > 0 {
+		report.ColdRatioAttainment = float64(s.coldEpochsMet) / float64(s.coldEpochs)
+	}
+	report.LatencyBurnRate = s.lastLatencyBurn
+	report.ColdRatioBurnRate = s.lastColdBurn
+	report.MinIdleBoost = s.minIdleBoost
+	report.KeepAliveBoostMs = int64(s.keepAliveSteps) * int64(fs.cfg.ContainerExpirationTime) * 1000
+	return report
+}
+
+/* Returns the SLO report of a Function, or of every Function with objectives
+ * if fname is empty */
+func GetSLOReport(fs *FunctionStore, fname string) ([]sloJSON, error) {
+	if fname != "" {
+		fn, ok := fs.lookupFunction(fname)
+		if !ok {
+			return nil, fmt.Errorf("[slo/GetSLOReport] Function '%s' not found", fname)
+		}
+		return []sloJSON{fs.sloReport(fn, fs.GetInvocationPolicy(fname))}, nil
+	}
+	fns, _ := fs.GetDeployedFunctions()
+	sort.Slice(fns, func(i, j int) bool { return fns[i].name < fns[j].name })
+	reports := make([]sloJSON, 0)
+	for _, fn := range fns {
+		policy := fs.GetInvocationPolicy(fn.name)
+		if policy.hasSLO() {
+			reports = append(reports, fs.sloReport(fn, policy))
+		}
+	}
+	return reports, nil
+}
@@ -0,0 +1,126 @@
+package handlers
+
+import (
+	"math"
+	"testing"
+	"time"
+
+	"github.gatech.edu/faasedge/fecore/pkg/provider/config"
+)
+
+func Test_parseSLOLabels(t *testing.T) {
+	tests := []struct {
+		name    string
+		labels  map[string]string
+		want    int
+		wantErr bool
+	}{
+		{name: "no objective by default", labels: map[string]string{}},
+		{name: "cold ratio objective", labels: map[string]string{"sloColdRatio": "5"}, want: 5},
+		{name: "over 100 percent", labels: map[string]string{"sloColdRatio": "150"}, wantErr: true},
+		{name: "not a number", labels: map[string]string{"sloColdRatio": "5%"}, wantErr: true},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			policy := Policy{}
+			err := parseSLOLabels(tc.labels, &policy)
+			if tc.wantErr {
+				if err == nil {
+					t.Fatalf("want error, got sloColdRatio %d", policy.sloColdRatio)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("want no error, got: %s", err)
+			}
+			if policy.sloColdRatio != tc.want {
+				t.Fatalf("want sloColdRatio %d, got %d", tc.want, policy.sloColdRatio)
+			}
+		})
+	}
+}
+
+func Test_recordSLO(t *testing.T) {
+	/* One epoch of 10 invocations each */
+	type epoch struct {
+		slow int // invocations over sloLatency
+		cold int // invocations served by a cold start
+	}
+	tests := []struct {
+		name             string
+		epochs           []epoch
+		hybrid           bool
+		wantMinIdleBoost int
+		wantKeepAlive    time.Duration
+		wantSpawnAddl    int
+		wantAttainment   float64
+		wantBudgetLeft   float64
+		wantColdAttained float64
+	}{
+		{name: "objectives met", epochs: []epoch{{}}, wantAttainment: 1, wantBudgetLeft: 1, wantColdAttained: 1},
+		{name: "slow invocations add resources", epochs: []epoch{{slow: 1}}, wantMinIdleBoost: 1, wantKeepAlive: 60 * time.Second, wantAttainment: 0.9, wantBudgetLeft: -9, wantColdAttained: 1},
+		{name: "cold starts add resources", epochs: []epoch{{cold: 2}, {cold: 2}}, wantMinIdleBoost: 2, wantKeepAlive: 120 * time.Second, wantAttainment: 1, wantBudgetLeft: 1},
+		{name: "hybrid spawns additional replicas", epochs: []epoch{{cold: 2}}, hybrid: true, wantMinIdleBoost: 1, wantKeepAlive: 60 * time.Second, wantSpawnAddl: 1, wantAttainment: 1, wantBudgetLeft: 1},
+		{name: "resources are given back one step per epoch", epochs: []epoch{{cold: 2}, {}, {}}, wantAttainment: 1, wantBudgetLeft: 1, wantColdAttained: 2.0 / 3},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			fs := newTestStore(t, config.Config{InvocationSampleThreshold: 10, ContainerExpirationTime: 60})
+			fn := &Function{name: "fn", activeReplicas: make(map[string]*Replica), labels: map[string]string{"ctrType": "native"}}
+			if tc.hybrid {
+				fn.labels = map[string]string{"ctrType": "hybrid"}
+				fn.sandboxes = map[string]string{"native": "fn-n", "wasm": "fn-w"}
+			}
+			fn.policy.sloLatency = 100
+			fn.policy.sloColdRatio = 10
+			fs.AddDeployedFunction(fn)
+
+			for _, e := range tc.epochs {
+				for i := 0; i < 10; i++ {
+					stat := FunctionStat{Fn: "fn", CtrType: "native", ExecTime: 50, StartupType: "warm"}
+					if i < e.slow {
+						stat.ExecTime = 150
+					}
+					if i < e.cold {
+						stat.StartupType = "cold"
+					}
+					fs.recordSLO(stat)
+				}
+			}
+
+			minIdleBoost, _ := fn.slo.boosts()
+			pool := "fn"
+			if tc.hybrid {
+				pool = "fn-n"
+			}
+			if minIdleBoost != tc.wantMinIdleBoost || fs.sloKeepAlive()[pool] != tc.wantKeepAlive {
+				t.Fatalf("want minIdleBoost=%d keepAlive=%s, got %d %s", tc.wantMinIdleBoost, tc.wantKeepAlive, minIdleBoost, fs.sloKeepAlive()[pool])
+			}
+			if spawnAddl := fs.GetInvocationPolicy("fn").spawnAddlCtrs; spawnAddl != tc.wantSpawnAddl {
+				t.Fatalf("want spawnAddlCtrs=%d, got %d", tc.wantSpawnAddl, spawnAddl)
+			}
+			reports, err := GetSLOReport(fs, "")
+			if err != nil || len(reports) != 1 {
+				t.Fatalf("want one SLO report, got %v (%v)", reports, err)
+			}
+			report := reports[0]
+			if report.Epochs != len(tc.epochs) || math.Abs(report.LatencyAttainment-tc.wantAttainment) > 1e-9 || math.Abs(report.LatencyBudgetRemaining-tc.wantBudgetLeft) > 1e-9 || math.Abs(report.ColdRatioAttainment-tc.wantColdAttained) > 1e-9 {
+				t.Fatalf("want %d epochs, attainment %.2f, budget left %.2f, cold ratio attainment %.2f, got %+v", len(tc.epochs), tc.wantAttainment, tc.wantBudgetLeft, tc.wantColdAttained, report)
+			}
+		})
+	}
+}
+
+func Test_warmPoolTargetsSLO(t *testing.T) {
+	fs := newTestStore(t, config.Config{})
+	fn := &Function{name: "fn", activeReplicas: make(map[string]*Replica), labels: map[string]string{"ctrType": "native"}}
+	fn.policy.minIdle = 1
+	fn.slo.minIdleBoost = 2
+	fs.AddDeployedFunction(fn)
+
+	if got := fs.warmPoolTargets()["fn"].minIdle; got != 3 {
+		t.Fatalf("want minIdle 3 with the SLO boost, got %d", got)
+	}
+}
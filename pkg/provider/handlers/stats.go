@@ -15,20 +15,34 @@ type FunctionStats struct {
 	entryPos         int
 	coldPos          int
 	warmPos          int
+	thawedPos        int
 	coldStarts       int // number cold starts in current epoch
 	warmStarts       int // number warm starts in current epoch
+	thawedStarts     int // number of starts from a frozen idle replica (see freeze.go)
 	currRPS          int // average RPS over a 10 second window
 	lastRPS          int // previous average RPS recorded
 	currInvocations  int // number invocations in current epoch
 	invokeNext       string
 	activeCount      int // number of active replicas
 	idleCount        int // number of idle replicas
+	recycledReplicas int // replicas retired by maxInvocationsPerReplica/maxReplicaAge
+	resets           int // replicas reset after an invocation (see isolation.go)
+	resetFailures    int // resets that failed; the replica was deleted
+	hedgeWins        int // hedged cold starts won by this sandbox (see hedge.go)
+	hedgeLosses      int // hedged cold starts where another sandbox was ready first
+	hedgeFailures    int // hedged cold starts where this sandbox failed to start
+	warmups          int // pre-spawned replicas warmed up (see warmup.go)
+	warmupFailures   int // pre-spawned replicas deleted after a failed warmup
+	readyReplicas    int // new replicas that passed the readiness probe (see readiness.go)
+	readyFailures    int // new replicas deleted because they never became ready
 	totalInvocations int64
 	totalExecTime    int64
 	totalStartupTime int64
 	totalSvcTime     int
 	totalSvcCold     int
 	totalSvcWarm     int
+	totalSvcThawed   int
+	totalThawTime    int
 	avgExecTime      int64
 	avgStartupTime   int64
 	p99SvcTime       int
@@ -36,6 +50,15 @@ type FunctionStats struct {
 	avgSvcTime       int
 	avgSvcCold       int
 	avgSvcWarm       int
+	avgSvcThawed     int
+	avgThawTime      int    // startup time of thawed starts, mostly thaw latency
+	totalResetTime   int64  // us
+	avgResetTime     int64  // us
+	totalWarmupTime  int64  // ms
+	avgWarmupTime    int64  // ms
+	totalReadyTime   int64  // ms
+	avgReadyTime     int64  // ms
+	avgMemoryBytes   uint64 // last sampled memory of a running replica (see evaluator.go)
 	sandboxUtil      float32
 	coldRatio        float32
 	warmRatio        float32
@@ -62,14 +85,13 @@ func (fs *FunctionStore) UpdateFunctionStats(fn string, ctrType string, requestI
 	if ctrType == "hybrid" {
 		tokens := strings.Split(requestID, "_")
 		sandboxName := tokens[0]
-		sandboxType := tokens[2]
-		if sandboxType == "n" {
-			fs.statsChan <- FunctionStat{fn, "native", startupTime, execTime, startupType}
-			fs.statsChan <- FunctionStat{sandboxName, "native", startupTime, execTime, startupType}
-		} else if sandboxType == "w" {
-			fs.statsChan <- FunctionStat{fn, "wasm", startupTime, execTime, startupType}
-			fs.statsChan <- FunctionStat{sandboxName, "wasm", startupTime, execTime, startupType}
+		rt, err := sandboxRuntimeForReplica(requestID)
+		if err != nil {
+			timec.LogEvent("function_store/UpdateFunctionStats", err.Error(), 1)
+			return
 		}
+		fs.statsChan <- FunctionStat{fn, rt.Type(), startupTime, execTime, startupType}
+		fs.statsChan <- FunctionStat{sandboxName, rt.Type(), startupTime, execTime, startupType}
 	} else {
 		// Add stats to the deployed container type
 		fs.statsChan <- FunctionStat{fn, ctrType, startupTime, execTime, startupType}
@@ -77,91 +99,114 @@ func (fs *FunctionStore) UpdateFunctionStats(fn string, ctrType string, requestI
 }
 
 func (fs *FunctionStore) ProcessFunctionStats() {
-	/* Use circular buffer for stats
-	 * Based on idea from:
-	 * https://stackoverflow.com/questions/55598220/efficiently-keeping-a-collection-of-the-last-n-pushed-items */
-	MAX_ENTRIES := 100
 	for {
 		select {
 		case stat := <-fs.statsChan:
-			fn := stat.Fn
-			_, ok := fs.functionStats[fn]
-			if !ok {
-				timec.LogEvent("stats/ProcessFunctionStats", fmt.Sprintf("ERROR: Unable to add stat: could not find %s in functionStats map", fn), 1)
-				continue
-			}
-			fs.functionStats[fn].statMu.Lock()
-			entryPos := fs.functionStats[fn].entryPos
-			coldPos := fs.functionStats[fn].coldPos
-			warmPos := fs.functionStats[fn].warmPos
-			fs.functionStats[fn].currInvocations += 1
-			/* Every 100 stats, calculate P50 and P99 */
-			if entryPos == 99 {
-				sort.Ints(fs.functionStats[fn].serviceTimes[:])
-				fs.functionStats[fn].p50SvcTime = fs.functionStats[fn].serviceTimes[49]
-				fs.functionStats[fn].p99SvcTime = fs.functionStats[fn].serviceTimes[98]
-				/* Reset epoch stats */
-				fs.functionStats[fn].totalSvcTime = 0
-				timec.LogEvent("function_store/ProcessFunctionStats", fmt.Sprintf("Got 100 entries for %s; p50 = %d; p99 = %d", fn, fs.functionStats[fn].p50SvcTime, fs.functionStats[fn].p99SvcTime), 3)
-			}
-			if coldPos == 99 {
-				fs.functionStats[fn].totalSvcCold = 0
-			}
-			if warmPos == 99 {
-				fs.functionStats[fn].totalSvcWarm = 0
-			}
-			fs.functionStats[fn].totalSvcTime += int(stat.StartupTime) + int(stat.ExecTime)
-			fs.functionStats[fn].avgSvcTime = fs.functionStats[fn].totalSvcTime / (entryPos + 1)
-			fs.functionStats[fn].Entries[entryPos] = stat
-			// fs.functionStats[fn].entryPos = (entryPos + 1) % MAX_ENTRIES
-			fs.functionStats[fn].totalInvocations += 1
-			fs.functionStats[fn].execTimes[entryPos] = int(stat.ExecTime)
-			fs.functionStats[fn].startupTimes[entryPos] = int(stat.StartupTime)
-			fs.functionStats[fn].serviceTimes[entryPos] = int(stat.ExecTime) + int(stat.StartupTime)
-			fs.functionStats[fn].totalExecTime += stat.ExecTime
-			fs.functionStats[fn].totalStartupTime += stat.StartupTime
-			fs.functionStats[fn].avgExecTime = (fs.functionStats[fn].totalExecTime / fs.functionStats[fn].totalInvocations)
-			fs.functionStats[fn].avgStartupTime = (fs.functionStats[fn].totalStartupTime / fs.functionStats[fn].totalInvocations)
-			if stat.StartupType == "cold" {
-				fs.functionStats[fn].coldStarts += 1
-				fs.functionStats[fn].totalSvcCold = int(stat.ExecTime) + int(stat.StartupTime)
-				fs.functionStats[fn].avgSvcCold = (fs.functionStats[fn].totalSvcCold / (coldPos + 1))
-				fs.functionStats[fn].coldPos = (coldPos + 1) % MAX_ENTRIES
-			} else if stat.StartupType == "warm" {
-				fs.functionStats[fn].warmStarts += 1
-				fs.functionStats[fn].totalSvcWarm = int(stat.ExecTime) + int(stat.StartupTime)
-				fs.functionStats[fn].avgSvcWarm = (fs.functionStats[fn].totalSvcWarm / (warmPos + 1))
-				fs.functionStats[fn].warmPos = (warmPos + 1) % MAX_ENTRIES
-			}
-			if stat.CtrType == "hybrid" {
-				if fs.functionStats[fn].currInvocations == fs.cfg.InvocationSampleThreshold {
-					go func() {
-						fs.EvalSandboxUtilization(fn)
-					}()
-					coldRatio := float32(fs.functionStats[fn].coldStarts) / float32(fs.cfg.InvocationSampleThreshold)
-					warmRatio := float32(fs.functionStats[fn].warmStarts) / float32(fs.cfg.InvocationSampleThreshold)
-					fs.functionStats[fn].coldRatio = coldRatio
-					fs.functionStats[fn].warmRatio = warmRatio
-					timec.LogEvent("stats/ProcessFunctionStats", fmt.Sprintf("====> EVAL WARM/COLD RATIO for %s: warm=%f ; cold=%f", fn, warmRatio, coldRatio), 4)
-					fs.functionStats[fn].currInvocations = 0
-					fs.functionStats[fn].warmStarts = 0
-					fs.functionStats[fn].coldStarts = 0
-				}
-				if (coldPos+1)%10 == 0 {
-					go func() {
-						fs.EvalColdStartPolicy(fn)
-					}()
-				}
-				if (warmPos+1)%10 == 0 {
-					go func() {
-						fs.EvalWarmStartPolicy(fn)
-					}()
-				}
-			}
-			fs.functionStats[fn].entryPos = (entryPos + 1) % MAX_ENTRIES
-			fs.functionStats[fn].statMu.Unlock()
+			fs.addStat(stat)
+		}
+	}
+}
+
+/* Adds a stat to its Function's stats and runs the evaluators it is due to
+ * trigger. Use circular buffer for stats
+ * Based on idea from:
+ * https://stackoverflow.com/questions/55598220/efficiently-keeping-a-collection-of-the-last-n-pushed-items */
+func (fs *FunctionStore) addStat(stat FunctionStat) {
+	MAX_ENTRIES := 100
+	fn := stat.Fn
+	/* Looked up before statMu is taken (lock order) */
+	hybrid := fs.isHybrid(fn)
+	fs.observeBandit(stat)
+	fs.recordSLO(stat)
+	stats, ok := fs.lookupStats(fn)
+	if !ok {
+		timec.LogEvent("stats/addStat", fmt.Sprintf("ERROR: Unable to add stat: could not find %s in functionStats map", fn), 1)
+		return
+	}
+	stats.statMu.Lock()
+	entryPos := stats.entryPos
+	coldPos := stats.coldPos
+	warmPos := stats.warmPos
+	thawedPos := stats.thawedPos
+	stats.currInvocations += 1
+	/* Every 100 stats, calculate P50 and P99 */
+	if entryPos == 99 {
+		sort.Ints(stats.serviceTimes[:])
+		stats.p50SvcTime = stats.serviceTimes[49]
+		stats.p99SvcTime = stats.serviceTimes[98]
+		/* Reset epoch stats */
+		stats.totalSvcTime = 0
+		timec.LogEvent("stats/addStat", fmt.Sprintf("Got 100 entries for %s; p50 = %d; p99 = %d", fn, stats.p50SvcTime, stats.p99SvcTime), 3)
+	}
+	/* The cold, warm and thawed averages cover the entries since their
+	 * position last wrapped */
+	if coldPos == 0 {
+		stats.totalSvcCold = 0
+	}
+	if warmPos == 0 {
+		stats.totalSvcWarm = 0
+	}
+	if thawedPos == 0 {
+		stats.totalSvcThawed = 0
+		stats.totalThawTime = 0
+	}
+	stats.totalSvcTime += int(stat.StartupTime) + int(stat.ExecTime)
+	stats.avgSvcTime = stats.totalSvcTime / (entryPos + 1)
+	stats.Entries[entryPos] = stat
+	// stats.entryPos = (entryPos + 1) % MAX_ENTRIES
+	stats.totalInvocations += 1
+	stats.execTimes[entryPos] = int(stat.ExecTime)
+	stats.startupTimes[entryPos] = int(stat.StartupTime)
+	stats.serviceTimes[entryPos] = int(stat.ExecTime) + int(stat.StartupTime)
+	stats.totalExecTime += stat.ExecTime
+	stats.totalStartupTime += stat.StartupTime
+	stats.avgExecTime = (stats.totalExecTime / stats.totalInvocations)
+	stats.avgStartupTime = (stats.totalStartupTime / stats.totalInvocations)
+	if stat.StartupType == "cold" {
+		stats.coldStarts += 1
+		stats.totalSvcCold += int(stat.ExecTime) + int(stat.StartupTime)
+		stats.avgSvcCold = (stats.totalSvcCold / (coldPos + 1))
+		stats.coldPos = (coldPos + 1) % MAX_ENTRIES
+	} else if stat.StartupType == "warm" {
+		stats.warmStarts += 1
+		stats.totalSvcWarm += int(stat.ExecTime) + int(stat.StartupTime)
+		stats.avgSvcWarm = (stats.totalSvcWarm / (warmPos + 1))
+		stats.warmPos = (warmPos + 1) % MAX_ENTRIES
+	} else if stat.StartupType == "thawed" {
+		stats.thawedStarts += 1
+		stats.totalSvcThawed += int(stat.ExecTime) + int(stat.StartupTime)
+		stats.totalThawTime += int(stat.StartupTime)
+		stats.avgSvcThawed = (stats.totalSvcThawed / (thawedPos + 1))
+		stats.avgThawTime = (stats.totalThawTime / (thawedPos + 1))
+		stats.thawedPos = (thawedPos + 1) % MAX_ENTRIES
+	}
+	if hybrid {
+		if stats.currInvocations == fs.cfg.InvocationSampleThreshold {
+			go func() {
+				fs.EvalSandboxUtilization(fn)
+			}()
+			coldRatio := float32(stats.coldStarts) / float32(fs.cfg.InvocationSampleThreshold)
+			warmRatio := float32(stats.warmStarts) / float32(fs.cfg.InvocationSampleThreshold)
+			stats.coldRatio = coldRatio
+			stats.warmRatio = warmRatio
+			timec.LogEvent("stats/addStat", fmt.Sprintf("====> EVAL WARM/COLD RATIO for %s: warm=%f ; cold=%f", fn, warmRatio, coldRatio), 4)
+			stats.currInvocations = 0
+			stats.warmStarts = 0
+			stats.coldStarts = 0
+		}
+		if (coldPos+1)%10 == 0 {
+			go func() {
+				fs.EvalColdStartPolicy(fn)
+			}()
+		}
+		if (warmPos+1)%10 == 0 {
+			go func() {
+				fs.EvalWarmStartPolicy(fn)
+			}()
 		}
 	}
+	stats.entryPos = (entryPos + 1) % MAX_ENTRIES
+	stats.statMu.Unlock()
 }
 
 func (fs *FunctionStore) RecordColdStartTime(csTime time.Duration, requestID string) {
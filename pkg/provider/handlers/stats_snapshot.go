@@ -0,0 +1,397 @@
+package handlers
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"time"
+
+	"github.gatech.edu/faasedge/fecore/pkg/provider/storage"
+	"github.gatech.edu/faasedge/fecore/pkg/timec"
+)
+
+/* Stats snapshots. Every StatsSnapshotInterval seconds, and once more when the
+ * provider drains, the FunctionStats of each Function that was invoked since
+ * its last snapshot are written to the DB together with the policy its
+ * evaluator arrived at (sandbox types and spawnAddlCtrs of Hybrid Functions)
+ * and the bandit evaluator's arms. InitFunctionStore restores them, so the
+ * policy doesn't have to be learned again after a restart.
+ *
+ * A snapshot is discarded instead of restored if
+ *   - it was written in an older format (statsSnapshotVersion),
+ *   - the Function was redeployed with another image or labels since, or
+ *   - it is older than StatsSnapshotMaxAge seconds (0 = no limit)
+ * The evaluated policy is only restored if it is newer than the Function's
+ * stored policy document (see policy_doc.go), so a document applied via the
+ * API or PolicyDir is not overridden by what was learned before it. */
+
+/* Bump when the snapshot format changes */
+const statsSnapshotVersion = 1
+
+type statsSnapshotJSON struct {
+	Stats  functionStatsJSON            `json:"stats"`
+	Policy *evaluatedPolicyJSON         `json:"policy,omitempty"` // Hybrid Functions
+	Bandit map[string]banditArmSnapshot `json:"bandit,omitempty"`
+}
+
+type evaluatedPolicyJSON struct {
+	ColdStartCtrType string `json:"coldStartCtrType"`
+	WarmStartCtrType string `json:"warmStartCtrType"`
+	SpawnAddlCtrs    int    `json:"spawnAddlCtrs"`
+}
+
+type banditArmSnapshot struct {
+	Weight float64 `json:"weight"`
+	Mean   float64 `json:"mean"`
+	Sq     float64 `json:"sq"`
+}
+
+/* The FunctionStats learned from invocations. Replica counts are left out;
+ * Reconcile re-adopts the Replicas themselves. */
+type functionStatsJSON struct {
+	Entries          [100]FunctionStat `json:"entries"`
+	EntryPos         int               `json:"entryPos"`
+	ColdPos          int               `json:"coldPos"`
+	WarmPos          int               `json:"warmPos"`
+	ThawedPos        int               `json:"thawedPos"`
+	ColdStarts       int               `json:"coldStarts"`
+	WarmStarts       int               `json:"warmStarts"`
+	ThawedStarts     int               `json:"thawedStarts"`
+	CurrInvocations  int               `json:"currInvocations"`
+	InvokeNext       string            `json:"invokeNext"`
+	RecycledReplicas int               `json:"recycledReplicas"`
+	Resets           int               `json:"resets"`
+	ResetFailures    int               `json:"resetFailures"`
+	HedgeWins        int               `json:"hedgeWins"`
+	HedgeLosses      int               `json:"hedgeLosses"`
+	HedgeFailures    int               `json:"hedgeFailures"`
+	Warmups          int               `json:"warmups"`
+	WarmupFailures   int               `json:"warmupFailures"`
+	ReadyReplicas    int               `json:"readyReplicas"`
+	ReadyFailures    int               `json:"readyFailures"`
+	TotalInvocations int64             `json:"totalInvocations"`
+	TotalExecTime    int64             `json:"totalExecTime"`
+	TotalStartupTime int64             `json:"totalStartupTime"`
+	TotalSvcTime     int               `json:"totalSvcTime"`
+	TotalSvcCold     int               `json:"totalSvcCold"`
+	TotalSvcWarm     int               `json:"totalSvcWarm"`
+	TotalSvcThawed   int               `json:"totalSvcThawed"`
+	TotalThawTime    int               `json:"totalThawTime"`
+	AvgExecTime      int64             `json:"avgExecTime"`
+	AvgStartupTime   int64             `json:"avgStartupTime"`
+	P99SvcTime       int               `json:"p99SvcTime"`
+	P50SvcTime       int               `json:"p50SvcTime"`
+	AvgSvcTime       int               `json:"avgSvcTime"`
+	AvgSvcCold       int               `json:"avgSvcCold"`
+	AvgSvcWarm       int               `json:"avgSvcWarm"`
+	AvgSvcThawed     int               `json:"avgSvcThawed"`
+	AvgThawTime      int               `json:"avgThawTime"`
+	TotalResetTime   int64             `json:"totalResetTime"`
+	AvgResetTime     int64             `json:"avgResetTime"`
+	TotalWarmupTime  int64             `json:"totalWarmupTime"`
+	AvgWarmupTime    int64             `json:"avgWarmupTime"`
+	TotalReadyTime   int64             `json:"totalReadyTime"`
+	AvgReadyTime     int64             `json:"avgReadyTime"`
+	AvgMemoryBytes   uint64            `json:"avgMemoryBytes"`
+	SandboxUtil      float32           `json:"sandboxUtil"`
+	ColdRatio        float32           `json:"coldRatio"`
+	WarmRatio        float32           `json:"warmRatio"`
+	ExecTimes        [100]int          `json:"execTimes"`
+	StartupTimes     [100]int          `json:"startupTimes"`
+	ServiceTimes     [100]int          `json:"serviceTimes"`
+}
+
+/* Caller holds stats.statMu */
+func (stats *FunctionStats) snapshot() functionStatsJSON {
+	return functionStatsJSON{
+		Entries:          stats.Entries,
+		EntryPos:         stats.entryPos,
+		ColdPos:          stats.coldPos,
+		WarmPos:          stats.warmPos,
+		ThawedPos:        stats.thawedPos,
+		ColdStarts:       stats.coldStarts,
+		WarmStarts:       stats.warmStarts,
+		ThawedStarts:     stats.thawedStarts,
+		CurrInvocations:  stats.currInvocations,
+		InvokeNext:       stats.invokeNext,
+		RecycledReplicas: stats.recycledReplicas,
+		Resets:           stats.resets,
+		ResetFailures:    stats.resetFailures,
+		HedgeWins:        stats.hedgeWins,
+		HedgeLosses:      stats.hedgeLosses,
+		HedgeFailures:    stats.hedgeFailures,
+		Warmups:          stats.warmups,
+		WarmupFailures:   stats.warmupFailures,
+		ReadyReplicas:    stats.readyReplicas,
+		ReadyFailures:    stats.readyFailures,
+		TotalInvocations: stats.totalInvocations,
+		TotalExecTime:    stats.totalExecTime,
+		TotalStartupTime: stats.totalStartupTime,
+		TotalSvcTime:     stats.totalSvcTime,
+		TotalSvcCold:     stats.totalSvcCold,
+		TotalSvcWarm:     stats.totalSvcWarm,
+		TotalSvcThawed:   stats.totalSvcThawed,
+		TotalThawTime:    stats.totalThawTime,
+		AvgExecTime:      stats.avgExecTime,
+		AvgStartupTime:   stats.avgStartupTime,
+		P99SvcTime:       stats.p99SvcTime,
+		P50SvcTime:       stats.p50SvcTime,
+		AvgSvcTime:       stats.avgSvcTime,
+		AvgSvcCold:       stats.avgSvcCold,
+		AvgSvcWarm:       stats.avgSvcWarm,
+		AvgSvcThawed:     stats.avgSvcThawed,
+		AvgThawTime:      stats.avgThawTime,
+		TotalResetTime:   stats.totalResetTime,
+		AvgResetTime:     stats.avgResetTime,
+		TotalWarmupTime:  stats.totalWarmupTime,
+		AvgWarmupTime:    stats.avgWarmupTime,
+		TotalReadyTime:   stats.totalReadyTime,
+		AvgReadyTime:     stats.avgReadyTime,
+		AvgMemoryBytes:   stats.avgMemoryBytes,
+		SandboxUtil:      stats.sandboxUtil,
+		ColdRatio:        stats.coldRatio,
+		WarmRatio:        stats.warmRatio,
+		ExecTimes:        stats.execTimes,
+		StartupTimes:     stats.startupTimes,
+		ServiceTimes:     stats.serviceTimes,
+	}
+}
+
+/* Caller holds stats.statMu */
+func (stats *FunctionStats) restore(s functionStatsJSON) {
+	stats.Entries = s.Entries
+	stats.entryPos = s.EntryPos
+	stats.coldPos = s.ColdPos
+	stats.warmPos = s.WarmPos
+	stats.thawedPos = s.ThawedPos
+	stats.coldStarts = s.ColdStarts
+	stats.warmStarts = s.WarmStarts
+	stats.thawedStarts = s.ThawedStarts
+	stats.currInvocations = s.CurrInvocations
+	stats.invokeNext = s.InvokeNext
+	stats.recycledReplicas = s.RecycledReplicas
+	stats.resets = s.Resets
+	stats.resetFailures = s.ResetFailures
+	stats.hedgeWins = s.HedgeWins
+	stats.hedgeLosses = s.HedgeLosses
+	stats.hedgeFailures = s.HedgeFailures
+	stats.warmups = s.Warmups
+	stats.warmupFailures = s.WarmupFailures
+	stats.readyReplicas = s.ReadyReplicas
+	stats.readyFailures = s.ReadyFailures
+	stats.totalInvocations = s.TotalInvocations
+	stats.totalExecTime = s.TotalExecTime
+	stats.totalStartupTime = s.TotalStartupTime
+	stats.totalSvcTime = s.TotalSvcTime
+	stats.totalSvcCold = s.TotalSvcCold
+	stats.totalSvcWarm = s.TotalSvcWarm
+	stats.totalSvcThawed = s.TotalSvcThawed
+	stats.totalThawTime = s.TotalThawTime
+	stats.avgExecTime = s.AvgExecTime
+	stats.avgStartupTime = s.AvgStartupTime
+	stats.p99SvcTime = s.P99SvcTime
+	stats.p50SvcTime = s.P50SvcTime
+	stats.avgSvcTime = s.AvgSvcTime
+	stats.avgSvcCold = s.AvgSvcCold
+	stats.avgSvcWarm = s.AvgSvcWarm
+	stats.avgSvcThawed = s.AvgSvcThawed
+	stats.avgThawTime = s.AvgThawTime
+	stats.totalResetTime = s.TotalResetTime
+	stats.avgResetTime = s.AvgResetTime
+	stats.totalWarmupTime = s.TotalWarmupTime
+	stats.avgWarmupTime = s.AvgWarmupTime
+	stats.totalReadyTime = s.TotalReadyTime
+	stats.avgReadyTime = s.AvgReadyTime
+	stats.avgMemoryBytes = s.AvgMemoryBytes
+	stats.sandboxUtil = s.SandboxUtil
+	stats.coldRatio = s.ColdRatio
+	stats.warmRatio = s.WarmRatio
+	stats.execTimes = s.ExecTimes
+	stats.startupTimes = s.StartupTimes
+	stats.serviceTimes = s.ServiceTimes
+}
+
+func (s functionStatsJSON) valid() bool {
+	for _, pos := range []int{s.EntryPos, s.ColdPos, s.WarmPos, s.ThawedPos} {
+		if pos < 0 || pos >= len(s.Entries) {
+			return false
+		}
+	}
+	return s.TotalInvocations > 0
+}
+
+func (b *banditState) snapshot() map[string]banditArmSnapshot {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if len(b.arms) == 0 {
+		return nil
+	}
+	arms := make(map[string]banditArmSnapshot, len(b.arms))
+	for key, arm := range b.arms {
+		arms[key] = banditArmSnapshot{Weight: arm.weight, Mean: arm.mean, Sq: arm.sq}
+	}
+	return arms
+}
+
+func (b *banditState) restore(arms map[string]banditArmSnapshot) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.arms = make(map[string]*banditArm, len(arms))
+	for key, arm := range arms {
+		b.arms[key] = &banditArm{weight: arm.Weight, mean: arm.Mean, sq: arm.Sq}
+	}
+}
+
+/* Identifies the deployment a snapshot was taken of */
+func snapshotFingerprint(fn *Function) string {
+	sFn := getStorageFunction(fn)
+	sum := sha256.Sum256([]byte(sFn.Image + "\n" + sFn.Labels))
+	return hex.EncodeToString(sum[:8])
+}
+
+/* Called every StatsSnapshotInterval seconds */
+func (fs *FunctionStore) SnapshotDaemon() {
+	/* Drain takes the last snapshot once the stats are flushed */
+	if fs.Draining() {
+		return
+	}
+	fs.SnapshotStats()
+}
+
+/* Writes a snapshot of each Function invoked since its last one. Returns the
+ * number of snapshots written. */
+func (fs *FunctionStore) SnapshotStats() int {
+	fs.snapshotMu.Lock()
+	defer fs.snapshotMu.Unlock()
+	if fs.lastSnapshot == nil {
+		fs.lastSnapshot = make(map[string]int64)
+	}
+
+	written := 0
+	fns, _ := fs.GetDeployedFunctions()
+	for _, fn := range fns {
+		stats, ok := fs.lookupStats(fn.name)
+		if !ok {
+			continue
+		}
+		stats.statMu.RLock()
+		snapshot := statsSnapshotJSON{Stats: stats.snapshot()}
+		stats.statMu.RUnlock()
+		invocations := snapshot.Stats.TotalInvocations
+		if invocations == 0 || invocations == fs.lastSnapshot[fn.name] {
+			continue
+		}
+
+		if fn.labels["ctrType"] == "hybrid" {
+			fn.policyMu.RLock()
+			snapshot.Policy = &evaluatedPolicyJSON{ColdStartCtrType: fn.policy.coldStartCtrType, WarmStartCtrType: fn.policy.warmStartCtrType, SpawnAddlCtrs: fn.policy.spawnAddlCtrs}
+			fn.policyMu.RUnlock()
+			snapshot.Bandit = fn.bandit.snapshot()
+		}
+
+		data, err := json.Marshal(snapshot)
+		if err == nil {
+			err = fs.storageManager.InsertStatsSnapshot(storage.StatsSnapshot{Function: fn.name, Version: statsSnapshotVersion, Fingerprint: snapshotFingerprint(fn), Taken: time.Now().Unix(), Data: string(data)})
+		}
+		if err != nil {
+			timec.LogEvent("stats_snapshot/SnapshotStats", fmt.Sprintf("Unable to store stats snapshot of %s: %s", fn.name, err), 1)
+			continue
+		}
+		fs.lastSnapshot[fn.name] = invocations
+		written += 1
+	}
+	if written > 0 {
+		timec.LogEvent("stats_snapshot/SnapshotStats", fmt.Sprintf("Stored %d stats snapshots", written), 3)
+	}
+	return written
+}
+
+/* Returns why a stored snapshot can't be restored, or "" if it can */
+func (fs *FunctionStore) staleSnapshot(s storage.StatsSnapshot, fn *Function, now time.Time) string {
+	if s.Version != statsSnapshotVersion {
+		return fmt.Sprintf("format version %d, want %d", s.Version, statsSnapshotVersion)
+	}
+	if s.Fingerprint != snapshotFingerprint(fn) {
+		return "the Function was redeployed since"
+	}
+	if maxAge := time.Duration(fs.cfg.StatsSnapshotMaxAge) * time.Second; maxAge > 0 && now.Sub(time.Unix(s.Taken, 0)) > maxAge {
+		return fmt.Sprintf("taken %s ago (StatsSnapshotMaxAge=%ds)", now.Sub(time.Unix(s.Taken, 0)).Round(time.Second), fs.cfg.StatsSnapshotMaxAge)
+	}
+	return ""
+}
+
+/* Restores the stored snapshots of the restored Functions and deletes the
+ * stale ones. Called from InitFunctionStore after the policies are restored. */
+func (fs *FunctionStore) restoreStatsSnapshots() {
+	snapshots, err := fs.storageManager.GetAllStatsSnapshots()
+	if err != nil {
+		timec.LogEvent("stats_snapshot/restoreStatsSnapshots", fmt.Sprintf("Unable to read stats snapshots: %s", err), 1)
+		return
+	}
+	policyUpdated := make(map[string]int64)
+	if policies, err := fs.storageManager.GetAllPolicies(); err == nil {
+		for _, p := range policies {
+			policyUpdated[p.Function] = p.Updated
+		}
+	}
+
+	fs.snapshotMu.Lock()
+	defer fs.snapshotMu.Unlock()
+	if fs.lastSnapshot == nil {
+		fs.lastSnapshot = make(map[string]int64)
+	}
+	now := time.Now()
+	for _, s := range snapshots {
+		fn, ok := fs.lookupFunction(s.Function)
+		if !ok {
+			fs.storageManager.DeleteStatsSnapshot(s.Function)
+			continue
+		}
+		reason := fs.staleSnapshot(s, fn, now)
+		var snapshot statsSnapshotJSON
+		if reason == "" {
+			if err := json.Unmarshal([]byte(s.Data), &snapshot); err != nil || !snapshot.Stats.valid() {
+				reason = "unreadable"
+			}
+		}
+		if reason != "" {
+			timec.LogEvent("stats_snapshot/restoreStatsSnapshots", fmt.Sprintf("Discarding stats snapshot of %s: %s", s.Function, reason), 2)
+			fs.storageManager.DeleteStatsSnapshot(s.Function)
+			continue
+		}
+
+		stats, ok := fs.lookupStats(s.Function)
+		if !ok {
+			continue
+		}
+		stats.statMu.Lock()
+		stats.restore(snapshot.Stats)
+		stats.statMu.Unlock()
+		fs.lastSnapshot[s.Function] = snapshot.Stats.TotalInvocations
+
+		if p := snapshot.Policy; p != nil && fn.labels["ctrType"] == "hybrid" && s.Taken >= policyUpdated[s.Function] {
+			fn.policyMu.Lock()
+			if (p.ColdStartCtrType == "native" || p.ColdStartCtrType == "wasm") && (p.WarmStartCtrType == "native" || p.WarmStartCtrType == "wasm") {
+				fn.policy.coldStartCtrType = p.ColdStartCtrType
+				fn.policy.warmStartCtrType = p.WarmStartCtrType
+			}
+			if p.SpawnAddlCtrs >= 0 && p.SpawnAddlCtrs < fs.MAX_ADDL_CTRS {
+				fn.policy.spawnAddlCtrs = p.SpawnAddlCtrs
+			}
+			fn.policyMu.Unlock()
+		}
+		if len(snapshot.Bandit) > 0 {
+			fn.bandit.restore(snapshot.Bandit)
+		}
+		timec.LogEvent("stats_snapshot/restoreStatsSnapshots", fmt.Sprintf("Restored stats of %s from %d invocations (taken %s ago)", s.Function, snapshot.Stats.TotalInvocations, now.Sub(time.Unix(s.Taken, 0)).Round(time.Second)), 2)
+	}
+}
+
+/* Deletes the snapshot of a removed Function */
+func (fs *FunctionStore) forgetStatsSnapshot(fname string) {
+	fs.snapshotMu.Lock()
+	delete(fs.lastSnapshot, fname)
+	fs.snapshotMu.Unlock()
+	fs.storageManager.DeleteStatsSnapshot(fname)
+}
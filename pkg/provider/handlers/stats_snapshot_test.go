@@ -0,0 +1,123 @@
+package handlers
+
+import (
+	"reflect"
+	"testing"
+	"time"
+
+	"github.gatech.edu/faasedge/fecore/pkg/provider/config"
+	"github.gatech.edu/faasedge/fecore/pkg/provider/storage"
+)
+
+/* Store with a Hybrid Function; ms is the DB of a store it replaces after a
+ * restart, or nil for a new one */
+func newSnapshotStore(t *testing.T, ms *memStorage, labels map[string]string) (*FunctionStore, *Function) {
+	fs := newTestStore(t, config.Config{StatsSnapshotMaxAge: 3600})
+	if ms != nil {
+		fs.storageManager = ms
+	}
+	fn := &Function{name: "hy", image: "hy-image", activeReplicas: make(map[string]*Replica), labels: labels, sandboxes: make(map[string]string)}
+	fs.configureFunction(fn, fn.labels)
+	fs.AddDeployedFunction(fn)
+	return fs, fn
+}
+
+func Test_statsSnapshotRoundTrip(t *testing.T) {
+	labels := map[string]string{"ctrType": "hybrid", "sandboxes": "hy-n,hy-w"}
+	fs, fn := newSnapshotStore(t, nil, labels)
+	ms := testDB(fs)
+
+	stats, _ := fs.lookupStats("hy")
+	for i := 0; i < 42; i++ {
+		stats.Entries[i] = FunctionStat{Fn: "hy", CtrType: "wasm", StartupTime: 10, ExecTime: int64(i), StartupType: "warm"}
+		stats.serviceTimes[i] = 10 + i
+	}
+	stats.entryPos = 42
+	stats.warmPos = 40
+	stats.totalInvocations = 142
+	stats.p99SvcTime = 98
+	stats.avgSvcCold = 300
+	stats.avgSvcWarm = 30
+	stats.coldRatio = 0.2
+	fn.policy.coldStartCtrType = "native"
+	fn.policy.spawnAddlCtrs = 3
+	fn.bandit.observe("cold", "wasm", 120, Policy{banditHalfLife: 10}.banditDecay())
+
+	if n := fs.SnapshotStats(); n != 1 {
+		t.Fatalf("want 1 snapshot, got %d", n)
+	}
+	if n := fs.SnapshotStats(); n != 0 {
+		t.Fatalf("want no snapshot without new invocations, got %d", n)
+	}
+
+	/* After a restart */
+	restored, restoredFn := newSnapshotStore(t, ms, labels)
+	restored.restoreStatsSnapshots()
+	restoredStats, _ := restored.lookupStats("hy")
+	if !reflect.DeepEqual(restoredStats.snapshot(), stats.snapshot()) {
+		t.Fatalf("want the stats restored, got %+v", restoredStats.snapshot())
+	}
+	policy := restored.GetInvocationPolicy("hy")
+	if policy.coldStartCtrType != "native" || policy.warmStartCtrType != "native" || policy.spawnAddlCtrs != 3 {
+		t.Fatalf("want the evaluated policy restored, got %+v", policy)
+	}
+	if arm, ok := restoredFn.bandit.arm("cold", "wasm"); !ok || arm.mean != 120 || arm.weight != 1 {
+		t.Fatalf("want the bandit arm restored, got %+v", arm)
+	}
+	if n := restored.SnapshotStats(); n != 0 {
+		t.Fatalf("want no snapshot of restored stats, got %d", n)
+	}
+}
+
+func Test_staleStatsSnapshots(t *testing.T) {
+	labels := map[string]string{"ctrType": "hybrid", "sandboxes": "hy-n,hy-w"}
+	tests := []struct {
+		name          string
+		snapshot      func(s *storage.StatsSnapshot)
+		policyUpdated int64 // unix seconds; 0 = no stored policy document
+		labels        map[string]string
+		wantStats     bool
+		wantPolicy    bool
+	}{
+		{name: "current snapshot", snapshot: func(s *storage.StatsSnapshot) {}, wantStats: true, wantPolicy: true},
+		{name: "older format", snapshot: func(s *storage.StatsSnapshot) { s.Version = statsSnapshotVersion - 1 }},
+		{name: "redeployed with other labels", snapshot: func(s *storage.StatsSnapshot) {}, labels: map[string]string{"ctrType": "hybrid", "sandboxes": "hy-n,hy-w", "minIdle": "1"}},
+		{name: "too old", snapshot: func(s *storage.StatsSnapshot) { s.Taken -= 7200 }},
+		{name: "unreadable", snapshot: func(s *storage.StatsSnapshot) { s.Data = `{"stats": {"entryPos": 100}}` }},
+		{name: "newer policy document", snapshot: func(s *storage.StatsSnapshot) {}, policyUpdated: time.Now().Unix() + 10, wantStats: true},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			fs, fn := newSnapshotStore(t, nil, labels)
+			ms := testDB(fs)
+			stats, _ := fs.lookupStats("hy")
+			stats.totalInvocations = 10
+			fn.policy.coldStartCtrType = "native"
+			fs.SnapshotStats()
+			s := ms.snapshots["hy"]
+			tc.snapshot(&s)
+			ms.InsertStatsSnapshot(s)
+			if tc.policyUpdated > 0 {
+				ms.InsertPolicy(storage.Policy{Function: "hy", Document: "{}", Updated: tc.policyUpdated})
+			}
+
+			restoredLabels := labels
+			if tc.labels != nil {
+				restoredLabels = tc.labels
+			}
+			restored, _ := newSnapshotStore(t, ms, restoredLabels)
+			restored.restoreStatsSnapshots()
+			restoredStats, _ := restored.lookupStats("hy")
+			if got := restoredStats.totalInvocations == 10; got != tc.wantStats {
+				t.Fatalf("want stats restored=%v, got totalInvocations=%d", tc.wantStats, restoredStats.totalInvocations)
+			}
+			if got := restored.GetInvocationPolicy("hy").coldStartCtrType == "native"; got != tc.wantPolicy {
+				t.Fatalf("want evaluated policy restored=%v, got %+v", tc.wantPolicy, restored.GetInvocationPolicy("hy"))
+			}
+			if _, kept := ms.snapshots["hy"]; kept != tc.wantStats {
+				t.Fatalf("want the snapshot kept=%v", tc.wantStats)
+			}
+		})
+	}
+}
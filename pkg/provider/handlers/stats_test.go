@@ -0,0 +1,48 @@
+package handlers
+
+import (
+	"testing"
+
+	"github.gatech.edu/faasedge/fecore/pkg/provider/config"
+)
+
+func Test_addStatWindowAverages(t *testing.T) {
+	tests := []struct {
+		name        string
+		startupType string
+		svcTimes    []int // one stat per entry; half startup, half exec
+		wantAvg     int
+		wantThaw    int
+	}{
+		{name: "cold within one window", startupType: "cold", svcTimes: repeatTimes(20, 50), wantAvg: 20},
+		{name: "cold over a full window", startupType: "cold", svcTimes: repeatTimes(20, 100), wantAvg: 20},
+		{name: "cold after the window wraps", startupType: "cold", svcTimes: append(repeatTimes(20, 100), repeatTimes(40, 30)...), wantAvg: 40},
+		{name: "warm over two windows", startupType: "warm", svcTimes: repeatTimes(10, 250), wantAvg: 10},
+		{name: "thawed after the window wraps", startupType: "thawed", svcTimes: append(repeatTimes(10, 100), repeatTimes(30, 1)...), wantAvg: 30, wantThaw: 15},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			fs := newTestStore(t, config.Config{}, &Function{name: "fn"})
+			for _, svc := range tc.svcTimes {
+				fs.addStat(FunctionStat{Fn: "fn", CtrType: "native", StartupTime: int64(svc / 2), ExecTime: int64(svc - svc/2), StartupType: tc.startupType})
+			}
+			stats, _ := fs.lookupStats("fn")
+			got := map[string]int{"cold": stats.avgSvcCold, "warm": stats.avgSvcWarm, "thawed": stats.avgSvcThawed}[tc.startupType]
+			if got != tc.wantAvg {
+				t.Fatalf("want average %d ms, got %d ms", tc.wantAvg, got)
+			}
+			if tc.wantThaw != 0 && stats.avgThawTime != tc.wantThaw {
+				t.Fatalf("want average thaw time %d ms, got %d ms", tc.wantThaw, stats.avgThawTime)
+			}
+		})
+	}
+}
+
+func repeatTimes(ms int, n int) []int {
+	times := make([]int, n)
+	for i := range times {
+		times[i] = ms
+	}
+	return times
+}
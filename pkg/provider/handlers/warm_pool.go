@@ -0,0 +1,186 @@
+package handlers
+
+import (
+	"fmt"
+	"strconv"
+	"time"
+
+	"github.gatech.edu/faasedge/fecore/pkg/timec"
+)
+
+/* The warm pool keeps between minIdle and maxIdle idle Replicas for each
+ * Function so latency-sensitive Functions don't take a cold start after an
+ * idle period. minIdle/maxIdle are given as labels at deploy time and can be
+ * changed via the /policy API. For Hybrid Functions the pool is kept in the
+ * sandbox that serves warm starts (policy.warmStartCtrType). Functions
+ * missing their SLOs get extra idle Replicas on top of minIdle. */
+
+type warmPoolTarget struct {
+	pool    string // Function whose idleReplicas holds the pool
+	ctrType string
+	minIdle int
+	maxIdle int // 0 means no upper bound
+}
+
+/* Reads the minIdle/maxIdle labels into a Function's policy */
+func (fs *FunctionStore) parseWarmPoolLabels(labels map[string]string, policy *Policy) error {
+	minIdle := 0
+	maxIdle := 0
+	var err error
+	if v, ok := labels["minIdle"]; ok {
+		minIdle, err = strconv.Atoi(v)
+		if err != nil || minIdle < 0 || minIdle > fs.MAX_IDLE_CTRS {
+			return fmt.Errorf("[warm_pool/parseWarmPoolLabels] minIdle must be between 0 and %d, got '%s'", fs.MAX_IDLE_CTRS, v)
+		}
+	}
+	if v, ok := labels["maxIdle"]; ok {
+		maxIdle, err = strconv.Atoi(v)
+		if err != nil || maxIdle < 0 {
+			return fmt.Errorf("[warm_pool/parseWarmPoolLabels] maxIdle must be a non-negative integer, got '%s'", v)
+		}
+	}
+	if maxIdle > 0 && minIdle > maxIdle {
+		return fmt.Errorf("[warm_pool/parseWarmPoolLabels] minIdle (%d) cannot exceed maxIdle (%d)", minIdle, maxIdle)
+	}
+	policy.minIdle = minIdle
+	policy.maxIdle = maxIdle
+	return nil
+}
+
+/* Collects the warm pool settings of all deployed Functions, keyed by the
+ * Function that holds the pool */
+func (fs *FunctionStore) warmPoolTargets() map[string]warmPoolTarget {
+	targets := make(map[string]warmPoolTarget)
+	now := time.Now()
+	fs.dfMu.RLock()
+	defer fs.dfMu.RUnlock()
+	for name, fn := range fs.deployedFunctions {
+		fn.policyMu.RLock()
+		policy := fn.policy
+		fn.policyMu.RUnlock()
+
+		/* With adaptive keep-alive, keep one Replica ready while the next
+		 * request is expected (see keepalive.go) */
+		/* Missed objectives add idle Replicas (see slo.go) */
+		minIdleBoost, _ := fn.slo.boosts()
+		minIdle := policy.minIdle + minIdleBoost
+		if policy.keepalivePolicy == "adaptive" && minIdle == 0 {
+			if w, ok := fn.arrivals.window(fs.minPrewarmWindow()); ok && w.prewarming(now) {
+				minIdle = 1
+			}
+		}
+		if minIdle == 0 && policy.maxIdle == 0 {
+			continue
+		}
+
+		target := warmPoolTarget{pool: name, ctrType: fn.labels["ctrType"], minIdle: minIdle, maxIdle: policy.maxIdle}
+		if target.ctrType == "hybrid" {
+			target.ctrType = policy.warmStartCtrType
+			target.pool = fn.sandboxes[policy.warmStartCtrType]
+			if _, ok := fs.deployedFunctions[target.pool]; !ok {
+				continue
+			}
+		} else if _, err := GetSandboxRuntime(target.ctrType); err != nil {
+			target.ctrType = "native"
+		}
+
+		/* A sandbox may back several Hybrid Functions; keep the largest pool */
+		if prev, ok := targets[target.pool]; ok {
+			if prev.minIdle > target.minIdle {
+				target.minIdle = prev.minIdle
+			}
+			if prev.maxIdle == 0 || (target.maxIdle != 0 && prev.maxIdle > target.maxIdle) {
+				target.maxIdle = prev.maxIdle
+			}
+		}
+		targets[target.pool] = target
+	}
+	return targets
+}
+
+/* Number of idle Replicas CleanupDaemon must leave in place for each pool */
+func (fs *FunctionStore) warmPoolFloors() map[string]int {
+	floors := make(map[string]int)
+	for pool, target := range fs.warmPoolTargets() {
+		floors[pool] = target.minIdle
+	}
+	return floors
+}
+
+/* Brings every Function's idle pool within its [minIdle, maxIdle] bounds.
+ * Runs next to CleanupDaemon and right after a Function is deployed. */
+func (fs *FunctionStore) WarmPoolDaemon() {
+	if !fs.warmPoolMu.TryLock() {
+		return
+	}
+	defer fs.warmPoolMu.Unlock()
+	if fs.Draining() {
+		return
+	}
+
+	for _, target := range fs.warmPoolTargets() {
+		fs.reconcileWarmPool(target)
+	}
+}
+
+func (fs *FunctionStore) reconcileWarmPool(target warmPoolTarget) {
+	fn, ok := fs.lookupFunction(target.pool)
+	if !ok {
+		return
+	}
+
+	fn.poolMu.RLock()
+	idle := fn.idleReplicas.size()
+	fn.poolMu.RUnlock()
+
+	fs.warmPoolPendingMu.Lock()
+	pending := fs.warmPoolPending[target.pool]
+	deficit := target.minIdle - idle - pending
+	if deficit > 0 {
+		fs.warmPoolPending[target.pool] += deficit
+	}
+	fs.warmPoolPendingMu.Unlock()
+
+	/* Spawn missing Replicas in the background so one slow pool doesn't hold
+	 * up the others */
+	if deficit > 0 {
+		timec.LogEvent("warm_pool/reconcileWarmPool", fmt.Sprintf("Spawning %d %s replicas for '%s' (idle=%d, minIdle=%d)", deficit, target.ctrType, target.pool, idle, target.minIdle), 2)
+		go func() {
+			for i := 0; i < deficit; i++ {
+				if fs.Draining() {
+					fs.warmPoolPendingMu.Lock()
+					fs.warmPoolPending[target.pool] -= deficit - i
+					fs.warmPoolPendingMu.Unlock()
+					return
+				}
+				replicaName, _, err := createReplica(fs, target.pool, target.ctrType, false, "WarmPool")
+				fs.warmPoolPendingMu.Lock()
+				fs.warmPoolPending[target.pool] -= 1
+				fs.warmPoolPendingMu.Unlock()
+				if err != nil {
+					timec.LogEvent("warm_pool/reconcileWarmPool", fmt.Sprintf("Unable to spawn replica for '%s': %s", target.pool, err), 1)
+					continue
+				}
+				timec.LogEvent("warm_pool/reconcileWarmPool", fmt.Sprintf("Added '%s' to warm pool of '%s'", replicaName, target.pool), 3)
+			}
+		}()
+		return
+	}
+
+	if target.maxIdle == 0 || idle <= target.maxIdle {
+		return
+	}
+
+	/* Evict the oldest idle Replicas above maxIdle */
+	evicted := make([]*Replica, 0, idle-target.maxIdle)
+	fn.poolMu.Lock()
+	for fn.idleReplicas.size() > target.maxIdle {
+		evicted = append(evicted, fn.idleReplicas.popLRU())
+	}
+	fn.poolMu.Unlock()
+
+	for _, replica := range evicted {
+		fs.DeleteReplica(replica)
+	}
+	timec.LogEvent("warm_pool/reconcileWarmPool", fmt.Sprintf("Evicted %d idle replicas from '%s' (maxIdle=%d)", len(evicted), target.pool, target.maxIdle), 2)
+}
@@ -0,0 +1,84 @@
+package handlers
+
+import (
+	"testing"
+
+	"github.gatech.edu/faasedge/fecore/pkg/provider/config"
+)
+
+func Test_parseWarmPoolLabels(t *testing.T) {
+	fs := newTestStore(t, config.Config{})
+	tests := []struct {
+		name        string
+		labels      map[string]string
+		wantMinIdle int
+		wantMaxIdle int
+		wantErr     bool
+	}{
+		{name: "no labels", labels: map[string]string{}, wantMinIdle: 0, wantMaxIdle: 0},
+		{name: "minIdle only", labels: map[string]string{"minIdle": "2"}, wantMinIdle: 2, wantMaxIdle: 0},
+		{name: "minIdle and maxIdle", labels: map[string]string{"minIdle": "1", "maxIdle": "4"}, wantMinIdle: 1, wantMaxIdle: 4},
+		{name: "minIdle above maxIdle", labels: map[string]string{"minIdle": "5", "maxIdle": "2"}, wantErr: true},
+		{name: "minIdle above limit", labels: map[string]string{"minIdle": "21"}, wantErr: true},
+		{name: "negative maxIdle", labels: map[string]string{"maxIdle": "-1"}, wantErr: true},
+		{name: "not a number", labels: map[string]string{"minIdle": "two"}, wantErr: true},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			policy := Policy{}
+			err := fs.parseWarmPoolLabels(tc.labels, &policy)
+			if tc.wantErr {
+				if err == nil {
+					t.Fatalf("want error, got policy %+v", policy)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("want no error, got: %s", err)
+			}
+			if policy.minIdle != tc.wantMinIdle || policy.maxIdle != tc.wantMaxIdle {
+				t.Fatalf("want minIdle=%d maxIdle=%d, got minIdle=%d maxIdle=%d", tc.wantMinIdle, tc.wantMaxIdle, policy.minIdle, policy.maxIdle)
+			}
+		})
+	}
+}
+
+func Test_IdleReplicasPool(t *testing.T) {
+	fs := newTestStore(t, config.Config{}, &Function{name: "fn"})
+	names := []string{"a", "b", "c", "d"}
+	for _, name := range names {
+		fs.AddIdleReplica(&Replica{fname: "fn", uuid: name})
+	}
+	pool := &fs.deployedFunctions["fn"].idleReplicas
+	if pool.size() != len(names) || int(pool.count) != len(names) {
+		t.Fatalf("want %d idle replicas, got size=%d count=%d", len(names), pool.size(), pool.count)
+	}
+
+	/* GetIdleReplica hands out the newest replica first... */
+	fs.deployedFunctions["fn"].activeReplicas = make(map[string]*Replica)
+	name, _, _, err := fs.GetIdleReplica("fn", "test")
+	if err != nil || name != "d" {
+		t.Fatalf("want replica 'd', got '%s' (err=%v)", name, err)
+	}
+
+	/* ...and popLRU evicts the oldest */
+	if replica := pool.popLRU(); replica == nil || replica.uuid != "a" {
+		t.Fatalf("want replica 'a' evicted, got %v", replica)
+	}
+
+	/* Every remaining replica must still be reachable */
+	want := []string{"c", "b"}
+	for _, w := range want {
+		name, _, _, err := fs.GetIdleReplica("fn", "test")
+		if err != nil || name != w {
+			t.Fatalf("want replica '%s', got '%s' (err=%v)", w, name, err)
+		}
+	}
+	if pool.size() != 0 || pool.count != 0 {
+		t.Fatalf("want empty pool, got size=%d count=%d", pool.size(), pool.count)
+	}
+	if replica := pool.popLRU(); replica != nil {
+		t.Fatalf("want nil from empty pool, got %v", replica)
+	}
+}
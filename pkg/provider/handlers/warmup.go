@@ -0,0 +1,96 @@
+package handlers
+
+import (
+	"fmt"
+	"io"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.gatech.edu/faasedge/fecore/pkg/timec"
+)
+
+/* Replica warmup. Replicas created ahead of demand (spawnAddlCtrs, warm pools)
+ * join the idle pool right after their sandbox starts, so the first request
+ * they serve pays the runtime's lazy initialization (imports, JIT, connection
+ * pools). A Function with a warmupPath label (e.g. /_/warmup) gets a GET on
+ * that path once such a Replica is ready (see readiness.go) and before it
+ * becomes idle; it must answer 2xx within warmupTimeout ms (default 10000).
+ * Replicas that fail warmup are deleted and counted in FunctionStats. Replicas
+ * created for a waiting invocation are not warmed up. */
+
+const defaultWarmupTimeoutMs = 10000
+
+/* Calls a Replica's warmup endpoint; replaced in tests */
+var warmupCall = callWarmup
+
+/* Reads the warmupPath and warmupTimeout labels into a Function's policy */
+func parseWarmupLabels(labels map[string]string, policy *Policy) error {
+	policy.warmupPath = ""
+	policy.warmupTimeout = defaultWarmupTimeoutMs
+	if v, ok := labels["warmupPath"]; ok {
+		if !strings.HasPrefix(v, "/") {
+			return fmt.Errorf("[warmup/parseWarmupLabels] warmupPath must start with '/', got '%s'", v)
+		}
+		policy.warmupPath = v
+	}
+	if v, ok := labels["warmupTimeout"]; ok {
+		val, err := strconv.Atoi(v)
+		if err != nil || val < 1 {
+			return fmt.Errorf("[warmup/parseWarmupLabels] warmupTimeout must be a positive number of ms, got '%s'", v)
+		}
+		policy.warmupTimeout = val
+	}
+	return nil
+}
+
+/* GETs a ready Replica's warmup path */
+func callWarmup(ip string, path string, timeout time.Duration) error {
+	client := http.Client{Timeout: timeout}
+	resp, err := client.Get(fmt.Sprintf("http://%s:%d%s", ip, watchdogPort, path))
+	if err != nil {
+		return fmt.Errorf("[warmup/callWarmup] GET %s failed: %w", path, err)
+	}
+	defer resp.Body.Close()
+	io.Copy(io.Discard, resp.Body)
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return fmt.Errorf("[warmup/callWarmup] GET %s returned %d", path, resp.StatusCode)
+	}
+	return nil
+}
+
+/* Warms up a Replica created ahead of demand if its Function has a warmup
+ * path. Returns an error if the Replica should be discarded. */
+func (fs *FunctionStore) warmupReplica(replica *Replica, requestID string) error {
+	policy := fs.GetInvocationPolicy(replica.fname)
+	if policy.warmupPath == "" {
+		return nil
+	}
+	start := time.Now()
+	err := warmupCall(replica.IP, policy.warmupPath, time.Duration(policy.warmupTimeout)*time.Millisecond)
+	elapsed := time.Since(start)
+	fs.recordWarmup(replica.fname, elapsed, err == nil)
+	if err != nil {
+		timec.LogEvent("warmup/warmupReplica", fmt.Sprintf("Warmup of replica '%s' failed after %d ms: %s <requestID=%s>", replica.uuid, elapsed.Milliseconds(), err, requestID), 1)
+		return err
+	}
+	timec.LogEvent("warmup/warmupReplica", fmt.Sprintf("Warmed up replica '%s' of '%s' in %d ms <requestID=%s>", replica.uuid, replica.fname, elapsed.Milliseconds(), requestID), 3)
+	return nil
+}
+
+func (fs *FunctionStore) recordWarmup(fname string, elapsed time.Duration, ok bool) {
+	stats, found := fs.lookupStats(fname)
+	if !found {
+		return
+	}
+	stats.statMu.Lock()
+	defer stats.statMu.Unlock()
+	if !ok {
+		stats.warmupFailures += 1
+		return
+	}
+	stats.warmups += 1
+	stats.totalWarmupTime += elapsed.Milliseconds()
+	stats.avgWarmupTime = stats.totalWarmupTime / int64(stats.warmups)
+}
@@ -0,0 +1,97 @@
+package handlers
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	"github.gatech.edu/faasedge/fecore/pkg/provider/config"
+)
+
+func Test_parseWarmupLabels(t *testing.T) {
+	tests := []struct {
+		name        string
+		labels      map[string]string
+		wantPath    string
+		wantTimeout int
+		wantErr     bool
+	}{
+		{name: "no warmup by default", labels: map[string]string{}, wantTimeout: defaultWarmupTimeoutMs},
+		{name: "warmup path", labels: map[string]string{"warmupPath": "/_/warmup"}, wantPath: "/_/warmup", wantTimeout: defaultWarmupTimeoutMs},
+		{name: "warmup timeout", labels: map[string]string{"warmupPath": "/_/warmup", "warmupTimeout": "2000"}, wantPath: "/_/warmup", wantTimeout: 2000},
+		{name: "relative path", labels: map[string]string{"warmupPath": "_/warmup"}, wantErr: true},
+		{name: "zero timeout", labels: map[string]string{"warmupTimeout": "0"}, wantErr: true},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			policy := Policy{}
+			err := parseWarmupLabels(tc.labels, &policy)
+			if tc.wantErr {
+				if err == nil {
+					t.Fatalf("want error, got warmupPath '%s'", policy.warmupPath)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("want no error, got: %s", err)
+			}
+			if policy.warmupPath != tc.wantPath || policy.warmupTimeout != tc.wantTimeout {
+				t.Fatalf("want warmupPath '%s' (%d ms), got '%s' (%d ms)", tc.wantPath, tc.wantTimeout, policy.warmupPath, policy.warmupTimeout)
+			}
+		})
+	}
+}
+
+func Test_createReplicaWarmup(t *testing.T) {
+	tests := []struct {
+		name        string
+		warmupPath  string
+		setActive   bool
+		warmupErr   error
+		wantCalls   int
+		wantIdle    int
+		wantDeleted int
+		wantErr     bool
+	}{
+		{name: "no warmup path", wantIdle: 1},
+		{name: "warmed up before going idle", warmupPath: "/_/warmup", wantCalls: 1, wantIdle: 1},
+		{name: "failed warmup is discarded", warmupPath: "/_/warmup", warmupErr: errors.New("503"), wantCalls: 1, wantDeleted: 1, wantErr: true},
+		{name: "replicas for a waiting invocation skip warmup", warmupPath: "/_/warmup", setActive: true},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			stop := make(chan struct{})
+			defer close(stop)
+			rt := &hedgeRuntime{ctrType: "warmup", stop: stop}
+			registerTestRuntime(t, "warmup", rt)
+			calls := 0
+			warmupCall = func(ip string, path string, timeout time.Duration) error {
+				calls += 1
+				return tc.warmupErr
+			}
+			defer func() { warmupCall = callWarmup }()
+			readinessCheck = func(addr string, probe readinessProbe) error { return nil }
+			defer func() { readinessCheck = probeReplica }()
+
+			fs := newTestStore(t, config.Config{ContainerExpirationTime: 3600})
+			fn := &Function{name: "fn", activeReplicas: make(map[string]*Replica)}
+			fn.policy.warmupPath = tc.warmupPath
+			fn.policy.warmupTimeout = defaultWarmupTimeoutMs
+			fs.AddDeployedFunction(fn)
+
+			_, _, err := createReplica(fs, "fn", "warmup", tc.setActive, "test")
+			if (err != nil) != tc.wantErr {
+				t.Fatalf("want error=%v, got %v", tc.wantErr, err)
+			}
+			if calls != tc.wantCalls || fs.idleCount("fn") != tc.wantIdle || rt.deletedCount() != tc.wantDeleted {
+				t.Fatalf("want calls=%d idle=%d deleted=%d, got calls=%d idle=%d deleted=%d", tc.wantCalls, tc.wantIdle, tc.wantDeleted, calls, fs.idleCount("fn"), rt.deletedCount())
+			}
+			stats, _ := fs.lookupStats("fn")
+			if stats.warmups+stats.warmupFailures != tc.wantCalls || stats.warmupFailures != tc.wantDeleted {
+				t.Fatalf("want %d warmups (%d failed) in stats, got %d (%d failed)", tc.wantCalls, tc.wantDeleted, stats.warmups+stats.warmupFailures, stats.warmupFailures)
+			}
+		})
+	}
+}
@@ -0,0 +1,442 @@
+package handlers
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"syscall"
+	"time"
+
+	"github.com/KarpelesLab/reflink"
+	"github.com/containerd/containerd/namespaces"
+	fecore "github.gatech.edu/faasedge/fecore/pkg"
+	cninetwork "github.gatech.edu/faasedge/fecore/pkg/cninetwork"
+	"github.gatech.edu/faasedge/fecore/pkg/timec"
+)
+
+const (
+	wasmImagesRoot     = "/mnt/faasedge/images"
+	wasmCgroupCPUSet   = "/sys/fs/cgroup/cpuset/fewasm"
+	wasmCgroupCPUPath  = "/sys/fs/cgroup/cpu/fewasm"
+	wasmCgroupMemPath  = "/sys/fs/cgroup/memory/fewasm"
+	wasmCgroupAcctPath = "/sys/fs/cgroup/cpuacct/fewasm"
+
+	adoptedReplicaPollInterval = time.Second
+	resetExitTimeout           = time.Second
+	resetExitPollInterval      = time.Millisecond
+)
+
+/* Runs Function replicas as WASM modules inside runw processes */
+type wasmRuntime struct{}
+
+func (rt *wasmRuntime) Type() string {
+	return "wasm"
+}
+
+func (rt *wasmRuntime) Suffix() string {
+	return "_w"
+}
+
+func (rt *wasmRuntime) Reserve(fs *FunctionStore) bool {
+	return fs.AddWasmContainerCount()
+}
+
+func (rt *wasmRuntime) Release(fs *FunctionStore) {
+	fs.DelWasmContainerCount()
+}
+
+func (rt *wasmRuntime) Create(fs *FunctionStore, fname string, replicaName string, requestID string) (*Replica, error) {
+	defer timec.RecordDuration("(wasm_runtime.go).Create <requestID="+requestID+">", time.Now())
+
+	labels, err := fs.GetFunctionLabels(fname)
+	if err != nil {
+		return nil, err
+	}
+	/* Create unique dir for replica and setup reflinks */
+	image, err := setupWasmStorage(fs, wasmImageFunction(labels, fname), replicaName, requestID)
+	if err != nil {
+		return nil, err
+	}
+	/* Get the next available network namespace */
+	netnsNum, IP := fs.GetNetNS(requestID)
+	if netnsNum == -1 {
+		os.RemoveAll(image + "/replicas/" + replicaName)
+		return nil, fmt.Errorf("[wasm_runtime/Create] No WASM network namespaces/IPs available")
+	}
+
+	replica := Replica{}
+	replica.fname = fname
+	replica.ctrType = rt.Type()
+	replica.uuid = replicaName
+	replica.image = image
+	replica.IP = IP
+	replica.netNS = netnsNum
+	return &replica, nil
+}
+
+func (rt *wasmRuntime) Start(fs *FunctionStore, replica *Replica, requestID string) error {
+	defer timec.RecordDuration("(wasm_runtime.go).Start <requestID="+requestID+">", time.Now())
+	image := replica.image
+	replicaName := replica.uuid
+
+	/* Exec wasmedge to create container process, retrieve PID */
+	runw_path := image + "/" + "runw"
+	container_wasm_file := image + "/" + "function.wasm"
+	container_dir_arg := ".:" + image + "/replicas/" + replicaName
+	timec.LogEvent("wasm_runtime/Start", fmt.Sprintf("Creating replica with command: /mnt/faasedge/runw %s %s %d <requestID=%s>", container_wasm_file, container_dir_arg, replica.netNS, requestID), 2)
+	startTime := time.Now()
+	cmd := exec.Command(runw_path, container_wasm_file, container_dir_arg, strconv.Itoa(replica.netNS))
+	endTime := time.Since(startTime)
+	timec.LogEvent("wasm_runtime/Start/exec.Command", fmt.Sprintf("exec.Command() to start runw took %d ms <requestID=%s>", endTime.Milliseconds(), requestID), 2)
+
+	startTime = time.Now()
+	err := cmd.Start()
+	endTime = time.Since(startTime)
+	timec.LogEvent("wasm_runtime/Start/cmd.Start", fmt.Sprintf("cmd.Start() to start runw took %d ms <requestID=%s>", endTime.Milliseconds(), requestID), 2)
+
+	timec.RecordDuration("(wasm_runtime.go).exec.Command <requestID="+requestID+">", startTime)
+	if err != nil {
+		timec.LogEvent("wasm_runtime/Start", fmt.Sprintf("Failed to start WASM container '%s' (%s)", replicaName, replica.IP), 1)
+		return err
+	}
+
+	wasmPid := cmd.Process.Pid
+
+	/* Add wasmPid to cpuset cgroups */
+	wferr := os.WriteFile(filepath.Join(wasmCgroupCPUSet, "tasks"), []byte(strconv.Itoa(wasmPid)), 0644)
+	if wferr != nil {
+		timec.LogEvent("wasm_runtime/Start", fmt.Sprintf("Failed to write to CPUSET cgroup: %s <requestID=%s>", wferr, requestID), 1)
+	}
+	/* Add wasmPid to its own cpu cgroup */
+	/* By default, each task gets 1 CPU in periods of contention, so we don't need to
+	 * set the number of shares */
+	os.Mkdir(filepath.Join(wasmCgroupCPUPath, replicaName), 0644)
+	wferr = os.WriteFile(filepath.Join(wasmCgroupCPUPath, replicaName, "tasks"), []byte(strconv.Itoa(wasmPid)), 0644)
+	/* Add quota to throttle function to 1 CPU even if no contention exists */
+	// wferr = os.WriteFile(filepath.Join(wasmCgroupCPUPath, replicaName, "cpu.cfs_quota_us"), []byte(strconv.Itoa(100000)), 0644)
+	if wferr != nil {
+		timec.LogEvent("wasm_runtime/Start", fmt.Sprintf("Failed to write to CPU cgroup: %s <requestID=%s>", wferr, requestID), 1)
+	}
+	/* Add wasmPid to its own memory cgroup */
+	os.Mkdir(filepath.Join(wasmCgroupMemPath, replicaName), 0644)
+	wferr = os.WriteFile(filepath.Join(wasmCgroupMemPath, replicaName, "tasks"), []byte(strconv.Itoa(wasmPid)), 0644)
+	/* Limit each replica to 1 GB memory */
+	// wferr = os.WriteFile(filepath.Join(wasmCgroupMemPath, replicaName, "memory.limit_in_bytes"), []byte(strconv.Itoa(1024*1024*1024)), 0644)
+	if wferr != nil {
+		timec.LogEvent("wasm_runtime/Start", fmt.Sprintf("Failed to write to MEM cgroup: %s <requestID=%s>", wferr, requestID), 1)
+	}
+
+	replica.PID = uint32(wasmPid)
+	replica.cmd = cmd
+	replica.lastAccess = time.Now()
+	timec.LogEvent("wasm_runtime/Start", fmt.Sprintf("Created WASM container for Function '%s' <requestID=%s>", replica.fname, requestID), 2)
+	/* runw is reaped by the liveness watcher (see Wait) */
+	return nil
+}
+
+/* Stops the runw process; a stopped process keeps its memory and sockets but
+ * isn't scheduled */
+func (rt *wasmRuntime) Freeze(fs *FunctionStore, replica *Replica) error {
+	if replica.PID == 0 {
+		return fmt.Errorf("[wasm_runtime/Freeze] Replica '%s' has no process", replica.uuid)
+	}
+	return syscall.Kill(int(replica.PID), syscall.SIGSTOP)
+}
+
+func (rt *wasmRuntime) Thaw(fs *FunctionStore, replica *Replica) error {
+	if replica.PID == 0 {
+		return fmt.Errorf("[wasm_runtime/Thaw] Replica '%s' has no process", replica.uuid)
+	}
+	return syscall.Kill(int(replica.PID), syscall.SIGCONT)
+}
+
+/* Kills the runw process, reflinks a fresh rootfs from the image and starts
+ * the module again in the same netns and cgroups */
+func (rt *wasmRuntime) Reset(fs *FunctionStore, replica *Replica, requestID string) error {
+	defer timec.RecordDuration("(wasm_runtime.go).Reset <requestID="+requestID+">", time.Now())
+	if replica.PID == 0 {
+		return fmt.Errorf("[wasm_runtime/Reset] Replica '%s' has no process", replica.uuid)
+	}
+	labels, err := fs.GetFunctionLabels(replica.fname)
+	if err != nil {
+		return err
+	}
+	pid := int(replica.PID)
+	if err := syscall.Kill(pid, syscall.SIGKILL); err != nil {
+		return fmt.Errorf("[wasm_runtime/Reset] Unable to kill runw process for replica '%s' (PID=%d): %w", replica.uuid, pid, err)
+	}
+	/* The new module binds the same address in the netns */
+	if !waitForExit(pid, resetExitTimeout) {
+		return fmt.Errorf("[wasm_runtime/Reset] runw process for replica '%s' (PID=%d) did not exit", replica.uuid, pid)
+	}
+	/* setupWasmStorage replaces the replica's rootfs dir */
+	image, err := setupWasmStorage(fs, wasmImageFunction(labels, replica.fname), replica.uuid, requestID)
+	if err != nil {
+		return err
+	}
+	replica.image = image
+	return rt.Start(fs, replica, requestID)
+}
+
+/* Polls until a killed process has exited (or is a zombie waiting for the
+ * liveness watcher to reap it). Returns false on timeout. */
+func waitForExit(pid int, timeout time.Duration) bool {
+	deadline := time.Now().Add(timeout)
+	for time.Now().Before(deadline) {
+		stat, err := os.ReadFile(filepath.Join("/proc", strconv.Itoa(pid), "stat"))
+		if err != nil {
+			return true
+		}
+		/* The state follows the parenthesised command name */
+		if i := strings.LastIndexByte(string(stat), ')'); i >= 0 && i+2 < len(stat) && stat[i+2] == 'Z' {
+			return true
+		}
+		time.Sleep(resetExitPollInterval)
+	}
+	return false
+}
+
+func (rt *wasmRuntime) Wait(fs *FunctionStore, replica *Replica) ReplicaExit {
+	if replica.cmd == nil && replica.PID != 0 {
+		/* Adopted replicas aren't children of this provider, so their exit
+		 * status can't be collected; poll until runw is gone */
+		pid := int(replica.PID)
+		for syscall.Kill(pid, syscall.Signal(0)) == nil {
+			time.Sleep(adoptedReplicaPollInterval)
+		}
+		return ReplicaExit{ExitCode: -1, Reason: "runw process exited"}
+	}
+	if replica.cmd == nil {
+		return ReplicaExit{ExitCode: -1, Reason: "no runw process"}
+	}
+	err := replica.cmd.Wait()
+	state := replica.cmd.ProcessState
+	if state == nil {
+		return ReplicaExit{ExitCode: -1, Reason: fmt.Sprintf("wait failed: %s", err)}
+	}
+	if status, ok := state.Sys().(syscall.WaitStatus); ok && status.Signaled() {
+		return ReplicaExit{ExitCode: -1, Reason: fmt.Sprintf("killed by signal %d (%s)", int(status.Signal()), status.Signal())}
+	}
+	return ReplicaExit{ExitCode: state.ExitCode(), Reason: fmt.Sprintf("exited with code %d", state.ExitCode())}
+}
+
+func (rt *wasmRuntime) Adopt(fs *FunctionStore, replica *Replica) error {
+	if replica.PID == 0 || replica.IP == "" {
+		return fmt.Errorf("[wasm_runtime/Adopt] No PID/IP recorded for replica '%s'", replica.uuid)
+	}
+	if replica.image == "" {
+		image, _, err := fs.GetFunctionImage(replica.fname)
+		if err != nil {
+			return err
+		}
+		replica.image = image
+	}
+	/* The PID may have been reused by an unrelated process */
+	if pid, ok := runwProcesses()[replica.uuid]; !ok || pid != int(replica.PID) {
+		return fmt.Errorf("[wasm_runtime/Adopt] runw process for replica '%s' (PID=%d) is gone", replica.uuid, replica.PID)
+	}
+	if _, err := os.Stat(replica.image + "/replicas/" + replica.uuid); err != nil {
+		return fmt.Errorf("[wasm_runtime/Adopt] rootfs for replica '%s' is gone: %w", replica.uuid, err)
+	}
+	if !fs.claimNetNS(replica.netNS, replica.IP) {
+		return fmt.Errorf("[wasm_runtime/Adopt] netns %d (%s) of replica '%s' is not in the pool", replica.netNS, replica.IP, replica.uuid)
+	}
+	/* Replicas kept frozen by a drain are resumed */
+	rt.Thaw(fs, replica)
+	return nil
+}
+
+/* Kills runw processes and removes rootfs dirs and cgroups of WASM replicas
+ * that aren't in keep */
+func (rt *wasmRuntime) Sweep(fs *FunctionStore, keep map[string]bool) []string {
+	removed := make([]string, 0)
+	for name, pid := range runwProcesses() {
+		if keep[name] {
+			continue
+		}
+		if err := syscall.Kill(pid, syscall.SIGKILL); err != nil {
+			timec.LogEvent("wasm_runtime/Sweep", fmt.Sprintf("Unable to kill orphaned runw process for '%s' (PID=%d): %s", name, pid, err), 1)
+			continue
+		}
+		removed = append(removed, fmt.Sprintf("process %s (PID=%d)", name, pid))
+	}
+
+	rootfsDirs, _ := filepath.Glob(wasmImagesRoot + "/*/replicas/*")
+	for _, dir := range rootfsDirs {
+		if keep[filepath.Base(dir)] {
+			continue
+		}
+		if err := os.RemoveAll(dir); err != nil {
+			timec.LogEvent("wasm_runtime/Sweep", fmt.Sprintf("Unable to remove orphaned rootfs '%s': %s", dir, err), 1)
+			continue
+		}
+		removed = append(removed, "rootfs "+dir)
+	}
+
+	for _, cgroupRoot := range []string{wasmCgroupCPUPath, wasmCgroupMemPath} {
+		entries, _ := os.ReadDir(cgroupRoot)
+		for _, entry := range entries {
+			name := entry.Name()
+			if !entry.IsDir() || !strings.HasSuffix(name, rt.Suffix()) || keep[name] {
+				continue
+			}
+			/* cgroup dirs can only be rmdir'd, and only once empty */
+			if err := os.Remove(filepath.Join(cgroupRoot, name)); err != nil {
+				timec.LogEvent("wasm_runtime/Sweep", fmt.Sprintf("Unable to remove orphaned cgroup '%s': %s", filepath.Join(cgroupRoot, name), err), 1)
+				continue
+			}
+			removed = append(removed, "cgroup "+filepath.Join(cgroupRoot, name))
+		}
+	}
+	return removed
+}
+
+/* Returns the PIDs of running runw processes by replica name. runw is
+ * started with a ".:<image>/replicas/<replica>" dir argument (see Start). */
+func runwProcesses() map[string]int {
+	procs := make(map[string]int)
+	entries, _ := os.ReadDir("/proc")
+	for _, entry := range entries {
+		pid, err := strconv.Atoi(entry.Name())
+		if err != nil {
+			continue
+		}
+		cmdline, err := os.ReadFile(filepath.Join("/proc", entry.Name(), "cmdline"))
+		if err != nil {
+			continue
+		}
+		args := strings.Split(string(cmdline), "\x00")
+		if len(args) < 3 || filepath.Base(args[0]) != "runw" {
+			continue
+		}
+		for _, arg := range args[1:] {
+			if strings.HasPrefix(arg, ".:") && strings.Contains(arg, "/replicas/") {
+				procs[filepath.Base(arg)] = pid
+			}
+		}
+	}
+	return procs
+}
+
+func (rt *wasmRuntime) Delete(fs *FunctionStore, replica *Replica) error {
+	fn := replica.fname
+	image, _, err := fs.GetFunctionImage(fn)
+	if err != nil {
+		image = replica.image
+	}
+	name := replica.uuid
+	pid := int(replica.PID)
+	var firstErr error
+	ctx := namespaces.WithNamespace(context.Background(), fecore.DefaultFunctionNamespace)
+	networkErr := cninetwork.DeleteWasmCNINetwork(ctx, *fs.CNI, fs.Client, name, pid)
+	if networkErr != nil {
+		timec.LogEvent("wasm_runtime/Delete", fmt.Sprintf("Error removing network for Function '%s': %s", name, networkErr), 1)
+	}
+	/* A replica that failed to start has no process; never signal PID 0 */
+	if replica.cmd != nil {
+		replica.cmd.Process.Kill()
+		timec.LogEvent("wasm_runtime/Delete", fmt.Sprintf("Killed WASM container process (PID=%d)", pid), 2)
+	} else if pid > 0 {
+		proc, procErr := os.FindProcess(pid)
+		if procErr != nil {
+			timec.LogEvent("wasm_runtime/Delete", fmt.Sprintf("Could not find WASM container process (PID=%d)", pid), 1)
+			firstErr = procErr
+		} else {
+			proc.Kill()
+			timec.LogEvent("wasm_runtime/Delete", fmt.Sprintf("Killed WASM container process (PID=%d)", pid), 2)
+		}
+	}
+	/* Keep tearing down on errors so the cgroups, netns and slot are never
+	 * leaked; the first error is returned */
+	rootfsErr := os.RemoveAll(image + "/replicas/" + name)
+	if rootfsErr != nil {
+		timec.LogEvent("wasm_runtime/Delete", fmt.Sprintf("Could not delete rootfs for WASM replica '%s': %s", name, rootfsErr), 1)
+		if firstErr == nil {
+			firstErr = rootfsErr
+		}
+	} else {
+		timec.LogEvent("wasm_runtime/Delete", fmt.Sprintf("Deleted rootfs for WASM replica '%s'", name), 2)
+	}
+
+	/* Remove wasm replica's cgroups */
+	cg_cpu_err := os.RemoveAll(wasmCgroupCPUPath + "/" + name)
+	if cg_cpu_err != nil {
+		timec.LogEvent("wasm_runtime/Delete", fmt.Sprintf("Unable to remove CPU cgroup for %s", name), 1)
+	} else {
+		timec.LogEvent("wasm_runtime/Delete", fmt.Sprintf("Deleted CPU cgroup for %s", name), 2)
+	}
+	cg_mem_err := os.RemoveAll(wasmCgroupMemPath + "/" + name)
+	if cg_mem_err != nil {
+		timec.LogEvent("wasm_runtime/Delete", fmt.Sprintf("Unable to remove MEMORY cgroup for %s", name), 1)
+	} else {
+		timec.LogEvent("wasm_runtime/Delete", fmt.Sprintf("Deleted MEMORY cgroup for %s", name), 2)
+	}
+
+	/* Hand the network namespace and its IP back to the pool */
+	if replica.IP != "" {
+		fs.ReturnNetNS(replica.netNS, replica.IP)
+		replica.IP = ""
+	}
+	rt.Release(fs)
+	return firstErr
+}
+
+func (rt *wasmRuntime) Stats(fs *FunctionStore, replica *Replica) (ReplicaStats, error) {
+	stats := ReplicaStats{}
+	mem, err := readCgroupValue(filepath.Join(wasmCgroupMemPath, replica.uuid), "memory.usage_in_bytes")
+	if err != nil {
+		return stats, err
+	}
+	cpu, err := readCgroupValue(filepath.Join(wasmCgroupAcctPath, replica.uuid), "cpuacct.usage")
+	if err != nil {
+		return stats, err
+	}
+	stats.MemoryBytes = mem
+	stats.CPUNanos = cpu
+	return stats, nil
+}
+
+/* Name of the Function whose image holds a WASM Replica's rootfs; the WASM
+ * sandbox of a Hybrid Function uses the image stored under <fname>.wasm */
+func wasmImageFunction(labels map[string]string, fname string) string {
+	if val, ok := labels["ctrType"]; ok && (val == "hybrid") {
+		return fname + ".wasm"
+	}
+	return fname
+}
+
+func setupWasmStorage(fs *FunctionStore, fname string, replicaName string, requestID string) (image string, err error) {
+	defer timec.RecordDuration("(wasm_runtime.go).setupWasmStorage <requestID="+requestID+">", time.Now())
+	image_path, imageFiles, err := fs.GetFunctionImage(fname)
+	if err != nil {
+		return "", err
+	}
+
+	rootfs_path := image_path + "/replicas/" + replicaName
+	/* Check if unique dir for replica exists; if so, remove */
+	if _, err := os.Stat(rootfs_path); !os.IsNotExist(err) {
+		timec.LogEvent("[wasm_runtime/setupWasmStorage]", fmt.Sprintf("WASM replica path already exists at '%s'. Removing", rootfs_path), 1)
+		os.RemoveAll(rootfs_path)
+	}
+	/* Create rootfs dir for Function instance */
+	err = os.Mkdir(rootfs_path, 0744)
+	if err != nil {
+		timec.LogEvent("[wasm_runtime/setupWasmStorage]", fmt.Sprintf("Unable to create rootfs for WASM container '%s'", replicaName), 1)
+		return "", err
+	}
+
+	/* Reflink all files in image rootfs */
+	for _, file := range imageFiles {
+		err := reflink.Auto(image_path+"/rootfs/"+file, rootfs_path+"/"+file)
+		if err != nil {
+			timec.LogEvent("[wasm_runtime/setupWasmStorage]", fmt.Sprintf("Error creating reflink to file '%s' for container '%s'", file, replicaName), 1)
+			return "", err
+		}
+	}
+	timec.LogEvent("[wasm_runtime/setupWasmStorage]", fmt.Sprintf("Setup WASM replica storage at '%s'", rootfs_path), 2)
+	return image_path, nil
+}
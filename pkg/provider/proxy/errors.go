@@ -0,0 +1,94 @@
+package proxy
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+
+	"github.gatech.edu/faasedge/fecore/pkg/provider/handlers"
+)
+
+/* Gateway-side errors. A Function's own responses, whatever their status,
+ * are passed through unchanged; responses fecore generates itself carry a
+ * JSON body with a machine-readable code so clients can tell them apart:
+ *   400 bad_request, 403 forbidden, 404 function_not_found/replica_not_found,
+ *   409 replica_busy, 413 request_too_large, 429 too_many_requests,
+ *   500 internal, 502 replica_unreachable/response_too_large,
+ *   503 draining/no_replica,
+ *   504 deadline_exceeded/replica_timeout */
+
+const (
+	codeBadRequest         = "bad_request"
+	codeForbidden          = "forbidden"
+	codeFunctionNotFound   = "function_not_found"
+	codeReplicaNotFound    = "replica_not_found"
+	codeReplicaBusy        = "replica_busy"
+	codeRequestTooLarge    = "request_too_large"
+	codeTooManyRequests    = "too_many_requests"
+	codeInternal           = "internal"
+	codeReplicaUnreachable = "replica_unreachable"
+	codeResponseTooLarge   = "response_too_large"
+	codeDraining           = "draining"
+	codeNoReplica          = "no_replica"
+	codeDeadlineExceeded   = "deadline_exceeded"
+	codeReplicaTimeout     = "replica_timeout"
+)
+
+type gatewayError struct {
+	Status    int    `json:"status"`
+	Code      string `json:"code"`
+	Message   string `json:"message"`
+	RequestID string `json:"requestId,omitempty"`
+}
+
+func writeError(w http.ResponseWriter, status int, code string, requestID string, format string, args ...interface{}) {
+	body, _ := json.Marshal(gatewayError{Status: status, Code: code, Message: fmt.Sprintf(format, args...), RequestID: requestID})
+	w.Header().Set("Content-Type", "application/json")
+	if requestID != "" {
+		w.Header().Set("Request-ID", requestID)
+	}
+	w.WriteHeader(status)
+	w.Write(body)
+}
+
+/* Maps an error from admission or the resolver to a status and code */
+func classifyError(err error) (int, string) {
+	var missed *handlers.DeadlineError
+	var denied *handlers.OverrideError
+	var rejected *handlers.AdmissionError
+	switch {
+	case errors.As(err, &missed):
+		return http.StatusGatewayTimeout, codeDeadlineExceeded
+	case errors.As(err, &denied):
+		switch denied.Status {
+		case http.StatusForbidden:
+			return denied.Status, codeForbidden
+		case http.StatusNotFound:
+			return denied.Status, codeReplicaNotFound
+		case http.StatusConflict:
+			return denied.Status, codeReplicaBusy
+		}
+		return http.StatusBadRequest, codeBadRequest
+	case errors.As(err, &rejected):
+		return http.StatusTooManyRequests, codeTooManyRequests
+	case errors.Is(err, handlers.ErrFunctionNotFound):
+		return http.StatusNotFound, codeFunctionNotFound
+	}
+	return http.StatusServiceUnavailable, codeNoReplica
+}
+
+/* Maps a failed request to a Replica to a status and code. The request's own
+ * deadline (X-Fecore-Deadline-Ms) is told apart from the Replica timing out. */
+func classifyUpstreamError(ctx context.Context, err error) (int, string) {
+	if errors.Is(ctx.Err(), context.DeadlineExceeded) {
+		return http.StatusGatewayTimeout, codeDeadlineExceeded
+	}
+	var netErr net.Error
+	if errors.As(err, &netErr) && netErr.Timeout() {
+		return http.StatusGatewayTimeout, codeReplicaTimeout
+	}
+	return http.StatusBadGateway, codeReplicaUnreachable
+}
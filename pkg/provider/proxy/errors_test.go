@@ -0,0 +1,75 @@
+package proxy
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.gatech.edu/faasedge/fecore/pkg/provider/handlers"
+)
+
+/* net.Error that reports a timeout */
+type timeoutError struct{}
+
+func (timeoutError) Error() string   { return "i/o timeout" }
+func (timeoutError) Timeout() bool   { return true }
+func (timeoutError) Temporary() bool { return true }
+
+var _ net.Error = timeoutError{}
+
+func Test_classifyError(t *testing.T) {
+	tests := []struct {
+		name       string
+		err        error
+		wantStatus int
+		wantCode   string
+	}{
+		{name: "deadline", err: &handlers.DeadlineError{Reason: "too slow"}, wantStatus: http.StatusGatewayTimeout, wantCode: codeDeadlineExceeded},
+		{name: "override forbidden", err: &handlers.OverrideError{Status: http.StatusForbidden}, wantStatus: http.StatusForbidden, wantCode: codeForbidden},
+		{name: "override replica not found", err: &handlers.OverrideError{Status: http.StatusNotFound}, wantStatus: http.StatusNotFound, wantCode: codeReplicaNotFound},
+		{name: "override replica busy", err: &handlers.OverrideError{Status: http.StatusConflict}, wantStatus: http.StatusConflict, wantCode: codeReplicaBusy},
+		{name: "override bad value", err: &handlers.OverrideError{Status: http.StatusBadRequest}, wantStatus: http.StatusBadRequest, wantCode: codeBadRequest},
+		{name: "admission", err: &handlers.AdmissionError{Reason: "queue full"}, wantStatus: http.StatusTooManyRequests, wantCode: codeTooManyRequests},
+		{name: "function not found", err: fmt.Errorf("lookup: %w", handlers.ErrFunctionNotFound), wantStatus: http.StatusNotFound, wantCode: codeFunctionNotFound},
+		{name: "no replica", err: errors.New("container limit reached"), wantStatus: http.StatusServiceUnavailable, wantCode: codeNoReplica},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			status, code := classifyError(tc.err)
+			if status != tc.wantStatus || code != tc.wantCode {
+				t.Fatalf("want %d %s, got %d %s", tc.wantStatus, tc.wantCode, status, code)
+			}
+		})
+	}
+}
+
+func Test_classifyUpstreamError(t *testing.T) {
+	expired, cancel := context.WithDeadline(context.Background(), time.Now().Add(-time.Second))
+	defer cancel()
+
+	tests := []struct {
+		name       string
+		ctx        context.Context
+		err        error
+		wantStatus int
+		wantCode   string
+	}{
+		{name: "request deadline", ctx: expired, err: context.DeadlineExceeded, wantStatus: http.StatusGatewayTimeout, wantCode: codeDeadlineExceeded},
+		{name: "replica timeout", ctx: context.Background(), err: fmt.Errorf("Post: %w", timeoutError{}), wantStatus: http.StatusGatewayTimeout, wantCode: codeReplicaTimeout},
+		{name: "replica unreachable", ctx: context.Background(), err: errors.New("connection refused"), wantStatus: http.StatusBadGateway, wantCode: codeReplicaUnreachable},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			status, code := classifyUpstreamError(tc.ctx, tc.err)
+			if status != tc.wantStatus || code != tc.wantCode {
+				t.Fatalf("want %d %s, got %d %s", tc.wantStatus, tc.wantCode, status, code)
+			}
+		})
+	}
+}
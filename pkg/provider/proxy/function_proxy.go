@@ -4,18 +4,17 @@ Based on "github.com/openfaas/faas-provider/proxy"
 package proxy
 
 import (
+	"context"
+	"errors"
 	"fmt"
-	"io"
+	"math"
 	"net/http"
 	"net/url"
 	"strconv"
 	"strings"
 	"time"
 
-	"github.com/hashicorp/go-retryablehttp"
-
 	"github.com/gorilla/mux"
-	"github.com/openfaas/faas-provider/httputil"
 	"github.com/openfaas/faas-provider/types"
 
 	"github.com/google/uuid"
@@ -29,25 +28,38 @@ const (
 	defaultContentType = "text/plain"
 )
 
+/* What proxyRequest needs from the Function Store (handlers.FunctionStore) */
+type invocationStore interface {
+	BeginInvocation() bool
+	EndInvocation()
+	RecordArrival(fname string)
+	InvokeOverrides(r *http.Request) (handlers.InvokeOptions, error)
+	BodyLimits(fname string) (int64, int64)
+	Admit(ctx context.Context, fname string, requestID string) (func(), error)
+	UpdateReplicaStatusInactive(fn string, replicaName string, requestID string) error
+	DiscardActiveReplica(fn string, replicaName string, reason string, requestID string) error
+	ReplicaReadyTime(fname string, replicaName string) time.Duration
+	RecordInvocationTime(invocationTime int64, startupType string)
+	UpdateFunctionStats(fn string, ctrType string, requestID string, startupTime int64, execTime int64, startupType string)
+}
+
+/* Picks the Replica for an invocation (handlers.InvokeResolver) */
+type replicaResolver interface {
+	Resolve(functionName string, requestID string, opts handlers.InvokeOptions) (url.URL, string, string, string, error)
+}
+
 func NewHandlerFunc(config types.FaaSConfig, resolver *handlers.InvokeResolver, fs *handlers.FunctionStore) http.HandlerFunc {
 	if resolver == nil {
 		panic("NewHandlerFunc: empty proxy handler resolver, cannot be nil")
 	}
 
-	/* Old method: Create proxyClient from OpenFaaS config */
-	// proxyClient := proxy.NewProxyClientFromConfig(config)
-
-	/* New method: Create client using retryableHttp pkg */
-	retryClient := retryablehttp.NewClient()
-	retryClient.RetryMax = 632 // Gives ~10 secs of retry
-	/* Uncomment below to use dynamic backoff time between request attempts */
-	// retryClient.Backoff(time.Duration(1)*time.Millisecond, time.Duration(25)*time.Millisecond, 25, nil)
-	/* Always wait 5 ms backoff time between request attempts */
-	retryClient.RetryWaitMin = time.Duration(5) * time.Millisecond
-	retryClient.RetryWaitMax = time.Duration(5) * time.Millisecond
-	retryClient.Logger = nil
-	proxyClient := retryClient.StandardClient()
+	/* Replicas are only handed out once they are ready (see
+	 * handlers/readiness.go), so each request is sent exactly once */
+	proxyClient := &http.Client{}
+	return newProxyHandler(proxyClient, resolver, fs)
+}
 
+func newProxyHandler(proxyClient *http.Client, resolver replicaResolver, fs invocationStore) http.HandlerFunc {
 	return func(w http.ResponseWriter, r *http.Request) {
 		if r.Body != nil {
 			defer r.Body.Close()
@@ -70,7 +82,7 @@ func NewHandlerFunc(config types.FaaSConfig, resolver *handlers.InvokeResolver,
 }
 
 // proxyRequest handles the actual resolution of and then request to the function service.
-func proxyRequest(w http.ResponseWriter, originalReq *http.Request, proxyClient *http.Client, resolver *handlers.InvokeResolver, fs *handlers.FunctionStore) {
+func proxyRequest(w http.ResponseWriter, originalReq *http.Request, proxyClient *http.Client, resolver replicaResolver, fs invocationStore) {
 	/* Begin function replica setup */
 	fnSetupStart := time.Now()
 	ctx := originalReq.Context()
@@ -80,26 +92,93 @@ func proxyRequest(w http.ResponseWriter, originalReq *http.Request, proxyClient
 	requestID := functionName + "_" + uuid.New().String()
 	defer timec.RecordDuration("[function_proxy.go/proxyRequest()] <requestID="+requestID+">", time.Now())
 	if functionName == "" {
-		httputil.Errorf(w, http.StatusBadRequest, "Provide function name in the request path")
+		writeError(w, http.StatusBadRequest, codeBadRequest, "", "Provide function name in the request path")
+		return
+	}
+	if !fs.BeginInvocation() {
+		writeError(w, http.StatusServiceUnavailable, codeDraining, requestID, "Provider is draining; not accepting new invocations")
+		return
+	}
+	defer fs.EndInvocation()
+	fs.RecordArrival(functionName)
+
+	/* Admin-only containerType/startupType/replica overrides */
+	opts, overrideErr := fs.InvokeOverrides(originalReq)
+	if overrideErr != nil {
+		status, code := classifyError(overrideErr)
+		writeError(w, status, code, requestID, "%s", overrideErr)
 		return
 	}
 
-	reqStartupType := originalReq.Header.Get("startupType")
-	reqContainerType := originalReq.Header.Get("containerType")
+	/* The client's latency budget, counted from the arrival of the request */
+	if v := originalReq.Header.Get(handlers.DeadlineHeader); v != "" {
+		ms, parseErr := strconv.Atoi(v)
+		if parseErr != nil || ms <= 0 {
+			writeError(w, http.StatusBadRequest, codeBadRequest, requestID, "%s must be a positive number of ms, got '%s'", handlers.DeadlineHeader, v)
+			return
+		}
+		opts.Deadline = fnSetupStart.Add(time.Duration(ms) * time.Millisecond)
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithDeadline(ctx, opts.Deadline)
+		defer cancel()
+	}
+
+	/* Bodies are streamed in both directions (see stream.go); a request that
+	 * announces a body over the limit is turned away before it takes a slot */
+	maxRequestBody, maxResponseBody := fs.BodyLimits(functionName)
+	if maxRequestBody > 0 && originalReq.ContentLength > maxRequestBody {
+		writeError(w, http.StatusRequestEntityTooLarge, codeRequestTooLarge, requestID, "Request body of %d bytes exceeds the limit of %d bytes", originalReq.ContentLength, maxRequestBody)
+		return
+	}
+	if maxRequestBody > 0 && originalReq.Body != nil {
+		originalReq.Body = http.MaxBytesReader(w, originalReq.Body, maxRequestBody)
+	}
+	/* Lets the Replica answer while the client is still sending (HTTP/1.x) */
+	http.NewResponseController(w).EnableFullDuplex()
+
+	/* Wait for a free slot if the Function has a concurrency limit */
+	release, admitErr := fs.Admit(ctx, functionName, requestID)
+	if admitErr != nil {
+		var rejected *handlers.AdmissionError
+		if errors.As(admitErr, &rejected) {
+			w.Header().Set("Retry-After", strconv.Itoa(int(math.Ceil(rejected.RetryAfter.Seconds()))))
+		}
+		status, code := classifyError(admitErr)
+		writeError(w, status, code, requestID, "%s", admitErr)
+		return
+	}
+	defer release()
 
-	functionAddr, startupType, containerType, replicaName, resolveErr := resolver.Resolve(functionName, requestID, reqStartupType, reqContainerType)
+	functionAddr, startupType, containerType, replicaName, resolveErr := resolver.Resolve(functionName, requestID, opts)
 	if resolveErr != nil {
 		timec.LogEvent("function_proxy/proxyRequest", fmt.Sprintf("Resolver error: No endpoints for %s: %s", functionName, resolveErr.Error()), 1)
-		httputil.Errorf(w, http.StatusServiceUnavailable, "No endpoints available for: %s.", functionName)
+		status, code := classifyError(resolveErr)
+		if code == codeNoReplica {
+			writeError(w, status, code, requestID, "No endpoints available for: %s.", functionName)
+			return
+		}
+		writeError(w, status, code, requestID, "%s", resolveErr)
 		return
 	}
 
-	var proxyReq *http.Request
+	/* From here on the Replica is ours: return it to its pool when done, or
+	 * delete it if the request to it failed, on every path */
+	keepReplica := true
+	defer func() {
+		if keepReplica {
+			if err := fs.UpdateReplicaStatusInactive(functionName, replicaName, requestID); err != nil {
+				timec.LogEvent("function_proxy/proxyRequest", fmt.Sprintf("<requestID=%s> Unable to update replica status to inactive: %s", requestID, err), 1)
+			}
+			return
+		}
+		if err := fs.DiscardActiveReplica(functionName, replicaName, "failed its request", requestID); err != nil {
+			timec.LogEvent("function_proxy/proxyRequest", fmt.Sprintf("<requestID=%s> Unable to discard replica: %s", requestID, err), 1)
+		}
+	}()
 
-	var err error
-	proxyReq, err = buildProxyRequest(originalReq, functionAddr, pathVars["params"])
+	proxyReq, err := buildProxyRequest(originalReq, functionAddr, pathVars["params"])
 	if err != nil {
-		httputil.Errorf(w, http.StatusInternalServerError, "Failed to resolve service: %s.", functionName)
+		writeError(w, http.StatusInternalServerError, codeInternal, requestID, "Failed to resolve service: %s.", functionName)
 		return
 	}
 
@@ -108,48 +187,52 @@ func proxyRequest(w http.ResponseWriter, originalReq *http.Request, proxyClient
 	}
 
 	fnSetupTime := time.Since(fnSetupStart).Milliseconds()
-	timec.LogEvent("function_proxy/proxyRequest", fmt.Sprintf("<requestID=%s> Setup for %s took %d ms", requestID, replicaName, fnSetupTime), 2)
+	/* Part of the setup time a new Replica spent booting until it was ready */
+	var fnReadyTime int64
+	if startupType == "cold" {
+		fnReadyTime = fs.ReplicaReadyTime(functionName, replicaName).Milliseconds()
+	}
+	timec.LogEvent("function_proxy/proxyRequest", fmt.Sprintf("<requestID=%s> Setup for %s took %d ms (readyTime=%d)", requestID, replicaName, fnSetupTime, fnReadyTime), 2)
 	/* End function replica setup */
 
-	var response *http.Response
-
 	fnExecStart := time.Now()
 
 	ip := strings.Split(functionAddr.Host, ":")[0]
 
-	/* Attempt connection to function replica; retryableHttp will keep trying
-	 * until it connects or times out */
-	response, err = proxyClient.Do(proxyReq.WithContext(ctx))
-
-	/* Connection timed out or we got a bad status from replica */
-	if err != nil || response.StatusCode != 200 {
-		timec.LogEvent("function_proxy/proxyRequest", fmt.Sprintf("<requestID=%s>  Connecting to function %s at %s failed. Status code: %d; Error: %s", requestID, functionName, ip, response.StatusCode, err), 1)
-		httputil.Errorf(w, http.StatusInternalServerError, "Can't reach service for '%s'", functionName)
+	response, err := proxyClient.Do(proxyReq.WithContext(ctx))
+	if err != nil {
+		/* The Replica may still be running the request (or be broken) */
+		keepReplica = false
+		if originalReq.Context().Err() != nil {
+			timec.LogEvent("function_proxy/proxyRequest", fmt.Sprintf("<requestID=%s> Client went away during request to function %s at %s", requestID, functionName, ip), 2)
+			return
+		}
+		var tooLarge *http.MaxBytesError
+		if errors.As(err, &tooLarge) {
+			writeError(w, http.StatusRequestEntityTooLarge, codeRequestTooLarge, requestID, "Request body exceeds the limit of %d bytes", tooLarge.Limit)
+			return
+		}
+		status, code := classifyUpstreamError(ctx, err)
+		timec.LogEvent("function_proxy/proxyRequest", fmt.Sprintf("<requestID=%s> Request to function %s at %s failed (%s): %s", requestID, functionName, ip, code, err), 1)
+		writeError(w, status, code, requestID, "Request to replica of '%s' failed: %s", functionName, err)
 		return
 	}
+	defer response.Body.Close()
 
-	/* Connection was successful; report time time taken to exec function */
-	fnExecTime := time.Since(fnExecStart).Milliseconds()
-	timec.LogEvent("function_proxy/proxyRequest", fmt.Sprintf("<requestID=%s> Exec for %s took %d ms", requestID, replicaName, fnExecTime), 2)
+	if maxResponseBody > 0 && response.ContentLength > maxResponseBody {
+		timec.LogEvent("function_proxy/proxyRequest", fmt.Sprintf("<requestID=%s> Response of %s is %d bytes, over the limit of %d", requestID, replicaName, response.ContentLength, maxResponseBody), 1)
+		writeError(w, http.StatusBadGateway, codeResponseTooLarge, requestID, "Response body of %d bytes exceeds the limit of %d bytes", response.ContentLength, maxResponseBody)
+		return
+	}
 
-	timec.LogEvent("function_proxy/proxyRequest", fmt.Sprintf("<requestID=%s> Success connecting to function %s (setupTime=%d ; execTime=%d)", requestID, functionName, fnSetupTime, fnExecTime), 2)
+	/* The Function answered; its status and body go to the client as is.
+	 * The headers can only report the time to the first byte */
+	fnFirstByteTime := time.Since(fnExecStart).Milliseconds()
+	timec.LogEvent("function_proxy/proxyRequest", fmt.Sprintf("<requestID=%s> First byte from %s after %d ms (status=%d)", requestID, replicaName, fnFirstByteTime, response.StatusCode), 2)
 
 	// proxyElapsed := time.Since(proxyStart)
 	// timec.RecordDuration("(function_proxy.go) proxyRequest() : [ProxyRequestTime] <requestID="+requestID+", startupType="+startupType+", retries="+strconv.Itoa(connectionRetries)+", connectTime="+strconv.Itoa(int(totalConnectTime))+" ms>", proxyStart)
 
-	if response.Body != nil {
-		defer response.Body.Close()
-	}
-
-	fs.RecordInvocationTime(fnSetupTime+fnExecTime, startupType)
-	fs.UpdateFunctionStats(functionName, containerType, replicaName, fnSetupTime, fnExecTime, startupType)
-	err = fs.UpdateReplicaStatusInactive(functionName, replicaName, requestID)
-	if err != nil {
-		timec.LogEvent("function_proxy/proxyRequest", fmt.Sprintf("<requestID=%s> Unable to update replica status to inactive: %s\n", requestID, err.Error()), 1)
-		httputil.Errorf(w, http.StatusInternalServerError, "Can't reach service for '%s'", functionName)
-		return
-	}
-
 	clientHeader := w.Header()
 	copyHeaders(clientHeader, &response.Header)
 	w.Header().Set("Content-Type", getContentType(originalReq.Header, response.Header))
@@ -157,6 +240,8 @@ func proxyRequest(w http.ResponseWriter, originalReq *http.Request, proxyClient
 	w.Header().Set("Startup-Type", startupType)
 	w.Header().Set("Container-Type", containerType)
 	w.Header().Set("Setup-Time", strconv.Itoa(int(fnSetupTime)))
+	w.Header().Set("Ready-Time", strconv.Itoa(int(fnReadyTime)))
+	w.Header().Set("Exec-Time", strconv.Itoa(int(fnFirstByteTime)))
 	w.Header().Set("Container-Name", replicaName)
 
 	/* This is the invocation time as reported by the client inside the function container */
@@ -174,8 +259,26 @@ func proxyRequest(w http.ResponseWriter, originalReq *http.Request, proxyClient
 	// }
 
 	w.WriteHeader(response.StatusCode)
-	if response.Body != nil {
-		io.Copy(w, response.Body)
+	written, toClient, err := copyResponse(w, response.Body, isStreaming(response), maxResponseBody)
+	if err == nil {
+		/* Stats cover the whole response, streamed ones included */
+		fnExecTime := time.Since(fnExecStart).Milliseconds()
+		timec.LogEvent("function_proxy/proxyRequest", fmt.Sprintf("<requestID=%s> Success invoking function %s (setupTime=%d ; execTime=%d ; %d bytes)", requestID, functionName, fnSetupTime, fnExecTime, written), 2)
+		fs.RecordInvocationTime(fnSetupTime+fnExecTime, startupType)
+		fs.UpdateFunctionStats(functionName, containerType, replicaName, fnSetupTime, fnExecTime, startupType)
+		return
+	}
+	timec.LogEvent("function_proxy/proxyRequest", fmt.Sprintf("<requestID=%s> Copying response of %s failed after %d bytes: %s", requestID, replicaName, written, err), 1)
+	if errors.Is(err, errResponseTooLarge) {
+		/* The status is already sent; the Replica's reply is cut short and
+		 * the stream to the client aborted so it doesn't look complete.
+		 * The Replica itself answered and is kept. */
+		panic(http.ErrAbortHandler)
+	}
+	/* A Replica that broke off its response is not reused; a client that
+	 * went away doesn't make the Replica suspect */
+	if !toClient && originalReq.Context().Err() == nil {
+		keepReplica = false
 	}
 }
 
@@ -200,6 +303,11 @@ func buildProxyRequest(originalReq *http.Request, baseURL url.URL, extraPath str
 		return nil, err
 	}
 	copyHeaders(upstreamReq.Header, &originalReq.Header)
+	/* The admin token and the overrides are meant for fecore, not the
+	 * Function */
+	for _, h := range []string{handlers.AdminTokenHeader, handlers.ContainerTypeHeader, handlers.StartupTypeHeader, handlers.ReplicaHeader} {
+		upstreamReq.Header.Del(h)
+	}
 
 	if len(originalReq.Host) > 0 && upstreamReq.Header.Get("X-Forwarded-Host") == "" {
 		upstreamReq.Header["X-Forwarded-Host"] = []string{originalReq.Host}
@@ -210,6 +318,8 @@ func buildProxyRequest(originalReq *http.Request, baseURL url.URL, extraPath str
 
 	if originalReq.Body != nil {
 		upstreamReq.Body = originalReq.Body
+		/* Known lengths are forwarded as is; -1 keeps the body chunked */
+		upstreamReq.ContentLength = originalReq.ContentLength
 	}
 
 	return upstreamReq, nil
@@ -0,0 +1,347 @@
+package proxy
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/gorilla/mux"
+
+	"github.gatech.edu/faasedge/fecore/pkg/provider/handlers"
+)
+
+/* Function Store that records what the proxy does with the Replica */
+type fakeStore struct {
+	mu              sync.Mutex
+	draining        bool
+	admitErr        error
+	maxRequestBody  int64
+	maxResponseBody int64
+	admitted        int
+	released        int
+	inactive        []string
+	discarded       []string
+	execTimes       []int64 // of the invocations recorded in the stats
+}
+
+func (fs *fakeStore) BeginInvocation() bool      { return !fs.draining }
+func (fs *fakeStore) EndInvocation()             {}
+func (fs *fakeStore) RecordArrival(fname string) {}
+func (fs *fakeStore) InvokeOverrides(r *http.Request) (handlers.InvokeOptions, error) {
+	return handlers.InvokeOptions{}, nil
+}
+func (fs *fakeStore) BodyLimits(fname string) (int64, int64) {
+	return fs.maxRequestBody, fs.maxResponseBody
+}
+func (fs *fakeStore) Admit(ctx context.Context, fname string, requestID string) (func(), error) {
+	if fs.admitErr != nil {
+		return nil, fs.admitErr
+	}
+	fs.mu.Lock()
+	defer fs.mu.Unlock()
+	fs.admitted += 1
+	return func() {
+		fs.mu.Lock()
+		defer fs.mu.Unlock()
+		fs.released += 1
+	}, nil
+}
+func (fs *fakeStore) UpdateReplicaStatusInactive(fn string, replicaName string, requestID string) error {
+	fs.mu.Lock()
+	defer fs.mu.Unlock()
+	fs.inactive = append(fs.inactive, replicaName)
+	return nil
+}
+func (fs *fakeStore) DiscardActiveReplica(fn string, replicaName string, reason string, requestID string) error {
+	fs.mu.Lock()
+	defer fs.mu.Unlock()
+	fs.discarded = append(fs.discarded, replicaName)
+	return nil
+}
+func (fs *fakeStore) ReplicaReadyTime(fname string, replicaName string) time.Duration { return 0 }
+func (fs *fakeStore) RecordInvocationTime(invocationTime int64, startupType string)   {}
+func (fs *fakeStore) UpdateFunctionStats(fn string, ctrType string, requestID string, startupTime int64, execTime int64, startupType string) {
+	fs.mu.Lock()
+	defer fs.mu.Unlock()
+	fs.execTimes = append(fs.execTimes, execTime)
+}
+
+/* Returns the replica "fn_r_n" at addr, or err */
+type fakeResolver struct {
+	addr string
+	err  error
+}
+
+func (r *fakeResolver) Resolve(functionName string, requestID string, opts handlers.InvokeOptions) (url.URL, string, string, string, error) {
+	if r.err != nil {
+		return url.URL{}, "", "", "", r.err
+	}
+	u, _ := url.Parse(r.addr)
+	return *u, "warm", "native", "fn_r_n", nil
+}
+
+/* Proxy handler for Function "fn" as routed by the gateway */
+func newTestProxy(client *http.Client, resolver replicaResolver, fs invocationStore) http.HandlerFunc {
+	h := newProxyHandler(client, resolver, fs)
+	return func(w http.ResponseWriter, r *http.Request) {
+		h(w, mux.SetURLVars(r, map[string]string{"name": "fn", "params": "/"}))
+	}
+}
+
+func Test_proxyRequest(t *testing.T) {
+	replica := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Query().Get("do") {
+		case "fail":
+			w.Header().Set("Content-Type", "application/json")
+			w.WriteHeader(http.StatusInternalServerError)
+			w.Write([]byte(`{"error":"boom"}`))
+		case "slow":
+			time.Sleep(500 * time.Millisecond)
+		case "large":
+			w.Header().Set("Content-Length", "100")
+			w.Write([]byte(strings.Repeat("x", 100)))
+		case "cut":
+			/* Announces more than it sends, then drops the connection */
+			w.Header().Set("Content-Length", "100")
+			w.Write([]byte("partial"))
+			rc := http.NewResponseController(w)
+			rc.Flush()
+			conn, _, _ := rc.Hijack()
+			conn.Close()
+		default:
+			io.Copy(w, r.Body)
+		}
+	}))
+	defer replica.Close()
+	closed := httptest.NewServer(http.NotFoundHandler())
+	closed.Close()
+
+	tests := []struct {
+		name          string
+		store         *fakeStore
+		resolver      *fakeResolver
+		client        *http.Client
+		query         string
+		header        map[string]string
+		body          io.Reader
+		wantStatus    int
+		wantCode      string // gateway error code; "" if the Function's response is passed through
+		wantBody      string
+		wantAdmitted  bool
+		wantInactive  bool
+		wantDiscarded bool
+	}{
+		{name: "success", body: strings.NewReader("hello"), wantStatus: http.StatusOK, wantBody: "hello", wantAdmitted: true, wantInactive: true},
+		{name: "function error passed through", query: "do=fail", wantStatus: http.StatusInternalServerError, wantBody: `{"error":"boom"}`, wantAdmitted: true, wantInactive: true},
+		{name: "draining", store: &fakeStore{draining: true}, wantStatus: http.StatusServiceUnavailable, wantCode: codeDraining},
+		{name: "admission rejected", store: &fakeStore{admitErr: &handlers.AdmissionError{RetryAfter: time.Second}}, wantStatus: http.StatusTooManyRequests, wantCode: codeTooManyRequests},
+		{name: "function not found", resolver: &fakeResolver{err: handlers.ErrFunctionNotFound}, wantStatus: http.StatusNotFound, wantCode: codeFunctionNotFound, wantAdmitted: true},
+		{name: "no replica", resolver: &fakeResolver{err: errors.New("container limit reached")}, wantStatus: http.StatusServiceUnavailable, wantCode: codeNoReplica, wantAdmitted: true},
+		{name: "replica unreachable", resolver: &fakeResolver{addr: closed.URL}, wantStatus: http.StatusBadGateway, wantCode: codeReplicaUnreachable, wantAdmitted: true, wantDiscarded: true},
+		{name: "replica timeout", client: &http.Client{Timeout: 50 * time.Millisecond}, query: "do=slow", wantStatus: http.StatusGatewayTimeout, wantCode: codeReplicaTimeout, wantAdmitted: true, wantDiscarded: true},
+		{name: "request deadline", query: "do=slow", header: map[string]string{handlers.DeadlineHeader: "50"}, wantStatus: http.StatusGatewayTimeout, wantCode: codeDeadlineExceeded, wantAdmitted: true, wantDiscarded: true},
+		{name: "bad deadline", header: map[string]string{handlers.DeadlineHeader: "soon"}, wantStatus: http.StatusBadRequest, wantCode: codeBadRequest},
+		{name: "request over limit", store: &fakeStore{maxRequestBody: 4}, body: strings.NewReader("hello"), wantStatus: http.StatusRequestEntityTooLarge, wantCode: codeRequestTooLarge},
+		{name: "chunked request over limit", store: &fakeStore{maxRequestBody: 4}, body: io.MultiReader(strings.NewReader("hello")), wantStatus: http.StatusRequestEntityTooLarge, wantCode: codeRequestTooLarge, wantAdmitted: true, wantDiscarded: true},
+		{name: "response over limit", store: &fakeStore{maxResponseBody: 10}, query: "do=large", wantStatus: http.StatusBadGateway, wantCode: codeResponseTooLarge, wantAdmitted: true, wantInactive: true},
+		{name: "replica broke off", query: "do=cut", wantStatus: http.StatusOK, wantBody: "partial", wantAdmitted: true, wantDiscarded: true},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			if tc.store == nil {
+				tc.store = &fakeStore{}
+			}
+			if tc.resolver == nil {
+				tc.resolver = &fakeResolver{addr: replica.URL}
+			}
+			if tc.client == nil {
+				tc.client = &http.Client{}
+			}
+			body := tc.body
+			if body == nil {
+				body = http.NoBody
+			}
+			req := httptest.NewRequest(http.MethodPost, "/function/fn?"+tc.query, body)
+			for k, v := range tc.header {
+				req.Header.Set(k, v)
+			}
+			w := httptest.NewRecorder()
+
+			newTestProxy(tc.client, tc.resolver, tc.store)(w, req)
+
+			if w.Code != tc.wantStatus {
+				t.Fatalf("want status %d, got %d (%s)", tc.wantStatus, w.Code, w.Body.String())
+			}
+			if tc.wantCode != "" {
+				var gwErr gatewayError
+				if err := json.Unmarshal(w.Body.Bytes(), &gwErr); err != nil || gwErr.Code != tc.wantCode || gwErr.Status != tc.wantStatus {
+					t.Fatalf("want gateway error %s, got %q", tc.wantCode, w.Body.String())
+				}
+			} else if got := w.Body.String(); got != tc.wantBody {
+				t.Fatalf("want body %q, got %q", tc.wantBody, got)
+			}
+			if tc.wantCode == "" && w.Header().Get("Container-Name") != "fn_r_n" {
+				t.Fatalf("want timing headers on the Function's response, got %v", w.Header())
+			}
+			wantAdmitted := 0
+			if tc.wantAdmitted {
+				wantAdmitted = 1
+			}
+			if tc.store.admitted != wantAdmitted || tc.store.released != tc.store.admitted {
+				t.Fatalf("want %d admission slots taken and released, got %d taken, %d released", wantAdmitted, tc.store.admitted, tc.store.released)
+			}
+			if tc.wantInactive != (len(tc.store.inactive) == 1) || tc.wantDiscarded != (len(tc.store.discarded) == 1) {
+				t.Fatalf("want replica returned=%v discarded=%v, got returned %v, discarded %v", tc.wantInactive, tc.wantDiscarded, tc.store.inactive, tc.store.discarded)
+			}
+			/* Only complete responses count towards the stats */
+			if wantStats := tc.wantCode == "" && tc.wantInactive; wantStats != (len(tc.store.execTimes) == 1) {
+				t.Fatalf("want stats recorded=%v, got %v", wantStats, tc.store.execTimes)
+			}
+		})
+	}
+}
+
+func Test_proxyRequestAbortsOversizedStream(t *testing.T) {
+	replica := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		/* No Content-Length, so the limit is only hit while copying */
+		w.Write([]byte(strings.Repeat("x", 10)))
+		http.NewResponseController(w).Flush()
+		w.Write([]byte(strings.Repeat("x", 10)))
+	}))
+	defer replica.Close()
+	fs := &fakeStore{maxResponseBody: 15}
+	req := httptest.NewRequest(http.MethodGet, "/function/fn", nil)
+	w := httptest.NewRecorder()
+
+	func() {
+		defer func() {
+			if r := recover(); r != http.ErrAbortHandler {
+				t.Fatalf("want the response aborted, got %v", r)
+			}
+		}()
+		newTestProxy(&http.Client{}, &fakeResolver{addr: replica.URL}, fs)(w, req)
+	}()
+
+	if w.Code != http.StatusOK || w.Body.Len() > 15 {
+		t.Fatalf("want the response cut off at the limit, got %d with %d bytes", w.Code, w.Body.Len())
+	}
+	/* The Replica answered, so it is kept */
+	if fs.released != 1 || len(fs.inactive) != 1 || len(fs.discarded) != 0 {
+		t.Fatalf("want the replica returned, got released=%d returned=%v discarded=%v", fs.released, fs.inactive, fs.discarded)
+	}
+}
+
+func Test_proxyRequestStreams(t *testing.T) {
+	tests := []struct {
+		name          string
+		contentType   string
+		contentLength string
+	}{
+		{name: "chunked", contentType: "text/plain"},
+		{name: "server-sent events", contentType: "text/event-stream", contentLength: "32"},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			proceed := make(chan struct{})
+			replica := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				w.Header().Set("Content-Type", tc.contentType)
+				if tc.contentLength != "" {
+					w.Header().Set("Content-Length", tc.contentLength)
+				}
+				w.Write([]byte(strings.Repeat("a", 15) + "\n"))
+				http.NewResponseController(w).Flush()
+				<-proceed
+				w.Write([]byte(strings.Repeat("b", 15) + "\n"))
+			}))
+			defer replica.Close()
+			gateway := httptest.NewServer(newTestProxy(&http.Client{}, &fakeResolver{addr: replica.URL}, &fakeStore{}))
+			defer gateway.Close()
+			defer close(proceed)
+
+			resp, err := http.Get(gateway.URL)
+			if err != nil {
+				t.Fatalf("request failed: %s", err)
+			}
+			defer resp.Body.Close()
+
+			/* The first chunk must arrive while the Replica is still
+			 * holding back the second one */
+			first := make(chan string, 1)
+			go func() {
+				line, _ := bufio.NewReader(resp.Body).ReadString('\n')
+				first <- line
+			}()
+			select {
+			case line := <-first:
+				if line != strings.Repeat("a", 15)+"\n" {
+					t.Fatalf("want the first chunk, got %q", line)
+				}
+			case <-time.After(2 * time.Second):
+				t.Fatalf("want the first chunk flushed to the client before the response ends")
+			}
+		})
+	}
+}
+
+func Test_proxyRequestExecTime(t *testing.T) {
+	replica := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte("a"))
+		http.NewResponseController(w).Flush()
+		time.Sleep(200 * time.Millisecond)
+		w.Write([]byte("b"))
+	}))
+	defer replica.Close()
+	fs := &fakeStore{}
+	w := httptest.NewRecorder()
+
+	newTestProxy(&http.Client{}, &fakeResolver{addr: replica.URL}, fs)(w, httptest.NewRequest(http.MethodGet, "/function/fn", nil))
+
+	/* The header goes out with the first byte; the stats wait for the last */
+	if firstByte, _ := strconv.Atoi(w.Header().Get("Exec-Time")); firstByte >= 200 {
+		t.Fatalf("want Exec-Time to be the time to the first byte, got %d ms", firstByte)
+	}
+	if len(fs.execTimes) != 1 || fs.execTimes[0] < 200 {
+		t.Fatalf("want the exec time of the whole response in the stats, got %v", fs.execTimes)
+	}
+}
+
+func Test_buildProxyRequestHeaders(t *testing.T) {
+	tests := []struct {
+		name          string
+		header        string
+		wantForwarded bool
+	}{
+		{name: "function header", header: "X-Custom", wantForwarded: true},
+		{name: "deadline", header: handlers.DeadlineHeader, wantForwarded: true},
+		{name: "admin token", header: handlers.AdminTokenHeader},
+		{name: "container type override", header: handlers.ContainerTypeHeader},
+		{name: "startup type override", header: handlers.StartupTypeHeader},
+		{name: "replica override", header: handlers.ReplicaHeader},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			req := httptest.NewRequest(http.MethodPost, "/function/fn", http.NoBody)
+			req.Header.Set(tc.header, "value")
+			upstreamReq, err := buildProxyRequest(req, url.URL{Scheme: "http", Host: "10.62.0.2"}, "/")
+			if err != nil {
+				t.Fatalf("unable to build upstream request: %s", err)
+			}
+			if got := upstreamReq.Header.Get(tc.header) == "value"; got != tc.wantForwarded {
+				t.Fatalf("want %s forwarded=%v, got %v", tc.header, tc.wantForwarded, upstreamReq.Header)
+			}
+		})
+	}
+}
@@ -0,0 +1,59 @@
+package proxy
+
+import (
+	"errors"
+	"io"
+	"mime"
+	"net/http"
+)
+
+/* Bodies are streamed, not buffered: request bodies go to the Replica as the
+ * client sends them and response chunks are written to the client as the
+ * Replica produces them. Responses without a Content-Length (chunked,
+ * long-poll) and Server-Sent Events are flushed after every chunk so clients
+ * see data as soon as it is available. */
+
+const streamBufferSize = 32 * 1024
+
+var errResponseTooLarge = errors.New("response body exceeds the limit")
+
+/* Whether each chunk of a response is flushed to the client right away */
+func isStreaming(resp *http.Response) bool {
+	if resp.ContentLength < 0 {
+		return true
+	}
+	mediaType, _, _ := mime.ParseMediaType(resp.Header.Get("Content-Type"))
+	return mediaType == "text/event-stream"
+}
+
+/* Copies a response body to the client, flushing after every chunk if flush
+ * is set. A limit above 0 caps the number of bytes copied; reaching it
+ * returns errResponseTooLarge. toClient reports whether the error came from
+ * writing to the client rather than from the Replica breaking off. */
+func copyResponse(w http.ResponseWriter, body io.Reader, flush bool, limit int64) (written int64, toClient bool, err error) {
+	rc := http.NewResponseController(w)
+	buf := make([]byte, streamBufferSize)
+	for {
+		n, readErr := body.Read(buf)
+		if n > 0 {
+			if limit > 0 && written+int64(n) > limit {
+				return written, false, errResponseTooLarge
+			}
+			if _, writeErr := w.Write(buf[:n]); writeErr != nil {
+				return written, true, writeErr
+			}
+			written += int64(n)
+			if flush {
+				if flushErr := rc.Flush(); flushErr != nil && !errors.Is(flushErr, http.ErrNotSupported) {
+					return written, true, flushErr
+				}
+			}
+		}
+		if readErr == io.EOF {
+			return written, false, nil
+		}
+		if readErr != nil {
+			return written, false, readErr
+		}
+	}
+}
@@ -0,0 +1,113 @@
+package proxy
+
+import (
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+/* ResponseRecorder that counts flushes */
+type flushRecorder struct {
+	*httptest.ResponseRecorder
+	flushes int
+}
+
+func (r *flushRecorder) Flush() {
+	r.flushes += 1
+	r.ResponseRecorder.Flush()
+}
+
+/* Reader returning one chunk per Read */
+type chunkReader struct {
+	chunks []string
+	err    error // returned once the chunks are used up; io.EOF if nil
+}
+
+func (r *chunkReader) Read(p []byte) (int, error) {
+	if len(r.chunks) == 0 {
+		if r.err != nil {
+			return 0, r.err
+		}
+		return 0, io.EOF
+	}
+	n := copy(p, r.chunks[0])
+	r.chunks = r.chunks[1:]
+	return n, nil
+}
+
+func Test_isStreaming(t *testing.T) {
+	tests := []struct {
+		name          string
+		contentLength int64
+		contentType   string
+		want          bool
+	}{
+		{name: "known length", contentLength: 5, contentType: "text/plain", want: false},
+		{name: "chunked", contentLength: -1, contentType: "text/plain", want: true},
+		{name: "server-sent events", contentLength: 5, contentType: "text/event-stream; charset=utf-8", want: true},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			resp := &http.Response{ContentLength: tc.contentLength, Header: http.Header{"Content-Type": {tc.contentType}}}
+			if got := isStreaming(resp); got != tc.want {
+				t.Fatalf("want %v, got %v", tc.want, got)
+			}
+		})
+	}
+}
+
+func Test_copyResponse(t *testing.T) {
+	broken := errors.New("connection reset")
+	tests := []struct {
+		name        string
+		body        *chunkReader
+		flush       bool
+		limit       int64
+		wantBody    string
+		wantFlushes int
+		wantErr     error
+	}{
+		{name: "buffered", body: &chunkReader{chunks: []string{"ab", "cd"}}, wantBody: "abcd"},
+		{name: "flushed per chunk", body: &chunkReader{chunks: []string{"data: 1\n\n", "data: 2\n\n"}}, flush: true, wantBody: "data: 1\n\ndata: 2\n\n", wantFlushes: 2},
+		{name: "within limit", body: &chunkReader{chunks: []string{"ab", "cd"}}, limit: 4, wantBody: "abcd"},
+		{name: "over limit", body: &chunkReader{chunks: []string{"ab", "cd"}}, limit: 3, wantBody: "ab", wantErr: errResponseTooLarge},
+		{name: "replica broke off", body: &chunkReader{chunks: []string{"ab"}, err: broken}, wantBody: "ab", wantErr: broken},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			w := &flushRecorder{ResponseRecorder: httptest.NewRecorder()}
+			written, toClient, err := copyResponse(w, tc.body, tc.flush, tc.limit)
+			if !errors.Is(err, tc.wantErr) || toClient {
+				t.Fatalf("want error %v from the replica, got %v (toClient=%v)", tc.wantErr, err, toClient)
+			}
+			if got := w.Body.String(); got != tc.wantBody || written != int64(len(tc.wantBody)) {
+				t.Fatalf("want body %q, got %q (%d bytes written)", tc.wantBody, got, written)
+			}
+			if w.flushes != tc.wantFlushes {
+				t.Fatalf("want %d flushes, got %d", tc.wantFlushes, w.flushes)
+			}
+		})
+	}
+}
+
+/* ResponseWriter whose client went away */
+type failingWriter struct {
+	*httptest.ResponseRecorder
+}
+
+func (w *failingWriter) Write(p []byte) (int, error) {
+	return 0, errors.New("broken pipe")
+}
+
+func Test_copyResponseClientGone(t *testing.T) {
+	w := &failingWriter{ResponseRecorder: httptest.NewRecorder()}
+	_, toClient, err := copyResponse(w, strings.NewReader("abcd"), false, 0)
+	if err == nil || !toClient {
+		t.Fatalf("want the write error reported as the client's, got %v (toClient=%v)", err, toClient)
+	}
+}
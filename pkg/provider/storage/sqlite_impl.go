@@ -34,7 +34,39 @@ func NewSQLiteStorageManager(db *sql.DB) (*SQLiteStorageManager, error) {
 	CREATE TABLE IF NOT EXISTS Container(
 		name TEXT PRIMARY KEY UNIQUE,
 		parentFunction TEXT,
-		ip TEXT
+		ip TEXT,
+		ctrType TEXT DEFAULT '',
+		pid INT DEFAULT 0,
+		netns INT DEFAULT 0,
+		image TEXT DEFAULT '',
+		namespace TEXT DEFAULT ''
+	);
+	`
+	if _, err := db.Exec(query); err != nil {
+		return nil, err
+	}
+	if err := migrateContainerTable(db); err != nil {
+		return nil, err
+	}
+
+	query = `
+	CREATE TABLE IF NOT EXISTS Policy(
+		function TEXT PRIMARY KEY UNIQUE,
+		document TEXT,
+		updated INT DEFAULT 0
+	);
+	`
+	if _, err := db.Exec(query); err != nil {
+		return nil, err
+	}
+
+	query = `
+	CREATE TABLE IF NOT EXISTS StatsSnapshot(
+		function TEXT PRIMARY KEY UNIQUE,
+		version INT DEFAULT 0,
+		fingerprint TEXT DEFAULT '',
+		taken INT DEFAULT 0,
+		data TEXT
 	);
 	`
 	if _, err := db.Exec(query); err != nil {
@@ -91,8 +123,56 @@ func (r *SQLiteStorageManager) DeleteFunction(name string) error {
 	return nil
 }
 
+/* Columns added to the Container table after its first release. Databases
+ * created by older versions get them on startup. */
+var containerColumns = []struct {
+	name string
+	decl string
+}{
+	{"ctrType", "TEXT DEFAULT ''"},
+	{"pid", "INT DEFAULT 0"},
+	{"netns", "INT DEFAULT 0"},
+	{"image", "TEXT DEFAULT ''"},
+	{"namespace", "TEXT DEFAULT ''"},
+}
+
+func migrateContainerTable(db *sql.DB) error {
+	rows, err := db.Query("PRAGMA table_info(Container)")
+	if err != nil {
+		return err
+	}
+	existing := map[string]bool{}
+	for rows.Next() {
+		var cid, notNull, pk int
+		var name, colType string
+		var dflt sql.NullString
+		if err := rows.Scan(&cid, &name, &colType, &notNull, &dflt, &pk); err != nil {
+			rows.Close()
+			return err
+		}
+		existing[name] = true
+	}
+	rows.Close()
+
+	for _, col := range containerColumns {
+		if existing[col.name] {
+			continue
+		}
+		if _, err := db.Exec("ALTER TABLE Container ADD COLUMN " + col.name + " " + col.decl); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
 func (r *SQLiteStorageManager) InsertContainer(container Container) error {
-	_, err := r.db.Exec("INSERT INTO Container(name, parentFunction, ip) values(?, ?, ?)", container.Name, container.ParentFunction, container.Ip)
+	query := `
+	INSERT OR REPLACE INTO Container(name, parentFunction, ip, ctrType, pid,
+	netns, image, namespace)
+	values(?, ?, ?, ?, ?, ?, ?, ?)
+	`
+	_, err := r.db.Exec(query, container.Name, container.ParentFunction, container.Ip,
+		container.CtrType, container.Pid, container.NetNS, container.Image, container.Namespace)
 	if err != nil {
 		return err
 	}
@@ -100,52 +180,139 @@ func (r *SQLiteStorageManager) InsertContainer(container Container) error {
 	return nil
 }
 
+const containerSelect = `
+	SELECT name, parentFunction, IFNULL(ip, ''), IFNULL(ctrType, ''), IFNULL(pid, 0),
+	IFNULL(netns, 0), IFNULL(image, ''), IFNULL(namespace, '') FROM Container
+	`
+
 func (r *SQLiteStorageManager) GetAllContainers() ([]Container, error) {
-	rows, err := r.db.Query("SELECT * FROM Container")
+	rows, err := r.db.Query(containerSelect)
+	if err != nil {
+		return nil, err
+	}
+	return scanContainers(rows)
+}
+
+func (r *SQLiteStorageManager) GetContainersForFunction(name string) ([]Container, error) {
+	rows, err := r.db.Query(containerSelect+"WHERE parentFunction = ?", name)
 	if err != nil {
 		return nil, err
 	}
+	return scanContainers(rows)
+}
+
+func scanContainers(rows *sql.Rows) ([]Container, error) {
 	defer rows.Close()
 
 	var containers []Container
 	for rows.Next() {
 		var c Container
-		if err := rows.Scan(&c.Name, &c.ParentFunction); err != nil {
+		if err := rows.Scan(&c.Name, &c.ParentFunction, &c.Ip, &c.CtrType, &c.Pid,
+			&c.NetNS, &c.Image, &c.Namespace); err != nil {
 			return nil, err
 		}
 		containers = append(containers, c)
 	}
 
-	return containers, nil
+	return containers, rows.Err()
 }
 
-func (r *SQLiteStorageManager) GetContainersForFunction(name string) ([]Container, error) {
-	rows, err := r.db.Query("SELECT * FROM Container WHERE parentFunction = ?", name)
+func (r *SQLiteStorageManager) DeleteContainer(name string) error {
+	_, err := r.db.Exec("DELETE FROM Container WHERE name = ?", name)
+	if err != nil {
+		return err
+	}
+	return nil
+}
+
+/* Stores a Function's policy document, replacing the one stored before */
+func (r *SQLiteStorageManager) InsertPolicy(policy Policy) error {
+	query := `
+	INSERT OR REPLACE INTO Policy(function, document, updated)
+	values(?, ?, ?)
+	`
+	_, err := r.db.Exec(query, policy.Function, policy.Document, policy.Updated)
+	if err != nil {
+		return err
+	}
+
+	return nil
+}
+
+func (r *SQLiteStorageManager) GetAllPolicies() ([]Policy, error) {
+	rows, err := r.db.Query("SELECT function, IFNULL(document, ''), IFNULL(updated, 0) FROM Policy")
 	if err != nil {
 		return nil, err
 	}
 	defer rows.Close()
 
-	var containers []Container
+	var policies []Policy
 	for rows.Next() {
-		var c Container
-		if err := rows.Scan(&c.Name, &c.ParentFunction, &c.Ip); err != nil {
+		var p Policy
+		if err := rows.Scan(&p.Function, &p.Document, &p.Updated); err != nil {
 			return nil, err
 		}
-		containers = append(containers, c)
+		policies = append(policies, p)
 	}
 
-	return containers, nil
+	return policies, rows.Err()
 }
 
-func (r *SQLiteStorageManager) DeleteContainer(name string) error {
-	_, err := r.db.Exec("DELETE FROM Container WHERE name = ?", name)
+func (r *SQLiteStorageManager) DeletePolicy(function string) error {
+	_, err := r.db.Exec("DELETE FROM Policy WHERE function = ?", function)
 	if err != nil {
 		return err
 	}
 	return nil
 }
 
+/* Stores a Function's stats snapshot, replacing the one stored before */
+func (r *SQLiteStorageManager) InsertStatsSnapshot(snapshot StatsSnapshot) error {
+	query := `
+	INSERT OR REPLACE INTO StatsSnapshot(function, version, fingerprint, taken, data)
+	values(?, ?, ?, ?, ?)
+	`
+	_, err := r.db.Exec(query, snapshot.Function, snapshot.Version, snapshot.Fingerprint,
+		snapshot.Taken, snapshot.Data)
+	if err != nil {
+		return err
+	}
+
+	return nil
+}
+
+func (r *SQLiteStorageManager) GetAllStatsSnapshots() ([]StatsSnapshot, error) {
+	rows, err := r.db.Query(`SELECT function, IFNULL(version, 0), IFNULL(fingerprint, ''),
+	IFNULL(taken, 0), IFNULL(data, '') FROM StatsSnapshot`)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var snapshots []StatsSnapshot
+	for rows.Next() {
+		var s StatsSnapshot
+		if err := rows.Scan(&s.Function, &s.Version, &s.Fingerprint, &s.Taken, &s.Data); err != nil {
+			return nil, err
+		}
+		snapshots = append(snapshots, s)
+	}
+
+	return snapshots, rows.Err()
+}
+
+func (r *SQLiteStorageManager) DeleteStatsSnapshot(function string) error {
+	_, err := r.db.Exec("DELETE FROM StatsSnapshot WHERE function = ?", function)
+	if err != nil {
+		return err
+	}
+	return nil
+}
+
+func (r *SQLiteStorageManager) Close() error {
+	return r.db.Close()
+}
+
 // func Cleanup(db *sql.DB) {
 // 	db.Close()
 // 	os.Remove("./func.db")
@@ -0,0 +1,125 @@
+package storage
+
+import (
+	"database/sql"
+	"path/filepath"
+	"testing"
+)
+
+func Test_ContainerTableMigration(t *testing.T) {
+	db, err := sql.Open("sqlite", filepath.Join(t.TempDir(), "fecore.db"))
+	if err != nil {
+		t.Fatalf("want no error opening db, got: %s", err)
+	}
+	defer db.Close()
+
+	/* Table as created by older versions */
+	if _, err := db.Exec("CREATE TABLE Container(name TEXT PRIMARY KEY UNIQUE, parentFunction TEXT, ip TEXT)"); err != nil {
+		t.Fatalf("want no error creating legacy table, got: %s", err)
+	}
+	if _, err := db.Exec("INSERT INTO Container(name, parentFunction, ip) values('old_w', 'fn', '10.63.100.1')"); err != nil {
+		t.Fatalf("want no error inserting legacy row, got: %s", err)
+	}
+
+	sm, err := NewSQLiteStorageManager(db)
+	if err != nil {
+		t.Fatalf("want no error migrating, got: %s", err)
+	}
+	want := Container{Name: "new_n", ParentFunction: "fn", Ip: "10.62.0.2", CtrType: "native", Pid: 42, Namespace: "openfaas-fn"}
+	if err := sm.InsertContainer(want); err != nil {
+		t.Fatalf("want no error inserting, got: %s", err)
+	}
+
+	containers, err := sm.GetContainersForFunction("fn")
+	if err != nil {
+		t.Fatalf("want no error reading, got: %s", err)
+	}
+	got := map[string]Container{}
+	for _, c := range containers {
+		got[c.Name] = c
+	}
+	if len(got) != 2 {
+		t.Fatalf("want 2 containers, got %+v", containers)
+	}
+	if got["new_n"] != want {
+		t.Fatalf("want %+v, got %+v", want, got["new_n"])
+	}
+	if old := got["old_w"]; old.Ip != "10.63.100.1" || old.CtrType != "" || old.Pid != 0 {
+		t.Fatalf("want legacy row with empty new columns, got %+v", old)
+	}
+
+	/* Running the migration again is a no-op */
+	if _, err := NewSQLiteStorageManager(db); err != nil {
+		t.Fatalf("want no error on second start, got: %s", err)
+	}
+}
+
+func Test_PolicyTable(t *testing.T) {
+	db, err := sql.Open("sqlite", filepath.Join(t.TempDir(), "fecore.db"))
+	if err != nil {
+		t.Fatalf("want no error opening db, got: %s", err)
+	}
+	defer db.Close()
+
+	sm, err := NewSQLiteStorageManager(db)
+	if err != nil {
+		t.Fatalf("want no error creating tables, got: %s", err)
+	}
+	for _, p := range []Policy{
+		{Function: "fn", Document: `{"minIdle":1}`, Updated: 1},
+		{Function: "other", Document: `{"maxIdle":2}`, Updated: 1},
+		/* A newer document replaces the stored one */
+		{Function: "fn", Document: `{"minIdle":2}`, Updated: 2},
+	} {
+		if err := sm.InsertPolicy(p); err != nil {
+			t.Fatalf("want no error inserting, got: %s", err)
+		}
+	}
+	if err := sm.DeletePolicy("other"); err != nil {
+		t.Fatalf("want no error deleting, got: %s", err)
+	}
+
+	policies, err := sm.GetAllPolicies()
+	if err != nil {
+		t.Fatalf("want no error reading, got: %s", err)
+	}
+	want := Policy{Function: "fn", Document: `{"minIdle":2}`, Updated: 2}
+	if len(policies) != 1 || policies[0] != want {
+		t.Fatalf("want [%+v], got %+v", want, policies)
+	}
+}
+
+func Test_StatsSnapshotTable(t *testing.T) {
+	db, err := sql.Open("sqlite", filepath.Join(t.TempDir(), "fecore.db"))
+	if err != nil {
+		t.Fatalf("want no error opening db, got: %s", err)
+	}
+	defer db.Close()
+
+	sm, err := NewSQLiteStorageManager(db)
+	if err != nil {
+		t.Fatalf("want no error creating tables, got: %s", err)
+	}
+	for _, s := range []StatsSnapshot{
+		{Function: "fn", Version: 1, Fingerprint: "a", Taken: 1, Data: `{}`},
+		{Function: "other", Version: 1, Fingerprint: "b", Taken: 1, Data: `{}`},
+		/* A newer snapshot replaces the stored one */
+		{Function: "fn", Version: 1, Fingerprint: "a", Taken: 2, Data: `{"stats":{}}`},
+	} {
+		if err := sm.InsertStatsSnapshot(s); err != nil {
+			t.Fatalf("want no error inserting, got: %s", err)
+		}
+	}
+	if err := sm.DeleteStatsSnapshot("other"); err != nil {
+		t.Fatalf("want no error deleting, got: %s", err)
+	}
+
+	snapshots, err := sm.GetAllStatsSnapshots()
+	if err != nil {
+		t.Fatalf("want no error reading, got: %s", err)
+	}
+	want := StatsSnapshot{Function: "fn", Version: 1, Fingerprint: "a", Taken: 2, Data: `{"stats":{}}`}
+	if len(snapshots) != 1 || snapshots[0] != want {
+		t.Fatalf("want [%+v], got %+v", want, snapshots)
+	}
+}
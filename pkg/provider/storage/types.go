@@ -19,6 +19,25 @@ type Container struct {
 	Name           string // unique
 	ParentFunction string
 	Ip             string
+	CtrType        string // sandbox runtime the replica runs in
+	Pid            int64
+	NetNS          int    // network namespace num (wasm)
+	Image          string // image dir holding the replica rootfs (wasm)
+	Namespace      string // containerd namespace (native)
+}
+
+type Policy struct {
+	Function string // unique
+	Document string // json (see handlers/policy_doc.go)
+	Updated  int64  // unix seconds
+}
+
+type StatsSnapshot struct {
+	Function    string // unique
+	Version     int    // snapshot format
+	Fingerprint string // image and labels of the Function the snapshot was taken of
+	Taken       int64  // unix seconds
+	Data        string // json (see handlers/stats_snapshot.go)
 }
 
 type StorageManager interface {
@@ -30,4 +49,14 @@ type StorageManager interface {
 	GetContainersForFunction(name string) ([]Container, error)
 	GetAllContainers() ([]Container, error)
 	DeleteContainer(name string) error
+
+	InsertPolicy(policy Policy) error
+	GetAllPolicies() ([]Policy, error)
+	DeletePolicy(function string) error
+
+	InsertStatsSnapshot(snapshot StatsSnapshot) error
+	GetAllStatsSnapshots() ([]StatsSnapshot, error)
+	DeleteStatsSnapshot(function string) error
+
+	Close() error
 }
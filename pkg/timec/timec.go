@@ -4,6 +4,7 @@ import (
 	"fmt"
 	"log"
 	"os"
+	"sync"
 	"time"
 )
 
@@ -16,10 +17,22 @@ var TimeLog []TimeTrack
 
 var EventLog []string
 
+/* Guards TimeLog and EventLog, which are appended to by every invocation */
+var logMu sync.Mutex
+
 func ClearDurationLog() {
+	logMu.Lock()
+	defer logMu.Unlock()
 	TimeLog = TimeLog[:0]
 }
 
+/* Returns a copy of TimeLog */
+func DurationLog() []TimeTrack {
+	logMu.Lock()
+	defer logMu.Unlock()
+	return append([]TimeTrack{}, TimeLog...)
+}
+
 func WriteDurationLog() {
 	filename := fmt.Sprintf("/mnt/faasedge/logs/timec-%d.log", time.Now().UnixMilli())
 	f, err := os.OpenFile(filename, os.O_RDWR|os.O_CREATE|os.O_APPEND, 0666)
@@ -31,7 +44,7 @@ func WriteDurationLog() {
 	//log.SetFlags(log.Flags() &^ (log.Ldate | log.Ltime))
 	log.SetFlags(0)
 	log.SetOutput(f)
-	for _, v := range TimeLog {
+	for _, v := range DurationLog() {
 		log.Printf("%s, %d\n", v.Fn, v.Duration)
 	}
 }
@@ -48,7 +61,10 @@ func WriteEventLog(output ...string) {
 		log.Fatalf("Error opening fecore events log file: %v", err)
 	}
 	defer f.Close()
-	for _, v := range EventLog {
+	logMu.Lock()
+	events := append([]string{}, EventLog...)
+	logMu.Unlock()
+	for _, v := range events {
 		f.WriteString(v + "\n")
 	}
 }
@@ -59,7 +75,9 @@ func RecordDuration(fn string, eventStart time.Time) {
 	event.Fn = fn
 	event.Duration = duration.Microseconds()
 	// fmt.
+	logMu.Lock()
 	TimeLog = append(TimeLog, event)
+	logMu.Unlock()
 }
 
 func LogEvent(tag string, msg string, console ...int) {
@@ -75,7 +93,9 @@ func LogEvent(tag string, msg string, console ...int) {
 	timestamp := fmt.Sprintf("%d/%d/%d %02d:%02d:%02d:%02d", currTime.Year(), currTime.Month(), currTime.Day(),
 		currTime.Hour(), currTime.Minute(), currTime.Second(), currTime.Nanosecond())
 	event := "<" + timestamp + "> " + "[" + tag + "] " + msg
+	logMu.Lock()
 	EventLog = append(EventLog, event)
+	logMu.Unlock()
 
 	var msgLogLevel int
 	if len(console) > 0 {